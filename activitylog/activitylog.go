@@ -0,0 +1,119 @@
+// Package activitylog logs the start and end of every activity a worker
+// runs, with inputs redacted the same way execution reports are
+package activitylog
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/interceptor"
+
+	"github.com/dynajoe/temporal-terraform-demo/tfworkspace"
+)
+
+type (
+	workerInterceptor struct {
+		interceptor.WorkerInterceptorBase
+	}
+
+	activityInterceptor struct {
+		interceptor.ActivityInboundInterceptorBase
+	}
+)
+
+// NewInterceptor returns a worker interceptor that logs activity inputs,
+// attempts, durations and errors
+func NewInterceptor() interceptor.WorkerInterceptor {
+	return &workerInterceptor{}
+}
+
+func (w *workerInterceptor) InterceptActivity(ctx context.Context, next interceptor.ActivityInboundInterceptor) interceptor.ActivityInboundInterceptor {
+	i := &activityInterceptor{}
+	i.Next = next
+	return i
+}
+
+func (a *activityInterceptor) ExecuteActivity(ctx context.Context, in *interceptor.ExecuteActivityInput) (interface{}, error) {
+	logger := activity.GetLogger(ctx)
+	info := activity.GetInfo(ctx)
+
+	logger.Info("activity started", "Attempt", info.Attempt, "Input", redactArgs(in.Args))
+
+	start := time.Now()
+	result, err := a.Next.ExecuteActivity(ctx, in)
+	duration := time.Since(start).Round(time.Millisecond).String()
+
+	switch {
+	case activity.ErrResultPending == err:
+		logger.Info("activity pending completion", "Attempt", info.Attempt, "Duration", duration)
+	case err != nil:
+		logger.Error("activity failed", "Attempt", info.Attempt, "Duration", duration, "Error", err)
+	default:
+		logger.Info("activity completed", "Attempt", info.Attempt, "Duration", duration)
+	}
+	return result, err
+}
+
+// redactArgs encodes the activity arguments as JSON with the values of
+// sensitive fields and all env values replaced. The Vars of an input naming
+// its module by TerraformPath are also redacted as the module declares.
+func redactArgs(args []interface{}) string {
+	data, err := json.Marshal(args)
+	if err != nil {
+		return "<unencodable input>"
+	}
+
+	var decoded interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return "<unencodable input>"
+	}
+
+	data, err = json.Marshal(redact(decoded))
+	if err != nil {
+		return "<unencodable input>"
+	}
+	return string(data)
+}
+
+func redact(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		var sensitiveVars map[string]bool
+		if terraformPath, ok := v["TerraformPath"].(string); ok {
+			sensitiveVars = tfworkspace.SensitiveVars(terraformPath)
+		}
+
+		for k, field := range v {
+			switch {
+			case tfworkspace.IsSensitive(k, nil):
+				v[k] = tfworkspace.Redacted
+			case k == "Env":
+				// Env values are commonly credentials, only log which keys were set
+				if env, ok := field.(map[string]interface{}); ok {
+					for name := range env {
+						env[name] = tfworkspace.Redacted
+					}
+				}
+			case k == "Vars":
+				if vars, ok := field.(map[string]interface{}); ok {
+					for name, value := range vars {
+						if tfworkspace.IsSensitive(name, sensitiveVars) {
+							vars[name] = tfworkspace.Redacted
+						} else {
+							vars[name] = redact(value)
+						}
+					}
+				}
+			default:
+				v[k] = redact(field)
+			}
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = redact(v[i])
+		}
+	}
+	return v
+}
@@ -1,32 +1,353 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"log"
+	"strings"
 	"time"
 
+	"go.temporal.io/api/serviceerror"
 	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/interceptor"
 	"go.temporal.io/sdk/worker"
 
+	"github.com/dynajoe/temporal-terraform-demo/activitylog"
+	"github.com/dynajoe/temporal-terraform-demo/compression"
+	"github.com/dynajoe/temporal-terraform-demo/config/awsconfig"
+	"github.com/dynajoe/temporal-terraform-demo/providercreds"
+	"github.com/dynajoe/temporal-terraform-demo/runlog"
+	"github.com/dynajoe/temporal-terraform-demo/tfactivity"
+	"github.com/dynajoe/temporal-terraform-demo/tfexec"
+	"github.com/dynajoe/temporal-terraform-demo/tfworkspace"
+	"github.com/dynajoe/temporal-terraform-demo/timeline"
 	"github.com/dynajoe/temporal-terraform-demo/workflows"
 )
 
 func main() {
-	serviceClient, err := client.NewClient(client.Options{
-		Namespace: "default",
-		HostPort:  "127.0.0.1:7233",
+	hostPort := flag.String("address", "127.0.0.1:7233", "temporal frontend address")
+	namespace := flag.String("namespace", "default", "temporal namespace")
+	bootstrapNamespace := flag.Bool("bootstrap-namespace", false, "register -namespace if it doesn't exist and verify the cluster can serve the workflows before starting")
+	namespaceRetention := flag.Duration("namespace-retention", 72*time.Hour, "how long closed workflows are kept in a namespace registered by -bootstrap-namespace")
+	workspaceRoot := flag.String("workspace-root", "", "directory for terraform working directories, e.g. a tmpfs mount")
+	keepFailed := flag.Bool("keep-failed-workspaces", false, "keep working directories of failed runs for post-mortem")
+	localStateDir := flag.String("local-state-dir", "", "keep state, reports and other artifacts in this directory instead of s3, for development without an aws account")
+	stateRoutes := flag.String("state-routes", "", "JSON file of rules routing stacks to state buckets")
+	allowedStacks := flag.String("allowed-stacks", "", "JSON file of the stacks callers may run through the module workflows")
+	compressOver := flag.Int("compress-payloads-over", 0, "gzip payloads larger than this many bytes, zero disables compression")
+	secretsDir := flag.String("secrets-dir", "", "directory of provider credential secrets, defaults to the worker's environment")
+	timeoutGrace := flag.Duration("apply-timeout-grace", 0, "interrupt terraform this long before an apply activity times out so it can persist state, defaults to 2m")
+	awsProfile := flag.String("aws-profile", "", "shared config profile for the worker's aws credentials, may be an sso profile")
+	credentialProcess := flag.String("aws-credential-process", "", "command printing the worker's aws credentials in the credential_process format")
+	providerMirror := flag.String("provider-mirror", "", "directory terraform installs providers from instead of the registry")
+	cliConfigFile := flag.String("terraform-cli-config", "", "JSON file of extra arguments by command and env added to every terraform command, stacks override it with cli_config")
+	pluginCache := flag.String("plugin-cache-dir", "", "directory terraform caches downloaded providers in between runs")
+	populateMirror := flag.Bool("populate-provider-mirror", false, "download the providers required by the embedded modules into -provider-mirror at startup")
+	sandboxPolicy := flag.String("sandbox-policy", "", "JSON file of the guardrails for developer sandboxes, sandboxes are refused without one")
+	searchAttributes := flag.Bool("search-attributes", false, "upsert resource count and provider search attributes after applies, they must be registered with the cluster")
+	tenantsFile := flag.String("tenants", "", "JSON file of tenants, each served on its own task queue instead of the default")
+	artifactRetention := flag.String("artifact-retention", "", "JSON file of how long execution reports, outputs, state snapshots and offloaded plans are kept, they are kept forever without one")
+	cleanupSchedule := flag.String("artifact-cleanup-schedule", "@daily", "cron schedule of the artifact cleanup workflow started with -artifact-retention")
+	securitySchedule := flag.String("state-security-schedule", "", "cron schedule of the workflow checking state buckets keep versioning, encryption, a public access block and a tls-only policy, and lock tables point in time recovery, empty disables it")
+	securityAlertURL := flag.String("state-security-alert-url", "", "URL findings of the state security check are posted to")
+	securityRemediate := flag.Bool("state-security-remediate", false, "restore the state bucket and lock table security controls the state security check finds missing")
+	approvalPolicy := flag.String("approval-policy", "", "JSON file of the approver tiers plans escalate through when nobody decides, anyone may decide without one")
+	budgetPolicy := flag.String("budget-policy", "", "JSON file of monthly cost caps by state key, plans over a cap need an approval that overrides the budget with a reason")
+	annotationWebhooks := flag.String("plan-annotation-webhooks", "", "comma separated URLs plans are posted to for annotations shown at approval")
+	offloadPlans := flag.Bool("offload-plans", false, "upload saved plans next to the state and remove them from disk, applies download the reviewed plan")
+	runLogDir := flag.String("run-log-dir", "", "directory each run's bundles, plans, decisions and applies are logged to and synced to s3 from, for review with tfctl run show")
+	outputCacheTTL := flag.Duration("output-cache-ttl", 0, "keep stack outputs read to resolve references for this long, applies and destroys on this worker drop them right away, zero disables the cache")
+	exportTimeline := flag.Bool("export-timeline", false, "export workflow starts, plans, approvals, applies and failures to the stack timeline as they happen")
+	readOnly := flag.Bool("read-only", false, "register only plans and lookups and refuse applies and destroys, e.g. for a standby region")
+	flag.Parse()
+
+	awsconfig.Configure(awsconfig.Options{
+		Profile:           *awsProfile,
+		CredentialProcess: *credentialProcess,
 	})
+
+	if *localStateDir != "" {
+		log.Printf("keeping state in %s instead of s3", *localStateDir)
+		workflows.ConfigureLocalState(*localStateDir)
+	}
+
+	if *stateRoutes != "" {
+		routes, err := workflows.LoadStateRoutes(*stateRoutes)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		if err := workflows.ConfigureStateRoutes(routes); err != nil {
+			log.Fatal(err.Error())
+		}
+	}
+
+	if *allowedStacks != "" {
+		stacks, err := workflows.LoadAllowedStacks(*allowedStacks)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		if err := workflows.ConfigureAllowedStacks(stacks); err != nil {
+			log.Fatal(err.Error())
+		}
+	}
+
+	if *sandboxPolicy != "" {
+		policy, err := workflows.LoadSandboxPolicy(*sandboxPolicy)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		if err := workflows.ConfigureSandboxPolicy(policy); err != nil {
+			log.Fatal(err.Error())
+		}
+	}
+
+	if *artifactRetention != "" {
+		policy, err := workflows.LoadArtifactRetention(*artifactRetention)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		if err := workflows.ConfigureArtifactRetention(policy); err != nil {
+			log.Fatal(err.Error())
+		}
+	}
+
+	if *approvalPolicy != "" {
+		policy, err := workflows.LoadApprovalPolicy(*approvalPolicy)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		if err := workflows.ConfigureApprovalPolicy(policy); err != nil {
+			log.Fatal(err.Error())
+		}
+	}
+
+	if *budgetPolicy != "" {
+		policy, err := workflows.LoadBudgetPolicy(*budgetPolicy)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		if err := workflows.ConfigureBudgetPolicy(policy); err != nil {
+			log.Fatal(err.Error())
+		}
+	}
+
+	if *annotationWebhooks != "" {
+		for _, url := range strings.Split(*annotationWebhooks, ",") {
+			workflows.RegisterPlanAnnotator(url, workflows.PlanAnnotationWebhook(url))
+		}
+	}
+
+	if *secretsDir != "" {
+		workflows.ConfigureSecretStore(providercreds.FileStore{Dir: *secretsDir})
+	}
+
+	workflows.ConfigureOutputCache(*outputCacheTTL)
+
+	// Fail at startup rather than on the first activity
+	if err := workflows.ValidateModules(); err != nil {
+		log.Fatal(err.Error())
+	}
+	if _, err := tfexec.FindTerraform(); err != nil {
+		log.Fatal(err.Error())
+	}
+
+	if *populateMirror {
+		if *providerMirror == "" {
+			log.Fatal("-populate-provider-mirror requires -provider-mirror")
+		}
+		if err := tfworkspace.MirrorProviders(context.Background(), *providerMirror); err != nil {
+			log.Fatal(err.Error())
+		}
+	}
+
+	var cliConfig tfexec.CLIConfig
+	if *cliConfigFile != "" {
+		c, err := tfexec.LoadCLIConfig(*cliConfigFile)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		cliConfig = c
+	}
+
+	var runLog *runlog.Log
+	if *runLogDir != "" {
+		l, err := workflows.NewRunLog(*runLogDir)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		runLog = l
+	}
+
+	tfactivity.Configure(tfactivity.WorkerOptions{
+		WorkspaceRoot:        *workspaceRoot,
+		KeepFailedWorkspaces: *keepFailed,
+		TimeoutGrace:         *timeoutGrace,
+		ReadOnly:             *readOnly,
+		RunLog:               runLog,
+		OffloadPlans:         *offloadPlans,
+		OnStateWritten:       workflows.InvalidateStackOutputs,
+		CLIConfig: cliConfig.Merge(tfexec.CLIConfig{
+			ProviderMirror: *providerMirror,
+			PluginCacheDir: *pluginCache,
+		}),
+	})
+
+	dataConverter := compression.NewDataConverter(compression.Options{
+		Threshold: *compressOver,
+	})
+	clientOptions := client.Options{
+		Namespace:     *namespace,
+		HostPort:      *hostPort,
+		DataConverter: dataConverter,
+	}
+
+	// A missing namespace otherwise surfaces as NotFound errors from pollers
+	if *bootstrapNamespace {
+		if err := workflows.EnsureNamespace(context.Background(), clientOptions, *namespaceRetention); err != nil {
+			log.Fatal(err.Error())
+		}
+	}
+
+	serviceClient, err := client.NewClient(clientOptions)
 	if err != nil {
 		log.Fatal(err.Error())
 	}
 
-	temporalWorker := worker.New(serviceClient, "temporal-terraform-demo", worker.Options{
-		WorkerStopTimeout: 30 * time.Second,
+	if *bootstrapNamespace {
+		if err := workflows.VerifyCluster(context.Background(), serviceClient, *searchAttributes); err != nil {
+			log.Fatal(err.Error())
+		}
+	}
+
+	workflows.ConfigureClient(serviceClient)
+	workflows.ConfigureSearchAttributes(*searchAttributes)
+
+	// Every worker with a policy schedules cleanup, the fixed ID keeps it to
+	// a single cron workflow. Tenant workers don't serve the default queue.
+	if *artifactRetention != "" && !*readOnly && *tenantsFile == "" {
+		_, err := serviceClient.ExecuteWorkflow(context.Background(), client.StartWorkflowOptions{
+			ID:           workflows.ArtifactCleanupWorkflowID,
+			TaskQueue:    "temporal-terraform-demo",
+			CronSchedule: *cleanupSchedule,
+		}, workflows.ArtifactCleanupWorkflow, workflows.ArtifactCleanupInput{})
+		var alreadyStarted *serviceerror.WorkflowExecutionAlreadyStarted
+		if err != nil && !errors.As(err, &alreadyStarted) {
+			log.Fatal(err.Error())
+		}
+	}
+
+	workflows.ConfigureStateSecurity(workflows.StateSecurityOptions{
+		AlertURL:  *securityAlertURL,
+		Remediate: *securityRemediate,
 	})
+	if *securitySchedule != "" && !*readOnly && *tenantsFile == "" {
+		_, err := serviceClient.ExecuteWorkflow(context.Background(), client.StartWorkflowOptions{
+			ID:           workflows.StateSecurityWorkflowID,
+			TaskQueue:    "temporal-terraform-demo",
+			CronSchedule: *securitySchedule,
+		}, workflows.StateSecurityWorkflow, workflows.StateSecurityInput{})
+		var alreadyStarted *serviceerror.WorkflowExecutionAlreadyStarted
+		if err != nil && !errors.As(err, &alreadyStarted) {
+			log.Fatal(err.Error())
+		}
+	}
+
+	// Tenants' timelines and run logs are kept in their own buckets
+	newInterceptors := func(sink timeline.Sink, runLog *runlog.Log) []interceptor.WorkerInterceptor {
+		interceptors := []interceptor.WorkerInterceptor{workflows.NewWriteQueueInterceptor(), activitylog.NewInterceptor()}
+		if *exportTimeline {
+			exporter := timeline.NewExporter(sink)
+			go exporter.Run(context.Background())
+
+			options := workflows.TimelineOptions()
+			options.DataConverter = dataConverter
+			interceptors = append(interceptors, timeline.NewInterceptor(exporter, options))
+		}
+		if runLog != nil {
+			options := workflows.RunLogOptions()
+			options.DataConverter = dataConverter
+			interceptors = append(interceptors, runlog.NewInterceptor(runLog, options))
+		}
+		return interceptors
+	}
 
-	log.Print("registering workflows")
-	workflows.Register(temporalWorker)
+	register := workflows.Register
+	if *readOnly {
+		log.Print("read-only: applies and destroys are left to other workers")
+		register = workflows.RegisterReadOnly
+	}
+
+	// Changes are scheduled on a write task queue next to each task queue,
+	// read-only workers don't poll it
+	startWriteWorker := func(taskQueue string, options worker.Options) worker.Worker {
+		if *readOnly {
+			return nil
+		}
+		writeWorker := worker.New(serviceClient, workflows.WriteTaskQueue(taskQueue), options)
+		workflows.RegisterWrites(writeWorker)
+		if err := writeWorker.Start(); err != nil {
+			log.Fatalln("unable to start Worker", err)
+		}
+		return writeWorker
+	}
+
+	// Without tenants a single unrestricted worker serves the default queue
+	if *tenantsFile == "" {
+		options := worker.Options{
+			WorkerStopTimeout: 30 * time.Second,
+			Interceptors:      newInterceptors(workflows.TimelineSink(), runLog),
+		}
+		temporalWorker := worker.New(serviceClient, "temporal-terraform-demo", options)
+
+		log.Print("registering workflows")
+		register(temporalWorker)
+
+		if writeWorker := startWriteWorker("temporal-terraform-demo", options); writeWorker != nil {
+			defer writeWorker.Stop()
+		}
+		if err := temporalWorker.Run(worker.InterruptCh()); err != nil {
+			log.Fatalln("unable to start Worker", err)
+		}
+		return
+	}
+
+	tenants, err := workflows.LoadTenants(*tenantsFile)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	var tenantWorkers []worker.Worker
+	for _, tenant := range tenants {
+		var tenantRunLog *runlog.Log
+		if *runLogDir != "" {
+			l, err := workflows.NewTenantRunLog(*runLogDir, tenant)
+			if err != nil {
+				log.Fatal(err.Error())
+			}
+			tenantRunLog = l
+		}
+		options := worker.Options{
+			WorkerStopTimeout:         30 * time.Second,
+			BackgroundActivityContext: tfactivity.WithRunLog(workflows.WithTenant(context.Background(), tenant), tenantRunLog),
+			Interceptors:              newInterceptors(workflows.TenantTimelineSink(tenant), tenantRunLog),
+		}
+		tenantWorker := worker.New(serviceClient, tenant.TaskQueue, options)
+
+		log.Printf("registering workflows for tenant %s on task queue %s", tenant.Name, tenant.TaskQueue)
+		register(tenantWorker)
+
+		if err := tenantWorker.Start(); err != nil {
+			log.Fatalln("unable to start Worker", err)
+		}
+		tenantWorkers = append(tenantWorkers, tenantWorker)
+		if writeWorker := startWriteWorker(tenant.TaskQueue, options); writeWorker != nil {
+			tenantWorkers = append(tenantWorkers, writeWorker)
+		}
+	}
 
-	if err := temporalWorker.Run(worker.InterruptCh()); err != nil {
-		log.Fatalln("unable to start Worker", err)
+	<-worker.InterruptCh()
+	for _, tenantWorker := range tenantWorkers {
+		tenantWorker.Stop()
 	}
 }
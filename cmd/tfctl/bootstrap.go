@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"go.temporal.io/sdk/client"
+
+	"github.com/dynajoe/temporal-terraform-demo/config/awsconfig"
+	"github.com/dynajoe/temporal-terraform-demo/locktable"
+	"github.com/dynajoe/temporal-terraform-demo/s3object"
+	"github.com/dynajoe/temporal-terraform-demo/workflows"
+)
+
+// bootstrap creates the state bucket and lock table a new installation needs
+// before any stack can be applied, and writes a state routes file pointing
+// at them for the worker's -state-routes flag. It is safe to re-run.
+func bootstrap(_ client.Client, args []string) error {
+	flags := flag.NewFlagSet("bootstrap", flag.ContinueOnError)
+	bucket := flags.String("bucket", "", "name of the state bucket to create")
+	region := flags.String("region", "us-west-2", "region to create the bucket and lock table in")
+	lockTable := flags.String("lock-table", "terraform-locks", "name of the lock table to create, empty to skip")
+	out := flags.String("out", "state-routes.json", "file to write the state routes to")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *bucket == "" {
+		return errors.New("bucket is required")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	credentials := awsconfig.LoadConfig().Credentials
+
+	s3Client := s3object.New(credentials, *region)
+	if err := s3Client.CreateBucket(ctx, *bucket); err != nil {
+		return fmt.Errorf("error creating state bucket: %w", err)
+	}
+	if err := s3Client.EnableVersioning(ctx, *bucket); err != nil {
+		return err
+	}
+	if err := s3Client.EnableEncryption(ctx, *bucket); err != nil {
+		return err
+	}
+	if err := s3Client.BlockPublicAccess(ctx, *bucket); err != nil {
+		return err
+	}
+	if err := s3Client.RequireTLS(ctx, *bucket); err != nil {
+		return err
+	}
+	fmt.Printf("state bucket s3://%s ready in %s\n", *bucket, *region)
+
+	if *lockTable != "" {
+		tables := locktable.New(credentials, *region)
+		if err := tables.Create(ctx, *lockTable); err != nil {
+			return err
+		}
+		if err := tables.EnablePointInTimeRecovery(ctx, *lockTable); err != nil {
+			return err
+		}
+		fmt.Printf("lock table %s ready in %s\n", *lockTable, *region)
+	}
+
+	routes := []workflows.StateRoute{{
+		Bucket:    *bucket,
+		Region:    *region,
+		LockTable: *lockTable,
+	}}
+	data, err := json.MarshalIndent(routes, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(*out, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	fmt.Printf("wrote %s, pass it to the worker and tfctl with -state-routes\n", *out)
+	return nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"go.temporal.io/sdk/client"
+
+	"github.com/dynajoe/temporal-terraform-demo/workflows"
+)
+
+// demoPollInterval is how often the demo checks on the workflow
+const demoPollInterval = 2 * time.Second
+
+// demo runs the network workflow end to end, standing in for the operator
+// that would otherwise approve the plan with tctl
+func demo(c client.Client, args []string) error {
+	flags := flag.NewFlagSet("demo", flag.ContinueOnError)
+	name := flags.String("name", "demo", "network name")
+	region := flags.String("region", "us-west-2", "aws region")
+	cidrBlock := flags.String("cidr", "10.0.0.0/16", "vpc cidr block")
+	taskQueue := flags.String("task-queue", "temporal-terraform-demo", "task queue the worker polls")
+	approveAfter := flags.Duration("approve-after", 10*time.Second, "how long to show the plan before approving it")
+	interactive := flags.Bool("interactive", false, "prompt for approval instead of approving after a delay")
+	destroy := flags.Bool("destroy", false, "destroy the network once it is created")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	run, err := c.ExecuteWorkflow(ctx, client.StartWorkflowOptions{
+		ID:        fmt.Sprintf("demo-network-%s", *name),
+		TaskQueue: *taskQueue,
+	}, workflows.CreateDemoNetworkWorkflow, workflows.CreateDemoNetworkInput{
+		Name:      *name,
+		Region:    *region,
+		CIDRBlock: *cidrBlock,
+		Subnets: []workflows.Subnet{
+			{AvailabilityZone: "a", CIDRBlock: "10.0.1.0/24"},
+			{AvailabilityZone: "b", CIDRBlock: "10.0.2.0/24"},
+		},
+		RequireApproval: true,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("started %s (run %s)\n", run.GetID(), run.GetRunID())
+
+	var output workflows.CreateDemoNetworkOutput
+	done := make(chan error, 1)
+	go func() { done <- run.Get(ctx, &output) }()
+
+	status, err := waitForPhase(ctx, c, run, done, workflows.PhaseAwaitingApproval)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("\nplan:")
+	if len(status.Plan) == 0 {
+		fmt.Println("  no changes")
+	}
+	for _, change := range status.Plan {
+		fmt.Printf("  %s\n", change)
+	}
+	fmt.Println()
+
+	approval := workflows.Approval{Approved: true, By: os.Getenv("USER"), Reason: "tfctl demo"}
+	if *interactive {
+		approval.Approved = confirm("apply this plan?")
+	} else {
+		fmt.Printf("approving in %s\n", *approveAfter)
+		time.Sleep(*approveAfter)
+	}
+	if err := c.SignalWorkflow(ctx, run.GetID(), run.GetRunID(), workflows.ApprovalSignal, approval); err != nil {
+		return err
+	}
+
+	if _, err := waitForPhase(ctx, c, run, done, ""); err != nil {
+		return err
+	}
+	fmt.Printf("network %s: vpc %s (%s)\n", *name, output.VpcID, output.Status)
+
+	if !*destroy {
+		return nil
+	}
+
+	fmt.Printf("destroying network %s\n", *name)
+	destroyRun, err := c.ExecuteWorkflow(ctx, client.StartWorkflowOptions{
+		ID:        fmt.Sprintf("demo-network-destroy-%s", *name),
+		TaskQueue: *taskQueue,
+	}, workflows.DestroyDemoNetworkWorkflow, workflows.DestroyDemoNetworkInput{
+		Name:   *name,
+		Region: *region,
+	})
+	if err != nil {
+		return err
+	}
+	done = make(chan error, 1)
+	go func() { done <- destroyRun.Get(ctx, nil) }()
+	if _, err := waitForPhase(ctx, c, destroyRun, done, ""); err != nil {
+		return err
+	}
+	fmt.Printf("network %s destroyed\n", *name)
+	return nil
+}
+
+// waitForPhase prints the workflow's phases until it reaches phase, or
+// until it completes if phase is empty
+func waitForPhase(ctx context.Context, c client.Client, run client.WorkflowRun, done <-chan error, phase string) (workflows.Status, error) {
+	ticker := time.NewTicker(demoPollInterval)
+	defer ticker.Stop()
+
+	var status workflows.Status
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				return status, fmt.Errorf("%s failed: %w", run.GetID(), err)
+			}
+			if phase != "" {
+				return status, fmt.Errorf("%s completed without reaching phase %q", run.GetID(), phase)
+			}
+			return status, nil
+		case <-ticker.C:
+		}
+
+		value, err := c.QueryWorkflow(ctx, run.GetID(), run.GetRunID(), workflows.StatusQuery)
+		if err != nil {
+			// The workflow may not have registered its query handler yet
+			continue
+		}
+		var current workflows.Status
+		if err := value.Get(&current); err != nil {
+			return status, err
+		}
+		if current.Phase != status.Phase {
+			fmt.Printf("%s: %s\n", run.GetID(), current.Phase)
+		}
+		status = current
+
+		if phase != "" && status.Phase == phase {
+			return status, nil
+		}
+	}
+}
+
+// confirm asks a yes/no question on the terminal, anything but yes is no
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
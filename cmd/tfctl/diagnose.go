@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	enumspb "go.temporal.io/api/enums/v1"
+	workflowpb "go.temporal.io/api/workflow/v1"
+	"go.temporal.io/sdk/client"
+
+	"github.com/dynajoe/temporal-terraform-demo/workflows"
+)
+
+// staleHeartbeat is how long after the last heartbeat an activity is assumed
+// to have lost its worker. Activities heartbeat every 10 seconds.
+const staleHeartbeat = 2 * time.Minute
+
+func diagnose(c client.Client, args []string) error {
+	if len(args) < 1 {
+		return errors.New("workflow id is required")
+	}
+	workflowID := args[0]
+	runID := ""
+	if len(args) > 1 {
+		runID = args[1]
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	desc, err := c.DescribeWorkflowExecution(ctx, workflowID, runID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	info := desc.GetWorkflowExecutionInfo()
+	status := strings.TrimPrefix(info.GetStatus().String(), "WorkflowExecutionStatus")
+	fmt.Printf("%s %s/%s is %s", info.GetType().GetName(), info.GetExecution().GetWorkflowId(), info.GetExecution().GetRunId(), status)
+	if info.GetStartTime() != nil {
+		fmt.Printf(" (started %s ago)", since(now, *info.GetStartTime()))
+	}
+	fmt.Println()
+
+	if info.GetStatus() != enumspb.WORKFLOW_EXECUTION_STATUS_RUNNING {
+		return nil
+	}
+
+	// Workflows that support it report which phase they are in
+	if v, err := c.QueryWorkflow(ctx, workflowID, runID, workflows.StatusQuery); err == nil {
+		var s workflows.Status
+		if err := v.Get(&s); err == nil {
+			fmt.Printf("  phase: %s\n", s.Phase)
+			if s.Frozen {
+				fmt.Printf("  waiting on change freeze: %s\n", s.FrozenReason)
+			}
+		}
+	}
+
+	if len(desc.GetPendingActivities()) == 0 && len(desc.GetPendingChildren()) == 0 {
+		fmt.Println("  no pending activities or child workflows, the workflow is waiting on a timer or signal")
+		return nil
+	}
+
+	for _, a := range desc.GetPendingActivities() {
+		fmt.Printf("  %s\n", explainActivity(now, a))
+		if f := a.GetLastFailure(); f != nil {
+			fmt.Printf("    last failure: %s\n", firstLine(f.GetMessage()))
+		}
+	}
+
+	for _, child := range desc.GetPendingChildren() {
+		fmt.Printf("  waiting for child workflow %s %s/%s, run tfctl diagnose %s for details\n",
+			child.GetWorkflowTypeName(), child.GetWorkflowId(), child.GetRunId(), child.GetWorkflowId())
+	}
+
+	return nil
+}
+
+func explainActivity(now time.Time, a *workflowpb.PendingActivityInfo) string {
+	name := a.GetActivityType().GetName()
+
+	attempts := fmt.Sprintf("attempt %d", a.GetAttempt())
+	if a.GetMaximumAttempts() > 0 {
+		attempts = fmt.Sprintf("attempt %d of %d", a.GetAttempt(), a.GetMaximumAttempts())
+	}
+
+	switch a.GetState() {
+	case enumspb.PENDING_ACTIVITY_STATE_SCHEDULED:
+		if a.GetAttempt() > 1 {
+			return fmt.Sprintf("%s failed and is waiting to retry (%s)", name, attempts)
+		}
+		scheduled := ""
+		if a.GetScheduledTime() != nil {
+			scheduled = fmt.Sprintf(" for %s", since(now, *a.GetScheduledTime()))
+		}
+		return fmt.Sprintf("%s has been waiting%s for a worker to pick it up, check that a worker is running", name, scheduled)
+	case enumspb.PENDING_ACTIVITY_STATE_STARTED:
+		msg := fmt.Sprintf("%s is running on %s (%s)", name, a.GetLastWorkerIdentity(), attempts)
+		if a.GetLastStartedTime() != nil {
+			msg += fmt.Sprintf(", started %s ago", since(now, *a.GetLastStartedTime()))
+		}
+		if hb := a.GetLastHeartbeatTime(); hb != nil {
+			msg += fmt.Sprintf(", last heartbeat %s ago", since(now, *hb))
+			if now.Sub(*hb) > staleHeartbeat {
+				msg += " (stale, the worker may have stopped)"
+			}
+		}
+		return msg
+	case enumspb.PENDING_ACTIVITY_STATE_CANCEL_REQUESTED:
+		return fmt.Sprintf("%s has been asked to cancel and is waiting for terraform to exit", name)
+	default:
+		return fmt.Sprintf("%s is in state %s", name, a.GetState())
+	}
+}
+
+func since(now time.Time, t time.Time) string {
+	return now.Sub(t).Round(time.Second).String()
+}
+
+func firstLine(s string) string {
+	if i := strings.IndexByte(strings.TrimSpace(s), '\n'); i >= 0 {
+		return strings.TrimSpace(s)[:i]
+	}
+	return strings.TrimSpace(s)
+}
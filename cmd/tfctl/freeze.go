@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"go.temporal.io/api/workflowservice/v1"
+	"go.temporal.io/sdk/client"
+
+	"github.com/dynajoe/temporal-terraform-demo/workflows"
+)
+
+func freeze(c client.Client, args []string) error {
+	flags := flag.NewFlagSet("freeze", flag.ContinueOnError)
+	reason := flags.String("reason", "", "why changes are frozen")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *reason == "" {
+		return errors.New("-reason is required")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := workflows.StoreChangeFreeze(ctx, workflows.NewStateClient(), workflows.ChangeFreeze{
+		Frozen: true,
+		Reason: *reason,
+		By:     os.Getenv("USER"),
+		Since:  time.Now().UTC(),
+	}); err != nil {
+		return err
+	}
+
+	fmt.Println("changes are frozen: applies will wait and destroys will be refused")
+	return nil
+}
+
+// thaw lifts the change freeze, or with a workflow id lets only that
+// workflow proceed
+func thaw(c client.Client, args []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if len(args) > 0 {
+		if err := c.SignalWorkflow(ctx, args[0], "", workflows.ThawSignal, nil); err != nil {
+			return err
+		}
+		fmt.Printf("thawed %s\n", args[0])
+		return nil
+	}
+
+	if err := workflows.StoreChangeFreeze(ctx, workflows.NewStateClient(), workflows.ChangeFreeze{}); err != nil {
+		return err
+	}
+
+	fmt.Println("change freeze lifted")
+	return nil
+}
+
+// frozen shows the change freeze and the executions waiting on it
+func frozen(c client.Client, args []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	f, err := workflows.LoadChangeFreeze(ctx, workflows.NewStateClient())
+	if err != nil {
+		return err
+	}
+	if f.Frozen {
+		fmt.Printf("frozen since %s by %s: %s\n", f.Since.Format(time.RFC3339), f.By, f.Reason)
+	} else {
+		fmt.Println("not frozen")
+	}
+
+	var nextPageToken []byte
+	for {
+		resp, err := c.ListOpenWorkflow(ctx, &workflowservice.ListOpenWorkflowExecutionsRequest{
+			MaximumPageSize: 100,
+			NextPageToken:   nextPageToken,
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, info := range resp.GetExecutions() {
+			execution := info.GetExecution()
+			v, err := c.QueryWorkflow(ctx, execution.GetWorkflowId(), execution.GetRunId(), workflows.StatusQuery)
+			if err != nil {
+				// Not every workflow supports the status query
+				continue
+			}
+			var s workflows.Status
+			if err := v.Get(&s); err != nil || !s.Frozen {
+				continue
+			}
+			fmt.Printf("  %s %s/%s waiting: %s\n", info.GetType().GetName(), execution.GetWorkflowId(), execution.GetRunId(), s.FrozenReason)
+		}
+
+		nextPageToken = resp.GetNextPageToken()
+		if len(nextPageToken) == 0 {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"go.temporal.io/sdk/client"
+
+	"github.com/dynajoe/temporal-terraform-demo/tfworkspace"
+)
+
+// history prints every recorded apply and destroy of a stack, identified by
+// its state key, e.g. vpc-demo.tfstate
+func history(c client.Client, args []string) error {
+	flags := flag.NewFlagSet("history", flag.ContinueOnError)
+	stack := newStackFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() < 1 {
+		return errors.New("state key is required")
+	}
+	stateKey := flags.Arg(0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	backend, err := stack.backend(ctx, stateKey)
+	if err != nil {
+		return err
+	}
+
+	reports, err := tfworkspace.History(ctx, backend)
+	if err != nil {
+		return err
+	}
+	if len(reports) == 0 {
+		fmt.Printf("no recorded runs for %s\n", stateKey)
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "STARTED\tOPERATION\tRESULT\tDURATION\tRUN\tMODULE")
+	for _, r := range reports {
+		result := "succeeded"
+		switch {
+		case r.Outcome != nil && r.Outcome.By != "":
+			result = fmt.Sprintf("%s by %s at %s: %s", r.Outcome.Kind, r.Outcome.By, r.Outcome.Phase, r.Outcome.Reason)
+		case r.Outcome != nil:
+			result = fmt.Sprintf("%s at %s: %s", r.Outcome.Kind, r.Outcome.Phase, r.Outcome.Reason)
+		case !r.Succeeded:
+			result = "failed: " + r.Error
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			r.StartedAt.Format(time.RFC3339), r.Operation, result, r.Duration, r.RunID, r.TerraformPath)
+	}
+	return tw.Flush()
+}
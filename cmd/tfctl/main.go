@@ -0,0 +1,120 @@
+// tfctl is an operator CLI for the terraform workflows
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"go.temporal.io/sdk/client"
+
+	"github.com/dynajoe/temporal-terraform-demo/compression"
+	"github.com/dynajoe/temporal-terraform-demo/config/awsconfig"
+	"github.com/dynajoe/temporal-terraform-demo/workflows"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(c client.Client, args []string) error
+
+	// offline commands don't talk to temporal and are run with a nil client
+	offline bool
+}
+
+var commands = []command{
+	{name: "bootstrap", usage: "bootstrap -bucket <bucket> [-region <region>] [-lock-table <table>] [-out <file>]", run: bootstrap, offline: true},
+	{name: "mirror", usage: "mirror <dir>", run: mirror, offline: true},
+	{name: "demo", usage: "demo [-name <name>] [-region <region>] [-approve-after <duration>] [-interactive] [-destroy]", run: demo},
+	{name: "status", usage: "status <workflow-id>", run: status},
+	{name: "annotate", usage: "annotate <workflow-id> <note>", run: annotate},
+	{name: "diagnose", usage: "diagnose <workflow-id> [run-id]", run: diagnose},
+	{name: "freeze", usage: "freeze -reason <reason>", run: freeze},
+	{name: "thaw", usage: "thaw [workflow-id]", run: thaw},
+	{name: "frozen", usage: "frozen", run: frozen},
+	{name: "pause", usage: "pause [-reason <reason>] <workflow-id>", run: pause},
+	{name: "resume", usage: "resume <workflow-id>", run: resume},
+	{name: "history", usage: "history [-module <terraform-path>] [-role <role-arn>] <state-key>", run: history},
+	{name: "outputs", usage: "outputs [-module <terraform-path>] [-role <role-arn>] [-json | -env] [-output <name>] <state-key>", run: outputs, offline: true},
+	{name: "reveal", usage: "reveal [-module <terraform-path>] [-role <role-arn>] <state-key> <output>", run: reveal},
+	{name: "run", usage: "run show <run-id>", run: run, offline: true},
+}
+
+func main() {
+	hostPort := flag.String("address", "127.0.0.1:7233", "temporal frontend address")
+	namespace := flag.String("namespace", "default", "temporal namespace")
+	stateRoutes := flag.String("state-routes", "", "JSON file of rules routing stacks to state buckets")
+	awsProfile := flag.String("aws-profile", "", "shared config profile for aws credentials, may be an sso profile")
+	localStateDir := flag.String("local-state-dir", "", "directory a worker started with -local-state-dir keeps state in")
+	flag.Usage = usage
+	flag.Parse()
+
+	awsconfig.Configure(awsconfig.Options{Profile: *awsProfile})
+
+	if flag.NArg() < 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	if *localStateDir != "" {
+		workflows.ConfigureLocalState(*localStateDir)
+	}
+
+	if *stateRoutes != "" {
+		if err := configureStateRoutes(*stateRoutes); err != nil {
+			log.Fatal(err.Error())
+		}
+	}
+
+	for _, cmd := range commands {
+		if cmd.name != flag.Arg(0) {
+			continue
+		}
+
+		if cmd.offline {
+			if err := cmd.run(nil, flag.Args()[1:]); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", cmd.name, err)
+				os.Exit(1)
+			}
+			return
+		}
+
+		c, err := client.NewClient(client.Options{
+			Namespace: *namespace,
+			HostPort:  *hostPort,
+			// Only decompresses, tfctl's own payloads are small
+			DataConverter: compression.NewDataConverter(compression.Options{}),
+		})
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		defer c.Close()
+
+		if err := cmd.run(c, flag.Args()[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", cmd.name, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	usage()
+	os.Exit(2)
+}
+
+func configureStateRoutes(path string) error {
+	routes, err := workflows.LoadStateRoutes(path)
+	if err != nil {
+		return err
+	}
+	return workflows.ConfigureStateRoutes(routes)
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: tfctl [flags] <command> [args]\n\ncommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %s\n", cmd.usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nflags:\n")
+	flag.PrintDefaults()
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"go.temporal.io/sdk/client"
+
+	"github.com/dynajoe/temporal-terraform-demo/tfworkspace"
+)
+
+// mirror downloads the providers required by the embedded modules into a
+// directory that can be baked into the worker image and passed to the
+// worker's -provider-mirror flag
+func mirror(_ client.Client, args []string) error {
+	if len(args) < 1 {
+		return errors.New("mirror directory is required")
+	}
+	return tfworkspace.MirrorProviders(context.Background(), args[0])
+}
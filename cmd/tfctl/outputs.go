@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"go.temporal.io/sdk/client"
+
+	"github.com/dynajoe/temporal-terraform-demo/tfstate"
+)
+
+const sensitiveOutput = "<sensitive>"
+
+var (
+	envUnsafe   = regexp.MustCompile(`[^A-Z0-9_]+`)
+	shellUnsafe = regexp.MustCompile(`[^A-Za-z0-9_./:,@%+=-]`)
+)
+
+// outputs prints a stack's outputs read from its state. Sensitive outputs
+// are withheld, see reveal.
+func outputs(_ client.Client, args []string) error {
+	flags := flag.NewFlagSet("outputs", flag.ContinueOnError)
+	stack := newStackFlags(flags)
+	asJSON := flags.Bool("json", false, "print the outputs as a JSON object")
+	asEnv := flags.Bool("env", false, "print the outputs as NAME=value lines for a shell")
+	only := flags.String("output", "", "print only this output's value")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() < 1 {
+		return errors.New("state key is required")
+	}
+	if *asJSON && *asEnv {
+		return errors.New("-json and -env can't be combined")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	backend, err := stack.backend(ctx, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	state, err := tfstate.Load(ctx, backend)
+	if err != nil {
+		return err
+	}
+
+	values := map[string]json.RawMessage{}
+	sensitive := map[string]bool{}
+	for name, o := range state.Outputs {
+		if *only != "" && name != *only {
+			continue
+		}
+		if o.Sensitive {
+			if *only != "" {
+				return fmt.Errorf("output %s is sensitive, use tfctl reveal", name)
+			}
+			values[name], _ = json.Marshal(sensitiveOutput)
+			sensitive[name] = true
+			continue
+		}
+		values[name] = o.Value
+	}
+	if *only != "" && len(values) == 0 {
+		return fmt.Errorf("missing output [%s] in state", *only)
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	switch {
+	case *asJSON:
+		if *only != "" {
+			fmt.Println(string(values[*only]))
+			return nil
+		}
+		data, err := json.MarshalIndent(values, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+	case *asEnv:
+		// A placeholder would be mistaken for the value
+		for _, name := range names {
+			if sensitive[name] {
+				continue
+			}
+			fmt.Printf("%s=%s\n", envName(name), shellQuote(outputText(values[name])))
+		}
+	case *only != "":
+		fmt.Println(outputText(values[*only]))
+	default:
+		for _, name := range names {
+			fmt.Printf("%s = %s\n", name, outputText(values[name]))
+		}
+	}
+	return nil
+}
+
+// outputText returns strings bare so they can be used in scripts, other
+// values as JSON
+func outputText(value json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(value, &s); err == nil {
+		return s
+	}
+	return string(value)
+}
+
+// envName turns an output name into an environment variable name, e.g.
+// vpc-id -> VPC_ID
+func envName(name string) string {
+	return envUnsafe.ReplaceAllString(strings.ToUpper(name), "_")
+}
+
+func shellQuote(s string) string {
+	if s != "" && !shellUnsafe.MatchString(s) {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
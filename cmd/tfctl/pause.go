@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"go.temporal.io/sdk/client"
+
+	"github.com/dynajoe/temporal-terraform-demo/workflows"
+)
+
+// pause holds a single workflow at its next safe point
+func pause(c client.Client, args []string) error {
+	flags := flag.NewFlagSet("pause", flag.ContinueOnError)
+	reason := flags.String("reason", "", "why the workflow is paused")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("usage: pause [-reason <reason>] <workflow-id>")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := c.SignalWorkflow(ctx, flags.Arg(0), "", workflows.PauseSignal, workflows.PauseRequest{
+		By:     os.Getenv("USER"),
+		Reason: *reason,
+	}); err != nil {
+		return err
+	}
+
+	fmt.Printf("%s will pause before it next plans or applies\n", flags.Arg(0))
+	return nil
+}
+
+func resume(c client.Client, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: resume <workflow-id>")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := c.SignalWorkflow(ctx, args[0], "", workflows.ResumeSignal, nil); err != nil {
+		return err
+	}
+
+	fmt.Printf("resumed %s\n", args[0])
+	return nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"go.temporal.io/sdk/client"
+
+	"github.com/dynajoe/temporal-terraform-demo/tfstate"
+)
+
+// reveal prints an output withheld from workflow results by a redaction
+// rule. It reads the stack's state directly, so only callers allowed to read
+// the state bucket can see the value.
+func reveal(c client.Client, args []string) error {
+	flags := flag.NewFlagSet("reveal", flag.ContinueOnError)
+	stack := newStackFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() < 2 {
+		return errors.New("state key and output name are required")
+	}
+	stateKey, name := flags.Arg(0), flags.Arg(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	backend, err := stack.backend(ctx, stateKey)
+	if err != nil {
+		return err
+	}
+
+	state, err := tfstate.Load(ctx, backend)
+	if err != nil {
+		return err
+	}
+
+	var value json.RawMessage
+	if err := state.OutputValue(name, &value); err != nil {
+		return err
+	}
+
+	fmt.Println(outputText(value))
+	return nil
+}
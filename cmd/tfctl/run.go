@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"go.temporal.io/sdk/client"
+
+	"github.com/dynajoe/temporal-terraform-demo/workflows"
+)
+
+// run renders the run log of a workflow run, identified as in tfctl
+// history by its workflow ID and run ID joined by an underscore. It reads
+// the synced log from s3 and doesn't need temporal.
+func run(_ client.Client, args []string) error {
+	if len(args) < 1 || args[0] != "show" {
+		return errors.New("usage: run show <run-id>")
+	}
+	if len(args) < 2 {
+		return errors.New("run id is required")
+	}
+	runID := args[1]
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	entries, err := workflows.ReadRunLog(ctx, runID)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		fmt.Printf("nothing logged for %s\n", runID)
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "AT\tEVENT\tSTACK\tSUMMARY")
+	for _, e := range entries {
+		stack := e.StateKey
+		if stack == "" {
+			stack = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", e.At.Format(time.RFC3339), e.Kind, stack, e.Summary)
+		for _, detail := range e.Details {
+			fmt.Fprintf(tw, "\t\t\t  %s\n", detail)
+		}
+		if e.Error != "" {
+			fmt.Fprintf(tw, "\t\t\t  error: %s\n", e.Error)
+		}
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"flag"
+
+	"github.com/dynajoe/temporal-terraform-demo/config/awsconfig"
+	"github.com/dynajoe/temporal-terraform-demo/tfexec"
+	"github.com/dynajoe/temporal-terraform-demo/workflows"
+)
+
+// stackFlags are the flags used to route to a stack's state bucket
+type stackFlags struct {
+	terraformPath *string
+	roleARN       *string
+}
+
+func newStackFlags(flags *flag.FlagSet) stackFlags {
+	return stackFlags{
+		terraformPath: flags.String("module", "", "terraform path of the stack, used to route to its state bucket"),
+		roleARN:       flags.String("role", "", "role the stack is applied with, used to route to its state bucket"),
+	}
+}
+
+func (f stackFlags) backend(ctx context.Context, stateKey string) (tfexec.S3BackendConfig, error) {
+	return workflows.StateBackend(ctx, awsconfig.LoadConfig().Credentials, workflows.StackRef{
+		TerraformPath: *f.terraformPath,
+		RoleARN:       *f.roleARN,
+		StateKey:      stateKey,
+	})
+}
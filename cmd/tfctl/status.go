@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"go.temporal.io/sdk/client"
+
+	"github.com/dynajoe/temporal-terraform-demo/workflows"
+)
+
+// status prints what a workflow reports through its status query
+func status(c client.Client, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: status <workflow-id>")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	v, err := c.QueryWorkflow(ctx, args[0], "", workflows.StatusQuery)
+	if err != nil {
+		return err
+	}
+	var s workflows.Status
+	if err := v.Get(&s); err != nil {
+		return err
+	}
+
+	fmt.Printf("phase: %s\n", s.Phase)
+	if s.Frozen {
+		fmt.Printf("waiting on change freeze: %s\n", s.FrozenReason)
+	}
+	if s.Paused {
+		fmt.Printf("paused by %s: %s\n", s.PausedBy, s.PausedReason)
+	}
+	if len(s.WaitingFor) > 0 {
+		fmt.Printf("waiting for: %s\n", strings.Join(s.WaitingFor, ", "))
+	}
+	if len(s.Plan) > 0 {
+		fmt.Println("plan:")
+		for _, line := range s.Plan {
+			fmt.Printf("  %s\n", line)
+		}
+	}
+	if s.ApprovalTier != "" {
+		fmt.Printf("approval tier: %s\n", s.ApprovalTier)
+	}
+	if len(s.OverBudget) > 0 {
+		fmt.Println("over budget, approval needs OverrideBudget and a reason:")
+		for _, line := range s.OverBudget {
+			fmt.Printf("  %s\n", line)
+		}
+	}
+	for _, a := range s.PlanAnnotations {
+		fmt.Printf("%s from %s: %s%s\n", a.Severity, a.Annotator, planAnnotationSubject(a), a.Message)
+	}
+	switch {
+	case s.Outcome != nil && s.Outcome.By != "":
+		fmt.Printf("%s by %s at %s: %s\n", s.Outcome.Kind, s.Outcome.By, s.Outcome.Phase, s.Outcome.Reason)
+	case s.Outcome != nil:
+		fmt.Printf("%s at %s: %s\n", s.Outcome.Kind, s.Outcome.Phase, s.Outcome.Reason)
+	}
+	if len(s.Annotations) > 0 {
+		fmt.Println("annotations:")
+		for _, a := range s.Annotations {
+			fmt.Printf("  %s %s: %s\n", a.At.Format(time.RFC3339), a.By, a.Note)
+		}
+	}
+	return nil
+}
+
+func annotate(c client.Client, args []string) error {
+	if len(args) < 2 {
+		return errors.New("usage: annotate <workflow-id> <note>")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	return c.SignalWorkflow(ctx, args[0], "", workflows.AnnotateSignal, workflows.Annotation{
+		By:   os.Getenv("USER"),
+		Note: strings.Join(args[1:], " "),
+	})
+}
+
+// planAnnotationSubject prefixes an annotation with what it is about
+func planAnnotationSubject(a workflows.PlanAnnotation) string {
+	switch {
+	case a.StateKey != "" && a.Address != "":
+		return a.StateKey + " " + a.Address + ": "
+	case a.StateKey != "" || a.Address != "":
+		return a.StateKey + a.Address + ": "
+	}
+	return ""
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"path"
+	"strings"
+	"unicode"
+
+	"github.com/dynajoe/temporal-terraform-demo/tfconfig"
+	"github.com/dynajoe/temporal-terraform-demo/tfworkspace"
+)
+
+// initialisms are rendered in upper case in generated identifiers
+var initialisms = map[string]string{
+	"arn":  "ARN",
+	"cidr": "CIDR",
+	"dns":  "DNS",
+	"id":   "ID",
+	"ids":  "IDs",
+	"ip":   "IP",
+	"kms":  "KMS",
+	"ttl":  "TTL",
+	"url":  "URL",
+}
+
+// outputAccessors maps output types to the tfworkspace.ApplyOutput accessor
+// used to decode them
+var outputAccessors = map[string]struct {
+	GoType     string
+	Accessor   string
+	OutputType string
+}{
+	"string":       {"string", "String", "tfworkspace.OutputString"},
+	"list(string)": {"[]string", "StringList", "tfworkspace.OutputStringList"},
+	"number":       {"float64", "Number", "tfworkspace.OutputNumber"},
+	"bool":         {"bool", "Bool", "tfworkspace.OutputBool"},
+	"map(string)":  {"map[string]string", "StringMap", "tfworkspace.OutputStringMap"},
+}
+
+type generator struct {
+	buf     bytes.Buffer
+	structs []string
+}
+
+func generate(pkg string, source string, m *tfconfig.Module) ([]byte, error) {
+	prefix := goName(path.Base(m.Path))
+	g := &generator{}
+
+	g.printf("// Code generated by tfgen from %s. DO NOT EDIT.\n\n", source)
+	g.printf("package %s\n\n", pkg)
+	if len(m.Outputs) > 0 {
+		g.printf("import \"github.com/dynajoe/temporal-terraform-demo/tfworkspace\"\n\n")
+	}
+
+	// Run metadata and the unique suffix are filled in by the workspace, not
+	// by callers
+	var variables []tfconfig.Variable
+	for _, v := range m.Variables {
+		if v.Name != tfworkspace.MetadataVar && v.Name != tfworkspace.UniqueSuffixVar {
+			variables = append(variables, v)
+		}
+	}
+
+	// Input variables
+	g.printf("// %sVars are the input variables of %s\n", prefix, m.Path)
+	g.printf("type %sVars struct {\n", prefix)
+	for _, v := range variables {
+		tag := v.Name
+		if v.HasDefault {
+			tag += ",omitempty"
+		}
+		if v.Description != "" {
+			g.printf("// %s\n", v.Description)
+		}
+		g.printf("%s %s `json:\"%s\"`\n", goName(v.Name), g.goType(v.Type, prefix+goName(v.Name)), tag)
+	}
+	g.printf("}\n\n")
+
+	g.printf("// Vars converts v to the var map passed to terraform\n")
+	g.printf("func (v %sVars) Vars() map[string]interface{} {\n", prefix)
+	g.printf("vars := map[string]interface{}{}\n")
+	for _, v := range variables {
+		field := "v." + goName(v.Name)
+		if v.HasDefault {
+			// Leave unset variables out so the module default applies
+			g.printf("if !isZero(%s) {\n", field)
+			g.printf("vars[%q] = %s\n", v.Name, field)
+			g.printf("}\n")
+			continue
+		}
+		g.printf("vars[%q] = %s\n", v.Name, field)
+	}
+	g.printf("return vars\n")
+	g.printf("}\n\n")
+
+	// Outputs
+	if len(m.Outputs) > 0 {
+		g.printf("// %sOutputs are the outputs of %s\n", prefix, m.Path)
+		g.printf("type %sOutputs struct {\n", prefix)
+		for _, o := range m.Outputs {
+			acc, ok := outputAccessors[o.Type.String()]
+			if !ok {
+				return nil, fmt.Errorf("output %q has unsupported type %s, wrap its value in a type conversion such as tostring()", o.Name, o.Type)
+			}
+			g.printf("%s %s\n", goName(o.Name), acc.GoType)
+		}
+		g.printf("}\n\n")
+
+		g.printf("// %sOutputContract is the output contract enforced after applying %s\n", prefix, m.Path)
+		g.printf("func %sOutputContract() map[string]tfworkspace.OutputType {\n", prefix)
+		g.printf("return map[string]tfworkspace.OutputType{\n")
+		for _, o := range m.Outputs {
+			g.printf("%q: %s,\n", o.Name, outputAccessors[o.Type.String()].OutputType)
+		}
+		g.printf("}\n")
+		g.printf("}\n\n")
+
+		g.printf("// Decode%sOutputs extracts typed outputs from the result of an apply\n", prefix)
+		g.printf("func Decode%sOutputs(o tfworkspace.ApplyOutput) (%sOutputs, error) {\n", prefix, prefix)
+		g.printf("var out %sOutputs\n", prefix)
+		g.printf("var err error\n")
+		for _, o := range m.Outputs {
+			g.printf("if out.%s, err = o.%s(%q); err != nil {\n", goName(o.Name), outputAccessors[o.Type.String()].Accessor, o.Name)
+			g.printf("return %sOutputs{}, err\n", prefix)
+			g.printf("}\n")
+		}
+		g.printf("return out, nil\n")
+		g.printf("}\n\n")
+	}
+
+	for _, s := range g.structs {
+		g.buf.WriteString(s)
+	}
+
+	return format.Source(g.buf.Bytes())
+}
+
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+// goType returns the Go type for t, generating named structs for objects
+func (g *generator) goType(t *tfconfig.Type, name string) string {
+	switch t.Kind {
+	case tfconfig.KindString:
+		return "string"
+	case tfconfig.KindNumber:
+		return "int"
+	case tfconfig.KindBool:
+		return "bool"
+	case tfconfig.KindList, tfconfig.KindSet:
+		return "[]" + g.goType(t.Elem, strings.TrimSuffix(name, "s"))
+	case tfconfig.KindMap:
+		return "map[string]" + g.goType(t.Elem, name)
+	case tfconfig.KindObject:
+		var s strings.Builder
+		fmt.Fprintf(&s, "type %s struct {\n", name)
+		for _, a := range t.Attrs {
+			tag := a.Name
+			if a.Optional {
+				tag += ",omitempty"
+			}
+			fmt.Fprintf(&s, "%s %s `json:\"%s\"`\n", goName(a.Name), g.goType(a.Type, name+goName(a.Name)), tag)
+		}
+		s.WriteString("}\n\n")
+		g.structs = append(g.structs, s.String())
+		return name
+	default:
+		return "interface{}"
+	}
+}
+
+func goName(tfName string) string {
+	var b strings.Builder
+	for _, part := range strings.FieldsFunc(tfName, func(r rune) bool { return r == '_' || r == '-' }) {
+		if upper, ok := initialisms[part]; ok {
+			b.WriteString(upper)
+			continue
+		}
+		runes := []rune(part)
+		runes[0] = unicode.ToUpper(runes[0])
+		b.WriteString(string(runes))
+	}
+	return b.String()
+}
@@ -0,0 +1,76 @@
+// tfgen generates typed Go input and output structs for each terraform module
+// found under -dir so workflows don't have to build untyped var maps by hand.
+package main
+
+import (
+	"flag"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/dynajoe/temporal-terraform-demo/tfconfig"
+)
+
+func main() {
+	dir := flag.String("dir", "terraform", "directory containing terraform modules")
+	out := flag.String("out", "stacks", "directory to write generated go files to")
+	pkg := flag.String("package", "stacks", "package name of the generated go files")
+	flag.Parse()
+
+	modules, err := findModules(os.DirFS(*dir))
+	if err != nil {
+		log.Fatalf("error finding terraform modules: %v", err)
+	}
+
+	// Generated names are based on the module directory name, so two modules
+	// with the same name can't live in the same package
+	seen := map[string]string{}
+	for _, m := range modules {
+		name := path.Base(m.Path)
+		if other, ok := seen[name]; ok {
+			log.Fatalf("modules %s and %s would generate the same type names", other, m.Path)
+		}
+		seen[name] = m.Path
+
+		src, err := generate(*pkg, path.Join(path.Base(*dir), m.Path), m)
+		if err != nil {
+			log.Fatalf("error generating code for %s: %v", m.Path, err)
+		}
+
+		fileName := path.Join(*out, strings.ReplaceAll(name, "-", "_")+"_gen.go")
+		if err := os.WriteFile(fileName, src, 0644); err != nil {
+			log.Fatalf("error writing %s: %v", fileName, err)
+		}
+		log.Printf("generated %s from %s", fileName, m.Path)
+	}
+}
+
+func findModules(fsys fs.FS) ([]*tfconfig.Module, error) {
+	var modules []*tfconfig.Module
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return err
+		}
+
+		tfFiles, err := fs.Glob(fsys, path.Join(p, "*.tf"))
+		if err != nil || len(tfFiles) == 0 {
+			return err
+		}
+
+		m, err := tfconfig.LoadModule(fsys, p)
+		if err != nil {
+			return err
+		}
+		modules = append(modules, m)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(modules, func(i, j int) bool { return modules[i].Path < modules[j].Path })
+	return modules, nil
+}
@@ -0,0 +1,100 @@
+// Package compression compresses large workflow and activity payloads, such
+// as big var maps, before they are written to workflow history
+package compression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+
+	commonpb "go.temporal.io/api/common/v1"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/converter"
+)
+
+const encodingGzip = "binary/gzip"
+
+type (
+	Options struct {
+		// Threshold is the encoded size in bytes above which payloads are
+		// compressed. Zero only decompresses, so readers can be rolled out
+		// before writers.
+		Threshold int
+
+		// Metrics records the sizes of compressed payloads, optional
+		Metrics client.MetricsHandler
+	}
+
+	encoder struct {
+		options Options
+	}
+)
+
+// NewDataConverter returns the default data converter with compression
+func NewDataConverter(options Options) converter.DataConverter {
+	return converter.NewEncodingDataConverter(converter.GetDefaultDataConverter(), NewEncoder(options))
+}
+
+// NewEncoder returns a payload encoder that gzips payloads over the threshold
+func NewEncoder(options Options) converter.PayloadEncoder {
+	if options.Metrics == nil {
+		options.Metrics = client.MetricsNopHandler
+	}
+	return &encoder{options: options}
+}
+
+func (e *encoder) Encode(p *commonpb.Payload) error {
+	if e.options.Threshold <= 0 || p.Size() <= e.options.Threshold {
+		return nil
+	}
+
+	b, err := p.Marshal()
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err = w.Write(b)
+	if closeErr := w.Close(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	// Incompressible payloads are left alone
+	if buf.Len() >= len(b) {
+		return nil
+	}
+
+	e.options.Metrics.Counter("payload_compression_payloads").Inc(1)
+	e.options.Metrics.Counter("payload_compression_bytes_in").Inc(int64(len(b)))
+	e.options.Metrics.Counter("payload_compression_bytes_out").Inc(int64(buf.Len()))
+	e.options.Metrics.Gauge("payload_compression_ratio").Update(float64(len(b)) / float64(buf.Len()))
+
+	p.Metadata = map[string][]byte{converter.MetadataEncoding: []byte(encodingGzip)}
+	p.Data = buf.Bytes()
+	return nil
+}
+
+func (e *encoder) Decode(p *commonpb.Payload) error {
+	if string(p.Metadata[converter.MetadataEncoding]) != encodingGzip {
+		return nil
+	}
+
+	r, err := gzip.NewReader(bytes.NewReader(p.Data))
+	if err != nil {
+		return err
+	}
+	b, err := ioutil.ReadAll(r)
+	if closeErr := r.Close(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	p.Reset()
+	return p.Unmarshal(b)
+}
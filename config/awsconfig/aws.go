@@ -2,16 +2,101 @@ package awsconfig
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/processcreds"
+	"github.com/aws/aws-sdk-go-v2/credentials/ssocreds"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 )
 
+// expiryWindow refreshes cached credentials this long before they expire
+const expiryWindow = 5 * time.Minute
+
+// Options select where the worker's own credentials come from
+type Options struct {
+	// Profile is the shared config profile, which may be an SSO profile
+	Profile string
+
+	// CredentialProcess is a command that prints credentials in the
+	// credential_process format. It takes precedence over the profile's
+	// credentials.
+	CredentialProcess string
+}
+
+var options = Options{Profile: "joedev"}
+
+// Configure sets the options used by LoadConfig, empty fields keep the
+// current value
+func Configure(o Options) {
+	if o.Profile != "" {
+		options.Profile = o.Profile
+	}
+	if o.CredentialProcess != "" {
+		options.CredentialProcess = o.CredentialProcess
+	}
+}
+
 func LoadConfig() aws.Config {
-	awsConfig, err := config.LoadDefaultConfig(context.Background(), config.WithSharedConfigProfile("joedev"))
+	loadOptions := []func(*config.LoadOptions) error{
+		config.WithSharedConfigProfile(options.Profile),
+		config.WithCredentialsCacheOptions(func(o *aws.CredentialsCacheOptions) {
+			o.ExpiryWindow = expiryWindow
+		}),
+	}
+	if options.CredentialProcess != "" {
+		loadOptions = append(loadOptions, config.WithCredentialsProvider(aws.NewCredentialsCache(
+			processcreds.NewProvider(options.CredentialProcess),
+			func(o *aws.CredentialsCacheOptions) {
+				o.ExpiryWindow = expiryWindow
+			},
+		)))
+	}
+
+	awsConfig, err := config.LoadDefaultConfig(context.Background(), loadOptions...)
 	if err != nil {
-		log.Fatal("unable to load aws config")
+		log.Fatalf("unable to load aws config: %v", err)
+	}
+	if awsConfig.Credentials != nil {
+		awsConfig.Credentials = &ssoLoginHint{provider: awsConfig.Credentials, profile: options.Profile}
 	}
 	return awsConfig
 }
+
+// WithRole returns a copy of awsConfig whose credentials assume roleARN.
+// An empty roleARN returns awsConfig unchanged.
+func WithRole(awsConfig aws.Config, roleARN string) aws.Config {
+	if roleARN == "" {
+		return awsConfig
+	}
+	awsConfig.Credentials = aws.NewCredentialsCache(stscreds.NewAssumeRoleProvider(sts.NewFromConfig(awsConfig), roleARN))
+	return awsConfig
+}
+
+// ssoLoginHint explains how to recover when the SSO session behind a
+// profile has expired, the SDK can't refresh it without a browser login
+type ssoLoginHint struct {
+	provider aws.CredentialsProvider
+	profile  string
+}
+
+func (p *ssoLoginHint) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	creds, err := p.provider.Retrieve(ctx)
+	var tokenErr *ssocreds.InvalidTokenError
+	if errors.As(err, &tokenErr) {
+		return creds, fmt.Errorf("sso session for profile %s has expired, run `aws sso login --profile %s`: %w", p.profile, p.profile, err)
+	}
+	return creds, err
+}
+
+// Invalidate forces the next Retrieve to refresh cached credentials
+func (p *ssoLoginHint) Invalidate() {
+	if cache, ok := p.provider.(interface{ Invalidate() }); ok {
+		cache.Invalidate()
+	}
+}
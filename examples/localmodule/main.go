@@ -0,0 +1,117 @@
+// localmodule shows using tfactivity and tfworkspace in your own Temporal
+// app: it applies a terraform module from a local directory, storing state
+// in your own bucket, without any of the demo workflows.
+//
+//	go run ./examples/localmodule -dir ./my-modules -module network -bucket my-state
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/worker"
+	"go.temporal.io/sdk/workflow"
+
+	"github.com/dynajoe/temporal-terraform-demo/tfactivity"
+	"github.com/dynajoe/temporal-terraform-demo/tfexec"
+	"github.com/dynajoe/temporal-terraform-demo/tfworkspace"
+)
+
+const taskQueue = "localmodule-example"
+
+type ApplyInput struct {
+	Module string
+	Vars   map[string]interface{}
+}
+
+type activities struct {
+	dir    string
+	bucket string
+	region string
+}
+
+func ApplyWorkflow(ctx workflow.Context, input ApplyInput) (map[string]interface{}, error) {
+	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: time.Hour,
+		HeartbeatTimeout:    time.Minute,
+		RetryPolicy: &temporal.RetryPolicy{
+			MaximumAttempts: 3,
+		},
+	})
+
+	var a *activities
+	var output map[string]interface{}
+	err := workflow.ExecuteActivity(ctx, a.Apply, input).Get(ctx, &output)
+	return output, err
+}
+
+func (a *activities) Apply(ctx context.Context, input ApplyInput) (map[string]interface{}, error) {
+	awsConfig, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tfa := tfactivity.New(tfworkspace.Config{
+		TerraformPath: input.Module,
+		TerraformFS:   os.DirFS(a.dir),
+		S3Backend: tfexec.S3BackendConfig{
+			Credentials: awsConfig.Credentials,
+			Bucket:      a.bucket,
+			Region:      a.region,
+			Key:         input.Module + ".tfstate",
+		},
+	})
+
+	output, err := tfa.Apply(ctx, tfworkspace.ApplyInput{
+		AwsCredentials: awsConfig.Credentials,
+		Env: map[string]string{
+			"AWS_REGION": a.region,
+		},
+		Vars: input.Vars,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return output.Output, nil
+}
+
+func main() {
+	dir := flag.String("dir", ".", "directory containing terraform modules")
+	module := flag.String("module", "", "module directory within -dir to apply")
+	bucket := flag.String("bucket", "", "S3 bucket for terraform state")
+	region := flag.String("region", "us-west-2", "AWS region")
+	flag.Parse()
+
+	c, err := client.NewClient(client.Options{})
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	defer c.Close()
+
+	w := worker.New(c, taskQueue, worker.Options{})
+	w.RegisterWorkflow(ApplyWorkflow)
+	w.RegisterActivity(&activities{dir: *dir, bucket: *bucket, region: *region})
+	if err := w.Start(); err != nil {
+		log.Fatal(err.Error())
+	}
+	defer w.Stop()
+
+	run, err := c.ExecuteWorkflow(context.Background(), client.StartWorkflowOptions{TaskQueue: taskQueue}, ApplyWorkflow, ApplyInput{
+		Module: *module,
+	})
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	var output map[string]interface{}
+	if err := run.Get(context.Background(), &output); err != nil {
+		log.Fatal(err.Error())
+	}
+	log.Printf("outputs: %v", output)
+}
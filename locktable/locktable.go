@@ -0,0 +1,250 @@
+// Package locktable creates the DynamoDB table terraform's S3 backend uses
+// for state locking, and takes its locks and keeps its state digests for
+// writes made outside terraform. Requests are signed with SigV4 against the
+// DynamoDB JSON API.
+package locktable
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+// LockIDAttribute is the hash key the S3 backend locks on
+const LockIDAttribute = "LockID"
+
+// ErrLocked is returned when the state is already locked
+var ErrLocked = errors.New("state is locked")
+
+type (
+	// LockInfo is stored in the lock item the way terraform stores it, so
+	// terraform reports who holds a lock taken here
+	LockInfo struct {
+		ID        string
+		Operation string
+		Info      string
+		Who       string
+		Version   string
+		Created   time.Time
+		Path      string
+	}
+)
+
+type Client struct {
+	credentials aws.CredentialsProvider
+	region      string
+	httpClient  *http.Client
+	signer      *v4.Signer
+}
+
+func New(credentials aws.CredentialsProvider, region string) *Client {
+	return &Client{
+		credentials: credentials,
+		region:      region,
+		httpClient:  http.DefaultClient,
+		signer:      v4.NewSigner(),
+	}
+}
+
+// Create creates an on-demand lock table and waits for it to become active,
+// succeeding if the table already exists
+func (c *Client) Create(ctx context.Context, table string) error {
+	err := c.call(ctx, "CreateTable", map[string]interface{}{
+		"TableName":   table,
+		"BillingMode": "PAY_PER_REQUEST",
+		"AttributeDefinitions": []map[string]string{
+			{"AttributeName": LockIDAttribute, "AttributeType": "S"},
+		},
+		"KeySchema": []map[string]string{
+			{"AttributeName": LockIDAttribute, "KeyType": "HASH"},
+		},
+	}, nil)
+	if err != nil && !strings.Contains(err.Error(), "ResourceInUseException") {
+		return fmt.Errorf("error creating lock table %s: %w", table, err)
+	}
+
+	for {
+		var described struct {
+			Table struct {
+				TableStatus string
+			}
+		}
+		if err := c.call(ctx, "DescribeTable", map[string]string{"TableName": table}, &described); err != nil {
+			return fmt.Errorf("error describing lock table %s: %w", table, err)
+		}
+		if described.Table.TableStatus == "ACTIVE" {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+// PointInTimeRecovery reports whether the table can be restored to any
+// point in the last 35 days
+func (c *Client) PointInTimeRecovery(ctx context.Context, table string) (bool, error) {
+	var described struct {
+		ContinuousBackupsDescription struct {
+			PointInTimeRecoveryDescription struct {
+				PointInTimeRecoveryStatus string
+			}
+		}
+	}
+	if err := c.call(ctx, "DescribeContinuousBackups", map[string]string{"TableName": table}, &described); err != nil {
+		return false, fmt.Errorf("error describing backups of lock table %s: %w", table, err)
+	}
+	return described.ContinuousBackupsDescription.PointInTimeRecoveryDescription.PointInTimeRecoveryStatus == "ENABLED", nil
+}
+
+// EnablePointInTimeRecovery turns on point in time recovery for the table
+func (c *Client) EnablePointInTimeRecovery(ctx context.Context, table string) error {
+	err := c.call(ctx, "UpdateContinuousBackups", map[string]interface{}{
+		"TableName": table,
+		"PointInTimeRecoverySpecification": map[string]bool{
+			"PointInTimeRecoveryEnabled": true,
+		},
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("error enabling point in time recovery of lock table %s: %w", table, err)
+	}
+	return nil
+}
+
+// LockID is the item terraform locks the state object at bucket/key with
+func LockID(bucket string, key string) string {
+	return bucket + "/" + key
+}
+
+// Lock takes the state lock, failing with ErrLocked if it is held
+func (c *Client) Lock(ctx context.Context, table string, lockID string, info LockInfo) error {
+	infoJSON, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	err = c.call(ctx, "PutItem", map[string]interface{}{
+		"TableName": table,
+		"Item": map[string]interface{}{
+			LockIDAttribute: map[string]string{"S": lockID},
+			"Info":          map[string]string{"S": string(infoJSON)},
+		},
+		"ConditionExpression": "attribute_not_exists(LockID)",
+	}, nil)
+	if err != nil && strings.Contains(err.Error(), "ConditionalCheckFailedException") {
+		return fmt.Errorf("%s: %w", lockID, ErrLocked)
+	}
+	if err != nil {
+		return fmt.Errorf("error locking %s: %w", lockID, err)
+	}
+	return nil
+}
+
+// Unlock releases a lock taken by Lock with the same info
+func (c *Client) Unlock(ctx context.Context, table string, lockID string, info LockInfo) error {
+	infoJSON, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	err = c.call(ctx, "DeleteItem", map[string]interface{}{
+		"TableName": table,
+		"Key": map[string]interface{}{
+			LockIDAttribute: map[string]string{"S": lockID},
+		},
+		"ConditionExpression": "Info = :info",
+		"ExpressionAttributeValues": map[string]interface{}{
+			":info": map[string]string{"S": string(infoJSON)},
+		},
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("error unlocking %s: %w", lockID, err)
+	}
+	return nil
+}
+
+// PutDigest records the md5 of the state terraform checks what it reads
+// against
+func (c *Client) PutDigest(ctx context.Context, table string, lockID string, state []byte) error {
+	sum := md5.Sum(state)
+	err := c.call(ctx, "PutItem", map[string]interface{}{
+		"TableName": table,
+		"Item": map[string]interface{}{
+			LockIDAttribute: map[string]string{"S": lockID + "-md5"},
+			"Digest":        map[string]string{"S": hex.EncodeToString(sum[:])},
+		},
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("error storing state digest of %s: %w", lockID, err)
+	}
+	return nil
+}
+
+// DeleteDigest removes the digest of a deleted state
+func (c *Client) DeleteDigest(ctx context.Context, table string, lockID string) error {
+	err := c.call(ctx, "DeleteItem", map[string]interface{}{
+		"TableName": table,
+		"Key": map[string]interface{}{
+			LockIDAttribute: map[string]string{"S": lockID + "-md5"},
+		},
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("error deleting state digest of %s: %w", lockID, err)
+	}
+	return nil
+}
+
+func (c *Client) call(ctx context.Context, operation string, input interface{}, output interface{}) error {
+	body, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+
+	endpoint := fmt.Sprintf("https://dynamodb.%s.amazonaws.com/", c.region)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.0")
+	req.Header.Set("X-Amz-Target", "DynamoDB_20120810."+operation)
+
+	creds, err := c.credentials.Retrieve(ctx)
+	if err != nil {
+		return fmt.Errorf("error retrieving aws credentials: %w", err)
+	}
+
+	payloadHash := sha256.Sum256(body)
+	if err := c.signer.SignHTTP(ctx, creds, req, hex.EncodeToString(payloadHash[:]), "dynamodb", c.region, time.Now()); err != nil {
+		return fmt.Errorf("error signing dynamodb request: %w", err)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("dynamodb %s failed with status %d: %s", operation, resp.StatusCode, msg)
+	}
+
+	if output == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(output)
+}
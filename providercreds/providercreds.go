@@ -0,0 +1,83 @@
+// Package providercreds resolves credentials for terraform providers other
+// than AWS, such as cloudflare or github tokens, into environment variables
+// for a single run
+package providercreds
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrSecretNotFound is returned by a SecretStore for unknown secrets
+var ErrSecretNotFound = errors.New("secret not found")
+
+type (
+	// Provider returns environment variables that configure a provider
+	Provider interface {
+		Env(ctx context.Context) (map[string]string, error)
+	}
+
+	// SecretStore looks up secrets by name
+	SecretStore interface {
+		Secret(ctx context.Context, name string) (string, error)
+	}
+
+	// Secrets sets each environment variable in Names to the named secret,
+	// e.g. CLOUDFLARE_API_TOKEN: cloudflare/api-token
+	Secrets struct {
+		Store SecretStore
+		Names map[string]string
+	}
+
+	// FileStore reads secrets from files under a directory, such as
+	// mounted kubernetes secrets
+	FileStore struct {
+		Dir string
+	}
+
+	// EnvStore reads secrets from the worker's environment, secret names
+	// are upper cased with path separators and dashes replaced by underscores
+	EnvStore struct{}
+)
+
+func (s Secrets) Env(ctx context.Context) (map[string]string, error) {
+	env := make(map[string]string, len(s.Names))
+	for k, name := range s.Names {
+		v, err := s.Store.Secret(ctx, name)
+		if err != nil {
+			return nil, fmt.Errorf("error resolving %s: %w", k, err)
+		}
+		env[k] = v
+	}
+	return env, nil
+}
+
+func (s FileStore) Secret(ctx context.Context, name string) (string, error) {
+	p := filepath.Join(s.Dir, filepath.FromSlash(name))
+	if rel, err := filepath.Rel(s.Dir, p); err != nil || strings.HasPrefix(rel, "..") {
+		return "", fmt.Errorf("invalid secret name: %s", name)
+	}
+
+	data, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return "", fmt.Errorf("%s: %w", name, ErrSecretNotFound)
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (EnvStore) Secret(ctx context.Context, name string) (string, error) {
+	key := strings.ToUpper(strings.NewReplacer("/", "_", "-", "_", ".", "_").Replace(name))
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return "", fmt.Errorf("%s: %w", name, ErrSecretNotFound)
+	}
+	return v, nil
+}
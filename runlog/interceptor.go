@@ -0,0 +1,86 @@
+package runlog
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/interceptor"
+	"go.temporal.io/sdk/workflow"
+)
+
+type (
+	Options struct {
+		// DataConverter decodes signal payloads, defaults to the default
+		// data converter
+		DataConverter converter.DataConverter
+
+		// ApprovalSignal is the signal approving or rejecting a plan, its
+		// payload has Approved, By and Reason fields
+		ApprovalSignal string
+	}
+
+	workerInterceptor struct {
+		interceptor.WorkerInterceptorBase
+		log     *Log
+		options Options
+	}
+
+	workflowInterceptor struct {
+		interceptor.WorkflowInboundInterceptorBase
+		root *workerInterceptor
+	}
+)
+
+// NewInterceptor returns a worker interceptor that logs the decisions made
+// on plans. Bundles, plans and applies are logged by the workspaces.
+func NewInterceptor(l *Log, options Options) interceptor.WorkerInterceptor {
+	if options.DataConverter == nil {
+		options.DataConverter = converter.GetDefaultDataConverter()
+	}
+	return &workerInterceptor{log: l, options: options}
+}
+
+func (w *workerInterceptor) InterceptWorkflow(ctx workflow.Context, next interceptor.WorkflowInboundInterceptor) interceptor.WorkflowInboundInterceptor {
+	i := &workflowInterceptor{root: w}
+	i.Next = next
+	return i
+}
+
+func (w *workflowInterceptor) HandleSignal(ctx workflow.Context, in *interceptor.HandleSignalInput) error {
+	if in.SignalName == w.root.options.ApprovalSignal && w.root.options.ApprovalSignal != "" && !workflow.IsReplaying(ctx) {
+		var approval struct {
+			Approved bool
+			By       string
+			Reason   string
+		}
+		if err := w.root.options.DataConverter.FromPayloads(in.Arg, &approval); err == nil {
+			summary := "rejected"
+			if approval.Approved {
+				summary = "approved"
+			}
+			if approval.By != "" {
+				summary += " by " + approval.By
+			}
+
+			info := workflow.GetInfo(ctx)
+			runID := info.WorkflowExecution.ID + "_" + info.WorkflowExecution.RunID
+			entry := Entry{At: workflow.Now(ctx), Kind: KindDecision, Summary: summary}
+			if reason := strings.TrimSpace(approval.Reason); reason != "" {
+				entry.Details = []string{reason}
+			}
+
+			// Syncing must not hold up the workflow task
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+				defer cancel()
+				if err := w.root.log.Append(ctx, runID, entry); err != nil {
+					log.Printf("runlog: decision on %s not logged: %v", runID, err)
+				}
+			}()
+		}
+	}
+	return w.Next.HandleSignal(ctx, in)
+}
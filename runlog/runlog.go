@@ -0,0 +1,155 @@
+// Package runlog keeps an append-only log of what a workflow run did, from
+// building the module bundle to the result of the apply, as JSON lines. The
+// log is written locally and synced to S3 after every entry so a run can be
+// reviewed later without access to Temporal.
+package runlog
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dynajoe/temporal-terraform-demo/s3object"
+)
+
+const (
+	KindBundle   = "bundle"
+	KindPlan     = "plan"
+	KindDecision = "decision"
+	KindApply    = "apply"
+	KindDestroy  = "destroy"
+)
+
+// unsafeNameChars are replaced when naming a local log or segment
+var unsafeNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+type (
+	Entry struct {
+		At   time.Time `json:"at"`
+		Kind string    `json:"kind"`
+
+		// StateKey is the stack the entry is about, empty for decisions
+		// covering every stack of the run
+		StateKey string `json:"state_key,omitempty"`
+
+		// Summary is one line, e.g. "3 changes", Details are e.g. the
+		// planned changes
+		Summary string   `json:"summary"`
+		Details []string `json:"details,omitempty"`
+		Error   string   `json:"error,omitempty"`
+	}
+
+	// Log appends entries to a file per run under a local directory and
+	// mirrors each file to S3. Runs spread over workers have a segment per
+	// worker, Read merges them.
+	Log struct {
+		dir     string
+		store   s3object.Store
+		bucket  string
+		segment string
+		mu      sync.Mutex
+	}
+)
+
+// New returns a log writing to dir and syncing to bucket. The segment name
+// identifies this writer among the ones appending to the same run, e.g. the
+// hostname.
+func New(dir string, store s3object.Store, bucket string, segment string) (*Log, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("error creating run log directory: %w", err)
+	}
+	return &Log{
+		dir:     dir,
+		store:   store,
+		bucket:  bucket,
+		segment: unsafeNameChars.ReplaceAllString(segment, "_"),
+	}, nil
+}
+
+// Prefix is where the segments of a run's log are stored, runID is the
+// workflow ID and run ID joined by an underscore
+func Prefix(runID string) string {
+	return path.Join("runs", runID) + "/"
+}
+
+// Append adds an entry to the run's log and syncs it. The local file keeps
+// the entry even if the sync fails, the next append syncs it again.
+func (l *Log) Append(ctx context.Context, runID string, entry Entry) error {
+	if entry.At.IsZero() {
+		entry.At = time.Now()
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	name := filepath.Join(l.dir, unsafeNameChars.ReplaceAllString(runID, "_")+".jsonl")
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("error opening run log: %w", err)
+	}
+	_, err = f.Write(append(line, '\n'))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("error appending to run log: %w", err)
+	}
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return fmt.Errorf("error reading run log: %w", err)
+	}
+	if err := l.store.Put(ctx, l.bucket, Prefix(runID)+l.segment+".jsonl", data); err != nil {
+		return fmt.Errorf("error syncing run log: %w", err)
+	}
+	return nil
+}
+
+// Read returns the entries of a run from every segment, oldest first
+func Read(ctx context.Context, store s3object.Store, bucket string, runID string) ([]Entry, error) {
+	keys, err := store.List(ctx, bucket, Prefix(runID))
+	if err != nil {
+		return nil, fmt.Errorf("error listing run log: %w", err)
+	}
+
+	var entries []Entry
+	for _, key := range keys {
+		data, err := store.Get(ctx, bucket, key)
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		scanner.Buffer(nil, 1024*1024)
+		for scanner.Scan() {
+			if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+				continue
+			}
+			var entry Entry
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+				return nil, fmt.Errorf("error decoding run log %s: %w", key, err)
+			}
+			entries = append(entries, entry)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("error reading run log %s: %w", key, err)
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].At.Before(entries[j].At)
+	})
+	return entries, nil
+}
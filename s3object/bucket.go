@@ -0,0 +1,204 @@
+package s3object
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// BucketSecurity is the security configuration of a bucket as far as the
+// state buckets rely on it
+type BucketSecurity struct {
+	Versioning          bool
+	Encryption          bool
+	PublicAccessBlocked bool
+
+	// TLSOnly is set when the bucket policy denies requests not sent over
+	// TLS
+	TLSOnly bool
+}
+
+// CreateBucket creates a bucket in the client's region, succeeding if the
+// caller already owns it
+func (c *Client) CreateBucket(ctx context.Context, bucket string) error {
+	// us-east-1 rejects an explicit location constraint
+	var body []byte
+	if c.region != "us-east-1" {
+		body = []byte(fmt.Sprintf(`<CreateBucketConfiguration xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><LocationConstraint>%s</LocationConstraint></CreateBucketConfiguration>`, c.region))
+	}
+
+	resp, err := c.do(ctx, http.MethodPut, bucket, "", nil, body)
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && strings.Contains(statusErr.Body, "BucketAlreadyOwnedByYou") {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// EnableVersioning keeps previous versions of every object in the bucket
+func (c *Client) EnableVersioning(ctx context.Context, bucket string) error {
+	return c.putBucketConfig(ctx, bucket, "versioning",
+		`<VersioningConfiguration xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Status>Enabled</Status></VersioningConfiguration>`)
+}
+
+// EnableEncryption encrypts new objects with S3 managed keys by default
+func (c *Client) EnableEncryption(ctx context.Context, bucket string) error {
+	return c.putBucketConfig(ctx, bucket, "encryption",
+		`<ServerSideEncryptionConfiguration xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Rule><ApplyServerSideEncryptionByDefault><SSEAlgorithm>AES256</SSEAlgorithm></ApplyServerSideEncryptionByDefault></Rule></ServerSideEncryptionConfiguration>`)
+}
+
+// BlockPublicAccess prevents the bucket and its objects from being made public
+func (c *Client) BlockPublicAccess(ctx context.Context, bucket string) error {
+	return c.putBucketConfig(ctx, bucket, "publicAccessBlock",
+		`<PublicAccessBlockConfiguration xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><BlockPublicAcls>true</BlockPublicAcls><IgnorePublicAcls>true</IgnorePublicAcls><BlockPublicPolicy>true</BlockPublicPolicy><RestrictPublicBuckets>true</RestrictPublicBuckets></PublicAccessBlockConfiguration>`)
+}
+
+// RequireTLS replaces the bucket policy with one denying requests not sent
+// over TLS
+func (c *Client) RequireTLS(ctx context.Context, bucket string) error {
+	return c.putBucketConfig(ctx, bucket, "policy", fmt.Sprintf(`{"Version":"2012-10-17","Statement":[{"Sid":"DenyInsecureTransport","Effect":"Deny","Principal":"*","Action":"s3:*","Resource":["arn:aws:s3:::%[1]s","arn:aws:s3:::%[1]s/*"],"Condition":{"Bool":{"aws:SecureTransport":"false"}}}]}`, bucket))
+}
+
+// Security reads the bucket's versioning, default encryption, public access
+// block and bucket policy
+func (c *Client) Security(ctx context.Context, bucket string) (BucketSecurity, error) {
+	var security BucketSecurity
+
+	// Every existing bucket has a versioning configuration, so a missing
+	// bucket is an error here rather than an insecure one
+	var versioning struct {
+		Status string
+	}
+	if err := c.getBucketConfig(ctx, bucket, "versioning", func(data []byte) error {
+		return xml.Unmarshal(data, &versioning)
+	}); err != nil {
+		return BucketSecurity{}, err
+	}
+	security.Versioning = versioning.Status == "Enabled"
+
+	var encryption struct {
+		Rules []struct {
+			ApplyServerSideEncryptionByDefault struct {
+				SSEAlgorithm string
+			}
+		} `xml:"Rule"`
+	}
+	err := c.getBucketConfig(ctx, bucket, "encryption", func(data []byte) error {
+		return xml.Unmarshal(data, &encryption)
+	})
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return BucketSecurity{}, err
+	}
+	for _, rule := range encryption.Rules {
+		if rule.ApplyServerSideEncryptionByDefault.SSEAlgorithm != "" {
+			security.Encryption = true
+		}
+	}
+
+	var block struct {
+		BlockPublicAcls       bool
+		IgnorePublicAcls      bool
+		BlockPublicPolicy     bool
+		RestrictPublicBuckets bool
+	}
+	err = c.getBucketConfig(ctx, bucket, "publicAccessBlock", func(data []byte) error {
+		return xml.Unmarshal(data, &block)
+	})
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return BucketSecurity{}, err
+	}
+	security.PublicAccessBlocked = block.BlockPublicAcls && block.IgnorePublicAcls && block.BlockPublicPolicy && block.RestrictPublicBuckets
+
+	var policy bucketPolicy
+	err = c.getBucketConfig(ctx, bucket, "policy", func(data []byte) error {
+		return json.Unmarshal(data, &policy)
+	})
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return BucketSecurity{}, err
+	}
+	security.TLSOnly = policy.deniesInsecureTransport()
+
+	return security, nil
+}
+
+// bucketPolicy is the part of a bucket policy needed to tell whether it
+// denies insecure transport. Single values may be given without a list.
+type bucketPolicy struct {
+	Statement []struct {
+		Effect    string
+		Action    stringOrList
+		Condition map[string]map[string]stringOrList
+	}
+}
+
+func (p bucketPolicy) deniesInsecureTransport() bool {
+	for _, statement := range p.Statement {
+		if statement.Effect != "Deny" || !statement.Action.contains("s3:*") {
+			continue
+		}
+		if statement.Condition["Bool"]["aws:SecureTransport"].contains("false") {
+			return true
+		}
+	}
+	return false
+}
+
+type stringOrList []string
+
+func (l *stringOrList) UnmarshalJSON(data []byte) error {
+	var values []interface{}
+	if err := json.Unmarshal(data, &values); err != nil {
+		var value interface{}
+		if err := json.Unmarshal(data, &value); err != nil {
+			return err
+		}
+		values = []interface{}{value}
+	}
+	for _, v := range values {
+		*l = append(*l, fmt.Sprint(v))
+	}
+	return nil
+}
+
+func (l stringOrList) contains(v string) bool {
+	for _, value := range l {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Client) getBucketConfig(ctx context.Context, bucket string, subresource string, decode func([]byte) error) error {
+	resp, err := c.do(ctx, http.MethodGet, bucket, "", url.Values{subresource: []string{""}}, nil)
+	if err != nil {
+		return fmt.Errorf("error reading bucket %s: %w", subresource, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if err := decode(data); err != nil {
+		return fmt.Errorf("error decoding bucket %s: %w", subresource, err)
+	}
+	return nil
+}
+
+func (c *Client) putBucketConfig(ctx context.Context, bucket string, subresource string, config string) error {
+	resp, err := c.do(ctx, http.MethodPut, bucket, "", url.Values{subresource: []string{""}}, []byte(config))
+	if err != nil {
+		return fmt.Errorf("error setting bucket %s: %w", subresource, err)
+	}
+	return resp.Body.Close()
+}
@@ -0,0 +1,158 @@
+package s3object
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Dir is a Store keeping objects as files under Root/<bucket>/<key>, so
+// the workflows can run on a laptop without S3
+type Dir struct {
+	Root string
+}
+
+// File returns the file an object is kept in
+func (d Dir) File(bucket string, key string) (string, error) {
+	if bucket == "" || strings.Contains(bucket, "/") || key == "" || path.Clean("/"+key) != "/"+key {
+		return "", fmt.Errorf("invalid object s3://%s/%s", bucket, key)
+	}
+	return filepath.Join(d.Root, bucket, filepath.FromSlash(key)), nil
+}
+
+func (d Dir) Get(_ context.Context, bucket string, key string) ([]byte, error) {
+	name, err := d.File(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("s3://%s/%s: %w", bucket, key, ErrNotFound)
+	}
+	return data, err
+}
+
+func (d Dir) Exists(_ context.Context, bucket string, key string) (bool, error) {
+	name, err := d.File(bucket, key)
+	if err != nil {
+		return false, err
+	}
+	_, err = os.Stat(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Put replaces the object atomically, readers never see a partial file
+func (d Dir) Put(_ context.Context, bucket string, key string, data []byte) error {
+	name, err := d.File(bucket, key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(name), ".put-*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), name)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// Delete succeeds whether or not the object exists, like S3
+func (d Dir) Delete(_ context.Context, bucket string, key string) error {
+	name, err := d.File(bucket, key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (d Dir) List(ctx context.Context, bucket string, prefix string) ([]string, error) {
+	objects, err := d.ListObjects(ctx, bucket, prefix)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, len(objects))
+	for i, o := range objects {
+		keys[i] = o.Key
+	}
+	return keys, nil
+}
+
+func (d Dir) ListObjects(_ context.Context, bucket string, prefix string) ([]ObjectInfo, error) {
+	root := filepath.Join(d.Root, bucket)
+	var objects []ObjectInfo
+	err := filepath.WalkDir(root, func(name string, entry fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && name == root {
+			return fs.SkipDir
+		}
+		// Hidden files are pending puts and terraform's lock info
+		if err != nil || entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			return err
+		}
+		rel, err := filepath.Rel(root, name)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, ObjectInfo{Key: key, Size: info.Size(), LastModified: info.ModTime().UTC()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+func (d Dir) PutFile(ctx context.Context, bucket string, key string, name string) error {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	return d.Put(ctx, bucket, key, data)
+}
+
+func (d Dir) GetFile(_ context.Context, bucket string, key string, name string) (int64, error) {
+	src, err := d.File(bucket, key)
+	if err != nil {
+		return 0, err
+	}
+	f, err := os.Open(src)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, fmt.Errorf("s3://%s/%s: %w", bucket, key, ErrNotFound)
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return writeFile(name, f)
+}
@@ -0,0 +1,316 @@
+package s3object
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+// ErrNotFound is returned when the requested object does not exist
+var ErrNotFound = errors.New("s3 object not found")
+
+// unsignedPayload is signed in place of the hash of a streamed body
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// StatusError is returned when S3 responds with an error status
+type StatusError struct {
+	Method     string
+	Bucket     string
+	Key        string
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("s3 %s s3://%s/%s failed with status %d: %s", e.Method, e.Bucket, e.Key, e.StatusCode, e.Body)
+}
+
+// IsThrottled reports whether S3 asked the caller to slow down
+func IsThrottled(err error) bool {
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) {
+		return false
+	}
+	return statusErr.StatusCode == http.StatusServiceUnavailable ||
+		statusErr.StatusCode == http.StatusTooManyRequests ||
+		strings.Contains(statusErr.Body, "SlowDown")
+}
+
+// Client is a minimal S3 object client for reading and writing small objects
+// such as terraform state, signed with SigV4.
+type Client struct {
+	credentials aws.CredentialsProvider
+	region      string
+	httpClient  *http.Client
+	signer      *v4.Signer
+
+	// kmsKeyID encrypts the objects put with a customer managed KMS key
+	kmsKeyID string
+}
+
+func New(credentials aws.CredentialsProvider, region string) *Client {
+	return &Client{
+		credentials: credentials,
+		region:      region,
+		httpClient:  http.DefaultClient,
+		signer: v4.NewSigner(func(o *v4.SignerOptions) {
+			// The path is escaped once, by escapePath, and signed as sent
+			o.DisableURIPathEscaping = true
+		}),
+	}
+}
+
+// WithKMSKey returns a client encrypting the objects it puts with the
+// customer managed KMS key rather than the bucket's default encryption. An
+// empty key keeps the default.
+func (c *Client) WithKMSKey(keyID string) *Client {
+	withKey := *c
+	withKey.kmsKeyID = keyID
+	return &withKey
+}
+
+func (c *Client) Get(ctx context.Context, bucket string, key string) ([]byte, error) {
+	resp, err := c.do(ctx, http.MethodGet, bucket, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+// Exists sends a HEAD request, it needs the same permission as Get
+func (c *Client) Exists(ctx context.Context, bucket string, key string) (bool, error) {
+	resp, err := c.do(ctx, http.MethodHead, bucket, key, nil, nil)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, resp.Body.Close()
+}
+
+func (c *Client) Put(ctx context.Context, bucket string, key string, data []byte) error {
+	resp, err := c.do(ctx, http.MethodPut, bucket, key, nil, data)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// PutFile uploads a file without reading it into memory. The payload isn't
+// signed, TLS protects it in transit.
+func (c *Client) PutFile(ctx context.Context, bucket string, key string, name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	req, err := c.newRequest(ctx, http.MethodPut, bucket, key, nil)
+	if err != nil {
+		return err
+	}
+	req.Body = ioutil.NopCloser(f)
+	req.ContentLength = info.Size()
+	if info.Size() == 0 {
+		req.Body = http.NoBody
+	}
+
+	resp, err := c.send(ctx, req, unsignedPayload, bucket, key)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// GetFile downloads an object to a file without reading it into memory and
+// returns its size
+func (c *Client) GetFile(ctx context.Context, bucket string, key string, name string) (int64, error) {
+	resp, err := c.do(ctx, http.MethodGet, bucket, key, nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	return writeFile(name, resp.Body)
+}
+
+func (c *Client) Delete(ctx context.Context, bucket string, key string) error {
+	resp, err := c.do(ctx, http.MethodDelete, bucket, key, nil, nil)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// List returns the keys of all objects under prefix in lexical order
+func (c *Client) List(ctx context.Context, bucket string, prefix string) ([]string, error) {
+	objects, err := c.ListObjects(ctx, bucket, prefix)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, len(objects))
+	for i, object := range objects {
+		keys[i] = object.Key
+	}
+	return keys, nil
+}
+
+// ListObjects returns all objects under prefix in lexical order of their keys
+func (c *Client) ListObjects(ctx context.Context, bucket string, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	continuationToken := ""
+	for {
+		query := url.Values{
+			"list-type": []string{"2"},
+			"prefix":    []string{prefix},
+		}
+		if continuationToken != "" {
+			query.Set("continuation-token", continuationToken)
+		}
+
+		resp, err := c.do(ctx, http.MethodGet, bucket, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			Contents []struct {
+				Key          string
+				Size         int64
+				LastModified time.Time
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error decoding s3 object list: %w", err)
+		}
+
+		for _, object := range result.Contents {
+			objects = append(objects, ObjectInfo{Key: object.Key, Size: object.Size, LastModified: object.LastModified})
+		}
+
+		if !result.IsTruncated {
+			return objects, nil
+		}
+		continuationToken = result.NextContinuationToken
+	}
+}
+
+func (c *Client) do(ctx context.Context, method string, bucket string, key string, query url.Values, body []byte) (*http.Response, error) {
+	req, err := c.newRequest(ctx, method, bucket, key, query)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		req.ContentLength = int64(len(body))
+
+		// Required by bucket configuration requests, checked on all others
+		bodyMD5 := md5.Sum(body)
+		req.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(bodyMD5[:]))
+	}
+
+	payloadHash := sha256.Sum256(body)
+	return c.send(ctx, req, hex.EncodeToString(payloadHash[:]), bucket, key)
+}
+
+func (c *Client) newRequest(ctx context.Context, method string, bucket string, key string, query url.Values) (*http.Request, error) {
+	objectPath := "/" + strings.TrimPrefix(key, "/")
+	objectURL := url.URL{
+		Scheme:   "https",
+		Host:     fmt.Sprintf("%s.s3.%s.amazonaws.com", bucket, c.region),
+		Path:     objectPath,
+		RawPath:  escapePath(objectPath),
+		RawQuery: query.Encode(),
+	}
+	req, err := http.NewRequestWithContext(ctx, method, objectURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	// Only object puts are encrypted, bucket requests have no key
+	if method == http.MethodPut && c.kmsKeyID != "" && key != "" {
+		req.Header.Set("X-Amz-Server-Side-Encryption", "aws:kms")
+		req.Header.Set("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id", c.kmsKeyID)
+	}
+	return req, nil
+}
+
+// escapePath URI encodes an object path the way S3 expects it in requests
+// and their signature, every byte but unreserved characters and the slashes
+// between segments. url.URL leaves characters such as + unescaped.
+func escapePath(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if c == '/' || c == '-' || c == '_' || c == '.' || c == '~' ||
+			('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+// send signs and sends a request whose body hashes to payloadHash
+func (c *Client) send(ctx context.Context, req *http.Request, payloadHash string, bucket string, key string) (*http.Response, error) {
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	creds, err := c.credentials.Retrieve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.signer.SignHTTP(ctx, creds, req, payloadHash, "s3", c.region, time.Now()); err != nil {
+		return nil, fmt.Errorf("error signing s3 request: %w", err)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	method := req.Method
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		resp.Body.Close()
+		return nil, fmt.Errorf("s3://%s/%s: %w", bucket, key, ErrNotFound)
+	case resp.StatusCode >= 300:
+		defer resp.Body.Close()
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, &StatusError{
+			Method:     method,
+			Bucket:     bucket,
+			Key:        key,
+			StatusCode: resp.StatusCode,
+			Body:       string(msg),
+		}
+	}
+
+	return resp, nil
+}
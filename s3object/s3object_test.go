@@ -0,0 +1,53 @@
+package s3object
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingTransport answers every request with an empty object and keeps
+// the requests
+type recordingTransport struct {
+	requests []*http.Request
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.requests = append(t.requests, req)
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       ioutil.NopCloser(strings.NewReader("")),
+		Request:    req,
+	}, nil
+}
+
+func TestClientEscapesKeys(t *testing.T) {
+	transport := &recordingTransport{}
+	c := New(aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+		return aws.Credentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret"}, nil
+	}), "us-east-1")
+	c.httpClient = &http.Client{Transport: transport}
+
+	for key, path := range map[string]string{
+		"network/vpc.tfstate":            "/network/vpc.tfstate",
+		"reports/run 1/apply.json":       "/reports/run%201/apply.json",
+		"plans/a+b=c.tfplan":             "/plans/a%2Bb%3Dc.tfplan",
+		"tenants/société/vpc.tfstate":    "/tenants/soci%C3%A9t%C3%A9/vpc.tfstate",
+		"runs/wf!(1)*/outputs~v1.json":   "/runs/wf%21%281%29%2A/outputs~v1.json",
+		"/leading/slash.json":            "/leading/slash.json",
+		"snapshots/run:1@2020,1.tfstate": "/snapshots/run%3A1%402020%2C1.tfstate",
+	} {
+		_, err := c.Get(context.Background(), "state", key)
+		require.NoError(t, err)
+
+		req := transport.requests[len(transport.requests)-1]
+		require.Equal(t, path, req.URL.EscapedPath(), key)
+		require.Equal(t, "state.s3.us-east-1.amazonaws.com", req.URL.Host)
+		require.Contains(t, req.Header.Get("Authorization"), "AWS4-HMAC-SHA256")
+	}
+}
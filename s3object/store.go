@@ -0,0 +1,162 @@
+package s3object
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store is the object storage used for state and the artifacts kept next to
+// it. *Client stores objects in S3, Dir in local files and Memory keeps them
+// in memory for tests.
+type Store interface {
+	Get(ctx context.Context, bucket string, key string) ([]byte, error)
+
+	// Exists checks for an object without reading it
+	Exists(ctx context.Context, bucket string, key string) (bool, error)
+
+	Put(ctx context.Context, bucket string, key string, data []byte) error
+	Delete(ctx context.Context, bucket string, key string) error
+	List(ctx context.Context, bucket string, prefix string) ([]string, error)
+	ListObjects(ctx context.Context, bucket string, prefix string) ([]ObjectInfo, error)
+
+	// PutFile and GetFile transfer large objects such as saved plans
+	// between a file and the store without holding them in memory
+	PutFile(ctx context.Context, bucket string, key string, name string) error
+	GetFile(ctx context.Context, bucket string, key string, name string) (int64, error)
+}
+
+// ObjectInfo describes a stored object
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+var (
+	_ Store = (*Client)(nil)
+	_ Store = Dir{}
+	_ Store = (*Memory)(nil)
+)
+
+// Memory is an in-memory Store, so code that reads and writes state objects
+// can be exercised without S3
+type Memory struct {
+	mu       sync.Mutex
+	objects  map[string][]byte
+	modified map[string]time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{objects: map[string][]byte{}, modified: map[string]time.Time{}}
+}
+
+func (m *Memory) Get(_ context.Context, bucket string, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	data, ok := m.objects[bucket+"/"+key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte{}, data...), nil
+}
+
+func (m *Memory) Exists(_ context.Context, bucket string, key string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, ok := m.objects[bucket+"/"+key]
+	return ok, nil
+}
+
+func (m *Memory) Put(_ context.Context, bucket string, key string, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.objects[bucket+"/"+key] = append([]byte{}, data...)
+	m.modified[bucket+"/"+key] = time.Now().UTC()
+	return nil
+}
+
+// Delete succeeds whether or not the object exists, like S3
+func (m *Memory) Delete(_ context.Context, bucket string, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.objects, bucket+"/"+key)
+	delete(m.modified, bucket+"/"+key)
+	return nil
+}
+
+func (m *Memory) List(_ context.Context, bucket string, prefix string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var keys []string
+	for k := range m.objects {
+		if key := strings.TrimPrefix(k, bucket+"/"); key != k && strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (m *Memory) ListObjects(ctx context.Context, bucket string, prefix string) ([]ObjectInfo, error) {
+	keys, err := m.List(ctx, bucket, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	objects := make([]ObjectInfo, len(keys))
+	for i, key := range keys {
+		objects[i] = ObjectInfo{
+			Key:          key,
+			Size:         int64(len(m.objects[bucket+"/"+key])),
+			LastModified: m.modified[bucket+"/"+key],
+		}
+	}
+	return objects, nil
+}
+
+func (m *Memory) PutFile(ctx context.Context, bucket string, key string, name string) error {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	return m.Put(ctx, bucket, key, data)
+}
+
+func (m *Memory) GetFile(ctx context.Context, bucket string, key string, name string) (int64, error) {
+	data, err := m.Get(ctx, bucket, key)
+	if err != nil {
+		return 0, err
+	}
+	return writeFile(name, bytes.NewReader(data))
+}
+
+// writeFile copies r to a new file, no partial file is left on failure
+func writeFile(name string, r io.Reader) (int64, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(name)
+		return 0, err
+	}
+	return n, nil
+}
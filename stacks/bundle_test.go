@@ -0,0 +1,193 @@
+package stacks
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/require"
+
+	"github.com/dynajoe/temporal-terraform-demo/s3object"
+	"github.com/dynajoe/temporal-terraform-demo/terraform"
+	"github.com/dynajoe/temporal-terraform-demo/tfexec"
+	"github.com/dynajoe/temporal-terraform-demo/tfworkspace"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden bundles in testdata")
+
+// bundleVars are the canned vars each embedded stack is bundled with
+var bundleVars = map[string]map[string]interface{}{
+	"aws/route53_record": Route53RecordVars{
+		ZoneID: "Z123", Name: "api.example.com", Type: "A", Records: []string{"10.0.0.10"},
+	}.Vars(),
+	"aws/route53_zone":          Route53ZoneVars{ZoneName: "example.com", VpcID: "vpc-123"}.Vars(),
+	"aws/state_bucket_security": StateBucketSecurityVars{Bucket: "state"}.Vars(),
+	"aws/subnet": SubnetVars{VpcID: "vpc-123", Subnets: []SubnetSubnet{
+		{Name: "a", AvailabilityZone: "us-east-1a", CIDRBlock: "10.0.0.0/24"},
+	}}.Vars(),
+	"aws/tgw_attachment": TgwAttachmentVars{
+		Name: "main", TransitGatewayID: "tgw-123", VpcID: "vpc-123", SubnetIDs: []string{"subnet-a"},
+	}.Vars(),
+	"aws/vpc":         VpcVars{CIDRBlock: "10.0.0.0/16", Name: "main"}.Vars(),
+	"aws/vpc_peering": VpcPeeringVars{Name: "main", RequesterVpcID: "vpc-123", AccepterVpcID: "vpc-456"}.Vars(),
+	"aws/vpc_peering_routes": VpcPeeringRoutesVars{
+		VpcID: "vpc-123", PeerVpcID: "vpc-456", PeeringConnectionID: "pcx-123",
+	}.Vars(),
+}
+
+// fakeTerraform is a terraform that does nothing, so applies run through
+// tfexec up to executing it
+const fakeTerraform = `#!/bin/sh
+if [ "$1" = output ]; then echo '{}'; fi
+exit 0
+`
+
+// bundleExecutor keeps a copy of the working directory as terraform was
+// about to apply it
+type bundleExecutor struct {
+	tfexec.Executor
+	workDir string
+	bundle  map[string]string
+}
+
+func (e *bundleExecutor) Apply(ctx context.Context, params tfexec.ApplyParams) error {
+	if err := e.Executor.Apply(ctx, params); err != nil {
+		return err
+	}
+
+	e.bundle = map[string]string{}
+	return filepath.WalkDir(e.workDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(e.workDir, p)
+		if err != nil {
+			return err
+		}
+		// The backend's credentials are written fresh for every run
+		if d.IsDir() && name == tfexec.BackendCredentialsDir {
+			return fs.SkipDir
+		}
+		if d.IsDir() {
+			return nil
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		e.bundle[filepath.ToSlash(name)] = string(data)
+		return nil
+	})
+}
+
+// applyBundle applies the stack and returns what its working directory held,
+// with the run's temporary directories replaced by placeholders
+func applyBundle(t *testing.T, modulePath string, backend tfexec.S3BackendConfig) []byte {
+	newTerraform := tfexec.LazyFromPath()
+	var executor *bundleExecutor
+	w := tfworkspace.New(tfworkspace.Config{
+		TerraformPath: modulePath,
+		NewExecutor: func(workDir string) (tfexec.Executor, error) {
+			tf, err := newTerraform(workDir)
+			if err != nil {
+				return nil, err
+			}
+			executor = &bundleExecutor{Executor: tf, workDir: workDir}
+			return executor, nil
+		},
+		S3Backend:        backend,
+		RunID:            "run-1",
+		Metadata:         map[string]string{"managed_by": "temporal-terraform-demo", "workflow_id": "bundle-test"},
+		UniqueSuffixSeed: "bundle-test",
+		WorkspaceRoot:    t.TempDir(),
+	})
+
+	_, err := w.Apply(context.Background(), tfworkspace.ApplyInput{Vars: bundleVars[modulePath]})
+	require.NoError(t, err)
+
+	var names []string
+	for name := range executor.bundle {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	replacements := []string{filepath.ToSlash(executor.workDir), "$WORKDIR"}
+	if backend.LocalDir != "" {
+		replacements = append(replacements, filepath.ToSlash(backend.LocalDir), "$LOCALDIR")
+	}
+	placeholders := strings.NewReplacer(replacements...)
+	var buf bytes.Buffer
+	for _, name := range names {
+		buf.WriteString("-- " + name + " --\n")
+		buf.WriteString(placeholders.Replace(executor.bundle[name]))
+		if !strings.HasSuffix(executor.bundle[name], "\n") {
+			buf.WriteString("\n")
+		}
+	}
+	return buf.Bytes()
+}
+
+// checkGolden compares a bundle against its golden file, or rewrites the
+// file with -update
+func checkGolden(t *testing.T, name string, bundle []byte) {
+	golden := filepath.Join("testdata", "bundles", name+".golden")
+	if *update {
+		require.NoError(t, os.MkdirAll(filepath.Dir(golden), 0755))
+		require.NoError(t, os.WriteFile(golden, bundle, 0644))
+		return
+	}
+
+	want, err := os.ReadFile(golden)
+	require.NoError(t, err, "run go test ./stacks -update to create the golden bundle")
+	require.Equal(t, string(want), string(bundle), "run go test ./stacks -update if the change is intended")
+}
+
+func TestBundles(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake terraform is a shell script")
+	}
+	bin := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(bin, "terraform"), []byte(fakeTerraform), 0755))
+	t.Setenv("PATH", bin)
+
+	modules, err := fs.ReadDir(terraform.FS, "aws")
+	require.NoError(t, err)
+	for _, m := range modules {
+		modulePath := path.Join("aws", m.Name())
+		t.Run(m.Name(), func(t *testing.T) {
+			require.Contains(t, bundleVars, modulePath, "add canned vars for the new stack")
+
+			backend := tfexec.S3BackendConfig{
+				Bucket:        "state",
+				Key:           path.Join("bundles", m.Name()+".tfstate"),
+				Region:        "us-east-1",
+				DynamoDBTable: "terraform-locks",
+				Credentials:   aws.NewCredentialsCache(aws.CredentialsProviderFunc(testCredentials)),
+				Store:         s3object.NewMemory(),
+			}
+			checkGolden(t, m.Name(), applyBundle(t, modulePath, backend))
+		})
+	}
+
+	t.Run("local", func(t *testing.T) {
+		backend := tfexec.S3BackendConfig{
+			Bucket:   "state",
+			Key:      "bundles/vpc.tfstate",
+			LocalDir: t.TempDir(),
+		}
+		checkGolden(t, "vpc-local", applyBundle(t, "aws/vpc", backend))
+	})
+}
+
+func testCredentials(context.Context) (aws.Credentials, error) {
+	return aws.Credentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret"}, nil
+}
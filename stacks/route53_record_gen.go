@@ -0,0 +1,49 @@
+// Code generated by tfgen from terraform/aws/route53_record. DO NOT EDIT.
+
+package stacks
+
+import "github.com/dynajoe/temporal-terraform-demo/tfworkspace"
+
+// Route53RecordVars are the input variables of aws/route53_record
+type Route53RecordVars struct {
+	Name    string   `json:"name"`
+	Records []string `json:"records"`
+	TTL     int      `json:"ttl,omitempty"`
+	Type    string   `json:"type"`
+	ZoneID  string   `json:"zone_id"`
+}
+
+// Vars converts v to the var map passed to terraform
+func (v Route53RecordVars) Vars() map[string]interface{} {
+	vars := map[string]interface{}{}
+	vars["name"] = v.Name
+	vars["records"] = v.Records
+	if !isZero(v.TTL) {
+		vars["ttl"] = v.TTL
+	}
+	vars["type"] = v.Type
+	vars["zone_id"] = v.ZoneID
+	return vars
+}
+
+// Route53RecordOutputs are the outputs of aws/route53_record
+type Route53RecordOutputs struct {
+	Fqdn string
+}
+
+// Route53RecordOutputContract is the output contract enforced after applying aws/route53_record
+func Route53RecordOutputContract() map[string]tfworkspace.OutputType {
+	return map[string]tfworkspace.OutputType{
+		"fqdn": tfworkspace.OutputString,
+	}
+}
+
+// DecodeRoute53RecordOutputs extracts typed outputs from the result of an apply
+func DecodeRoute53RecordOutputs(o tfworkspace.ApplyOutput) (Route53RecordOutputs, error) {
+	var out Route53RecordOutputs
+	var err error
+	if out.Fqdn, err = o.String("fqdn"); err != nil {
+		return Route53RecordOutputs{}, err
+	}
+	return out, nil
+}
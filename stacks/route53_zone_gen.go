@@ -0,0 +1,48 @@
+// Code generated by tfgen from terraform/aws/route53_zone. DO NOT EDIT.
+
+package stacks
+
+import "github.com/dynajoe/temporal-terraform-demo/tfworkspace"
+
+// Route53ZoneVars are the input variables of aws/route53_zone
+type Route53ZoneVars struct {
+	VpcID    string `json:"vpc_id,omitempty"`
+	ZoneName string `json:"zone_name"`
+}
+
+// Vars converts v to the var map passed to terraform
+func (v Route53ZoneVars) Vars() map[string]interface{} {
+	vars := map[string]interface{}{}
+	if !isZero(v.VpcID) {
+		vars["vpc_id"] = v.VpcID
+	}
+	vars["zone_name"] = v.ZoneName
+	return vars
+}
+
+// Route53ZoneOutputs are the outputs of aws/route53_zone
+type Route53ZoneOutputs struct {
+	NameServers []string
+	ZoneID      string
+}
+
+// Route53ZoneOutputContract is the output contract enforced after applying aws/route53_zone
+func Route53ZoneOutputContract() map[string]tfworkspace.OutputType {
+	return map[string]tfworkspace.OutputType{
+		"name_servers": tfworkspace.OutputStringList,
+		"zone_id":      tfworkspace.OutputString,
+	}
+}
+
+// DecodeRoute53ZoneOutputs extracts typed outputs from the result of an apply
+func DecodeRoute53ZoneOutputs(o tfworkspace.ApplyOutput) (Route53ZoneOutputs, error) {
+	var out Route53ZoneOutputs
+	var err error
+	if out.NameServers, err = o.StringList("name_servers"); err != nil {
+		return Route53ZoneOutputs{}, err
+	}
+	if out.ZoneID, err = o.String("zone_id"); err != nil {
+		return Route53ZoneOutputs{}, err
+	}
+	return out, nil
+}
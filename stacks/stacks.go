@@ -0,0 +1,11 @@
+// Package stacks contains typed inputs and outputs for the embedded terraform
+// modules, generated from their variable and output declarations.
+package stacks
+
+import "reflect"
+
+//go:generate go run ../cmd/tfgen -dir ../terraform -out .
+
+func isZero(v interface{}) bool {
+	return v == nil || reflect.ValueOf(v).IsZero()
+}
@@ -0,0 +1,39 @@
+// Code generated by tfgen from terraform/aws/state_bucket_security. DO NOT EDIT.
+
+package stacks
+
+import "github.com/dynajoe/temporal-terraform-demo/tfworkspace"
+
+// StateBucketSecurityVars are the input variables of aws/state_bucket_security
+type StateBucketSecurityVars struct {
+	Bucket string `json:"bucket"`
+}
+
+// Vars converts v to the var map passed to terraform
+func (v StateBucketSecurityVars) Vars() map[string]interface{} {
+	vars := map[string]interface{}{}
+	vars["bucket"] = v.Bucket
+	return vars
+}
+
+// StateBucketSecurityOutputs are the outputs of aws/state_bucket_security
+type StateBucketSecurityOutputs struct {
+	Bucket string
+}
+
+// StateBucketSecurityOutputContract is the output contract enforced after applying aws/state_bucket_security
+func StateBucketSecurityOutputContract() map[string]tfworkspace.OutputType {
+	return map[string]tfworkspace.OutputType{
+		"bucket": tfworkspace.OutputString,
+	}
+}
+
+// DecodeStateBucketSecurityOutputs extracts typed outputs from the result of an apply
+func DecodeStateBucketSecurityOutputs(o tfworkspace.ApplyOutput) (StateBucketSecurityOutputs, error) {
+	var out StateBucketSecurityOutputs
+	var err error
+	if out.Bucket, err = o.String("bucket"); err != nil {
+		return StateBucketSecurityOutputs{}, err
+	}
+	return out, nil
+}
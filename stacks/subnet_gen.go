@@ -0,0 +1,47 @@
+// Code generated by tfgen from terraform/aws/subnet. DO NOT EDIT.
+
+package stacks
+
+import "github.com/dynajoe/temporal-terraform-demo/tfworkspace"
+
+// SubnetVars are the input variables of aws/subnet
+type SubnetVars struct {
+	Subnets []SubnetSubnet `json:"subnets"`
+	VpcID   string         `json:"vpc_id"`
+}
+
+// Vars converts v to the var map passed to terraform
+func (v SubnetVars) Vars() map[string]interface{} {
+	vars := map[string]interface{}{}
+	vars["subnets"] = v.Subnets
+	vars["vpc_id"] = v.VpcID
+	return vars
+}
+
+// SubnetOutputs are the outputs of aws/subnet
+type SubnetOutputs struct {
+	SubnetIDs []string
+}
+
+// SubnetOutputContract is the output contract enforced after applying aws/subnet
+func SubnetOutputContract() map[string]tfworkspace.OutputType {
+	return map[string]tfworkspace.OutputType{
+		"subnet_ids": tfworkspace.OutputStringList,
+	}
+}
+
+// DecodeSubnetOutputs extracts typed outputs from the result of an apply
+func DecodeSubnetOutputs(o tfworkspace.ApplyOutput) (SubnetOutputs, error) {
+	var out SubnetOutputs
+	var err error
+	if out.SubnetIDs, err = o.StringList("subnet_ids"); err != nil {
+		return SubnetOutputs{}, err
+	}
+	return out, nil
+}
+
+type SubnetSubnet struct {
+	Name             string `json:"name"`
+	AvailabilityZone string `json:"availability_zone"`
+	CIDRBlock        string `json:"cidr_block"`
+}
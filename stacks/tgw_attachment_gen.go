@@ -0,0 +1,45 @@
+// Code generated by tfgen from terraform/aws/tgw_attachment. DO NOT EDIT.
+
+package stacks
+
+import "github.com/dynajoe/temporal-terraform-demo/tfworkspace"
+
+// TgwAttachmentVars are the input variables of aws/tgw_attachment
+type TgwAttachmentVars struct {
+	Name             string   `json:"name"`
+	SubnetIDs        []string `json:"subnet_ids"`
+	TransitGatewayID string   `json:"transit_gateway_id"`
+	VpcID            string   `json:"vpc_id"`
+}
+
+// Vars converts v to the var map passed to terraform
+func (v TgwAttachmentVars) Vars() map[string]interface{} {
+	vars := map[string]interface{}{}
+	vars["name"] = v.Name
+	vars["subnet_ids"] = v.SubnetIDs
+	vars["transit_gateway_id"] = v.TransitGatewayID
+	vars["vpc_id"] = v.VpcID
+	return vars
+}
+
+// TgwAttachmentOutputs are the outputs of aws/tgw_attachment
+type TgwAttachmentOutputs struct {
+	AttachmentID string
+}
+
+// TgwAttachmentOutputContract is the output contract enforced after applying aws/tgw_attachment
+func TgwAttachmentOutputContract() map[string]tfworkspace.OutputType {
+	return map[string]tfworkspace.OutputType{
+		"attachment_id": tfworkspace.OutputString,
+	}
+}
+
+// DecodeTgwAttachmentOutputs extracts typed outputs from the result of an apply
+func DecodeTgwAttachmentOutputs(o tfworkspace.ApplyOutput) (TgwAttachmentOutputs, error) {
+	var out TgwAttachmentOutputs
+	var err error
+	if out.AttachmentID, err = o.String("attachment_id"); err != nil {
+		return TgwAttachmentOutputs{}, err
+	}
+	return out, nil
+}
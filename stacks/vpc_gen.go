@@ -0,0 +1,41 @@
+// Code generated by tfgen from terraform/aws/vpc. DO NOT EDIT.
+
+package stacks
+
+import "github.com/dynajoe/temporal-terraform-demo/tfworkspace"
+
+// VpcVars are the input variables of aws/vpc
+type VpcVars struct {
+	CIDRBlock string `json:"cidr_block"`
+	Name      string `json:"name"`
+}
+
+// Vars converts v to the var map passed to terraform
+func (v VpcVars) Vars() map[string]interface{} {
+	vars := map[string]interface{}{}
+	vars["cidr_block"] = v.CIDRBlock
+	vars["name"] = v.Name
+	return vars
+}
+
+// VpcOutputs are the outputs of aws/vpc
+type VpcOutputs struct {
+	VpcID string
+}
+
+// VpcOutputContract is the output contract enforced after applying aws/vpc
+func VpcOutputContract() map[string]tfworkspace.OutputType {
+	return map[string]tfworkspace.OutputType{
+		"vpc_id": tfworkspace.OutputString,
+	}
+}
+
+// DecodeVpcOutputs extracts typed outputs from the result of an apply
+func DecodeVpcOutputs(o tfworkspace.ApplyOutput) (VpcOutputs, error) {
+	var out VpcOutputs
+	var err error
+	if out.VpcID, err = o.String("vpc_id"); err != nil {
+		return VpcOutputs{}, err
+	}
+	return out, nil
+}
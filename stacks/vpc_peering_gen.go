@@ -0,0 +1,43 @@
+// Code generated by tfgen from terraform/aws/vpc_peering. DO NOT EDIT.
+
+package stacks
+
+import "github.com/dynajoe/temporal-terraform-demo/tfworkspace"
+
+// VpcPeeringVars are the input variables of aws/vpc_peering
+type VpcPeeringVars struct {
+	AccepterVpcID  string `json:"accepter_vpc_id"`
+	Name           string `json:"name"`
+	RequesterVpcID string `json:"requester_vpc_id"`
+}
+
+// Vars converts v to the var map passed to terraform
+func (v VpcPeeringVars) Vars() map[string]interface{} {
+	vars := map[string]interface{}{}
+	vars["accepter_vpc_id"] = v.AccepterVpcID
+	vars["name"] = v.Name
+	vars["requester_vpc_id"] = v.RequesterVpcID
+	return vars
+}
+
+// VpcPeeringOutputs are the outputs of aws/vpc_peering
+type VpcPeeringOutputs struct {
+	PeeringConnectionID string
+}
+
+// VpcPeeringOutputContract is the output contract enforced after applying aws/vpc_peering
+func VpcPeeringOutputContract() map[string]tfworkspace.OutputType {
+	return map[string]tfworkspace.OutputType{
+		"peering_connection_id": tfworkspace.OutputString,
+	}
+}
+
+// DecodeVpcPeeringOutputs extracts typed outputs from the result of an apply
+func DecodeVpcPeeringOutputs(o tfworkspace.ApplyOutput) (VpcPeeringOutputs, error) {
+	var out VpcPeeringOutputs
+	var err error
+	if out.PeeringConnectionID, err = o.String("peering_connection_id"); err != nil {
+		return VpcPeeringOutputs{}, err
+	}
+	return out, nil
+}
@@ -0,0 +1,43 @@
+// Code generated by tfgen from terraform/aws/vpc_peering_routes. DO NOT EDIT.
+
+package stacks
+
+import "github.com/dynajoe/temporal-terraform-demo/tfworkspace"
+
+// VpcPeeringRoutesVars are the input variables of aws/vpc_peering_routes
+type VpcPeeringRoutesVars struct {
+	PeerVpcID           string `json:"peer_vpc_id"`
+	PeeringConnectionID string `json:"peering_connection_id"`
+	VpcID               string `json:"vpc_id"`
+}
+
+// Vars converts v to the var map passed to terraform
+func (v VpcPeeringRoutesVars) Vars() map[string]interface{} {
+	vars := map[string]interface{}{}
+	vars["peer_vpc_id"] = v.PeerVpcID
+	vars["peering_connection_id"] = v.PeeringConnectionID
+	vars["vpc_id"] = v.VpcID
+	return vars
+}
+
+// VpcPeeringRoutesOutputs are the outputs of aws/vpc_peering_routes
+type VpcPeeringRoutesOutputs struct {
+	RouteTableIDs []string
+}
+
+// VpcPeeringRoutesOutputContract is the output contract enforced after applying aws/vpc_peering_routes
+func VpcPeeringRoutesOutputContract() map[string]tfworkspace.OutputType {
+	return map[string]tfworkspace.OutputType{
+		"route_table_ids": tfworkspace.OutputStringList,
+	}
+}
+
+// DecodeVpcPeeringRoutesOutputs extracts typed outputs from the result of an apply
+func DecodeVpcPeeringRoutesOutputs(o tfworkspace.ApplyOutput) (VpcPeeringRoutesOutputs, error) {
+	var out VpcPeeringRoutesOutputs
+	var err error
+	if out.RouteTableIDs, err = o.StringList("route_table_ids"); err != nil {
+		return VpcPeeringRoutesOutputs{}, err
+	}
+	return out, nil
+}
@@ -0,0 +1,3 @@
+output "fqdn" {
+    value = tostring(aws_route53_record.record.fqdn)
+}
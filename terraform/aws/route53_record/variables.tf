@@ -0,0 +1,25 @@
+variable "zone_id" {
+    type = string
+}
+
+variable "name" {
+    type = string
+}
+
+variable "type" {
+    type = string
+}
+
+variable "ttl" {
+    type    = number
+    default = 300
+}
+
+variable "records" {
+    type = list(string)
+}
+
+variable "managed_by_metadata" {
+    type    = map(string)
+    default = {}
+}
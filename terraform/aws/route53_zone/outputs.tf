@@ -0,0 +1,7 @@
+output "zone_id" {
+    value = tostring(aws_route53_zone.zone.zone_id)
+}
+
+output "name_servers" {
+    value = [for ns in aws_route53_zone.zone.name_servers : tostring(ns)]
+}
@@ -0,0 +1,14 @@
+variable "zone_name" {
+    type = string
+}
+
+# A private zone is created when vpc_id is set
+variable "vpc_id" {
+    type    = string
+    default = ""
+}
+
+variable "managed_by_metadata" {
+    type    = map(string)
+    default = {}
+}
@@ -0,0 +1,15 @@
+resource "aws_route53_zone" "zone" {
+  name = var.zone_name
+
+  dynamic "vpc" {
+    for_each = var.vpc_id == "" ? [] : [var.vpc_id]
+    content {
+      vpc_id = vpc.value
+    }
+  }
+
+  tags = merge(
+    { for k, v in var.managed_by_metadata : "managed-by:${k}" => v },
+    { Name = var.zone_name },
+  )
+}
@@ -0,0 +1,45 @@
+# Restores the public access block and TLS-only policy of an existing state
+# bucket. The policy replaces the bucket's whole policy, state buckets
+# aren't meant to have any other.
+
+data "aws_partition" "current" {}
+
+resource "aws_s3_bucket_public_access_block" "state" {
+  bucket = var.bucket
+
+  block_public_acls       = true
+  ignore_public_acls      = true
+  block_public_policy     = true
+  restrict_public_buckets = true
+}
+
+data "aws_iam_policy_document" "tls_only" {
+  statement {
+    sid     = "DenyInsecureTransport"
+    effect  = "Deny"
+    actions = ["s3:*"]
+    resources = [
+      "arn:${data.aws_partition.current.partition}:s3:::${var.bucket}",
+      "arn:${data.aws_partition.current.partition}:s3:::${var.bucket}/*",
+    ]
+
+    principals {
+      type        = "*"
+      identifiers = ["*"]
+    }
+
+    condition {
+      test     = "Bool"
+      variable = "aws:SecureTransport"
+      values   = ["false"]
+    }
+  }
+}
+
+resource "aws_s3_bucket_policy" "tls_only" {
+  bucket = var.bucket
+  policy = data.aws_iam_policy_document.tls_only.json
+
+  # S3 rejects concurrent changes to a bucket's policy and access block
+  depends_on = [aws_s3_bucket_public_access_block.state]
+}
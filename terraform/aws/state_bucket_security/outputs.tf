@@ -0,0 +1,3 @@
+output "bucket" {
+    value = tostring(aws_s3_bucket_policy.tls_only.bucket)
+}
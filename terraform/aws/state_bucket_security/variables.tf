@@ -0,0 +1,8 @@
+variable "bucket" {
+    type = string
+}
+
+variable "managed_by_metadata" {
+    type    = map(string)
+    default = {}
+}
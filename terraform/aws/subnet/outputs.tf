@@ -0,0 +1,3 @@
+output "subnet_ids" {
+    value = [for s in aws_subnet.subnet : tostring(s.id)]
+}
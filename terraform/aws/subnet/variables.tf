@@ -1,4 +1,6 @@
-variable "vpc_id" {}
+variable "vpc_id" {
+    type = string
+}
 
 variable "subnets" {
     type = list(object({
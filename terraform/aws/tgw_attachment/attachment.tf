@@ -0,0 +1,10 @@
+resource "aws_ec2_transit_gateway_vpc_attachment" "attachment" {
+  transit_gateway_id = var.transit_gateway_id
+  vpc_id             = var.vpc_id
+  subnet_ids         = var.subnet_ids
+
+  tags = merge(
+    { for k, v in var.managed_by_metadata : "managed-by:${k}" => v },
+    { Name = var.name },
+  )
+}
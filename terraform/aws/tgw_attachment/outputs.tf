@@ -0,0 +1,3 @@
+output "attachment_id" {
+    value = tostring(aws_ec2_transit_gateway_vpc_attachment.attachment.id)
+}
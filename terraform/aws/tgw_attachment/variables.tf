@@ -0,0 +1,20 @@
+variable "name" {
+    type = string
+}
+
+variable "transit_gateway_id" {
+    type = string
+}
+
+variable "vpc_id" {
+    type = string
+}
+
+variable "subnet_ids" {
+    type = list(string)
+}
+
+variable "managed_by_metadata" {
+    type    = map(string)
+    default = {}
+}
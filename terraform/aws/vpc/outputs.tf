@@ -1,3 +1,3 @@
 output "vpc_id" {
-    value = aws_vpc.vpc.id
+    value = tostring(aws_vpc.vpc.id)
 }
@@ -1,3 +1,12 @@
-variable "cidr_block" {}
+variable "cidr_block" {
+    type = string
+}
 
-variable "name" {}
+variable "name" {
+    type = string
+}
+
+variable "managed_by_metadata" {
+    type    = map(string)
+    default = {}
+}
@@ -1,6 +1,7 @@
 resource "aws_vpc" "vpc" {
   cidr_block = var.cidr_block
-  tags = {
-    Name = var.name
-  }
+  tags = merge(
+    { for k, v in var.managed_by_metadata : "managed-by:${k}" => v },
+    { Name = var.name },
+  )
 }
@@ -0,0 +1,3 @@
+output "peering_connection_id" {
+    value = tostring(aws_vpc_peering_connection.peering.id)
+}
@@ -0,0 +1,12 @@
+resource "aws_vpc_peering_connection" "peering" {
+  vpc_id      = var.requester_vpc_id
+  peer_vpc_id = var.accepter_vpc_id
+
+  # Both networks are in the same account and region
+  auto_accept = true
+
+  tags = merge(
+    { for k, v in var.managed_by_metadata : "managed-by:${k}" => v },
+    { Name = var.name },
+  )
+}
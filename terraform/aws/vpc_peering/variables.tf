@@ -0,0 +1,16 @@
+variable "name" {
+    type = string
+}
+
+variable "requester_vpc_id" {
+    type = string
+}
+
+variable "accepter_vpc_id" {
+    type = string
+}
+
+variable "managed_by_metadata" {
+    type    = map(string)
+    default = {}
+}
@@ -0,0 +1,3 @@
+output "route_table_ids" {
+    value = [for r in aws_route.peer : tostring(r.route_table_id)]
+}
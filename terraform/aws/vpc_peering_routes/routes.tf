@@ -0,0 +1,15 @@
+data "aws_vpc" "peer" {
+  id = var.peer_vpc_id
+}
+
+data "aws_route_tables" "vpc" {
+  vpc_id = var.vpc_id
+}
+
+resource "aws_route" "peer" {
+  for_each = toset(data.aws_route_tables.vpc.ids)
+
+  route_table_id            = each.value
+  destination_cidr_block    = data.aws_vpc.peer.cidr_block
+  vpc_peering_connection_id = var.peering_connection_id
+}
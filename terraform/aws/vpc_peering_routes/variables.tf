@@ -0,0 +1,16 @@
+variable "vpc_id" {
+    type = string
+}
+
+variable "peer_vpc_id" {
+    type = string
+}
+
+variable "peering_connection_id" {
+    type = string
+}
+
+variable "managed_by_metadata" {
+    type    = map(string)
+    default = {}
+}
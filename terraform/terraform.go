@@ -1,6 +1,68 @@
 package terraform
 
-import "embed"
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultNamespace is used for terraform paths that don't specify a namespace
+const DefaultNamespace = "core"
 
 //go:embed aws
 var FS embed.FS
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]fs.FS{
+		DefaultNamespace: FS,
+	}
+)
+
+// Register makes a tree of terraform modules available under namespace, so
+// it can be referenced with terraform paths like "namespace:aws/vpc".
+// Registering the same namespace twice replaces the previous tree.
+func Register(namespace string, fsys fs.FS) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[namespace] = fsys
+}
+
+// Namespaces returns the registered namespaces in sorted order
+func Namespaces() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	var namespaces []string
+	for ns := range registry {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+	return namespaces
+}
+
+// SplitPath splits a terraform path of the form "namespace:path"
+func SplitPath(terraformPath string) (namespace string, modulePath string) {
+	if i := strings.Index(terraformPath, ":"); i >= 0 {
+		return terraformPath[:i], terraformPath[i+1:]
+	}
+	return DefaultNamespace, terraformPath
+}
+
+// Resolve returns the filesystem registered for a terraform path's namespace
+// along with the path within it
+func Resolve(terraformPath string) (fs.FS, string, error) {
+	namespace, modulePath := SplitPath(terraformPath)
+
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	fsys, ok := registry[namespace]
+	if !ok {
+		return nil, "", fmt.Errorf("unknown terraform namespace [%s] in path: %s", namespace, terraformPath)
+	}
+	return fsys, modulePath, nil
+}
@@ -0,0 +1,30 @@
+package tfactivity
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.temporal.io/sdk/activity"
+)
+
+// executionEnv copies env and adds the workflow execution and attempt the
+// terraform process runs for. Providers append TF_APPEND_USER_AGENT to their
+// user agent, so cloud-side request logs can be traced back to the attempt
+// that made them.
+func executionEnv(ctx context.Context, env map[string]string) map[string]string {
+	info := activity.GetInfo(ctx)
+
+	withExecution := make(map[string]string, len(env)+4)
+	for k, v := range env {
+		withExecution[k] = v
+	}
+	withExecution["TEMPORAL_WORKFLOW_ID"] = info.WorkflowExecution.ID
+	withExecution["TEMPORAL_RUN_ID"] = info.WorkflowExecution.RunID
+	withExecution["TEMPORAL_ACTIVITY_ATTEMPT"] = fmt.Sprint(info.Attempt)
+
+	userAgent := fmt.Sprintf("temporal-workflow/%s temporal-run/%s temporal-attempt/%d",
+		info.WorkflowExecution.ID, info.WorkflowExecution.RunID, info.Attempt)
+	withExecution["TF_APPEND_USER_AGENT"] = strings.TrimSpace(env["TF_APPEND_USER_AGENT"] + " " + userAgent)
+	return withExecution
+}
@@ -1,43 +1,347 @@
+// Package tfactivity runs terraform workspaces inside Temporal activities,
+// heartbeating while terraform runs and mapping failures to Temporal errors
 package tfactivity
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
 	"time"
 
 	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/temporal"
 
 	"github.com/dynajoe/temporal-terraform-demo/heartbeat"
+	"github.com/dynajoe/temporal-terraform-demo/runlog"
+	"github.com/dynajoe/temporal-terraform-demo/tfexec"
 	"github.com/dynajoe/temporal-terraform-demo/tfworkspace"
 )
 
-type Activity struct {
-	config tfworkspace.Config
+type (
+	Activity struct {
+		config       tfworkspace.Config
+		newWorkspace func(tfworkspace.Config) Workspace
+	}
+
+	// Workspace runs terraform operations for a stack, *tfworkspace.Workspace
+	// is the default implementation
+	Workspace interface {
+		Apply(ctx context.Context, input tfworkspace.ApplyInput) (tfworkspace.ApplyOutput, error)
+		Destroy(ctx context.Context, input tfworkspace.DestroyInput) error
+		Plan(ctx context.Context, input tfworkspace.PlanInput) (tfworkspace.PlanOutput, error)
+		Test(ctx context.Context, input tfworkspace.TestInput) (tfexec.TestReport, error)
+		ProviderSchemas(ctx context.Context) (*tfexec.ProviderSchemas, error)
+	}
+
+	// WorkerOptions are workspace settings that belong to the worker host
+	// rather than to a stack
+	WorkerOptions struct {
+		WorkspaceRoot        string
+		KeepFailedWorkspaces bool
+
+		// CLIConfig applies to every stack, stacks override its fields
+		CLIConfig tfexec.CLIConfig
+
+		// TimeoutGrace is how long before the activity times out that
+		// terraform is interrupted, giving it time to persist partial state.
+		// Defaults to defaultTimeoutGrace.
+		TimeoutGrace time.Duration
+
+		// AtRiskWarning is how long before the activity times out that a
+		// warning is logged. Defaults to defaultAtRiskWarning.
+		AtRiskWarning time.Duration
+
+		// ReadOnly refuses applies, destroys and tests and plans without
+		// locking or storing anything, so a standby worker can't change
+		// infrastructure or state
+		ReadOnly bool
+
+		// RunLog, if set, logs the bundles, plans and applies of each run
+		RunLog *runlog.Log
+
+		// OffloadPlans moves saved plans off the worker's disk, see
+		// tfworkspace.Config.OffloadPlans
+		OffloadPlans bool
+
+		// OnStateWritten is called with the state bucket and key once an
+		// apply or destroy finished, whether or not it succeeded
+		OnStateWritten func(bucket string, key string)
+	}
+)
+
+const (
+	defaultTimeoutGrace  = 2 * time.Minute
+	defaultAtRiskWarning = 10 * time.Minute
+)
+
+var workerOptions WorkerOptions
+
+type runLogContextKey struct{}
+
+// Configure sets the worker options applied to every activity's workspace
+func Configure(options WorkerOptions) {
+	workerOptions = options
+}
+
+// WithRunLog returns a context whose activities log runs to l instead of
+// WorkerOptions.RunLog, used as the background activity context of a worker
+// whose runs are logged elsewhere, e.g. a tenant's
+func WithRunLog(ctx context.Context, l *runlog.Log) context.Context {
+	return context.WithValue(ctx, runLogContextKey{}, l)
+}
+
+func activityRunLog(ctx context.Context) *runlog.Log {
+	if l, ok := ctx.Value(runLogContextKey{}).(*runlog.Log); ok {
+		return l
+	}
+	return workerOptions.RunLog
 }
 
 func New(wsConfig tfworkspace.Config) *Activity {
-	return &Activity{config: wsConfig}
+	return NewWithWorkspace(wsConfig, func(config tfworkspace.Config) Workspace {
+		return tfworkspace.New(config)
+	})
+}
+
+// NewWithWorkspace uses newWorkspace to create the workspace for each run
+func NewWithWorkspace(wsConfig tfworkspace.Config, newWorkspace func(tfworkspace.Config) Workspace) *Activity {
+	return &Activity{config: wsConfig, newWorkspace: newWorkspace}
 }
 
 func (a *Activity) Apply(ctx context.Context, input tfworkspace.ApplyInput) (tfworkspace.ApplyOutput, error) {
-	logger := activity.GetLogger(ctx)
+	if err := refuseIfReadOnly("apply"); err != nil {
+		return tfworkspace.ApplyOutput{}, err
+	}
+
 	ctx, cancel := heartbeat.Begin(ctx, 10*time.Second)
 	defer cancel()
 
-	logger.Info("terraform activity apply", "TerraformPath", a.config.TerraformPath,
-		"StateBucket", a.config.S3Backend.Bucket, "StateKey", a.config.S3Backend.Key)
+	defer a.stateWritten()
+
+	applyCtx, cancelApply := withTimeoutEscalation(ctx)
+	defer cancelApply()
+	if input.InterruptTimeout == 0 {
+		input.InterruptTimeout = timeoutGrace()
+	}
+	input.Env = executionEnv(ctx, input.Env)
 
 	// Blocking call that returns when terraform exits
-	return tfworkspace.New(a.config).Apply(ctx, input)
+	output, err := a.newWorkspace(a.workspaceConfig(ctx)).Apply(applyCtx, input)
+
+	// Interrupted ahead of the activity timeout
+	var applyErr *tfexec.ApplyError
+	if ctx.Err() == nil && applyCtx.Err() != nil && errors.As(err, &applyErr) {
+		activity.GetLogger(ctx).Error("terraform apply interrupted before the activity timed out",
+			"Completed", applyErr.Completed, "Interrupted", applyErr.Interrupted)
+		return output, fmt.Errorf("apply interrupted before the activity timed out, completed %v, interrupted %v: %w",
+			applyErr.Completed, applyErr.Interrupted, err)
+	}
+
+	// Retrying won't change the outputs the configuration produces
+	var contractErr *tfworkspace.ContractViolationError
+	if errors.As(err, &contractErr) {
+		return output, temporal.NewNonRetryableApplicationError(contractErr.Error(), "OutputContractViolation", err)
+	}
+
+	// Retrying won't bring the state back to the planned serial
+	var staleErr *tfworkspace.StaleStateError
+	if errors.As(err, &staleErr) {
+		return output, temporal.NewNonRetryableApplicationError(staleErr.Error(), "StaleState", err)
+	}
+	var versionErr *tfworkspace.VersionMismatchError
+	if errors.As(err, &versionErr) {
+		return output, temporal.NewNonRetryableApplicationError(versionErr.Error(), "VersionMismatch", err, versionErr.Diffs)
+	}
+	// Retrying would apply changes nobody approved
+	var reviewedErr *tfworkspace.ReviewedPlanFailedError
+	if errors.As(err, &reviewedErr) {
+		return output, temporal.NewNonRetryableApplicationError(reviewedErr.Error(), "ReviewedPlanFailed", err, reviewedErr.Addresses)
+	}
+
+	return output, activityError(err)
 }
 
 func (a *Activity) Destroy(ctx context.Context, input tfworkspace.DestroyInput) error {
-	logger := activity.GetLogger(ctx)
+	if err := refuseIfReadOnly("destroy"); err != nil {
+		return err
+	}
+
+	ctx, cancel := heartbeat.Begin(ctx, 10*time.Second)
+	defer cancel()
+
+	defer a.stateWritten()
+
+	input.Env = executionEnv(ctx, input.Env)
+
+	// Blocking call that returns when terraform exits
+	return activityError(a.newWorkspace(a.workspaceConfig(ctx)).Destroy(ctx, input))
+}
+
+// stateWritten reports a finished apply or destroy, terraform may have
+// written state even if it failed
+func (a *Activity) stateWritten() {
+	if workerOptions.OnStateWritten != nil {
+		workerOptions.OnStateWritten(a.config.S3Backend.Bucket, a.config.S3Backend.Key)
+	}
+}
+
+func (a *Activity) Plan(ctx context.Context, input tfworkspace.PlanInput) (tfworkspace.PlanOutput, error) {
 	ctx, cancel := heartbeat.Begin(ctx, 10*time.Second)
 	defer cancel()
 
-	logger.Info("terraform activity destroy", "TerraformPath", a.config.TerraformPath,
-		"StateBucket", a.config.S3Backend.Bucket, "StateKey", a.config.S3Backend.Key)
+	input.Env = executionEnv(ctx, input.Env)
+
+	// A read-only worker doesn't take the state lock or store the plan
+	config := a.workspaceConfig(ctx)
+	if workerOptions.ReadOnly {
+		input.NoLock = true
+		config.CachePlans = false
+		config.OffloadPlans = false
+	}
+
+	// Blocking call that returns when terraform exits
+	output, err := a.newWorkspace(config).Plan(ctx, input)
+	return output, activityError(err)
+}
+
+func (a *Activity) Test(ctx context.Context, input tfworkspace.TestInput) (tfexec.TestReport, error) {
+	if err := refuseIfReadOnly("test"); err != nil {
+		return tfexec.TestReport{}, err
+	}
+
+	ctx, cancel := heartbeat.Begin(ctx, 10*time.Second)
+	defer cancel()
+
+	input.Env = executionEnv(ctx, input.Env)
+
+	// Blocking call that returns when terraform exits
+	report, err := a.newWorkspace(a.workspaceConfig(ctx)).Test(ctx, input)
+	if errors.Is(err, tfworkspace.ErrNoTests) {
+		return report, temporal.NewNonRetryableApplicationError(err.Error(), "NoTests", err)
+	}
+	return report, activityError(err)
+}
+
+func (a *Activity) ProviderSchemas(ctx context.Context) (*tfexec.ProviderSchemas, error) {
+	ctx, cancel := heartbeat.Begin(ctx, 10*time.Second)
+	defer cancel()
 
 	// Blocking call that returns when terraform exits
-	return tfworkspace.New(a.config).Destroy(ctx, input)
+	schemas, err := a.newWorkspace(a.workspaceConfig(ctx)).ProviderSchemas(ctx)
+	return schemas, activityError(err)
+}
+
+// withTimeoutEscalation warns when the activity is close to timing out and
+// cancels the returned context TimeoutGrace before it does, so terraform is
+// interrupted and persists state rather than being killed
+func withTimeoutEscalation(ctx context.Context) (context.Context, context.CancelFunc) {
+	// Short activities can't afford to give up part of their time
+	deadline := activity.GetInfo(ctx).Deadline
+	if deadline.IsZero() || time.Until(deadline) < 2*timeoutGrace() {
+		return context.WithCancel(ctx)
+	}
+
+	atRiskWarning := workerOptions.AtRiskWarning
+	if atRiskWarning <= 0 {
+		atRiskWarning = defaultAtRiskWarning
+	}
+	logger := activity.GetLogger(ctx)
+	warnTimer := time.AfterFunc(time.Until(deadline.Add(-atRiskWarning)), func() {
+		logger.Warn("terraform apply at risk of timing out", "Deadline", deadline)
+	})
+
+	escalationCtx, cancel := context.WithDeadline(ctx, deadline.Add(-timeoutGrace()))
+	return escalationCtx, func() {
+		warnTimer.Stop()
+		cancel()
+	}
+}
+
+// refuseIfReadOnly fails the attempt on a read-only worker. Read-only
+// workers don't poll the write task queue changes are scheduled on, so this
+// only catches changes scheduled before they moved there. The error is
+// retryable, the retry is dispatched to any worker polling the task queue.
+func refuseIfReadOnly(operation string) error {
+	if !workerOptions.ReadOnly {
+		return nil
+	}
+	return temporal.NewApplicationError(fmt.Sprintf("worker is read-only, refusing terraform %s", operation), "ReadOnlyWorker")
+}
+
+func timeoutGrace() time.Duration {
+	if workerOptions.TimeoutGrace > 0 {
+		return workerOptions.TimeoutGrace
+	}
+	return defaultTimeoutGrace
+}
+
+// activityError fails fast on errors no retry on this worker can fix
+func activityError(err error) error {
+	var configErr *tfexec.ConfigurationError
+	if errors.As(err, &configErr) {
+		return temporal.NewNonRetryableApplicationError(configErr.Error(), "ConfigurationError", err, configErr.Remediation)
+	}
+	return err
+}
+
+// workspaceConfig ties the workspace to the activity's workflow run and
+// the worker's options
+func (a *Activity) workspaceConfig(ctx context.Context) tfworkspace.Config {
+	config := a.config
+	if config.RunID == "" {
+		info := activity.GetInfo(ctx)
+		config.RunID = info.WorkflowExecution.ID + "_" + info.WorkflowExecution.RunID
+	}
+	// Modules tag resources with the metadata, so it only carries values
+	// that stay the same across runs. Run IDs are in the report.
+	if config.Metadata == nil {
+		info := activity.GetInfo(ctx)
+		config.Metadata = map[string]string{
+			"workflow_type": info.WorkflowType.Name,
+			"stack":         strings.TrimSuffix(config.S3Backend.Key, path.Ext(config.S3Backend.Key)),
+		}
+	}
+	if config.UniqueSuffixSeed == "" {
+		config.UniqueSuffixSeed = activity.GetInfo(ctx).WorkflowExecution.ID
+	}
+	if config.WorkspaceRoot == "" {
+		config.WorkspaceRoot = workerOptions.WorkspaceRoot
+	}
+	if workerOptions.KeepFailedWorkspaces {
+		config.KeepFailedWorkspaces = true
+	}
+	config.CLIConfig = workerOptions.CLIConfig.Merge(config.CLIConfig)
+	if config.OnPluginCache == nil {
+		metrics := activity.GetMetricsHandler(ctx)
+		config.OnPluginCache = func(usage tfexec.PluginCacheUsage) {
+			metrics.Counter("terraform_plugin_cache_hits").Inc(int64(len(usage.Hits)))
+			metrics.Counter("terraform_plugin_cache_misses").Inc(int64(len(usage.Misses)))
+			if usage.Repaired {
+				metrics.Counter("terraform_plugin_cache_repairs").Inc(1)
+			}
+		}
+	}
+	if workerOptions.OffloadPlans {
+		config.OffloadPlans = true
+	}
+	if config.OnDiskUsage == nil {
+		metrics := activity.GetMetricsHandler(ctx)
+		config.OnDiskUsage = func(usage tfworkspace.DiskUsage) {
+			tagged := metrics.WithTags(map[string]string{"operation": usage.Operation})
+			tagged.Gauge("terraform_workdir_bytes").Update(float64(usage.WorkDirBytes))
+			tagged.Gauge("terraform_plan_file_bytes").Update(float64(usage.PlanFileBytes))
+		}
+	}
+	if runLog := activityRunLog(ctx); config.OnRunLog == nil && runLog != nil {
+		logger := activity.GetLogger(ctx)
+		runID := config.RunID
+		config.OnRunLog = func(entry runlog.Entry) {
+			if err := runLog.Append(ctx, runID, entry); err != nil {
+				logger.Warn("Unable to append to run log", "Kind", entry.Kind, "Error", err)
+			}
+		}
+	}
+	return config
 }
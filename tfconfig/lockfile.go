@@ -0,0 +1,25 @@
+package tfconfig
+
+import "strings"
+
+// defaultRegistry is left out of provider sources, as in required_providers
+const defaultRegistry = "registry.terraform.io/"
+
+// LockedProviders returns the provider versions selected by a dependency
+// lock file (.terraform.lock.hcl) by source, e.g. hashicorp/aws: 3.59.0
+func LockedProviders(src string) (map[string]string, error) {
+	blocks, err := ParseBlocks(src)
+	if err != nil {
+		return nil, err
+	}
+
+	providers := map[string]string{}
+	for _, b := range blocks {
+		if b.Type != "provider" || len(b.Labels) != 1 {
+			continue
+		}
+		source := strings.TrimPrefix(b.Labels[0], defaultRegistry)
+		providers[source] = unquote(Attributes(b.Body)["version"])
+	}
+	return providers, nil
+}
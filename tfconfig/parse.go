@@ -0,0 +1,292 @@
+package tfconfig
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// ParseBlocks splits HCL source into its top level blocks. It understands
+// enough of the syntax (strings, comments, nesting) to find block boundaries
+// but does not evaluate anything.
+func ParseBlocks(src string) ([]Block, error) {
+	var blocks []Block
+	s := scanner{src: src}
+
+	for {
+		s.skipSpaceAndComments()
+		if s.eof() {
+			return blocks, nil
+		}
+
+		ident := s.ident()
+		if ident == "" {
+			return nil, fmt.Errorf("line %d: expected block type", s.line())
+		}
+
+		b := Block{Type: ident}
+		for {
+			s.skipSpaceAndComments()
+			if s.peek() == '"' {
+				label, err := s.quoted()
+				if err != nil {
+					return nil, err
+				}
+				b.Labels = append(b.Labels, label)
+				continue
+			}
+			break
+		}
+
+		if s.peek() != '{' {
+			return nil, fmt.Errorf("line %d: expected '{' after %s", s.line(), ident)
+		}
+		body, err := s.braced()
+		if err != nil {
+			return nil, err
+		}
+		b.Body = body
+		blocks = append(blocks, b)
+	}
+}
+
+// Attributes returns the raw expression text of the top level attributes in a
+// block body. Nested blocks are skipped.
+func Attributes(body string) map[string]string {
+	attrs := map[string]string{}
+	s := scanner{src: body}
+
+	for {
+		s.skipSpaceAndComments()
+		if s.eof() {
+			return attrs
+		}
+
+		// Separates attributes of single line objects
+		if s.peek() == ',' {
+			s.pos++
+			continue
+		}
+
+		name := s.ident()
+		if name == "" {
+			// Not something we understand, skip to the next line
+			s.skipLine()
+			continue
+		}
+
+		s.skipInlineSpace()
+		switch s.peek() {
+		case '=':
+			s.pos++
+			attrs[name] = strings.TrimSpace(s.expression())
+		case '{', '"':
+			// Nested block, skip labels and body
+			for s.peek() == '"' {
+				if _, err := s.quoted(); err != nil {
+					return attrs
+				}
+				s.skipInlineSpace()
+			}
+			if _, err := s.braced(); err != nil {
+				return attrs
+			}
+		default:
+			s.skipLine()
+		}
+	}
+}
+
+// NestedBlocks returns the blocks of the given type in a block body.
+// Attributes are skipped.
+func NestedBlocks(body string, blockType string) []Block {
+	var blocks []Block
+	s := scanner{src: body}
+
+	for {
+		s.skipSpaceAndComments()
+		if s.eof() {
+			return blocks
+		}
+
+		name := s.ident()
+		if name == "" {
+			s.skipLine()
+			continue
+		}
+
+		s.skipInlineSpace()
+		switch s.peek() {
+		case '=':
+			s.pos++
+			s.expression()
+		case '{', '"':
+			b := Block{Type: name}
+			for s.peek() == '"' {
+				label, err := s.quoted()
+				if err != nil {
+					return blocks
+				}
+				b.Labels = append(b.Labels, label)
+				s.skipInlineSpace()
+			}
+			body, err := s.braced()
+			if err != nil {
+				return blocks
+			}
+			b.Body = body
+			if name == blockType {
+				blocks = append(blocks, b)
+			}
+		default:
+			s.skipLine()
+		}
+	}
+}
+
+type scanner struct {
+	src string
+	pos int
+}
+
+func (s *scanner) eof() bool {
+	return s.pos >= len(s.src)
+}
+
+func (s *scanner) peek() byte {
+	if s.eof() {
+		return 0
+	}
+	return s.src[s.pos]
+}
+
+func (s *scanner) line() int {
+	return strings.Count(s.src[:s.pos], "\n") + 1
+}
+
+func (s *scanner) skipInlineSpace() {
+	for !s.eof() && (s.peek() == ' ' || s.peek() == '\t') {
+		s.pos++
+	}
+}
+
+func (s *scanner) skipLine() {
+	for !s.eof() && s.peek() != '\n' {
+		s.pos++
+	}
+}
+
+func (s *scanner) skipSpaceAndComments() {
+	for !s.eof() {
+		switch {
+		case unicode.IsSpace(rune(s.peek())):
+			s.pos++
+		case s.peek() == '#' || strings.HasPrefix(s.src[s.pos:], "//"):
+			s.skipLine()
+		case strings.HasPrefix(s.src[s.pos:], "/*"):
+			end := strings.Index(s.src[s.pos+2:], "*/")
+			if end < 0 {
+				s.pos = len(s.src)
+				return
+			}
+			s.pos += end + 4
+		default:
+			return
+		}
+	}
+}
+
+func (s *scanner) ident() string {
+	start := s.pos
+	for !s.eof() {
+		c := rune(s.peek())
+		if unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_' || c == '-' {
+			s.pos++
+			continue
+		}
+		break
+	}
+	return s.src[start:s.pos]
+}
+
+func (s *scanner) quoted() (string, error) {
+	start := s.pos
+	s.pos++
+	for !s.eof() {
+		switch s.peek() {
+		case '\\':
+			s.pos += 2
+			continue
+		case '"':
+			s.pos++
+			return s.src[start+1 : s.pos-1], nil
+		}
+		s.pos++
+	}
+	return "", fmt.Errorf("unterminated string starting on line %d", strings.Count(s.src[:start], "\n")+1)
+}
+
+// braced consumes a {...} group and returns its contents
+func (s *scanner) braced() (string, error) {
+	start := s.pos
+	depth := 0
+	for !s.eof() {
+		switch c := s.peek(); {
+		case c == '"':
+			if _, err := s.quoted(); err != nil {
+				return "", err
+			}
+			continue
+		case c == '#' || strings.HasPrefix(s.src[s.pos:], "//") || strings.HasPrefix(s.src[s.pos:], "/*"):
+			// Comments are kept in the body text, only skip them for matching
+			s.skipSpaceAndComments()
+			continue
+		case c == '{':
+			depth++
+		case c == '}':
+			depth--
+			if depth == 0 {
+				s.pos++
+				return s.src[start+1 : s.pos-1], nil
+			}
+		}
+		s.pos++
+	}
+	return "", fmt.Errorf("unterminated block starting on line %d", strings.Count(s.src[:start], "\n")+1)
+}
+
+// expression consumes an attribute value up to the end of the line, following
+// brackets that span multiple lines
+func (s *scanner) expression() string {
+	start := s.pos
+	depth := 0
+	for !s.eof() {
+		switch c := s.peek(); c {
+		case '"':
+			if _, err := s.quoted(); err != nil {
+				return s.src[start:]
+			}
+			continue
+		case '{', '(', '[':
+			depth++
+		case '}', ')', ']':
+			depth--
+		case ',':
+			if depth == 0 {
+				return s.src[start:s.pos]
+			}
+		case '#':
+			if depth == 0 {
+				end := s.pos
+				s.skipLine()
+				return s.src[start:end]
+			}
+		case '\n':
+			if depth <= 0 {
+				return s.src[start:s.pos]
+			}
+		}
+		s.pos++
+	}
+	return s.src[start:]
+}
@@ -0,0 +1,217 @@
+package tfconfig
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+)
+
+type (
+	// Module is the subset of a terraform module's configuration needed to
+	// describe its interface: the variables it accepts and outputs it produces
+	Module struct {
+		Path      string
+		Variables []Variable
+		Outputs   []Output
+
+		// RequiredProviders are declared in the terraform block, by local name
+		RequiredProviders map[string]ProviderRequirement
+
+		// ModuleCalls are the child modules the module calls
+		ModuleCalls []ModuleCall
+
+		// ResourceTypes are the managed resource types the module declares,
+		// e.g. aws_vpc
+		ResourceTypes []string
+	}
+
+	ModuleCall struct {
+		Name    string
+		Source  string
+		Version string
+	}
+
+	ProviderRequirement struct {
+		Source  string
+		Version string
+	}
+
+	Variable struct {
+		Name        string
+		Type        *Type
+		Description string
+		HasDefault  bool
+		Sensitive   bool
+	}
+
+	Output struct {
+		Name        string
+		Description string
+		Sensitive   bool
+
+		// Type is inferred from the value expression since terraform itself
+		// does not allow declaring output types. Wrap the value in a type
+		// conversion such as tostring() to declare it.
+		Type *Type
+	}
+
+	Block struct {
+		Type   string
+		Labels []string
+		Body   string
+	}
+)
+
+// LoadModule reads the top level .tf files in dir
+func LoadModule(fsys fs.FS, dir string) (*Module, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	module := &Module{Path: dir}
+	for _, e := range entries {
+		if e.IsDir() || path.Ext(e.Name()) != ".tf" {
+			continue
+		}
+
+		src, err := fs.ReadFile(fsys, path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		blocks, err := ParseBlocks(string(src))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path.Join(dir, e.Name()), err)
+		}
+
+		for _, b := range blocks {
+			if b.Type == "terraform" {
+				for name, req := range parseRequiredProviders(b) {
+					if module.RequiredProviders == nil {
+						module.RequiredProviders = map[string]ProviderRequirement{}
+					}
+					module.RequiredProviders[name] = req
+				}
+				continue
+			}
+			if b.Type == "resource" && len(b.Labels) == 2 {
+				if !containsString(module.ResourceTypes, b.Labels[0]) {
+					module.ResourceTypes = append(module.ResourceTypes, b.Labels[0])
+				}
+				continue
+			}
+			if len(b.Labels) != 1 {
+				continue
+			}
+			switch b.Type {
+			case "variable":
+				v, err := parseVariable(b)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", path.Join(dir, e.Name()), err)
+				}
+				module.Variables = append(module.Variables, v)
+			case "output":
+				module.Outputs = append(module.Outputs, parseOutput(b))
+			case "module":
+				attrs := Attributes(b.Body)
+				module.ModuleCalls = append(module.ModuleCalls, ModuleCall{
+					Name:    b.Labels[0],
+					Source:  unquote(attrs["source"]),
+					Version: unquote(attrs["version"]),
+				})
+			}
+		}
+	}
+
+	sort.Slice(module.Variables, func(i, j int) bool { return module.Variables[i].Name < module.Variables[j].Name })
+	sort.Slice(module.Outputs, func(i, j int) bool { return module.Outputs[i].Name < module.Outputs[j].Name })
+	sort.Slice(module.ModuleCalls, func(i, j int) bool { return module.ModuleCalls[i].Name < module.ModuleCalls[j].Name })
+	sort.Strings(module.ResourceTypes)
+
+	return module, nil
+}
+
+// Variable returns the named variable and whether the module declares it
+func (m *Module) Variable(name string) (Variable, bool) {
+	for _, v := range m.Variables {
+		if v.Name == name {
+			return v, true
+		}
+	}
+	return Variable{}, false
+}
+
+func parseVariable(b Block) (Variable, error) {
+	attrs := Attributes(b.Body)
+	v := Variable{
+		Name:        b.Labels[0],
+		Description: unquote(attrs["description"]),
+		Sensitive:   attrs["sensitive"] == "true",
+	}
+	_, v.HasDefault = attrs["default"]
+
+	typeExpr, ok := attrs["type"]
+	if !ok {
+		v.Type = &Type{Kind: KindAny}
+		return v, nil
+	}
+
+	t, err := ParseType(typeExpr)
+	if err != nil {
+		return Variable{}, fmt.Errorf("variable %q: %w", v.Name, err)
+	}
+	v.Type = t
+	return v, nil
+}
+
+func parseOutput(b Block) Output {
+	attrs := Attributes(b.Body)
+	return Output{
+		Name:        b.Labels[0],
+		Description: unquote(attrs["description"]),
+		Sensitive:   attrs["sensitive"] == "true",
+		Type:        InferType(attrs["value"]),
+	}
+}
+
+// parseRequiredProviders reads the required_providers of a terraform block.
+// The legacy form, a bare version constraint, implies a hashicorp provider.
+func parseRequiredProviders(b Block) map[string]ProviderRequirement {
+	providers := map[string]ProviderRequirement{}
+	for _, rp := range NestedBlocks(b.Body, "required_providers") {
+		for name, expr := range Attributes(rp.Body) {
+			expr = strings.TrimSpace(expr)
+			if !strings.HasPrefix(expr, "{") {
+				providers[name] = ProviderRequirement{Source: "hashicorp/" + name, Version: unquote(expr)}
+				continue
+			}
+
+			attrs := Attributes(strings.TrimSuffix(strings.TrimPrefix(expr, "{"), "}"))
+			req := ProviderRequirement{
+				Source:  unquote(attrs["source"]),
+				Version: unquote(attrs["version"]),
+			}
+			if req.Source == "" {
+				req.Source = "hashicorp/" + name
+			}
+			providers[name] = req
+		}
+	}
+	return providers
+}
+
+func unquote(s string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(s, `"`), `"`)
+}
+
+func containsString(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
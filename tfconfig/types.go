@@ -0,0 +1,369 @@
+package tfconfig
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type Kind string
+
+const (
+	KindAny    Kind = "any"
+	KindString Kind = "string"
+	KindNumber Kind = "number"
+	KindBool   Kind = "bool"
+	KindList   Kind = "list"
+	KindSet    Kind = "set"
+	KindMap    Kind = "map"
+	KindObject Kind = "object"
+	KindTuple  Kind = "tuple"
+)
+
+type (
+	// Type is a parsed terraform type constraint
+	Type struct {
+		Kind Kind
+
+		// Elem is the element type of list, set and map types
+		Elem *Type
+
+		// Attrs are the attributes of an object type in declaration order
+		Attrs []Attr
+	}
+
+	Attr struct {
+		Name     string
+		Type     *Type
+		Optional bool
+	}
+)
+
+// ParseType parses a type constraint expression such as
+// list(object({ name = string }))
+func ParseType(expr string) (*Type, error) {
+	p := typeParser{tokens: tokenizeType(expr)}
+	t, err := p.parse()
+	if err != nil {
+		return nil, fmt.Errorf("invalid type %q: %w", expr, err)
+	}
+	if !p.done() {
+		return nil, fmt.Errorf("invalid type %q: unexpected %q", expr, p.peek())
+	}
+	return t, nil
+}
+
+func (t *Type) String() string {
+	switch t.Kind {
+	case KindList, KindSet, KindMap:
+		return fmt.Sprintf("%s(%s)", t.Kind, t.Elem)
+	case KindObject:
+		var attrs []string
+		for _, a := range t.Attrs {
+			attrs = append(attrs, fmt.Sprintf("%s = %s", a.Name, a.Type))
+		}
+		return fmt.Sprintf("object({%s})", strings.Join(attrs, ", "))
+	default:
+		return string(t.Kind)
+	}
+}
+
+type typeParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *typeParser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *typeParser) peek() string {
+	if p.done() {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *typeParser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *typeParser) expect(tok string) error {
+	if got := p.next(); got != tok {
+		return fmt.Errorf("expected %q, got %q", tok, got)
+	}
+	return nil
+}
+
+func (p *typeParser) parse() (*Type, error) {
+	switch name := p.next(); Kind(name) {
+	case KindAny, KindString, KindNumber, KindBool:
+		return &Type{Kind: Kind(name)}, nil
+	case KindList, KindSet, KindMap:
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		elem, err := p.parse()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return &Type{Kind: Kind(name), Elem: elem}, nil
+	case KindObject:
+		return p.parseObject()
+	case KindTuple:
+		// Tuples don't map to anything useful, consume and treat as any
+		depth := 0
+		for !p.done() {
+			switch p.next() {
+			case "(":
+				depth++
+			case ")":
+				depth--
+				if depth == 0 {
+					return &Type{Kind: KindTuple}, nil
+				}
+			}
+		}
+		return nil, fmt.Errorf("unterminated tuple")
+	default:
+		return nil, fmt.Errorf("unknown type %q", name)
+	}
+}
+
+func (p *typeParser) parseObject() (*Type, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+
+	t := &Type{Kind: KindObject}
+	for p.peek() != "}" {
+		if p.done() {
+			return nil, fmt.Errorf("unterminated object")
+		}
+
+		name := p.next()
+		if err := p.expect("="); err != nil {
+			return nil, err
+		}
+
+		attr := Attr{Name: name}
+		if p.peek() == "optional" {
+			p.next()
+			if err := p.expect("("); err != nil {
+				return nil, err
+			}
+			attrType, err := p.parse()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			attr.Type = attrType
+			attr.Optional = true
+		} else {
+			attrType, err := p.parse()
+			if err != nil {
+				return nil, err
+			}
+			attr.Type = attrType
+		}
+		t.Attrs = append(t.Attrs, attr)
+
+		if p.peek() == "," {
+			p.next()
+		}
+	}
+
+	if err := p.expect("}"); err != nil {
+		return nil, err
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func tokenizeType(expr string) []string {
+	var tokens []string
+	var ident strings.Builder
+	flush := func() {
+		if ident.Len() > 0 {
+			tokens = append(tokens, ident.String())
+			ident.Reset()
+		}
+	}
+
+	for _, c := range expr {
+		switch {
+		case unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_':
+			ident.WriteRune(c)
+		case strings.ContainsRune("(){}[]=,:", c):
+			flush()
+			// HCL allows : as well as = in object type constraints
+			if c == ':' {
+				c = '='
+			}
+			tokens = append(tokens, string(c))
+		default:
+			flush()
+		}
+	}
+	flush()
+
+	return tokens
+}
+
+// InferType returns the type of the value an expression produces, as far as
+// it can be told without evaluating it: literals, the type conversion
+// functions and for expressions over them. Anything else is any.
+func InferType(expr string) *Type {
+	expr = strings.TrimSpace(expr)
+	switch {
+	case expr == "":
+		return &Type{Kind: KindAny}
+	case expr == "true" || expr == "false":
+		return &Type{Kind: KindBool}
+	case expr[0] == '"' && closingIndex(expr, 0) == len(expr)-1:
+		return &Type{Kind: KindString}
+	case isNumber(expr):
+		return &Type{Kind: KindNumber}
+	}
+
+	if arg, ok := callArgument(expr, "tostring"); ok && arg != "" {
+		return &Type{Kind: KindString}
+	}
+	if arg, ok := callArgument(expr, "tonumber"); ok && arg != "" {
+		return &Type{Kind: KindNumber}
+	}
+	if arg, ok := callArgument(expr, "tobool"); ok && arg != "" {
+		return &Type{Kind: KindBool}
+	}
+	for fn, kind := range map[string]Kind{"tolist": KindList, "toset": KindSet, "tomap": KindMap} {
+		if arg, ok := callArgument(expr, fn); ok {
+			if t := InferType(arg); t.Elem != nil {
+				return &Type{Kind: kind, Elem: t.Elem}
+			}
+			return &Type{Kind: kind, Elem: &Type{Kind: KindAny}}
+		}
+	}
+
+	// [for x in xs : value] and {for k, v in m : key => value}
+	if (expr[0] == '[' || expr[0] == '{') && closingIndex(expr, 0) == len(expr)-1 {
+		inner := strings.TrimSpace(expr[1 : len(expr)-1])
+		if !strings.HasPrefix(inner, "for ") {
+			return &Type{Kind: KindAny}
+		}
+		colon := topLevelIndex(inner, ":")
+		if colon < 0 {
+			return &Type{Kind: KindAny}
+		}
+		value := inner[colon+1:]
+		if i := topLevelIndex(value, " if "); i >= 0 {
+			value = value[:i]
+		}
+		if expr[0] == '[' {
+			return &Type{Kind: KindList, Elem: InferType(value)}
+		}
+		arrow := topLevelIndex(value, "=>")
+		if arrow < 0 {
+			return &Type{Kind: KindAny}
+		}
+		value = strings.TrimSpace(value[arrow+2:])
+		if strings.HasSuffix(value, "...") {
+			// Grouped values are lists
+			return &Type{Kind: KindMap, Elem: &Type{Kind: KindList, Elem: InferType(strings.TrimSuffix(value, "..."))}}
+		}
+		return &Type{Kind: KindMap, Elem: InferType(value)}
+	}
+	return &Type{Kind: KindAny}
+}
+
+// callArgument returns the argument of expr when it is a single call of fn
+func callArgument(expr string, fn string) (string, bool) {
+	if !strings.HasPrefix(expr, fn+"(") {
+		return "", false
+	}
+	open := len(fn)
+	if closingIndex(expr, open) != len(expr)-1 {
+		return "", false
+	}
+	return strings.TrimSpace(expr[open+1 : len(expr)-1]), true
+}
+
+// closingIndex returns the index of the bracket or quote closing the one at
+// open, -1 if it isn't closed
+func closingIndex(s string, open int) int {
+	if s[open] == '"' {
+		for i := open + 1; i < len(s); i++ {
+			switch s[i] {
+			case '\\':
+				i++
+			case '"':
+				return i
+			}
+		}
+		return -1
+	}
+
+	depth := 0
+	for i := open; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			end := closingIndex(s, i)
+			if end < 0 {
+				return -1
+			}
+			i = end
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// topLevelIndex returns the index of the first sub in s outside of brackets
+// and strings, -1 if there is none
+func topLevelIndex(s string, sub string) int {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			end := closingIndex(s, i)
+			if end < 0 {
+				return -1
+			}
+			i = end
+			continue
+		case '(', '[', '{':
+			depth++
+			continue
+		case ')', ']', '}':
+			depth--
+			continue
+		}
+		if depth == 0 && strings.HasPrefix(s[i:], sub) {
+			return i
+		}
+	}
+	return -1
+}
+
+func isNumber(s string) bool {
+	_, err := strconv.ParseFloat(s, 64)
+	return err == nil
+}
@@ -0,0 +1,164 @@
+package tfexec
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+const (
+	// BackendCredentialsDir holds the s3 backend's credentials, relative to
+	// the working directory. Remove it from workspaces kept for inspection.
+	BackendCredentialsDir = "_backend_credentials"
+
+	// backendProfile is the profile of the backend's shared credentials file
+	backendProfile = "terraform-backend"
+
+	// MinCredentialsLifetime is how long credentials handed to terraform
+	// should remain valid
+	MinCredentialsLifetime = 15 * time.Minute
+)
+
+// backendCredentials are handed to the s3 backend by a credential process
+// printing a file that is rewritten before the credentials in it expire, so
+// the state written at the end of a long apply isn't refused with an expired
+// token
+type backendCredentials struct {
+	credentials aws.CredentialsProvider
+	file        string
+}
+
+// writeBackendCredentials writes the backend's credentials to files only the
+// worker can read and returns them along with the path of the shared
+// credentials file naming the credential process
+func writeBackendCredentials(ctx context.Context, workDir string, credentials aws.CredentialsProvider) (*backendCredentials, string, error) {
+	dir, err := filepath.Abs(filepath.Join(workDir, BackendCredentialsDir))
+	if err != nil {
+		return nil, "", err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, "", fmt.Errorf("error creating backend credentials directory: %w", err)
+	}
+
+	b := &backendCredentials{
+		credentials: credentials,
+		file:        filepath.Join(dir, "credentials.json"),
+	}
+	if _, err := b.write(ctx); err != nil {
+		return nil, "", err
+	}
+
+	printFile := "cat"
+	if runtime.GOOS == "windows" {
+		printFile = "type"
+	}
+	data := fmt.Sprintf("[%s]\ncredential_process = %s %q\n", backendProfile, printFile, b.file)
+
+	name := filepath.Join(dir, "credentials")
+	if err := os.WriteFile(name, []byte(data), 0600); err != nil {
+		return nil, "", fmt.Errorf("error writing backend credentials: %w", err)
+	}
+	return b, name, nil
+}
+
+// write replaces the file with fresh credentials in the credential process
+// format and returns when they expire, zero if they don't
+func (b *backendCredentials) write(ctx context.Context) (time.Time, error) {
+	creds, err := FreshCredentials(ctx, b.credentials)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	output := struct {
+		Version         int
+		AccessKeyID     string `json:"AccessKeyId"`
+		SecretAccessKey string
+		SessionToken    string `json:",omitempty"`
+		Expiration      string `json:",omitempty"`
+	}{
+		Version:         1,
+		AccessKeyID:     creds.AccessKeyID,
+		SecretAccessKey: creds.SecretAccessKey,
+		SessionToken:    creds.SessionToken,
+	}
+	var expires time.Time
+	if creds.CanExpire {
+		expires = creds.Expires
+		output.Expiration = creds.Expires.UTC().Format(time.RFC3339)
+	}
+	data, err := json.Marshal(output)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	// Replaced by a rename so the credential process never reads a partial
+	// file
+	tmp := b.file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return time.Time{}, fmt.Errorf("error writing backend credentials: %w", err)
+	}
+	if err := os.Rename(tmp, b.file); err != nil {
+		return time.Time{}, fmt.Errorf("error writing backend credentials: %w", err)
+	}
+	return expires, nil
+}
+
+// keepFresh rewrites the credentials as they near expiry until the returned
+// func is called, once the command using them has exited
+func (b *backendCredentials) keepFresh(ctx context.Context) (func(), error) {
+	expires, err := b.write(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		for !expires.IsZero() {
+			wait := time.Until(expires) - MinCredentialsLifetime
+			if wait < time.Minute {
+				wait = time.Minute
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+
+			refreshed, err := b.write(ctx)
+			if err != nil {
+				log.Printf("unable to refresh terraform backend credentials: %v", err)
+				continue
+			}
+			expires = refreshed
+		}
+	}()
+	return cancel, nil
+}
+
+// FreshCredentials retrieves credentials that won't expire for at least
+// MinCredentialsLifetime if the provider can refresh them, since terraform
+// can't refresh credentials handed to it
+func FreshCredentials(ctx context.Context, credentials aws.CredentialsProvider) (aws.Credentials, error) {
+	creds, err := credentials.Retrieve(ctx)
+	if err != nil {
+		return aws.Credentials{}, err
+	}
+	if !creds.CanExpire || time.Until(creds.Expires) >= MinCredentialsLifetime {
+		return creds, nil
+	}
+
+	cache, ok := credentials.(interface{ Invalidate() })
+	if !ok {
+		log.Printf("aws credentials expire at %s and cannot be refreshed", creds.Expires.Format(time.RFC3339))
+		return creds, nil
+	}
+	cache.Invalidate()
+	return credentials.Retrieve(ctx)
+}
@@ -0,0 +1,189 @@
+package tfexec
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// cliConfigFile is the CLI configuration written to the working directory
+const cliConfigFile = "_terraformrc"
+
+// CLIConfig is written to a terraform CLI configuration file in the working
+// directory and used by every command run there
+type CLIConfig struct {
+	// ProviderMirror is a filesystem mirror providers are installed from
+	// instead of the registry
+	ProviderMirror string `json:"provider_mirror,omitempty"`
+
+	// PluginCacheDir shares downloaded providers between runs
+	PluginCacheDir string `json:"plugin_cache_dir,omitempty"`
+
+	// Credentials are API tokens for private registries by hostname. They
+	// are never read from JSON configuration.
+	Credentials map[string]string `json:"-"`
+
+	// Args are extra arguments by command, e.g. {"plan": ["-compact-warnings"]}
+	// or {"init": ["-plugin-dir=/opt/plugins"]}. Nested commands are keyed
+	// by both words, e.g. "state rm".
+	Args map[string][]string `json:"args,omitempty"`
+
+	// Env is set for every command, the env of the command itself wins,
+	// e.g. {"TF_IN_AUTOMATION": "1"}
+	Env map[string]string `json:"env,omitempty"`
+}
+
+// nestedCommands take a subcommand, see CLIConfig.Args
+var nestedCommands = map[string]bool{
+	"providers": true,
+	"state":     true,
+	"workspace": true,
+}
+
+var cliConfigTemplate = template.Must(template.New("cliconfig").Parse(`
+{{- if .PluginCacheDir }}
+plugin_cache_dir = "{{ .PluginCacheDir }}"
+{{ end }}
+{{- range .Credentials }}
+credentials "{{ .Host }}" {
+  token = "{{ .Token }}"
+}
+{{ end }}
+{{- if .ProviderMirror }}
+provider_installation {
+  filesystem_mirror {
+    path = "{{ .ProviderMirror }}"
+  }
+}
+{{ end }}`))
+
+// IsZero reports whether the config changes nothing from terraform's defaults
+func (c CLIConfig) IsZero() bool {
+	return c.ProviderMirror == "" && c.PluginCacheDir == "" && len(c.Credentials) == 0 &&
+		len(c.Args) == 0 && len(c.Env) == 0
+}
+
+// LoadCLIConfig reads a CLI config from a JSON file
+func LoadCLIConfig(name string) (CLIConfig, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return CLIConfig{}, err
+	}
+
+	var config CLIConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return CLIConfig{}, fmt.Errorf("error decoding terraform cli config: %w", err)
+	}
+	return config, nil
+}
+
+// Merge returns c with the fields set in override replacing its own.
+// Credentials are merged by hostname, Args by command and Env by name.
+func (c CLIConfig) Merge(override CLIConfig) CLIConfig {
+	if override.ProviderMirror != "" {
+		c.ProviderMirror = override.ProviderMirror
+	}
+	if override.PluginCacheDir != "" {
+		c.PluginCacheDir = override.PluginCacheDir
+	}
+	if len(override.Credentials) > 0 {
+		credentials := make(map[string]string, len(c.Credentials)+len(override.Credentials))
+		for host, token := range c.Credentials {
+			credentials[host] = token
+		}
+		for host, token := range override.Credentials {
+			credentials[host] = token
+		}
+		c.Credentials = credentials
+	}
+	if len(override.Args) > 0 {
+		args := make(map[string][]string, len(c.Args)+len(override.Args))
+		for command, a := range c.Args {
+			args[command] = a
+		}
+		for command, a := range override.Args {
+			args[command] = a
+		}
+		c.Args = args
+	}
+	if len(override.Env) > 0 {
+		env := make(map[string]string, len(c.Env)+len(override.Env))
+		for k, v := range c.Env {
+			env[k] = v
+		}
+		for k, v := range override.Env {
+			env[k] = v
+		}
+		c.Env = env
+	}
+	return c
+}
+
+// withArgs inserts the extra arguments of the command right after its
+// name, ahead of positional arguments such as a plan file
+func (c CLIConfig) withArgs(args []string) []string {
+	n := 1
+	if len(args) > 1 && nestedCommands[args[0]] {
+		n = 2
+	}
+	if len(args) < n {
+		return args
+	}
+	extra := c.Args[strings.Join(args[:n], " ")]
+	if len(extra) == 0 {
+		return args
+	}
+
+	withExtra := make([]string, 0, len(args)+len(extra))
+	withExtra = append(withExtra, args[:n]...)
+	withExtra = append(withExtra, extra...)
+	return append(withExtra, args[n:]...)
+}
+
+// writeCLIConfig renders the config into the working directory, readable
+// only by the worker since it may hold registry tokens
+func (t *Terraform) writeCLIConfig(config CLIConfig) error {
+	vars := struct {
+		ProviderMirror string
+		PluginCacheDir string
+		Credentials    []struct{ Host, Token string }
+	}{}
+
+	var err error
+	if config.ProviderMirror != "" {
+		if vars.ProviderMirror, err = filepath.Abs(config.ProviderMirror); err != nil {
+			return err
+		}
+	}
+	if config.PluginCacheDir != "" {
+		if vars.PluginCacheDir, err = filepath.Abs(config.PluginCacheDir); err != nil {
+			return err
+		}
+		// Terraform ignores a cache directory that doesn't exist
+		if err := os.MkdirAll(vars.PluginCacheDir, 0755); err != nil {
+			return fmt.Errorf("error creating plugin cache directory: %w", err)
+		}
+	}
+	for host, token := range config.Credentials {
+		vars.Credentials = append(vars.Credentials, struct{ Host, Token string }{host, token})
+	}
+	sort.Slice(vars.Credentials, func(i, j int) bool { return vars.Credentials[i].Host < vars.Credentials[j].Host })
+
+	configBuf := bytes.Buffer{}
+	if err := cliConfigTemplate.Execute(&configBuf, vars); err != nil {
+		return fmt.Errorf("error creating terraform cli config: %w", err)
+	}
+
+	configPath := path.Join(t.workDir, cliConfigFile)
+	if err := os.WriteFile(configPath, configBuf.Bytes(), 0600); err != nil {
+		return fmt.Errorf("error writing terraform cli config: %w", err)
+	}
+	t.cliConfigPath = configPath
+	return nil
+}
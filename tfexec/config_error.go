@@ -0,0 +1,43 @@
+package tfexec
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+)
+
+// ConfigurationError is returned when the worker host is not set up to run
+// terraform. Retrying won't help until an operator fixes the host.
+type ConfigurationError struct {
+	Problem     string
+	Remediation string
+	Err         error
+}
+
+func (e *ConfigurationError) Error() string {
+	return fmt.Sprintf("%s: %v (%s)", e.Problem, e.Err, e.Remediation)
+}
+
+func (e *ConfigurationError) Unwrap() error {
+	return e.Err
+}
+
+// FindTerraform returns the path of the terraform binary on the PATH
+func FindTerraform() (string, error) {
+	tfPath, err := exec.LookPath("terraform")
+	if errors.Is(err, exec.ErrNotFound) {
+		return "", &ConfigurationError{
+			Problem:     "terraform binary not found on PATH",
+			Remediation: "install terraform from https://www.terraform.io/downloads and make sure it is on the worker's PATH",
+			Err:         err,
+		}
+	}
+	if err != nil {
+		return "", &ConfigurationError{
+			Problem:     "terraform binary on PATH cannot be used",
+			Remediation: "check that the terraform found on the worker's PATH is an executable file",
+			Err:         err,
+		}
+	}
+	return tfPath, nil
+}
@@ -8,10 +8,13 @@ import (
 	"os"
 	"os/exec"
 	"strings"
-	"syscall"
 	"time"
 )
 
+// defaultInterruptTimeout is how long terraform has to persist state and
+// exit after being interrupted before it is killed
+const defaultInterruptTimeout = 30 * time.Second
+
 type terraformExecParams struct {
 	tfPath  string
 	args    []string
@@ -19,6 +22,12 @@ type terraformExecParams struct {
 	stdErr  io.Writer
 	stdOut  io.Writer
 	workDir string
+
+	// interruptTimeout overrides defaultInterruptTimeout
+	interruptTimeout time.Duration
+
+	// backendCredentials are kept fresh while the command runs
+	backendCredentials *backendCredentials
 }
 
 type terraformErrorInterceptor struct {
@@ -52,7 +61,7 @@ func terraformExec(ctx context.Context, run terraformExecParams) error {
 	cmd := exec.Command(run.tfPath, run.args...)
 	cmd.Env = cmdEnv
 	cmd.Dir = run.workDir
-	cmd.SysProcAttr = osSpecificSysProcAttr()
+	processes.prepare(cmd)
 
 	cmd.Stdout = io.MultiWriter(run.stdOut, errorInterceptor)
 	cmd.Stderr = io.MultiWriter(run.stdErr, errorInterceptor)
@@ -62,6 +71,14 @@ func terraformExec(ctx context.Context, run terraformExecParams) error {
 		return ctx.Err()
 	}
 
+	if run.backendCredentials != nil {
+		stop, err := run.backendCredentials.keepFresh(ctx)
+		if err != nil {
+			return fmt.Errorf("error refreshing backend credentials: %w", err)
+		}
+		defer stop()
+	}
+
 	// Run the command
 	if err := cmd.Start(); err != nil {
 		return fmt.Errorf("terraform start command error: %s\n%w", strings.Join(errorInterceptor.errors, "\n"), err)
@@ -80,20 +97,22 @@ func terraformExec(ctx context.Context, run terraformExecParams) error {
 			return
 		}
 
-		// Send sigint to the process gorup and wait for some time to allow for graceful shutdown
-		if err := syscall.Kill(-cmd.Process.Pid, syscall.SIGINT); err != nil {
+		// Interrupt the process group and wait for some time to allow for graceful shutdown
+		if err := processes.interrupt(cmd); err != nil {
 			if errors.Is(os.ErrProcessDone, err) {
 				return
 			}
 
-			// If there was an error sending sigint just send kill
-			// Using -pid will send the kill signal to process group
-			_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
-			_ = cmd.Process.Kill()
+			// If there was an error interrupting just kill
+			_ = processes.kill(cmd)
 		}
 
 		// Check frequently until the process has exited
-		deadline := time.Now().Add(30 * time.Second)
+		interruptTimeout := run.interruptTimeout
+		if interruptTimeout <= 0 {
+			interruptTimeout = defaultInterruptTimeout
+		}
+		deadline := time.Now().Add(interruptTimeout)
 		for time.Now().Before(deadline) {
 			<-time.After(200 * time.Millisecond)
 			if exited {
@@ -102,9 +121,7 @@ func terraformExec(ctx context.Context, run terraformExecParams) error {
 		}
 
 		// The process hasn't exited, try to kill it again and abandon ship
-		// Using -pid will send the kill signal to process group
-		_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
-		_ = cmd.Process.Kill()
+		_ = processes.kill(cmd)
 	}()
 
 	if err := cmd.Wait(); err != nil {
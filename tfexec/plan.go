@@ -0,0 +1,151 @@
+package tfexec
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"path"
+	"regexp"
+	"time"
+)
+
+// PlanFile is where Plan saves the plan, relative to the working directory
+const PlanFile = "tfplan"
+
+type (
+	PlanParams struct {
+		Vars map[string]interface{}
+		Env  map[string]string
+
+		// LockTimeout is how long to wait for the state lock, zero fails
+		// immediately if the state is locked
+		LockTimeout time.Duration
+
+		// NoLock skips locking the state, only safe because a plan never
+		// writes state
+		NoLock bool
+
+		// NoRefresh plans against the state as stored without checking for
+		// drift, for fast previews
+		NoRefresh bool
+	}
+
+	// ResourceChange is a resource the plan would change
+	ResourceChange struct {
+		Address string
+		Actions []string
+	}
+
+	// ApplyError is returned when apply fails, it carries the addresses of
+	// the resources terraform reported errors for
+	ApplyError struct {
+		Err       error
+		Addresses []string
+
+		// Completed resources were applied before the failure, Interrupted
+		// ones were still being applied when terraform exited
+		Completed   []string
+		Interrupted []string
+	}
+)
+
+// diagnosticAddress matches the resource a diagnostic is attributed to, e.g.
+// `with aws_subnet.subnet["a"],`
+var diagnosticAddress = regexp.MustCompile(`(?m)^[\s│]*with ([^\s,]+),`)
+
+func (e *ApplyError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ApplyError) Unwrap() error {
+	return e.Err
+}
+
+// Plan saves a plan in the working directory and returns the resources it
+// would change
+func (t *Terraform) Plan(ctx context.Context, params PlanParams) ([]ResourceChange, error) {
+	args, err := t.withVars(params.Vars, []string{"plan", "-no-color", "-input=false", "-detailed-exitcode", "-out=" + PlanFile})
+	if err != nil {
+		return nil, err
+	}
+	args = withLocking(params.LockTimeout, !params.NoLock, args)
+	args = withRefresh(!params.NoRefresh, args)
+
+	// With -detailed-exitcode, 0 means no changes and 2 means changes
+	err = terraformExec(ctx, t.terraformParams(args, params.Env))
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return nil, nil
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == 2:
+		return t.Show(ctx, params.Env)
+	default:
+		return nil, err
+	}
+}
+
+// Show returns the resources changed by the plan saved by Plan
+func (t *Terraform) Show(ctx context.Context, env map[string]string) ([]ResourceChange, error) {
+	output := bytes.Buffer{}
+	execParams := t.terraformParams([]string{"show", "-no-color", "-json", path.Join(t.workDir, PlanFile)}, env)
+	execParams.stdOut = &output
+	if err := terraformExec(ctx, execParams); err != nil {
+		return nil, err
+	}
+
+	var plan struct {
+		ResourceChanges []struct {
+			Address string `json:"address"`
+			Change  struct {
+				Actions []string `json:"actions"`
+			} `json:"change"`
+		} `json:"resource_changes"`
+	}
+	if err := json.Unmarshal(output.Bytes(), &plan); err != nil {
+		return nil, fmt.Errorf("error decoding plan: %w", err)
+	}
+
+	var changes []ResourceChange
+	for _, rc := range plan.ResourceChanges {
+		if len(rc.Change.Actions) == 1 && (rc.Change.Actions[0] == "no-op" || rc.Change.Actions[0] == "read") {
+			continue
+		}
+		changes = append(changes, ResourceChange{
+			Address: rc.Address,
+			Actions: rc.Change.Actions,
+		})
+	}
+	return changes, nil
+}
+
+// applyError attributes a failed apply to the resources named in its
+// diagnostics
+func applyError(err error, stdErr []byte, progress *progressWriter) error {
+	seen := map[string]bool{}
+	var addresses []string
+	for _, m := range diagnosticAddress.FindAllSubmatch(stdErr, -1) {
+		address := string(m[1])
+		if !seen[address] {
+			seen[address] = true
+			addresses = append(addresses, address)
+		}
+	}
+
+	return &ApplyError{
+		Err:         err,
+		Addresses:   addresses,
+		Completed:   progress.completed,
+		Interrupted: progress.interrupted(),
+	}
+}
+
+// captureStdErr tees stderr of the command into a buffer
+func captureStdErr(execParams *terraformExecParams) *bytes.Buffer {
+	buf := &bytes.Buffer{}
+	execParams.stdErr = io.MultiWriter(buf, execParams.stdErr)
+	return buf
+}
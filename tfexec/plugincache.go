@@ -0,0 +1,182 @@
+package tfexec
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// pluginCacheLocks holds the lock files, terraform ignores directories in
+// the cache that aren't provider hostnames
+const pluginCacheLocks = ".locks"
+
+// corruptCacheEntry matches init errors caused by a damaged cache entry,
+// e.g. one left behind by an init that was killed while copying it
+var corruptCacheEntry = regexp.MustCompile(`(?i)(doesn't match any of the checksums|shared cache|plugin cache|failed to install provider from.*cache)`)
+
+// PluginCacheUsage is how an init used the plugin cache. Providers are
+// missed when init had to download a version the cache didn't have.
+type PluginCacheUsage struct {
+	Hits   []string
+	Misses []string
+
+	// Repaired is set when a corrupted entry was removed and init retried
+	Repaired bool
+}
+
+// pluginCache guards a shared plugin cache directory while terraform init
+// writes to it. Inits installing the same provider are serialized with
+// file locks, so concurrent activities and workers sharing the directory
+// never see each other's partial copies. An init whose providers aren't
+// known locks the whole cache.
+type pluginCache struct {
+	dir       string
+	providers []string
+}
+
+// lock blocks until the cache entries of the providers are free, the
+// returned func releases them
+func (c pluginCache) lock(ctx context.Context) (func(), error) {
+	locksDir := filepath.Join(c.dir, pluginCacheLocks)
+	if err := os.MkdirAll(locksDir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating plugin cache locks: %w", err)
+	}
+
+	var held []*os.File
+	unlock := func() {
+		for i := len(held) - 1; i >= 0; i-- {
+			_ = unlockFile(held[i])
+			_ = held[i].Close()
+		}
+	}
+
+	f, err := lockFile(ctx, filepath.Join(locksDir, "cache.lock"), len(c.providers) == 0)
+	if err != nil {
+		return nil, err
+	}
+	held = append(held, f)
+
+	// Sorted so two inits can't each wait on a provider the other holds
+	for _, provider := range c.providers {
+		f, err := lockFile(ctx, filepath.Join(locksDir, strings.ReplaceAll(provider, "/", "_")+".lock"), true)
+		if err != nil {
+			unlock()
+			return nil, err
+		}
+		held = append(held, f)
+	}
+	return unlock, nil
+}
+
+// versions lists the cached versions of each provider
+func (c pluginCache) versions() map[string]map[string]bool {
+	cached := make(map[string]map[string]bool, len(c.providers))
+	for _, provider := range c.providers {
+		cached[provider] = map[string]bool{}
+		entries, err := os.ReadDir(filepath.Join(c.dir, filepath.FromSlash(provider)))
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			cached[provider][entry.Name()] = true
+		}
+	}
+	return cached
+}
+
+// usage compares the cache to its versions before init
+func (c pluginCache) usage(before map[string]map[string]bool) PluginCacheUsage {
+	var usage PluginCacheUsage
+	after := c.versions()
+	for _, provider := range c.providers {
+		missed := false
+		for version := range after[provider] {
+			if !before[provider][version] {
+				missed = true
+			}
+		}
+		if missed {
+			usage.Misses = append(usage.Misses, provider)
+		} else {
+			usage.Hits = append(usage.Hits, provider)
+		}
+	}
+	return usage
+}
+
+// repair removes the cache entries of the providers named in an init
+// error, or of every locked provider if none is named. Only safe while
+// the providers are locked.
+func (c pluginCache) repair(initErr string) error {
+	var damaged []string
+	for _, provider := range c.providers {
+		if strings.Contains(initErr, provider) {
+			damaged = append(damaged, provider)
+		}
+	}
+	if len(damaged) == 0 {
+		damaged = c.providers
+	}
+
+	for _, provider := range damaged {
+		if err := os.RemoveAll(filepath.Join(c.dir, filepath.FromSlash(provider))); err != nil {
+			return fmt.Errorf("error removing corrupted plugin cache entry %s: %w", provider, err)
+		}
+	}
+	return nil
+}
+
+// ProviderAddress returns the fully qualified source of a provider, e.g.
+// hashicorp/aws -> registry.terraform.io/hashicorp/aws
+func ProviderAddress(source string) string {
+	source = strings.ToLower(source)
+	if strings.Count(source, "/") == 1 {
+		return "registry.terraform.io/" + source
+	}
+	return source
+}
+
+func newPluginCache(dir string, sources []string) pluginCache {
+	seen := map[string]bool{}
+	var providers []string
+	for _, source := range sources {
+		provider := ProviderAddress(source)
+		if !seen[provider] {
+			seen[provider] = true
+			providers = append(providers, provider)
+		}
+	}
+	sort.Strings(providers)
+	return pluginCache{dir: dir, providers: providers}
+}
+
+// lockFile opens path and waits for a lock on it, shared unless exclusive
+func lockFile(ctx context.Context, path string, exclusive bool) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening plugin cache lock: %w", err)
+	}
+
+	for {
+		locked, err := tryLockFile(f, exclusive)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("error locking %s: %w", path, err)
+		}
+		if locked {
+			return f, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			f.Close()
+			return nil, ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
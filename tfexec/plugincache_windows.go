@@ -0,0 +1,17 @@
+//go:build windows
+// +build windows
+
+package tfexec
+
+import (
+	"os"
+)
+
+// Windows has no flock, the plugin cache is left unguarded there
+func tryLockFile(f *os.File, exclusive bool) (bool, error) {
+	return true, nil
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
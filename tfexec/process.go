@@ -0,0 +1,21 @@
+package tfexec
+
+import (
+	"os/exec"
+)
+
+// processGroup runs terraform in its own process group so terraform and the
+// provider plugins it starts can be signalled together
+type processGroup interface {
+	// prepare configures cmd before it is started
+	prepare(cmd *exec.Cmd)
+
+	// interrupt asks the process group to shut down gracefully
+	interrupt(cmd *exec.Cmd) error
+
+	// kill forcibly stops the process group
+	kill(cmd *exec.Cmd) error
+}
+
+// processes is the process group implementation for the current OS
+var processes processGroup = osProcessGroup{}
@@ -0,0 +1,26 @@
+//go:build !windows
+// +build !windows
+
+package tfexec
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+type osProcessGroup struct{}
+
+func (osProcessGroup) prepare(cmd *exec.Cmd) {
+	cmd.SysProcAttr = osSpecificSysProcAttr()
+}
+
+// Using -pid sends the signal to the whole process group
+func (osProcessGroup) interrupt(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGINT)
+}
+
+func (osProcessGroup) kill(cmd *exec.Cmd) error {
+	err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	_ = cmd.Process.Kill()
+	return err
+}
@@ -0,0 +1,36 @@
+//go:build windows
+// +build windows
+
+package tfexec
+
+import (
+	"os/exec"
+	"strconv"
+	"syscall"
+)
+
+var generateConsoleCtrlEvent = syscall.NewLazyDLL("kernel32.dll").NewProc("GenerateConsoleCtrlEvent")
+
+type osProcessGroup struct{}
+
+func (osProcessGroup) prepare(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP,
+	}
+}
+
+// Windows has no SIGINT, CTRL_BREAK is the closest thing terraform handles
+func (osProcessGroup) interrupt(cmd *exec.Cmd) error {
+	r, _, err := generateConsoleCtrlEvent.Call(syscall.CTRL_BREAK_EVENT, uintptr(cmd.Process.Pid))
+	if r == 0 {
+		return err
+	}
+	return nil
+}
+
+// taskkill /T stops the provider plugins terraform started along with it
+func (osProcessGroup) kill(cmd *exec.Cmd) error {
+	err := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(cmd.Process.Pid)).Run()
+	_ = cmd.Process.Kill()
+	return err
+}
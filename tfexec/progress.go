@@ -0,0 +1,70 @@
+package tfexec
+
+import (
+	"bytes"
+	"regexp"
+)
+
+var (
+	// resourceStarted matches terraform starting an operation on a resource,
+	// e.g. `aws_vpc.vpc: Creating...`
+	resourceStarted = regexp.MustCompile(`^(\S+): (Creating|Modifying|Destroying|Reading)\.\.\.`)
+
+	// resourceFinished matches terraform completing an operation on a
+	// resource, e.g. `aws_vpc.vpc: Creation complete after 2s [id=vpc-1]`
+	resourceFinished = regexp.MustCompile(`^(\S+): (Creation|Modifications|Destruction|Read) complete`)
+)
+
+// progressWriter follows the resource operations in apply output so a failed
+// or interrupted apply can report how far it got
+type progressWriter struct {
+	partial    []byte
+	started    []string
+	inProgress map[string]bool
+	completed  []string
+}
+
+func newProgressWriter() *progressWriter {
+	return &progressWriter{inProgress: map[string]bool{}}
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	p.partial = append(p.partial, b...)
+	for {
+		i := bytes.IndexByte(p.partial, '\n')
+		if i < 0 {
+			break
+		}
+		p.line(bytes.TrimSpace(p.partial[:i]))
+		p.partial = p.partial[i+1:]
+	}
+	return len(b), nil
+}
+
+func (p *progressWriter) line(line []byte) {
+	if m := resourceStarted.FindSubmatch(line); m != nil {
+		address := string(m[1])
+		if !p.inProgress[address] {
+			p.inProgress[address] = true
+			p.started = append(p.started, address)
+		}
+		return
+	}
+	if m := resourceFinished.FindSubmatch(line); m != nil {
+		address := string(m[1])
+		delete(p.inProgress, address)
+		p.completed = append(p.completed, address)
+	}
+}
+
+// interrupted returns the resources that were started but never completed,
+// in the order they were started
+func (p *progressWriter) interrupted() []string {
+	var addresses []string
+	for _, address := range p.started {
+		if p.inProgress[address] {
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses
+}
@@ -0,0 +1,106 @@
+package tfexec
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+type (
+	ProvidersSchemaParams struct {
+		Env map[string]string
+	}
+
+	// ProviderSchemas is the output of terraform providers schema, keyed by
+	// provider source, e.g. registry.terraform.io/hashicorp/aws
+	ProviderSchemas struct {
+		FormatVersion string                    `json:"format_version"`
+		Schemas       map[string]ProviderSchema `json:"provider_schemas"`
+	}
+
+	ProviderSchema struct {
+		Provider          *Schema           `json:"provider,omitempty"`
+		ResourceSchemas   map[string]Schema `json:"resource_schemas,omitempty"`
+		DataSourceSchemas map[string]Schema `json:"data_source_schemas,omitempty"`
+	}
+
+	Schema struct {
+		Version int64       `json:"version"`
+		Block   SchemaBlock `json:"block"`
+	}
+
+	SchemaBlock struct {
+		Attributes  map[string]SchemaAttribute   `json:"attributes,omitempty"`
+		BlockTypes  map[string]SchemaNestedBlock `json:"block_types,omitempty"`
+		Description string                       `json:"description,omitempty"`
+		Deprecated  bool                         `json:"deprecated,omitempty"`
+	}
+
+	SchemaAttribute struct {
+		// Type is the attribute's type in terraform's JSON type syntax,
+		// e.g. "string" or ["list","string"]
+		Type        json.RawMessage `json:"type,omitempty"`
+		Description string          `json:"description,omitempty"`
+		Required    bool            `json:"required,omitempty"`
+		Optional    bool            `json:"optional,omitempty"`
+		Computed    bool            `json:"computed,omitempty"`
+		Sensitive   bool            `json:"sensitive,omitempty"`
+		Deprecated  bool            `json:"deprecated,omitempty"`
+	}
+
+	SchemaNestedBlock struct {
+		NestingMode string      `json:"nesting_mode"`
+		Block       SchemaBlock `json:"block"`
+		MinItems    int         `json:"min_items,omitempty"`
+		MaxItems    int         `json:"max_items,omitempty"`
+	}
+)
+
+// ProvidersSchema returns the schemas of the providers the initialized
+// working directory requires
+func (t *Terraform) ProvidersSchema(ctx context.Context, params ProvidersSchemaParams) (*ProviderSchemas, error) {
+	// Schemas run to megabytes, keep them out of the log
+	output := bytes.Buffer{}
+	execParams := t.terraformParams([]string{"providers", "schema", "-json"}, params.Env)
+	execParams.stdOut = &output
+	if err := terraformExec(ctx, execParams); err != nil {
+		return nil, err
+	}
+
+	var schemas ProviderSchemas
+	if err := json.Unmarshal(output.Bytes(), &schemas); err != nil {
+		return nil, fmt.Errorf("error parsing provider schemas: %w", err)
+	}
+	return &schemas, nil
+}
+
+// Resource returns the schema of a managed resource type from whichever
+// provider defines it
+func (s *ProviderSchemas) Resource(resourceType string) (Schema, bool) {
+	for _, provider := range s.Schemas {
+		if schema, ok := provider.ResourceSchemas[resourceType]; ok {
+			return schema, true
+		}
+	}
+	return Schema{}, false
+}
+
+// SensitivePaths lists the sensitive attributes of the block and its nested
+// blocks, e.g. "password" or "master_user_secret.secret_arn"
+func (b SchemaBlock) SensitivePaths() []string {
+	var paths []string
+	for name, attr := range b.Attributes {
+		if attr.Sensitive {
+			paths = append(paths, name)
+		}
+	}
+	for name, nested := range b.BlockTypes {
+		for _, p := range nested.Block.SensitivePaths() {
+			paths = append(paths, name+"."+p)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
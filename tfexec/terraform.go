@@ -1,3 +1,5 @@
+// Package tfexec runs the terraform CLI with cancellation that shuts down
+// terraform and its provider plugins cleanly. Executor is the stable API.
 package tfexec
 
 import (
@@ -8,16 +10,33 @@ import (
 	"io"
 	"log"
 	"os"
-	"os/exec"
 	"path"
+	"path/filepath"
+	"sort"
+	"strings"
 	"text/template"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+
+	"github.com/dynajoe/temporal-terraform-demo/s3object"
 )
 
 type (
 	InitParams struct {
 		Backend S3BackendConfig
+
+		// CLIConfig is used by init and every later command in the working
+		// directory
+		CLIConfig CLIConfig
+
+		// Providers are the sources of the providers the module requires,
+		// e.g. hashicorp/aws. With a plugin cache only their entries are
+		// locked during init, otherwise the whole cache is.
+		Providers []string
+
+		// OnPluginCache is called with how init used the plugin cache
+		OnPluginCache func(PluginCacheUsage)
 	}
 
 	ImportParams struct {
@@ -30,6 +49,44 @@ type (
 	ApplyParams struct {
 		Vars map[string]interface{}
 		Env  map[string]string
+
+		// Parallelism limits concurrent resource operations, zero uses
+		// terraform's default
+		Parallelism int
+
+		// ResourceTimeouts overrides the timeouts block of resources by
+		// address, e.g. "aws_subnet.subnet"
+		ResourceTimeouts map[string]ResourceTimeouts
+
+		// Targets limits the apply to the given resource addresses
+		Targets []string
+
+		// InterruptTimeout is how long terraform may take to exit after being
+		// interrupted before it is killed, see defaultInterruptTimeout
+		InterruptTimeout time.Duration
+
+		// LockTimeout and NoRefresh, see PlanParams
+		LockTimeout time.Duration
+		NoRefresh   bool
+
+		// PlanFile applies a saved plan, relative to the working directory,
+		// instead of planning again. Vars, targets, resource timeouts and
+		// refresh are then taken from the plan.
+		PlanFile string
+	}
+
+	// ResourceTimeouts are durations such as "30m", empty values keep the
+	// provider's default
+	ResourceTimeouts struct {
+		Create string
+		Update string
+		Delete string
+	}
+
+	resourceTimeoutsTemplateVars struct {
+		Type string
+		Name string
+		ResourceTimeouts
 	}
 
 	OutputParams struct {
@@ -37,8 +94,22 @@ type (
 	}
 
 	DestroyParams struct {
-		Vars map[string]interface{}
-		Env  map[string]string
+		Vars        map[string]interface{}
+		Env         map[string]string
+		Parallelism int
+
+		// LockTimeout, see PlanParams
+		LockTimeout time.Duration
+	}
+
+	// StateRmParams names the resource instances to forget, they are left
+	// in place outside of terraform
+	StateRmParams struct {
+		Env       map[string]string
+		Addresses []string
+
+		// LockTimeout, see PlanParams
+		LockTimeout time.Duration
 	}
 
 	Output struct {
@@ -52,22 +123,68 @@ type (
 		Region      string
 		Env         map[string]string
 		Credentials aws.CredentialsProvider
+
+		// DynamoDBTable enables state locking with the given table
+		DynamoDBTable string
+
+		// KMSKeyID encrypts the state, and the artifacts Objects puts next to
+		// it, with a customer managed KMS key rather than the bucket's default
+		// encryption
+		KMSKeyID string
+
+		// Store overrides S3 for access to state and artifacts outside of
+		// terraform, e.g. s3object.Memory in tests
+		Store s3object.Store
+
+		// LocalDir keeps the state in terraform's local backend at the file
+		// s3object.Dir keeps the object in, and the artifacts next to it, for
+		// development without an AWS account. Credentials aren't needed.
+		LocalDir string
 	}
 
 	s3BackendConfigTemplateVars struct {
-		Bucket    string
-		Key       string
-		Region    string
-		AccessKey string
-		SecretKey string
-		Token     string
+		Bucket        string
+		Key           string
+		Region        string
+		DynamoDBTable string
+		KMSKeyID      string
+
+		// CredentialsFile is the shared credentials file holding Profile
+		CredentialsFile string
+		Profile         string
 	}
 
-	NewTerraformFunc func(workDir string) (*Terraform, error)
+	// Executor runs terraform commands in a working directory. *Terraform
+	// is the implementation backed by the terraform CLI.
+	Executor interface {
+		Init(ctx context.Context, params InitParams) error
+		Import(ctx context.Context, params ImportParams) error
+		Plan(ctx context.Context, params PlanParams) ([]ResourceChange, error)
+		Apply(ctx context.Context, params ApplyParams) error
+		Destroy(ctx context.Context, params DestroyParams) error
+		StateRm(ctx context.Context, params StateRmParams) error
+		Output(ctx context.Context, params OutputParams) (map[string]Output, error)
+		Test(ctx context.Context, params TestParams) (TestReport, error)
+		ProvidersSchema(ctx context.Context, params ProvidersSchemaParams) (*ProviderSchemas, error)
+		Version(ctx context.Context, env map[string]string) (Versions, error)
+	}
+
+	NewTerraformFunc func(workDir string) (Executor, error)
 
 	Terraform struct {
 		tfPath  string
 		workDir string
+
+		// cliConfigPath is set once Init has written a CLI config
+		cliConfigPath string
+
+		// cliConfig is set by Init, its Args and Env apply to init and every
+		// later command
+		cliConfig CLIConfig
+
+		// backendCredentials are set by Init for an s3 backend and kept
+		// fresh while every command runs
+		backendCredentials *backendCredentials
 	}
 )
 
@@ -78,18 +195,61 @@ terraform {
 	  bucket     = "{{ .Bucket }}"
 	  key        = "{{ .Key }}"
 	  region     = "{{ .Region }}"
-	  access_key = "{{ .AccessKey }}"
-	  secret_key = "{{ .SecretKey }}"
-	  token      = "{{ .Token }}"
+{{- if .DynamoDBTable }}
+	  dynamodb_table = "{{ .DynamoDBTable }}"
+{{- end }}
+{{- if .KMSKeyID }}
+	  kms_key_id = "{{ .KMSKeyID }}"
+{{- end }}
+	  shared_credentials_file = "{{ .CredentialsFile }}"
+	  profile    = "{{ .Profile }}"
 	}
 }
 `))
 
+var localBackendConfigTemplate = template.Must(template.New("terraform local backend config").Parse(`
+terraform {
+	backend "local" {
+	  path = "{{ . }}"
+	}
+}
+`))
+
+// resourceTimeoutsTemplate is written as an override file, which terraform
+// merges into the resources of the module
+var resourceTimeoutsTemplate = template.Must(template.New("terraform resource timeouts").Parse(`
+{{- range . }}
+resource "{{ .Type }}" "{{ .Name }}" {
+  timeouts {
+{{- if .Create }}
+    create = "{{ .Create }}"
+{{- end }}
+{{- if .Update }}
+    update = "{{ .Update }}"
+{{- end }}
+{{- if .Delete }}
+    delete = "{{ .Delete }}"
+{{- end }}
+  }
+}
+{{ end }}`))
+
+// Objects returns the store holding the state and artifacts stored next to it
+func (b S3BackendConfig) Objects() s3object.Store {
+	if b.Store != nil {
+		return b.Store
+	}
+	if b.LocalDir != "" {
+		return s3object.Dir{Root: b.LocalDir}
+	}
+	return s3object.New(b.Credentials, b.Region).WithKMSKey(b.KMSKeyID)
+}
+
 func LazyFromPath() NewTerraformFunc {
 	var resolvedPath string
-	return func(workDir string) (*Terraform, error) {
+	return func(workDir string) (Executor, error) {
 		if resolvedPath == "" {
-			tfPath, err := exec.LookPath("terraform")
+			tfPath, err := FindTerraform()
 			if err != nil {
 				return nil, err
 			}
@@ -103,33 +263,110 @@ func LazyFromPath() NewTerraformFunc {
 }
 
 func (t *Terraform) Init(ctx context.Context, params InitParams) error {
-	creds, err := params.Backend.Credentials.Retrieve(ctx)
+	configBuf, backendCreds, err := backendConfig(ctx, t.workDir, params.Backend)
 	if err != nil {
+		return fmt.Errorf("error creating backend config: %w", err)
+	}
+	t.backendCredentials = backendCreds
+	if err := os.WriteFile(path.Join(t.workDir, "_backend.tf"), configBuf, 0600); err != nil {
 		return err
 	}
 
-	// Ensure backend is configured for s3
+	if !params.CLIConfig.IsZero() {
+		if err := t.writeCLIConfig(params.CLIConfig); err != nil {
+			return err
+		}
+	}
+	t.cliConfig = params.CLIConfig
+
+	if params.CLIConfig.PluginCacheDir == "" {
+		execParams := t.terraformParams([]string{"init", "-no-color"}, params.Backend.Env)
+		return terraformExec(ctx, execParams)
+	}
+	return t.initWithPluginCache(ctx, params)
+}
+
+// backendConfig renders the backend block, s3 unless the state is local. The
+// s3 backend's credentials are written to a shared credentials file in the
+// working directory rather than into the block.
+func backendConfig(ctx context.Context, workDir string, backend S3BackendConfig) ([]byte, *backendCredentials, error) {
 	configBuf := bytes.Buffer{}
+	if backend.LocalDir != "" {
+		name, err := s3object.Dir{Root: backend.LocalDir}.File(backend.Bucket, backend.Key)
+		if err != nil {
+			return nil, nil, err
+		}
+		if name, err = filepath.Abs(name); err != nil {
+			return nil, nil, err
+		}
+		// Terraform doesn't create the state's directory
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			return nil, nil, err
+		}
+		if err := localBackendConfigTemplate.Execute(&configBuf, filepath.ToSlash(name)); err != nil {
+			return nil, nil, err
+		}
+		return configBuf.Bytes(), nil, nil
+	}
+
+	credentials, credentialsFile, err := writeBackendCredentials(ctx, workDir, backend.Credentials)
+	if err != nil {
+		return nil, nil, err
+	}
 	if err := backendConfigTemplate.Execute(&configBuf, s3BackendConfigTemplateVars{
-		Bucket:    params.Backend.Bucket,
-		Key:       params.Backend.Key,
-		Region:    params.Backend.Region,
-		AccessKey: creds.AccessKeyID,
-		SecretKey: creds.SecretAccessKey,
-		Token:     creds.SessionToken,
+		Bucket:          backend.Bucket,
+		Key:             backend.Key,
+		Region:          backend.Region,
+		DynamoDBTable:   backend.DynamoDBTable,
+		KMSKeyID:        backend.KMSKeyID,
+		CredentialsFile: filepath.ToSlash(credentialsFile),
+		Profile:         backendProfile,
 	}); err != nil {
-		return fmt.Errorf("error creating backend config: %w", err)
+		return nil, nil, err
 	}
+	return configBuf.Bytes(), credentials, nil
+}
 
-	if err := os.WriteFile(path.Join(t.workDir, "_backend.tf"), configBuf.Bytes(), os.ModePerm); err != nil {
+// initWithPluginCache runs init holding the plugin cache entries it may
+// write, and retries once after removing entries init found corrupted
+func (t *Terraform) initWithPluginCache(ctx context.Context, params InitParams) error {
+	cacheDir, err := filepath.Abs(params.CLIConfig.PluginCacheDir)
+	if err != nil {
 		return err
 	}
+	cache := newPluginCache(cacheDir, params.Providers)
 
-	execParams := t.terraformParams([]string{"init", "-no-color"}, params.Backend.Env)
-	if err := terraformExec(ctx, execParams); err != nil {
+	unlock, err := cache.lock(ctx)
+	if err != nil {
 		return err
 	}
+	defer unlock()
+
+	before := cache.versions()
+	repaired := false
+	for {
+		execParams := t.terraformParams([]string{"init", "-no-color"}, params.Backend.Env)
+		stdErr := captureStdErr(&execParams)
+		err = terraformExec(ctx, execParams)
+		if err == nil || repaired || !corruptCacheEntry.Match(stdErr.Bytes()) {
+			break
+		}
 
+		log.Printf("plugin cache entry corrupted, removing it and retrying init")
+		if err := cache.repair(stdErr.String()); err != nil {
+			return err
+		}
+		repaired = true
+	}
+	if err != nil {
+		return err
+	}
+
+	if params.OnPluginCache != nil {
+		usage := cache.usage(before)
+		usage.Repaired = repaired
+		params.OnPluginCache(usage)
+	}
 	return nil
 }
 
@@ -144,13 +381,36 @@ func (t *Terraform) Import(ctx context.Context, params ImportParams) error {
 }
 
 func (t *Terraform) Apply(ctx context.Context, params ApplyParams) error {
-	args, err := t.withVars(params.Vars, []string{"apply", "-auto-approve", "-no-color", "-input=false"})
-	if err != nil {
-		return err
+	args := []string{"apply", "-auto-approve", "-no-color", "-input=false"}
+	args = withParallelism(params.Parallelism, args)
+	args = withLocking(params.LockTimeout, true, args)
+
+	if params.PlanFile != "" {
+		// The saved plan carries everything else
+		args = append(args, params.PlanFile)
+	} else {
+		var err error
+		if args, err = t.withVars(params.Vars, args); err != nil {
+			return err
+		}
+		args = withRefresh(!params.NoRefresh, args)
+		for _, target := range params.Targets {
+			args = append(args, "-target="+target)
+		}
+		if err := t.writeResourceTimeouts(params.ResourceTimeouts); err != nil {
+			return err
+		}
 	}
 
 	execParams := t.terraformParams(args, params.Env)
-	return terraformExec(ctx, execParams)
+	execParams.interruptTimeout = params.InterruptTimeout
+	stdErr := captureStdErr(&execParams)
+	progress := newProgressWriter()
+	execParams.stdOut = io.MultiWriter(progress, execParams.stdOut)
+	if err := terraformExec(ctx, execParams); err != nil {
+		return applyError(err, stdErr.Bytes(), progress)
+	}
+	return nil
 }
 
 func (t *Terraform) Destroy(ctx context.Context, params DestroyParams) error {
@@ -158,6 +418,19 @@ func (t *Terraform) Destroy(ctx context.Context, params DestroyParams) error {
 	if err != nil {
 		return err
 	}
+	args = withParallelism(params.Parallelism, args)
+	args = withLocking(params.LockTimeout, true, args)
+
+	execParams := t.terraformParams(args, params.Env)
+	return terraformExec(ctx, execParams)
+}
+
+func (t *Terraform) StateRm(ctx context.Context, params StateRmParams) error {
+	if len(params.Addresses) == 0 {
+		return nil
+	}
+	args := withLocking(params.LockTimeout, true, []string{"state", "rm"})
+	args = append(args, params.Addresses...)
 
 	execParams := t.terraformParams(args, params.Env)
 	return terraformExec(ctx, execParams)
@@ -195,16 +468,36 @@ func (t *Terraform) Output(ctx context.Context, params OutputParams) (map[string
 }
 
 func (t *Terraform) terraformParams(args []string, env map[string]string) terraformExecParams {
+	if t.cliConfigPath != "" || len(t.cliConfig.Env) > 0 {
+		withConfig := make(map[string]string, len(t.cliConfig.Env)+len(env)+1)
+		for k, v := range t.cliConfig.Env {
+			withConfig[k] = v
+		}
+		for k, v := range env {
+			withConfig[k] = v
+		}
+		if t.cliConfigPath != "" {
+			withConfig["TF_CLI_CONFIG_FILE"] = t.cliConfigPath
+		}
+		env = withConfig
+	}
+	args = t.cliConfig.withArgs(args)
+
 	return terraformExecParams{
-		tfPath:  t.tfPath,
-		workDir: t.workDir,
-		args:    args,
-		env:     env,
-		stdErr:  log.Writer(),
-		stdOut:  log.Writer(),
+		tfPath:             t.tfPath,
+		workDir:            t.workDir,
+		args:               args,
+		env:                env,
+		stdErr:             log.Writer(),
+		stdOut:             log.Writer(),
+		backendCredentials: t.backendCredentials,
 	}
 }
 
+// VarsFile is where commands are passed their vars, relative to the working
+// directory. It holds sensitive values.
+const VarsFile = "terraform.tfvars.json"
+
 func (t *Terraform) withVars(vars map[string]interface{}, args []string) ([]string, error) {
 	if len(vars) > 0 {
 		varsJson, err := json.Marshal(vars)
@@ -212,8 +505,8 @@ func (t *Terraform) withVars(vars map[string]interface{}, args []string) ([]stri
 			return nil, err
 		}
 
-		varFilePath := path.Join(t.workDir, "terraform.tfvars.json")
-		if err := os.WriteFile(varFilePath, varsJson, os.ModePerm); err != nil {
+		varFilePath := path.Join(t.workDir, VarsFile)
+		if err := os.WriteFile(varFilePath, varsJson, 0600); err != nil {
 			return nil, err
 		}
 
@@ -222,21 +515,115 @@ func (t *Terraform) withVars(vars map[string]interface{}, args []string) ([]stri
 	return args, nil
 }
 
-func parseJson(message json.RawMessage) interface{} {
-	var s string
-	if err := json.Unmarshal(message, &s); err == nil {
-		return s
+func withParallelism(parallelism int, args []string) []string {
+	if parallelism > 0 {
+		args = append(args, fmt.Sprintf("-parallelism=%d", parallelism))
+	}
+	return args
+}
+
+func withLocking(lockTimeout time.Duration, lock bool, args []string) []string {
+	if !lock {
+		return append(args, "-lock=false")
+	}
+	if lockTimeout > 0 {
+		args = append(args, "-lock-timeout="+lockTimeout.String())
+	}
+	return args
+}
+
+func withRefresh(refresh bool, args []string) []string {
+	if !refresh {
+		args = append(args, "-refresh=false")
+	}
+	return args
+}
+
+func (t *Terraform) writeResourceTimeouts(timeouts map[string]ResourceTimeouts) error {
+	if len(timeouts) == 0 {
+		return nil
+	}
+
+	addresses := make([]string, 0, len(timeouts))
+	for address := range timeouts {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+
+	vars := make([]resourceTimeoutsTemplateVars, 0, len(addresses))
+	for _, address := range addresses {
+		parts := strings.Split(address, ".")
+		if len(parts) != 2 {
+			return fmt.Errorf("resource timeouts address [%s] must be of the form type.name", address)
+		}
+		vars = append(vars, resourceTimeoutsTemplateVars{
+			Type:             parts[0],
+			Name:             parts[1],
+			ResourceTimeouts: timeouts[address],
+		})
+	}
+
+	configBuf := bytes.Buffer{}
+	if err := resourceTimeoutsTemplate.Execute(&configBuf, vars); err != nil {
+		return fmt.Errorf("error creating resource timeouts: %w", err)
 	}
 
-	var ss []string
-	if err := json.Unmarshal(message, &ss); err == nil {
-		return ss
+	return os.WriteFile(path.Join(t.workDir, "_timeouts_override.tf"), configBuf.Bytes(), os.ModePerm)
+}
+
+// DecodeOutputValue decodes an output value the same way Output does
+func DecodeOutputValue(message json.RawMessage) interface{} {
+	return parseJson(message)
+}
+
+// parseJson decodes an output value as plain JSON, numbers are float64 as
+// they are once a value passed through an activity result. Lists of strings
+// are decoded as []string.
+func parseJson(message json.RawMessage) interface{} {
+	var v interface{}
+	if err := json.Unmarshal(message, &v); err != nil {
+		return message
 	}
+	return stringLists(v)
+}
 
-	var n int
-	if err := json.Unmarshal(message, &n); err == nil {
-		return n
+func stringLists(v interface{}) interface{} {
+	switch v := v.(type) {
+	case []interface{}:
+		ss := make([]string, 0, len(v))
+		for i, e := range v {
+			v[i] = stringLists(e)
+			if s, ok := e.(string); ok {
+				ss = append(ss, s)
+			}
+		}
+		if len(ss) == len(v) {
+			return ss
+		}
+		return v
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = stringLists(e)
+		}
+		return v
+	default:
+		return v
 	}
+}
 
-	return message
+// MirrorProviders downloads the providers the configuration in workDir
+// requires into a filesystem mirror at mirrorDir
+func MirrorProviders(ctx context.Context, workDir string, mirrorDir string) error {
+	tfPath, err := FindTerraform()
+	if err != nil {
+		return err
+	}
+	return terraformExec(ctx, terraformExecParams{
+		tfPath:  tfPath,
+		workDir: workDir,
+		args:    []string{"providers", "mirror", mirrorDir},
+		env:     map[string]string{"PATH": os.Getenv("PATH"), "HOME": os.Getenv("HOME")},
+		stdErr:  log.Writer(),
+		stdOut:  log.Writer(),
+	})
 }
@@ -0,0 +1,154 @@
+package tfexec
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+)
+
+type (
+	TestParams struct {
+		Vars map[string]interface{}
+		Env  map[string]string
+
+		// Filter limits the run to the given test files
+		Filter []string
+	}
+
+	// TestReport is the outcome of terraform test. Status is pass, fail,
+	// error or skip.
+	TestReport struct {
+		Status  string
+		Passed  int
+		Failed  int
+		Errored int
+		Skipped int
+		Runs    []TestRun
+	}
+
+	// TestRun is a single run block of a test file
+	TestRun struct {
+		File        string
+		Run         string
+		Status      string
+		Diagnostics []string
+	}
+
+	testMessage struct {
+		Type     string `json:"type"`
+		TestFile string `json:"@testfile"`
+		TestRun  string `json:"@testrun"`
+		Run      *struct {
+			Path     string `json:"path"`
+			Run      string `json:"run"`
+			Progress string `json:"progress"`
+			Status   string `json:"status"`
+		} `json:"test_run"`
+		Summary *struct {
+			Status  string `json:"status"`
+			Passed  int    `json:"passed"`
+			Failed  int    `json:"failed"`
+			Errored int    `json:"errored"`
+			Skipped int    `json:"skipped"`
+		} `json:"test_summary"`
+		Diagnostic *struct {
+			Severity string `json:"severity"`
+			Summary  string `json:"summary"`
+			Detail   string `json:"detail"`
+		} `json:"diagnostic"`
+	}
+)
+
+// OK reports whether the tests passed
+func (r TestReport) OK() bool {
+	return r.Status == "pass"
+}
+
+// Test runs the module's tests. Failing tests are reported rather than
+// returned as an error, which is kept for terraform failing to run them.
+func (t *Terraform) Test(ctx context.Context, params TestParams) (TestReport, error) {
+	args, err := t.withVars(params.Vars, []string{"test", "-no-color", "-json"})
+	if err != nil {
+		return TestReport{}, err
+	}
+	for _, file := range params.Filter {
+		args = append(args, "-filter="+file)
+	}
+
+	output := bytes.Buffer{}
+	execParams := t.terraformParams(args, params.Env)
+	execParams.stdOut = io.MultiWriter(&output, execParams.stdOut)
+	execErr := terraformExec(ctx, execParams)
+
+	// terraform test exits 1 when tests fail
+	var exitErr *exec.ExitError
+	if execErr != nil && !(errors.As(execErr, &exitErr) && exitErr.ExitCode() == 1) {
+		return TestReport{}, execErr
+	}
+
+	report, ok, err := parseTestReport(&output)
+	if err != nil {
+		return TestReport{}, err
+	}
+	if !ok {
+		if execErr != nil {
+			return TestReport{}, execErr
+		}
+		return TestReport{}, errors.New("terraform test did not report a summary")
+	}
+	return report, nil
+}
+
+// parseTestReport reads the JSON lines written by terraform test -json
+func parseTestReport(r io.Reader) (TestReport, bool, error) {
+	var report TestReport
+	summarized := false
+	runs := map[string]int{}
+	runKey := func(file, run string) string { return file + "/" + run }
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var msg testMessage
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			continue
+		}
+
+		switch {
+		case msg.Type == "test_run" && msg.Run != nil && msg.Run.Progress == "complete":
+			key := runKey(msg.Run.Path, msg.Run.Run)
+			if i, ok := runs[key]; ok {
+				report.Runs[i].Status = msg.Run.Status
+				continue
+			}
+			runs[key] = len(report.Runs)
+			report.Runs = append(report.Runs, TestRun{File: msg.Run.Path, Run: msg.Run.Run, Status: msg.Run.Status})
+		case msg.Type == "diagnostic" && msg.Diagnostic != nil && msg.TestRun != "":
+			key := runKey(msg.TestFile, msg.TestRun)
+			i, ok := runs[key]
+			if !ok {
+				i = len(report.Runs)
+				runs[key] = i
+				report.Runs = append(report.Runs, TestRun{File: msg.TestFile, Run: msg.TestRun})
+			}
+			report.Runs[i].Diagnostics = append(report.Runs[i].Diagnostics,
+				fmt.Sprintf("%s: %s %s", msg.Diagnostic.Severity, msg.Diagnostic.Summary, msg.Diagnostic.Detail))
+		case msg.Type == "test_summary" && msg.Summary != nil:
+			summarized = true
+			report.Status = msg.Summary.Status
+			report.Passed = msg.Summary.Passed
+			report.Failed = msg.Summary.Failed
+			report.Errored = msg.Summary.Errored
+			report.Skipped = msg.Summary.Skipped
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return TestReport{}, false, fmt.Errorf("error reading terraform test output: %w", err)
+	}
+	return report, summarized, nil
+}
@@ -0,0 +1,72 @@
+package tfexec
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// Versions are the terraform and provider versions of an initialized
+// working directory. Providers are keyed by source address, e.g.
+// registry.terraform.io/hashicorp/aws.
+type Versions struct {
+	Terraform string
+	Providers map[string]string
+}
+
+// Version returns the versions terraform and the working directory's
+// providers run at
+func (t *Terraform) Version(ctx context.Context, env map[string]string) (Versions, error) {
+	output := bytes.Buffer{}
+	execParams := t.terraformParams([]string{"version", "-json"}, env)
+	execParams.stdOut = &output
+	if err := terraformExec(ctx, execParams); err != nil {
+		return Versions{}, err
+	}
+
+	var parsed struct {
+		TerraformVersion   string            `json:"terraform_version"`
+		ProviderSelections map[string]string `json:"provider_selections"`
+	}
+	if err := json.Unmarshal(output.Bytes(), &parsed); err != nil {
+		return Versions{}, fmt.Errorf("error parsing terraform version: %w", err)
+	}
+	return Versions{Terraform: parsed.TerraformVersion, Providers: parsed.ProviderSelections}, nil
+}
+
+// Diff describes how other differs from v, e.g. "terraform 1.1.7 != 1.2.0"
+func (v Versions) Diff(other Versions) []string {
+	var diffs []string
+	if v.Terraform != other.Terraform {
+		diffs = append(diffs, fmt.Sprintf("terraform %s != %s", v.Terraform, other.Terraform))
+	}
+
+	providers := map[string]bool{}
+	for p := range v.Providers {
+		providers[p] = true
+	}
+	for p := range other.Providers {
+		providers[p] = true
+	}
+	names := make([]string, 0, len(providers))
+	for p := range providers {
+		names = append(names, p)
+	}
+	sort.Strings(names)
+
+	for _, p := range names {
+		if v.Providers[p] != other.Providers[p] {
+			diffs = append(diffs, fmt.Sprintf("%s %s != %s", p, versionOrNone(v.Providers[p]), versionOrNone(other.Providers[p])))
+		}
+	}
+	return diffs
+}
+
+func versionOrNone(version string) string {
+	if version == "" {
+		return "(none)"
+	}
+	return version
+}
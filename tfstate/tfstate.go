@@ -0,0 +1,103 @@
+package tfstate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/dynajoe/temporal-terraform-demo/tfexec"
+)
+
+// supportedVersion is the state format version written by terraform >= 0.12
+const supportedVersion = 4
+
+type (
+	State struct {
+		Version          int               `json:"version"`
+		TerraformVersion string            `json:"terraform_version"`
+		Serial           int64             `json:"serial"`
+		Lineage          string            `json:"lineage"`
+		Outputs          map[string]Output `json:"outputs"`
+		Resources        []Resource        `json:"resources"`
+	}
+
+	Output struct {
+		Value     json.RawMessage `json:"value"`
+		Type      json.RawMessage `json:"type"`
+		Sensitive bool            `json:"sensitive,omitempty"`
+	}
+
+	Resource struct {
+		Module    string     `json:"module,omitempty"`
+		Mode      string     `json:"mode"`
+		Type      string     `json:"type"`
+		Name      string     `json:"name"`
+		Provider  string     `json:"provider"`
+		Instances []Instance `json:"instances"`
+	}
+
+	Instance struct {
+		IndexKey      interface{}            `json:"index_key,omitempty"`
+		SchemaVersion int                    `json:"schema_version"`
+		Attributes    map[string]interface{} `json:"attributes"`
+		Dependencies  []string               `json:"dependencies,omitempty"`
+	}
+)
+
+// Parse decodes terraform state JSON
+func Parse(data []byte) (*State, error) {
+	var state State
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("error parsing terraform state: %w", err)
+	}
+	if state.Version != supportedVersion {
+		return nil, fmt.Errorf("unsupported terraform state version: %d", state.Version)
+	}
+	return &state, nil
+}
+
+// Load reads and parses the state stored by the S3 backend without running
+// terraform. Returns s3object.ErrNotFound if no state has been written yet.
+func Load(ctx context.Context, backend tfexec.S3BackendConfig) (*State, error) {
+	data, err := backend.Objects().Get(ctx, backend.Bucket, backend.Key)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// OutputValue decodes the value of the named output into v
+func (s *State) OutputValue(name string, v interface{}) error {
+	o, ok := s.Outputs[name]
+	if !ok {
+		return fmt.Errorf("missing output [%s] in state", name)
+	}
+	if err := json.Unmarshal(o.Value, v); err != nil {
+		return fmt.Errorf("error decoding output [%s]: %w", name, err)
+	}
+	return nil
+}
+
+// Address returns the resource address as used on the terraform command line
+func (r Resource) Address() string {
+	addr := r.Type + "." + r.Name
+	if r.Mode == "data" {
+		addr = "data." + addr
+	}
+	if r.Module != "" {
+		addr = r.Module + "." + addr
+	}
+	return addr
+}
+
+// InstanceAddress returns the address of a single instance of the resource
+func (r Resource) InstanceAddress(i Instance) string {
+	switch k := i.IndexKey.(type) {
+	case string:
+		return fmt.Sprintf("%s[%q]", r.Address(), k)
+	case float64:
+		return fmt.Sprintf("%s[%d]", r.Address(), int(k))
+	default:
+		return r.Address()
+	}
+}
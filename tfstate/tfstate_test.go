@@ -0,0 +1,58 @@
+package tfstate
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dynajoe/temporal-terraform-demo/s3object"
+	"github.com/dynajoe/temporal-terraform-demo/tfexec"
+)
+
+const testState = `{
+  "version": 4,
+  "serial": 7,
+  "outputs": {"vpc_id": {"value": "vpc-123", "type": "string"}},
+  "resources": [
+    {"mode": "managed", "type": "aws_subnet", "name": "subnet", "instances": [
+      {"index_key": "a", "attributes": {"id": "subnet-a"}},
+      {"index_key": 1, "attributes": {"id": "subnet-b"}}
+    ]},
+    {"module": "module.dns", "mode": "data", "type": "aws_route53_zone", "name": "zone", "instances": [{}]}
+  ]
+}`
+
+func TestLoad(t *testing.T) {
+	ctx := context.Background()
+	backend := tfexec.S3BackendConfig{Bucket: "state", Key: "network/vpc.tfstate", Store: s3object.NewMemory()}
+
+	_, err := Load(ctx, backend)
+	require.ErrorIs(t, err, s3object.ErrNotFound)
+
+	require.NoError(t, backend.Store.Put(ctx, backend.Bucket, backend.Key, []byte(testState)))
+	state, err := Load(ctx, backend)
+	require.NoError(t, err)
+	require.Equal(t, int64(7), state.Serial)
+
+	var vpcID string
+	require.NoError(t, state.OutputValue("vpc_id", &vpcID))
+	require.Equal(t, "vpc-123", vpcID)
+
+	var addresses []string
+	for _, r := range state.Resources {
+		for _, i := range r.Instances {
+			addresses = append(addresses, r.InstanceAddress(i))
+		}
+	}
+	require.Equal(t, []string{
+		`aws_subnet.subnet["a"]`,
+		`aws_subnet.subnet[1]`,
+		`module.dns.data.aws_route53_zone.zone`,
+	}, addresses)
+}
+
+func TestParseRejectsOldVersions(t *testing.T) {
+	_, err := Parse([]byte(`{"version": 3}`))
+	require.Error(t, err)
+}
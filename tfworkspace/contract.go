@@ -0,0 +1,128 @@
+package tfworkspace
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/dynajoe/temporal-terraform-demo/tfconfig"
+)
+
+// OutputType is the expected type of a terraform output
+type OutputType string
+
+const (
+	OutputString     OutputType = "string"
+	OutputStringList OutputType = "list(string)"
+	OutputNumber     OutputType = "number"
+	OutputBool       OutputType = "bool"
+	OutputStringMap  OutputType = "map(string)"
+)
+
+// ContractViolationError is returned when a successful apply produces outputs
+// that don't match the outputs declared for the stack
+type ContractViolationError struct {
+	TerraformPath string
+	Violations    []string
+}
+
+func (e *ContractViolationError) Error() string {
+	return fmt.Sprintf("output contract violation for %s: %s", e.TerraformPath, strings.Join(e.Violations, "; "))
+}
+
+func verifyOutputs(terraformPath string, contract map[string]OutputType, output map[string]interface{}) error {
+	var violations []string
+	for name, expected := range contract {
+		v, ok := output[name]
+		if !ok {
+			violations = append(violations, fmt.Sprintf("missing output [%s]", name))
+			continue
+		}
+		if !matchesType(v, expected) {
+			violations = append(violations, fmt.Sprintf("output [%s] is not of type %s", name, expected))
+		}
+	}
+
+	if len(violations) == 0 {
+		return nil
+	}
+
+	// Map iteration order is random, keep the error message stable
+	sort.Strings(violations)
+	return &ContractViolationError{
+		TerraformPath: terraformPath,
+		Violations:    violations,
+	}
+}
+
+func matchesType(v interface{}, t OutputType) bool {
+	var ok bool
+	switch t {
+	case OutputString:
+		_, ok = v.(string)
+	case OutputStringList:
+		_, ok = stringList(v)
+	case OutputNumber:
+		_, ok = v.(float64)
+	case OutputBool:
+		_, ok = v.(bool)
+	case OutputStringMap:
+		_, ok = stringMap(v)
+	}
+	return ok
+}
+
+// stringList accepts the []string tfexec decodes string lists as and the
+// []interface{} they become after passing through an activity result
+func stringList(v interface{}) ([]string, bool) {
+	switch v := v.(type) {
+	case []string:
+		return v, true
+	case []interface{}:
+		ss := make([]string, len(v))
+		for i, e := range v {
+			s, ok := e.(string)
+			if !ok {
+				return nil, false
+			}
+			ss[i] = s
+		}
+		return ss, true
+	default:
+		return nil, false
+	}
+}
+
+// stringMap accepts maps of strings before and after passing through an
+// activity result
+func stringMap(v interface{}) (map[string]string, bool) {
+	switch v := v.(type) {
+	case map[string]string:
+		return v, true
+	case map[string]interface{}:
+		m := make(map[string]string, len(v))
+		for k, e := range v {
+			s, ok := e.(string)
+			if !ok {
+				return nil, false
+			}
+			m[k] = s
+		}
+		return m, true
+	default:
+		return nil, false
+	}
+}
+
+// ModuleOutputContract derives an output contract from the type annotations
+// on a module's outputs. Outputs without a supported type are not checked.
+func ModuleOutputContract(module *tfconfig.Module) map[string]OutputType {
+	contract := map[string]OutputType{}
+	for _, o := range module.Outputs {
+		switch t := OutputType(o.Type.String()); t {
+		case OutputString, OutputStringList, OutputNumber, OutputBool, OutputStringMap:
+			contract[o.Name] = t
+		}
+	}
+	return contract
+}
@@ -0,0 +1,114 @@
+package tfworkspace
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+
+	"github.com/dynajoe/temporal-terraform-demo/tfexec"
+)
+
+// CredentialsMode controls how AWS credentials are handed to the terraform process
+type CredentialsMode int
+
+const (
+	// CredentialsEnv passes credentials as AWS_* environment variables
+	CredentialsEnv CredentialsMode = iota
+
+	// CredentialsFile writes credentials to a read-only shared credentials file
+	// that exists only for the duration of the run. Only the path to the file
+	// is placed in the terraform process environment.
+	CredentialsFile
+
+	// CredentialsEndpoint serves credentials to terraform from a loopback
+	// endpoint for the duration of the run, refreshing them as they expire.
+	// Use it for runs that may outlive session credentials.
+	CredentialsEndpoint
+)
+
+// terraformEnv copies env and adds the stack's provider credentials and AWS
+// credentials according to mode. The returned cleanup func must be called
+// once terraform has exited.
+func (w *Workspace) terraformEnv(ctx context.Context, env map[string]string, credentials aws.CredentialsProvider, mode CredentialsMode) (map[string]string, func(), error) {
+	// Copy env to a new map
+	tfEnv := make(map[string]string, len(env))
+	for k, v := range env {
+		tfEnv[k] = v
+	}
+
+	for _, provider := range w.config.ProviderCredentials {
+		providerEnv, err := provider.Env(ctx)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error resolving provider credentials: %w", err)
+		}
+		for k, v := range providerEnv {
+			tfEnv[k] = v
+		}
+	}
+
+	if credentials == nil {
+		return tfEnv, func() {}, nil
+	}
+
+	if mode == CredentialsEndpoint {
+		endpoint, token, stop, err := serveCredentials(credentials)
+		if err != nil {
+			return nil, nil, err
+		}
+		tfEnv["AWS_CONTAINER_CREDENTIALS_FULL_URI"] = endpoint
+		tfEnv["AWS_CONTAINER_AUTHORIZATION_TOKEN"] = token
+		return tfEnv, stop, nil
+	}
+
+	creds, err := tfexec.FreshCredentials(ctx, credentials)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch mode {
+	case CredentialsEnv:
+		tfEnv["AWS_ACCESS_KEY_ID"] = creds.AccessKeyID
+		tfEnv["AWS_SECRET_ACCESS_KEY"] = creds.SecretAccessKey
+		tfEnv["AWS_SESSION_TOKEN"] = creds.SessionToken
+		return tfEnv, func() {}, nil
+	case CredentialsFile:
+		credsPath, cleanup, err := writeCredentialsFile(creds)
+		if err != nil {
+			return nil, nil, err
+		}
+		tfEnv["AWS_SHARED_CREDENTIALS_FILE"] = credsPath
+		tfEnv["AWS_PROFILE"] = "default"
+		return tfEnv, cleanup, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown credentials mode: %d", mode)
+	}
+}
+
+func writeCredentialsFile(creds aws.Credentials) (string, func(), error) {
+	// Keep credentials out of the terraform workspace so they never end up in
+	// anything extracted or uploaded from it
+	dir, err := ioutil.TempDir("", "tf-creds-")
+	if err != nil {
+		return "", nil, fmt.Errorf("error creating credentials directory: %w", err)
+	}
+	cleanup := func() {
+		_ = os.RemoveAll(dir)
+	}
+
+	data := fmt.Sprintf("[default]\naws_access_key_id = %s\naws_secret_access_key = %s\n", creds.AccessKeyID, creds.SecretAccessKey)
+	if creds.SessionToken != "" {
+		data += fmt.Sprintf("aws_session_token = %s\n", creds.SessionToken)
+	}
+
+	credsPath := path.Join(dir, "credentials")
+	if err := os.WriteFile(credsPath, []byte(data), 0400); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("error writing credentials file: %w", err)
+	}
+
+	return credsPath, cleanup, nil
+}
@@ -0,0 +1,75 @@
+package tfworkspace
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+
+	"github.com/dynajoe/temporal-terraform-demo/tfexec"
+)
+
+// serveCredentials serves credentials on a loopback address in the format of
+// the container credentials endpoint. Terraform's AWS provider fetches them
+// again as they near expiry, so runs can outlive any single set of session
+// credentials. Requests must carry the returned token.
+func serveCredentials(credentials aws.CredentialsProvider) (endpoint string, token string, stop func(), err error) {
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return "", "", nil, fmt.Errorf("error generating credentials endpoint token: %w", err)
+	}
+	token = hex.EncodeToString(tokenBytes)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", "", nil, fmt.Errorf("error starting credentials endpoint: %w", err)
+	}
+
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != token {
+				http.Error(rw, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			creds, err := tfexec.FreshCredentials(r.Context(), credentials)
+			if err != nil {
+				log.Printf("unable to serve aws credentials to terraform: %v", err)
+				http.Error(rw, "unable to retrieve credentials", http.StatusInternalServerError)
+				return
+			}
+
+			response := struct {
+				AccessKeyID     string `json:"AccessKeyId"`
+				SecretAccessKey string `json:"SecretAccessKey"`
+				Token           string `json:"Token,omitempty"`
+				Expiration      string `json:"Expiration,omitempty"`
+			}{
+				AccessKeyID:     creds.AccessKeyID,
+				SecretAccessKey: creds.SecretAccessKey,
+				Token:           creds.SessionToken,
+			}
+			if creds.CanExpire {
+				response.Expiration = creds.Expires.UTC().Format(time.RFC3339)
+			}
+
+			rw.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(rw).Encode(response)
+		}),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		_ = server.Serve(listener)
+	}()
+
+	stop = func() {
+		_ = server.Close()
+	}
+	return fmt.Sprintf("http://%s/credentials", listener.Addr()), token, stop, nil
+}
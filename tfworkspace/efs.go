@@ -1,13 +1,13 @@
 package tfworkspace
 
 import (
-	"embed"
+	"io/fs"
 	"os"
 	"path"
 )
 
-func extractEmbeddedTerraform(efs embed.FS, src string, dst string) error {
-	entries, err := efs.ReadDir(src)
+func extractEmbeddedTerraform(efs fs.FS, src string, dst string) error {
+	entries, err := fs.ReadDir(efs, src)
 	if err != nil {
 		return err
 	}
@@ -24,7 +24,7 @@ func extractEmbeddedTerraform(efs embed.FS, src string, dst string) error {
 			continue
 		}
 
-		data, err := efs.ReadFile(path.Join(src, e.Name()))
+		data, err := fs.ReadFile(efs, path.Join(src, e.Name()))
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,72 @@
+package tfworkspace
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+
+	"github.com/dynajoe/temporal-terraform-demo/tfexec"
+)
+
+// History returns the execution reports for the stack whose state is stored
+// in backend, oldest first
+func History(ctx context.Context, backend tfexec.S3BackendConfig) ([]Report, error) {
+	client := backend.Objects()
+
+	keys, err := ReportKeys(ctx, backend)
+	if err != nil {
+		return nil, err
+	}
+
+	// Report keys start with their start time so they are already in order
+	reports := make([]Report, 0, len(keys))
+	for _, key := range keys {
+		data, err := client.Get(ctx, backend.Bucket, key)
+		if err != nil {
+			return nil, err
+		}
+
+		var r Report
+		if err := json.Unmarshal(data, &r); err != nil {
+			return nil, fmt.Errorf("error decoding execution report %s: %w", key, err)
+		}
+		reports = append(reports, r)
+	}
+
+	return reports, nil
+}
+
+// ReportKeys lists the keys of the stack's execution reports, oldest first
+func ReportKeys(ctx context.Context, backend tfexec.S3BackendConfig) ([]string, error) {
+	keys, err := backend.Objects().List(ctx, backend.Bucket, path.Join(statePrefix(backend.Key), "reports")+"/")
+	if err != nil {
+		return nil, fmt.Errorf("error listing execution reports: %w", err)
+	}
+	return keys, nil
+}
+
+// LastApplied returns the most recent successful apply or adoption in
+// history
+func LastApplied(history []Report) (Report, bool) {
+	for i := len(history) - 1; i >= 0; i-- {
+		operation := history[i].Operation
+		if (operation == "apply" || operation == "adopt") && history[i].Succeeded {
+			return history[i], true
+		}
+	}
+	return Report{}, false
+}
+
+// RedactedVars lists the vars whose values were not recorded
+func (r Report) RedactedVars() []string {
+	var names []string
+	for k, v := range r.Vars {
+		if v == Redacted {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
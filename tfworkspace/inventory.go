@@ -0,0 +1,53 @@
+package tfworkspace
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"path"
+
+	"github.com/dynajoe/temporal-terraform-demo/s3object"
+	"github.com/dynajoe/temporal-terraform-demo/tfconfig"
+	"github.com/dynajoe/temporal-terraform-demo/tfstate"
+)
+
+// Inventory summarizes what a stack manages
+type Inventory struct {
+	// ResourceCount counts managed resource instances in the state
+	ResourceCount int
+
+	// Providers are the provider versions locked at init by source, e.g.
+	// hashicorp/aws: 3.59.0
+	Providers map[string]string
+}
+
+// inventory reads the stack's state and the working directory's lock file.
+// It is informational, so what can't be read is logged and left out.
+func (w *Workspace) inventory(ctx context.Context, workDir string) Inventory {
+	var inventory Inventory
+
+	state, err := tfstate.Load(ctx, w.config.S3Backend)
+	switch {
+	case err == nil:
+		for _, r := range state.Resources {
+			if r.Mode == "managed" {
+				inventory.ResourceCount += len(r.Instances)
+			}
+		}
+	case !errors.Is(err, s3object.ErrNotFound):
+		log.Printf("unable to count resources: %v", err)
+	}
+
+	data, err := os.ReadFile(path.Join(workDir, ".terraform.lock.hcl"))
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("unable to read provider lock file: %v", err)
+		}
+		return inventory
+	}
+	if inventory.Providers, err = tfconfig.LockedProviders(string(data)); err != nil {
+		log.Printf("unable to parse provider lock file: %v", err)
+	}
+	return inventory
+}
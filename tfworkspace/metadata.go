@@ -0,0 +1,121 @@
+package tfworkspace
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/dynajoe/temporal-terraform-demo/s3object"
+	"github.com/dynajoe/temporal-terraform-demo/tfconfig"
+)
+
+// MetadataVar is the variable a module declares to receive run metadata,
+// e.g. to tag resources with the workflow that manages them:
+//
+//	variable "managed_by_metadata" {
+//	  type    = map(string)
+//	  default = {}
+//	}
+const MetadataVar = "managed_by_metadata"
+
+// UniqueSuffixVar is the variable a module declares to receive a suffix for
+// names that must be globally unique, e.g. S3 buckets and IAM roles:
+//
+//	variable "unique_suffix" {
+//	  type = string
+//	}
+//
+// The suffix is derived from UniqueSuffixSeed and the state key, and the
+// first apply stores it next to the state so retries, replans and later
+// runs all reuse it.
+const UniqueSuffixVar = "unique_suffix"
+
+// withMetadata adds the configured metadata to vars when the module declares
+// MetadataVar and the caller hasn't set it
+func (w *Workspace) withMetadata(vars map[string]interface{}) map[string]interface{} {
+	if len(w.config.Metadata) == 0 {
+		return vars
+	}
+	if _, ok := vars[MetadataVar]; ok {
+		return vars
+	}
+	if !w.declares(MetadataVar) {
+		return vars
+	}
+
+	metadata := w.config.Metadata
+	if suffix, ok := vars[UniqueSuffixVar].(string); ok {
+		metadata = make(map[string]string, len(w.config.Metadata)+1)
+		for k, v := range w.config.Metadata {
+			metadata[k] = v
+		}
+		metadata[UniqueSuffixVar] = suffix
+	}
+
+	withMetadata := make(map[string]interface{}, len(vars)+1)
+	for k, v := range vars {
+		withMetadata[k] = v
+	}
+	withMetadata[MetadataVar] = metadata
+	return withMetadata
+}
+
+// withUniqueSuffix adds the stack's suffix to vars when the module declares
+// UniqueSuffixVar and the caller hasn't set it. store records a newly
+// derived suffix, only applies do so.
+func (w *Workspace) withUniqueSuffix(ctx context.Context, vars map[string]interface{}, store bool) (map[string]interface{}, error) {
+	if _, ok := vars[UniqueSuffixVar]; ok {
+		return vars, nil
+	}
+	if !w.declares(UniqueSuffixVar) {
+		return vars, nil
+	}
+
+	backend := w.config.S3Backend
+	client := backend.Objects()
+	key := path.Join(statePrefix(backend.Key), "unique_suffix")
+
+	var suffix string
+	data, err := client.Get(ctx, backend.Bucket, key)
+	switch {
+	case err == nil:
+		suffix = strings.TrimSpace(string(data))
+	case !errors.Is(err, s3object.ErrNotFound):
+		return nil, fmt.Errorf("error reading unique suffix: %w", err)
+	case w.config.UniqueSuffixSeed == "":
+		return nil, fmt.Errorf("module declares %s but no unique suffix seed is configured", UniqueSuffixVar)
+	default:
+		sum := sha256.Sum256([]byte(w.config.UniqueSuffixSeed + "\x00" + backend.Key))
+		suffix = hex.EncodeToString(sum[:])[:8]
+		if store {
+			if err := client.Put(ctx, backend.Bucket, key, []byte(suffix)); err != nil {
+				return nil, fmt.Errorf("error storing unique suffix: %w", err)
+			}
+		}
+	}
+
+	withSuffix := make(map[string]interface{}, len(vars)+1)
+	for k, v := range vars {
+		withSuffix[k] = v
+	}
+	withSuffix[UniqueSuffixVar] = suffix
+	return withSuffix, nil
+}
+
+// declares reports whether the configured module declares the variable
+func (w *Workspace) declares(name string) bool {
+	moduleFS, modulePath, err := w.module()
+	if err != nil {
+		return false
+	}
+	module, err := tfconfig.LoadModule(moduleFS, modulePath)
+	if err != nil {
+		return false
+	}
+	_, ok := module.Variable(name)
+	return ok
+}
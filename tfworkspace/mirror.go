@@ -0,0 +1,89 @@
+package tfworkspace
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/dynajoe/temporal-terraform-demo/terraform"
+	"github.com/dynajoe/temporal-terraform-demo/tfconfig"
+	"github.com/dynajoe/temporal-terraform-demo/tfexec"
+)
+
+// MirrorProviders populates a filesystem provider mirror at dir with every
+// provider required by the registered modules, so workers using it don't
+// need access to the registry
+func MirrorProviders(ctx context.Context, dir string) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+
+	mirrored := map[string]bool{}
+	for _, namespace := range terraform.Namespaces() {
+		fsys, _, err := terraform.Resolve(namespace + ":.")
+		if err != nil {
+			return err
+		}
+
+		// Every module has a versions.tf at its top level
+		err = fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || d.Name() != "versions.tf" {
+				return err
+			}
+			return mirrorModule(ctx, fsys, path.Dir(p), dir, mirrored)
+		})
+		if err != nil {
+			return fmt.Errorf("error mirroring providers for namespace %s: %w", namespace, err)
+		}
+	}
+	return nil
+}
+
+// mirrorModule mirrors a module's providers unless another module already
+// required the same versions
+func mirrorModule(ctx context.Context, fsys fs.FS, modulePath string, mirrorDir string, mirrored map[string]bool) error {
+	module, err := tfconfig.LoadModule(fsys, modulePath)
+	if err != nil {
+		return err
+	}
+
+	var requirements []string
+	for _, req := range module.RequiredProviders {
+		requirements = append(requirements, req.Source+" "+req.Version)
+	}
+	sort.Strings(requirements)
+	key := strings.Join(requirements, "\n")
+	if len(requirements) == 0 || mirrored[key] {
+		return nil
+	}
+
+	workDir, err := ioutil.TempDir("", "tf-mirror-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+
+	// Only the provider requirements are needed
+	versions, err := fs.ReadFile(fsys, path.Join(modulePath, "versions.tf"))
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path.Join(workDir, "versions.tf"), versions, 0644); err != nil {
+		return err
+	}
+
+	log.Printf("mirroring providers for %s: %s", modulePath, strings.Join(requirements, ", "))
+	if err := tfexec.MirrorProviders(ctx, workDir, mirrorDir); err != nil {
+		return fmt.Errorf("error mirroring providers for %s: %w", modulePath, err)
+	}
+	mirrored[key] = true
+	return nil
+}
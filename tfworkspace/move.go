@@ -0,0 +1,133 @@
+package tfworkspace
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/dynajoe/temporal-terraform-demo/s3object"
+	"github.com/dynajoe/temporal-terraform-demo/tfexec"
+)
+
+// ErrStateExists is returned when moving state onto a key already in use
+var ErrStateExists = errors.New("state already exists")
+
+// MoveState copies a stack's state and execution reports to a new key in the
+// same bucket, holding the state locks of both keys. The source is left in
+// place until RemoveState is called, so a failed move never orphans the
+// stack.
+func MoveState(ctx context.Context, from tfexec.S3BackendConfig, toKey string) error {
+	return withStateLock(ctx, from, from.Key, "OperationTypeMove", func() error {
+		return withStateLock(ctx, from, toKey, "OperationTypeMove", func() error {
+			return moveState(ctx, from, toKey)
+		})
+	})
+}
+
+func moveState(ctx context.Context, from tfexec.S3BackendConfig, toKey string) error {
+	client := from.Objects()
+
+	state, err := client.Get(ctx, from.Bucket, from.Key)
+	if err != nil {
+		return fmt.Errorf("error reading state: %w", err)
+	}
+
+	// An identical copy means a previous attempt already finished the move
+	existing, err := client.Get(ctx, from.Bucket, toKey)
+	switch {
+	case err == nil && bytes.Equal(existing, state):
+		return nil
+	case err == nil:
+		return fmt.Errorf("s3://%s/%s: %w", from.Bucket, toKey, ErrStateExists)
+	case !errors.Is(err, s3object.ErrNotFound):
+		return err
+	}
+
+	// Reports first, the state landing marks the move as done
+	fromReports := path.Join(statePrefix(from.Key), "reports") + "/"
+	toReports := path.Join(statePrefix(toKey), "reports") + "/"
+	keys, err := client.List(ctx, from.Bucket, fromReports)
+	if err != nil {
+		return fmt.Errorf("error listing execution reports: %w", err)
+	}
+	for _, key := range keys {
+		if err := copyObject(ctx, client, from.Bucket, key, toReports+strings.TrimPrefix(key, fromReports)); err != nil {
+			return err
+		}
+	}
+
+	if err := putState(ctx, from, toKey, state); err != nil {
+		return fmt.Errorf("error copying state: %w", err)
+	}
+	return nil
+}
+
+// CopyState copies a stack's state and every artifact stored next to it to
+// another bucket under the same key. Like MoveState the source is left in
+// place and an identical copy counts as done.
+func CopyState(ctx context.Context, from tfexec.S3BackendConfig, to tfexec.S3BackendConfig) error {
+	return withStateLock(ctx, from, from.Key, "OperationTypeCopy", func() error {
+		return withStateLock(ctx, to, from.Key, "OperationTypeCopy", func() error {
+			return copyState(ctx, from, to)
+		})
+	})
+}
+
+func copyState(ctx context.Context, from tfexec.S3BackendConfig, to tfexec.S3BackendConfig) error {
+	fromClient := from.Objects()
+	toClient := to.Objects()
+
+	state, err := fromClient.Get(ctx, from.Bucket, from.Key)
+	if err != nil {
+		return fmt.Errorf("error reading state: %w", err)
+	}
+
+	existing, err := toClient.Get(ctx, to.Bucket, from.Key)
+	switch {
+	case err == nil && bytes.Equal(existing, state):
+		return nil
+	case err == nil:
+		return fmt.Errorf("s3://%s/%s: %w", to.Bucket, from.Key, ErrStateExists)
+	case !errors.Is(err, s3object.ErrNotFound):
+		return err
+	}
+
+	// Artifacts first, the state landing marks the copy as done
+	keys, err := fromClient.List(ctx, from.Bucket, statePrefix(from.Key)+"/")
+	if err != nil {
+		return fmt.Errorf("error listing artifacts: %w", err)
+	}
+	for _, key := range keys {
+		data, err := fromClient.Get(ctx, from.Bucket, key)
+		if err != nil {
+			return err
+		}
+		if err := toClient.Put(ctx, to.Bucket, key, data); err != nil {
+			return err
+		}
+	}
+
+	if err := putState(ctx, to, from.Key, state); err != nil {
+		return fmt.Errorf("error copying state: %w", err)
+	}
+	return nil
+}
+
+// RemoveState deletes a stack's state object and its digest, holding the
+// state lock
+func RemoveState(ctx context.Context, backend tfexec.S3BackendConfig) error {
+	return withStateLock(ctx, backend, backend.Key, "OperationTypeRemove", func() error {
+		return deleteState(ctx, backend, backend.Key)
+	})
+}
+
+func copyObject(ctx context.Context, client s3object.Store, bucket string, from string, to string) error {
+	data, err := client.Get(ctx, bucket, from)
+	if err != nil {
+		return err
+	}
+	return client.Put(ctx, bucket, to, data)
+}
@@ -0,0 +1,174 @@
+package tfworkspace
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Get returns the output value at a path of map keys and list indexes,
+// e.g. "module_a.subnet_ids[0]". Nested values are returned as decoded
+// JSON: maps, []interface{}, strings, float64 and bools.
+func (o ApplyOutput) Get(path string) (interface{}, error) {
+	if o.Output == nil && o.OutputRef != nil {
+		return nil, fmt.Errorf("outputs were offloaded to s3://%s/%s, resolve them first", o.OutputRef.Bucket, o.OutputRef.Key)
+	}
+
+	steps, err := parseOutputPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var v interface{} = map[string]interface{}(o.Output)
+	for i, step := range steps {
+		at := formatOutputPath(steps[:i])
+		switch node := normalizeOutputValue(v).(type) {
+		case map[string]interface{}:
+			if step.index >= 0 {
+				return nil, fmt.Errorf("output [%s] is a map, cannot index it with [%d]", at, step.index)
+			}
+			next, ok := node[step.key]
+			if !ok {
+				return nil, fmt.Errorf("missing key [%s] in output", formatOutputPath(steps[:i+1]))
+			}
+			v = next
+		case []interface{}:
+			if step.index < 0 {
+				return nil, fmt.Errorf("output [%s] is a list, cannot look up key [%s]", at, step.key)
+			}
+			if step.index >= len(node) {
+				return nil, fmt.Errorf("output [%s] has %d elements, index [%d] is out of range", at, len(node), step.index)
+			}
+			v = node[step.index]
+		default:
+			return nil, fmt.Errorf("output [%s] is a %T, cannot descend into it", at, node)
+		}
+	}
+	return normalizeOutputValue(v), nil
+}
+
+// Flatten returns every leaf output value keyed by its path, e.g.
+// "module_a.subnet_ids[0]", for exporting to flat stores such as SSM. Empty
+// maps and lists are kept as leaves so no output goes missing.
+func (o ApplyOutput) Flatten() map[string]interface{} {
+	flat := map[string]interface{}{}
+	for k, v := range o.Output {
+		flattenOutput(flat, k, v)
+	}
+	return flat
+}
+
+// FlattenedKeys returns the keys of Flatten in sorted order
+func (o ApplyOutput) FlattenedKeys() []string {
+	flat := o.Flatten()
+	keys := make([]string, 0, len(flat))
+	for k := range flat {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func flattenOutput(flat map[string]interface{}, prefix string, v interface{}) {
+	switch node := normalizeOutputValue(v).(type) {
+	case map[string]interface{}:
+		if len(node) == 0 {
+			flat[prefix] = node
+		}
+		for k, child := range node {
+			flattenOutput(flat, prefix+"."+k, child)
+		}
+	case []interface{}:
+		if len(node) == 0 {
+			flat[prefix] = node
+		}
+		for i, child := range node {
+			flattenOutput(flat, fmt.Sprintf("%s[%d]", prefix, i), child)
+		}
+	default:
+		flat[prefix] = node
+	}
+}
+
+// normalizeOutputValue decodes the shapes tfexec leaves outputs in, and the
+// ones they take after passing through an activity result, to plain JSON
+// values
+func normalizeOutputValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case json.RawMessage:
+		var decoded interface{}
+		if err := json.Unmarshal(value, &decoded); err != nil {
+			return value
+		}
+		return decoded
+	case []string:
+		list := make([]interface{}, len(value))
+		for i, s := range value {
+			list[i] = s
+		}
+		return list
+	case int:
+		return float64(value)
+	default:
+		return v
+	}
+}
+
+type outputPathStep struct {
+	key   string
+	index int
+}
+
+func parseOutputPath(path string) ([]outputPathStep, error) {
+	var steps []outputPathStep
+	rest := path
+	for rest != "" {
+		switch {
+		case rest[0] == '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid output path [%s]: unclosed index", path)
+			}
+			index, err := strconv.Atoi(rest[1:end])
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("invalid output path [%s]: bad index [%s]", path, rest[1:end])
+			}
+			steps = append(steps, outputPathStep{index: index})
+			rest = rest[end+1:]
+		case rest[0] == '.' && len(steps) > 0:
+			rest = rest[1:]
+			fallthrough
+		default:
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("invalid output path [%s]: empty key", path)
+			}
+			steps = append(steps, outputPathStep{key: rest[:end], index: -1})
+			rest = rest[end:]
+		}
+	}
+	if len(steps) == 0 || steps[0].index >= 0 {
+		return nil, fmt.Errorf("invalid output path [%s]: must start with an output name", path)
+	}
+	return steps, nil
+}
+
+func formatOutputPath(steps []outputPathStep) string {
+	var b strings.Builder
+	for i, step := range steps {
+		switch {
+		case step.index >= 0:
+			fmt.Fprintf(&b, "[%d]", step.index)
+		case i > 0:
+			b.WriteString("." + step.key)
+		default:
+			b.WriteString(step.key)
+		}
+	}
+	return b.String()
+}
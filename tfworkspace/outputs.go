@@ -0,0 +1,102 @@
+package tfworkspace
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+
+	"github.com/dynajoe/temporal-terraform-demo/s3object"
+	"github.com/dynajoe/temporal-terraform-demo/tfexec"
+)
+
+// OutputRef locates a full set of outputs that was too large to return
+// through the activity result
+type OutputRef struct {
+	Bucket string
+	Key    string
+	Region string
+
+	// LocalDir is set if the outputs were stored locally, see
+	// tfexec.S3BackendConfig.LocalDir
+	LocalDir string
+}
+
+// statePrefix is the prefix under which per-stack artifacts are stored,
+// e.g. vpc-demo.tfstate -> vpc-demo
+func statePrefix(stateKey string) string {
+	return strings.TrimSuffix(stateKey, path.Ext(stateKey))
+}
+
+// offloadOutputs uploads outputs and returns a reference to them
+func (w *Workspace) offloadOutputs(ctx context.Context, data []byte) (*OutputRef, error) {
+	name := w.config.RunID
+	if name == "" {
+		name = time.Now().UTC().Format("20060102T150405Z")
+	}
+
+	backend := w.config.S3Backend
+	ref := &OutputRef{
+		Bucket: backend.Bucket,
+		Key:    path.Join(statePrefix(backend.Key), "outputs", name+".json"),
+		Region: backend.Region,
+
+		LocalDir: backend.LocalDir,
+	}
+
+	if err := backend.Objects().Put(ctx, ref.Bucket, ref.Key, data); err != nil {
+		return nil, fmt.Errorf("error uploading outputs: %w", err)
+	}
+	return ref, nil
+}
+
+// FetchOutputs downloads outputs that were offloaded by Apply
+func FetchOutputs(ctx context.Context, credentials aws.CredentialsProvider, ref OutputRef) (ApplyOutput, error) {
+	var store s3object.Store = s3object.New(credentials, ref.Region)
+	if ref.LocalDir != "" {
+		store = s3object.Dir{Root: ref.LocalDir}
+	}
+	data, err := store.Get(ctx, ref.Bucket, ref.Key)
+	if err != nil {
+		return ApplyOutput{}, err
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return ApplyOutput{}, fmt.Errorf("error decoding outputs: %w", err)
+	}
+
+	output := make(map[string]interface{}, len(raw))
+	for k, v := range raw {
+		output[k] = tfexec.DecodeOutputValue(v)
+	}
+	return ApplyOutput{Output: output}, nil
+}
+
+// Resolve returns the full outputs, fetching them if they were offloaded
+func (o ApplyOutput) Resolve(ctx context.Context, credentials aws.CredentialsProvider) (ApplyOutput, error) {
+	if o.OutputRef == nil {
+		return o, nil
+	}
+	fetched, err := FetchOutputs(ctx, credentials, *o.OutputRef)
+	if err != nil {
+		return ApplyOutput{}, err
+	}
+	o.Output = fetched.Output
+	o.OutputRef = nil
+	return o, nil
+}
+
+func outputNames(output map[string]interface{}) []string {
+	names := make([]string, 0, len(output))
+	for k := range output {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
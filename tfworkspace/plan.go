@@ -0,0 +1,190 @@
+package tfworkspace
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+
+	"github.com/dynajoe/temporal-terraform-demo/tfexec"
+)
+
+type PlanInput struct {
+	Env                map[string]string
+	Vars               map[string]interface{}
+	AwsCredentials     aws.CredentialsProvider
+	AwsCredentialsMode CredentialsMode
+
+	// RoleARN is the role AwsCredentials are for, plans cached under one
+	// role aren't reused for another
+	RoleARN string
+
+	// ForceReplan plans even if the module, vars and state are unchanged
+	// since the last plan that found no changes
+	ForceReplan bool
+
+	// LockTimeout, NoLock and NoRefresh, see tfexec.PlanParams
+	LockTimeout time.Duration
+	NoLock      bool
+	NoRefresh   bool
+}
+
+type PlanOutput struct {
+	Changes []tfexec.ResourceChange
+
+	// StateSerial is the serial of the state the plan was made against,
+	// pass it to ApplyInput.PlannedSerial to apply only that state
+	StateSerial int64
+
+	// Versions are the terraform and provider versions the plan was made
+	// with, pass them to ApplyInput.PlannedVersions to apply with the same.
+	// Unset for cached plans.
+	Versions *tfexec.Versions
+
+	// Cached is set when no changes were found without planning, because
+	// the last plan of the same module, inputs and state found none
+	Cached bool
+
+	// PlanRef is the offloaded plan if it found changes, pass it to
+	// ApplyInput.PlanRef to apply it
+	PlanRef *PlanRef
+}
+
+// StaleStateError is returned when the state changed between the plan and
+// the apply, the plan has to be made again
+type StaleStateError struct {
+	Key           string
+	PlannedSerial int64
+	CurrentSerial int64
+}
+
+func (e *StaleStateError) Error() string {
+	return fmt.Sprintf("state %s changed since it was planned (serial %d, now %d), replan before applying",
+		e.Key, e.PlannedSerial, e.CurrentSerial)
+}
+
+// VersionMismatchError is returned when the apply would run different
+// terraform or provider versions than the plan was made with
+type VersionMismatchError struct {
+	Key   string
+	Diffs []string
+}
+
+func (e *VersionMismatchError) Error() string {
+	return fmt.Sprintf("state %s was planned with different versions (%s), replan before applying",
+		e.Key, strings.Join(e.Diffs, ", "))
+}
+
+// ReviewedPlanFailedError is returned when resources of a reviewed plan fail
+// to apply. They aren't retried, a retry would apply a plan nobody reviewed.
+type ReviewedPlanFailedError struct {
+	Key       string
+	Addresses []string
+	Err       error
+}
+
+func (e *ReviewedPlanFailedError) Error() string {
+	return fmt.Sprintf("resources [%s] of the reviewed plan of %s failed to apply, replan and approve again to retry them: %v",
+		strings.Join(e.Addresses, ", "), e.Key, e.Err)
+}
+
+func (e *ReviewedPlanFailedError) Unwrap() error {
+	return e.Err
+}
+
+// Plan returns the changes an apply with the same input would make
+func (w *Workspace) Plan(ctx context.Context, input PlanInput) (PlanOutput, error) {
+	output, err := w.plan(ctx, input)
+	w.logPlan(output, err)
+	return output, err
+}
+
+func (w *Workspace) plan(ctx context.Context, input PlanInput) (_ PlanOutput, err error) {
+	moduleFS, modulePath, err := w.module()
+	if err != nil {
+		return PlanOutput{}, err
+	}
+
+	// Read before planning so a concurrent apply shows up as a newer serial
+	serial, ok := w.stateSerial(ctx)
+	if !ok {
+		return PlanOutput{}, fmt.Errorf("unable to read state serial for %s", w.config.S3Backend.Key)
+	}
+
+	// Metadata changes every run, so the fingerprint is taken without it
+	var cacheEntry planCacheEntry
+	if w.config.CachePlans {
+		fingerprint, err := planFingerprint(moduleFS, modulePath, input.Vars, input.Env, input.RoleARN)
+		if err != nil {
+			return PlanOutput{}, err
+		}
+		var hit bool
+		cacheEntry, hit = w.cachedPlanEntry(ctx, fingerprint)
+		if hit && !input.ForceReplan {
+			log.Printf("no changes (cached) for %s at serial %d", w.config.S3Backend.Key, cacheEntry.Serial)
+			return PlanOutput{StateSerial: serial, Cached: true}, nil
+		}
+	}
+
+	input.Vars, err = w.withUniqueSuffix(ctx, input.Vars, false)
+	if err != nil {
+		return PlanOutput{}, err
+	}
+	input.Vars = w.withMetadata(input.Vars)
+
+	workDir, cleanup, err := w.newWorkDir("plan")
+	if err != nil {
+		return PlanOutput{}, err
+	}
+	defer func() { cleanup(err) }()
+
+	if err = extractEmbeddedTerraform(moduleFS, modulePath, workDir); err != nil {
+		return PlanOutput{}, fmt.Errorf("error extracting terraform: %w", err)
+	}
+	w.logBundle("plan", moduleFS, modulePath)
+
+	tf, err := w.init(ctx, workDir)
+	if err != nil {
+		return PlanOutput{}, err
+	}
+
+	env, cleanupCreds, err := w.terraformEnv(ctx, input.Env, input.AwsCredentials, input.AwsCredentialsMode)
+	if err != nil {
+		return PlanOutput{}, err
+	}
+	defer cleanupCreds()
+
+	versions, err := tf.Version(ctx, env)
+	if err != nil {
+		return PlanOutput{}, fmt.Errorf("terraform version error: %w", err)
+	}
+
+	changes, err := tf.Plan(ctx, tfexec.PlanParams{
+		Vars:        input.Vars,
+		Env:         env,
+		LockTimeout: input.LockTimeout,
+		NoLock:      input.NoLock,
+		NoRefresh:   input.NoRefresh,
+	})
+	if err != nil {
+		return PlanOutput{}, fmt.Errorf("terraform plan error: %w", err)
+	}
+	w.reportDiskUsage("plan", workDir)
+
+	var planRef *PlanRef
+	if w.config.OffloadPlans && len(changes) > 0 {
+		planRef, err = w.offloadPlan(ctx, workDir)
+		if err != nil {
+			return PlanOutput{}, err
+		}
+	}
+
+	// A plan that skipped refresh didn't check for drift
+	if w.config.CachePlans && cacheEntry.Fingerprint != "" && len(changes) == 0 && !input.NoRefresh {
+		w.storePlanEntry(ctx, cacheEntry)
+	}
+	return PlanOutput{Changes: changes, StateSerial: serial, Versions: &versions, PlanRef: planRef}, nil
+}
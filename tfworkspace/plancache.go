@@ -0,0 +1,128 @@
+package tfworkspace
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"path"
+
+	"github.com/dynajoe/temporal-terraform-demo/s3object"
+	"github.com/dynajoe/temporal-terraform-demo/tfstate"
+)
+
+// planCacheEntry records the last plan that found no changes
+type planCacheEntry struct {
+	Fingerprint string `json:"fingerprint"`
+	Lineage     string `json:"lineage"`
+	Serial      int64  `json:"serial"`
+	RunID       string `json:"run_id,omitempty"`
+}
+
+// planCacheKey places the cache under the state key's prefix,
+// e.g. vpc-demo.tfstate -> vpc-demo/plan-cache.json
+func planCacheKey(stateKey string) string {
+	return path.Join(statePrefix(stateKey), "plan-cache.json")
+}
+
+// planFingerprint hashes the module files, vars, env and role a plan was
+// made with. Files are hashed in lexical order with normalized line endings
+// and trailing whitespace so checkouts on different OSes hash identically.
+func planFingerprint(moduleFS fs.FS, modulePath string, vars map[string]interface{}, env map[string]string, roleARN string) (string, error) {
+	h := sha256.New()
+	err := fs.WalkDir(moduleFS, modulePath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := fs.ReadFile(moduleFS, p)
+		if err != nil {
+			return err
+		}
+		h.Write([]byte(p))
+		h.Write([]byte{0})
+		h.Write(normalizeSource(data))
+		h.Write([]byte{0})
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// Map keys are encoded in sorted order. Env decides e.g. the region the
+	// plan was made in and the role the account.
+	inputsJSON, err := json.Marshal(struct {
+		Vars    map[string]interface{}
+		Env     map[string]string
+		RoleARN string
+	}{vars, env, roleARN})
+	if err != nil {
+		return "", err
+	}
+	h.Write(inputsJSON)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// normalizeSource converts line endings to LF and strips trailing whitespace
+// from each line and trailing blank lines from the file
+func normalizeSource(data []byte) []byte {
+	lines := bytes.Split(bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n")), []byte("\n"))
+	for i, line := range lines {
+		lines[i] = bytes.TrimRight(line, " \t\r")
+	}
+	return append(bytes.TrimRight(bytes.Join(lines, []byte("\n")), "\n"), '\n')
+}
+
+// cachedPlanEntry returns the entry a clean plan of the current state would
+// be recorded under, and whether an identical plan already found no changes
+func (w *Workspace) cachedPlanEntry(ctx context.Context, fingerprint string) (planCacheEntry, bool) {
+	backend := w.config.S3Backend
+
+	state, err := tfstate.Load(ctx, backend)
+	if err != nil {
+		if !errors.Is(err, s3object.ErrNotFound) {
+			log.Printf("unable to read state for plan cache: %v", err)
+		}
+		return planCacheEntry{}, false
+	}
+	current := planCacheEntry{
+		Fingerprint: fingerprint,
+		Lineage:     state.Lineage,
+		Serial:      state.Serial,
+		RunID:       w.config.RunID,
+	}
+
+	data, err := backend.Objects().Get(ctx, backend.Bucket, planCacheKey(backend.Key))
+	if err != nil {
+		if !errors.Is(err, s3object.ErrNotFound) {
+			log.Printf("unable to read plan cache: %v", err)
+		}
+		return current, false
+	}
+
+	var cached planCacheEntry
+	if err := json.Unmarshal(data, &cached); err != nil {
+		log.Printf("ignoring unreadable plan cache: %v", err)
+		return current, false
+	}
+	hit := cached.Fingerprint == current.Fingerprint && cached.Lineage == current.Lineage && cached.Serial == current.Serial
+	return current, hit
+}
+
+// storePlanEntry records a plan that found no changes. Failures only cost
+// a replan next time, so they are logged rather than returned.
+func (w *Workspace) storePlanEntry(ctx context.Context, entry planCacheEntry) {
+	backend := w.config.S3Backend
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("unable to encode plan cache: %v", err)
+		return
+	}
+	if err := backend.Objects().Put(ctx, backend.Bucket, planCacheKey(backend.Key), data); err != nil {
+		log.Printf("unable to store plan cache: %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package tfworkspace
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/dynajoe/temporal-terraform-demo/tfexec"
+)
+
+type (
+	// PlanRef locates a saved plan uploaded next to the state, see
+	// Config.OffloadPlans
+	PlanRef struct {
+		Bucket string
+		Key    string
+		Size   int64
+	}
+
+	// DiskUsage is how much disk an operation's working directory took at
+	// its largest, PlanFileBytes of it for the saved plan
+	DiskUsage struct {
+		Operation     string
+		WorkDirBytes  int64
+		PlanFileBytes int64
+	}
+)
+
+// offloadPlan uploads the saved plan and removes the local copy, so the
+// plan doesn't hold disk while it waits for approval
+func (w *Workspace) offloadPlan(ctx context.Context, workDir string) (*PlanRef, error) {
+	name := w.config.RunID
+	if name == "" {
+		return nil, fmt.Errorf("plans can only be offloaded for a run")
+	}
+
+	backend := w.config.S3Backend
+	local := filepath.Join(workDir, tfexec.PlanFile)
+	info, err := os.Stat(local)
+	if err != nil {
+		return nil, fmt.Errorf("error reading saved plan: %w", err)
+	}
+
+	ref := &PlanRef{
+		Bucket: backend.Bucket,
+		Key:    path.Join(statePrefix(backend.Key), "plans", name+".tfplan"),
+		Size:   info.Size(),
+	}
+	if err := backend.Objects().PutFile(ctx, ref.Bucket, ref.Key, local); err != nil {
+		return nil, fmt.Errorf("error uploading saved plan: %w", err)
+	}
+	if err := os.Remove(local); err != nil {
+		log.Printf("unable to remove uploaded plan: %v", err)
+	}
+	return ref, nil
+}
+
+// fetchPlan downloads an offloaded plan into the working directory
+func (w *Workspace) fetchPlan(ctx context.Context, workDir string, ref PlanRef) error {
+	size, err := w.config.S3Backend.Objects().GetFile(ctx, ref.Bucket, ref.Key, filepath.Join(workDir, tfexec.PlanFile))
+	if err != nil {
+		return fmt.Errorf("error downloading saved plan s3://%s/%s: %w", ref.Bucket, ref.Key, err)
+	}
+	if size != ref.Size {
+		return fmt.Errorf("saved plan s3://%s/%s is %d bytes, expected %d", ref.Bucket, ref.Key, size, ref.Size)
+	}
+	return nil
+}
+
+// removePlan deletes an offloaded plan once it was applied, it can't be
+// applied twice
+func (w *Workspace) removePlan(ctx context.Context, ref PlanRef) {
+	if err := w.config.S3Backend.Objects().Delete(ctx, ref.Bucket, ref.Key); err != nil {
+		log.Printf("unable to remove applied plan s3://%s/%s: %v", ref.Bucket, ref.Key, err)
+	}
+}
+
+// reportDiskUsage measures the working directory, call it where the
+// operation uses the most disk
+func (w *Workspace) reportDiskUsage(operation string, workDir string) {
+	usage := DiskUsage{Operation: operation}
+	_ = filepath.WalkDir(workDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		usage.WorkDirBytes += info.Size()
+		if p == filepath.Join(workDir, tfexec.PlanFile) {
+			usage.PlanFileBytes = info.Size()
+		}
+		return nil
+	})
+
+	log.Printf("%s of %s used %d bytes of disk, %d for the plan", operation, w.config.S3Backend.Key, usage.WorkDirBytes, usage.PlanFileBytes)
+	if w.config.OnDiskUsage != nil {
+		w.config.OnDiskUsage(usage)
+	}
+}
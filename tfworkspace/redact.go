@@ -0,0 +1,75 @@
+package tfworkspace
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+
+	"github.com/dynajoe/temporal-terraform-demo/terraform"
+	"github.com/dynajoe/temporal-terraform-demo/tfconfig"
+)
+
+// Redacted replaces the values of secrets in reports and logs
+const Redacted = "<redacted>"
+
+// sensitiveName matches variable and env names that are treated as secrets
+// even when the module doesn't mark them sensitive
+var sensitiveName = regexp.MustCompile(`(?i)(password|secret|token|private_key|credentials)`)
+
+// IsSensitive reports whether the value of the variable, env var or field
+// name is a secret, either because the module declares the variable
+// sensitive or because of its name
+func IsSensitive(name string, sensitiveVars map[string]bool) bool {
+	return sensitiveVars[name] || sensitiveName.MatchString(name)
+}
+
+// SensitiveVars returns the variables the module at terraformPath declares
+// sensitive, nil if it can't be loaded
+func SensitiveVars(terraformPath string) map[string]bool {
+	moduleFS, modulePath, err := terraform.Resolve(terraformPath)
+	if err != nil {
+		return nil
+	}
+	return moduleSensitiveVars(moduleFS, modulePath)
+}
+
+func moduleSensitiveVars(moduleFS fs.FS, modulePath string) map[string]bool {
+	module, err := tfconfig.LoadModule(moduleFS, modulePath)
+	if err != nil {
+		return nil
+	}
+	sensitiveVars := make(map[string]bool, len(module.Variables))
+	for _, v := range module.Variables {
+		sensitiveVars[v.Name] = v.Sensitive
+	}
+	return sensitiveVars
+}
+
+// validateRedactOutputs checks the redaction globs are well formed
+func validateRedactOutputs(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid output redaction pattern [%s]: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// redactOutputs replaces the values of outputs matching any of the patterns
+// and returns the redacted names. The values remain in the stack's state.
+func redactOutputs(patterns []string, output map[string]interface{}) []string {
+	var names []string
+	for k := range output {
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(pattern, k); ok {
+				output[k] = Redacted
+				names = append(names, k)
+				break
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,236 @@
+package tfworkspace
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path"
+	"time"
+
+	"github.com/dynajoe/temporal-terraform-demo/tfexec"
+)
+
+type (
+	// Report is a record of a single apply or destroy, stored alongside the
+	// stack's state for change management
+	Report struct {
+		Operation     string                 `json:"operation"`
+		RunID         string                 `json:"run_id,omitempty"`
+		TerraformPath string                 `json:"terraform_path"`
+		StateBucket   string                 `json:"state_bucket"`
+		StateKey      string                 `json:"state_key"`
+		Vars          map[string]interface{} `json:"vars,omitempty"`
+		Env           map[string]string      `json:"env,omitempty"`
+		Outputs       map[string]interface{} `json:"outputs,omitempty"`
+		StartedAt     time.Time              `json:"started_at"`
+		FinishedAt    time.Time              `json:"finished_at"`
+		Duration      string                 `json:"duration"`
+		Phases        []ReportPhase          `json:"phases"`
+		Succeeded     bool                   `json:"succeeded"`
+		Error         string                 `json:"error,omitempty"`
+
+		// CompletedResources and InterruptedResources show how far a failed
+		// apply got
+		CompletedResources   []string `json:"completed_resources,omitempty"`
+		InterruptedResources []string `json:"interrupted_resources,omitempty"`
+
+		// RetainedResources were left in place by a soft delete
+		RetainedResources []RetainedResource `json:"retained_resources,omitempty"`
+
+		// CLIArgs and CLIEnv are the extra arguments and env the CLI config
+		// added to terraform's commands, see tfexec.CLIConfig
+		CLIArgs map[string][]string `json:"cli_args,omitempty"`
+		CLIEnv  map[string]string   `json:"cli_env,omitempty"`
+
+		// Outcome is set on the report of a run that was stopped rather than
+		// failed, see RecordOutcome
+		Outcome *Outcome `json:"outcome,omitempty"`
+	}
+
+	// Outcome is why a run stopped without finishing, e.g. an operator
+	// rejected its plan or a policy refused it
+	Outcome struct {
+		Kind   string `json:"kind"`
+		By     string `json:"by,omitempty"`
+		Reason string `json:"reason,omitempty"`
+		Phase  string `json:"phase,omitempty"`
+	}
+
+	ReportPhase struct {
+		Name     string `json:"name"`
+		Duration string `json:"duration"`
+		Error    string `json:"error,omitempty"`
+	}
+)
+
+func (w *Workspace) newReport(operation string, vars map[string]interface{}, env map[string]string) *Report {
+	// Sensitive variables declared by the module are always redacted
+	var sensitiveVars map[string]bool
+	if moduleFS, modulePath, err := w.module(); err == nil {
+		sensitiveVars = moduleSensitiveVars(moduleFS, modulePath)
+	}
+
+	reportVars := make(map[string]interface{}, len(vars))
+	for k, v := range vars {
+		if IsSensitive(k, sensitiveVars) {
+			v = Redacted
+		}
+		reportVars[k] = v
+	}
+
+	// Env values are commonly credentials, only record which keys were set
+	reportEnv := make(map[string]string, len(env))
+	for k := range env {
+		reportEnv[k] = Redacted
+	}
+
+	// Injected env is configuration rather than credentials, unless its
+	// name says otherwise
+	var cliEnv map[string]string
+	if len(w.config.CLIConfig.Env) > 0 {
+		cliEnv = make(map[string]string, len(w.config.CLIConfig.Env))
+		for k, v := range w.config.CLIConfig.Env {
+			if IsSensitive(k, nil) {
+				v = Redacted
+			}
+			cliEnv[k] = v
+		}
+	}
+
+	return &Report{
+		Operation:     operation,
+		RunID:         w.config.RunID,
+		TerraformPath: w.config.TerraformPath,
+		StateBucket:   w.config.S3Backend.Bucket,
+		StateKey:      w.config.S3Backend.Key,
+		Vars:          reportVars,
+		Env:           reportEnv,
+		StartedAt:     time.Now().UTC(),
+		CLIArgs:       w.config.CLIConfig.Args,
+		CLIEnv:        cliEnv,
+	}
+}
+
+// phase records the duration of a step, call the returned func with the
+// step's result when it completes
+func (r *Report) phase(name string) func(error) {
+	start := time.Now()
+	return func(err error) {
+		p := ReportPhase{Name: name, Duration: time.Since(start).Round(time.Millisecond).String()}
+		if err != nil {
+			p.Error = err.Error()
+		}
+		r.Phases = append(r.Phases, p)
+	}
+}
+
+func (r *Report) finish(err error) {
+	r.FinishedAt = time.Now().UTC()
+	r.Duration = r.FinishedAt.Sub(r.StartedAt).Round(time.Millisecond).String()
+	r.Succeeded = err == nil
+	if err != nil {
+		r.Error = err.Error()
+	}
+}
+
+// reportKey places reports under the state key's prefix, e.g.
+// vpc-demo.tfstate -> vpc-demo/reports/20220101T000000.000000000Z-apply.json.
+// Runs starting within the same second must not overwrite each other's
+// reports, and the fixed width keeps keys listing oldest first.
+func (r *Report) reportKey() string {
+	return path.Join(statePrefix(r.StateKey), "reports",
+		fmt.Sprintf("%s-%s.json", r.StartedAt.UTC().Format("20060102T150405.000000000Z"), r.Operation))
+}
+
+// RecordOutcome adds a report of a stopped run to the stack's history, the
+// report's operation is the outcome's kind
+func RecordOutcome(ctx context.Context, backend tfexec.S3BackendConfig, terraformPath string, runID string, outcome Outcome) error {
+	now := time.Now().UTC()
+	r := &Report{
+		Operation:     outcome.Kind,
+		RunID:         runID,
+		TerraformPath: terraformPath,
+		StateBucket:   backend.Bucket,
+		StateKey:      backend.Key,
+		StartedAt:     now,
+		FinishedAt:    now,
+		Duration:      "0s",
+		Error:         outcome.Reason,
+		Outcome:       &outcome,
+	}
+
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := backend.Objects().Put(ctx, backend.Bucket, r.reportKey(), data); err != nil {
+		return fmt.Errorf("error uploading execution report: %w", err)
+	}
+	return nil
+}
+
+// RecordAdoption adds a report to the history of a stack applied before
+// execution reports were kept, recording the module and vars it is managed
+// with from now on. LastApplied treats it as an apply.
+func RecordAdoption(ctx context.Context, backend tfexec.S3BackendConfig, terraformPath string, runID string, vars map[string]interface{}) error {
+	reportVars := make(map[string]interface{}, len(vars))
+	for k, v := range vars {
+		if IsSensitive(k, nil) {
+			v = Redacted
+		}
+		reportVars[k] = v
+	}
+
+	now := time.Now().UTC()
+	r := &Report{
+		Operation:     "adopt",
+		RunID:         runID,
+		TerraformPath: terraformPath,
+		StateBucket:   backend.Bucket,
+		StateKey:      backend.Key,
+		Vars:          reportVars,
+		StartedAt:     now,
+		FinishedAt:    now,
+		Duration:      "0s",
+		Succeeded:     true,
+	}
+
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := backend.Objects().Put(ctx, backend.Bucket, r.reportKey(), data); err != nil {
+		return fmt.Errorf("error uploading execution report: %w", err)
+	}
+	return nil
+}
+
+func (w *Workspace) publishReport(ctx context.Context, r *Report) {
+	if !w.config.Reports {
+		return
+	}
+
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		log.Printf("error encoding execution report: %v", err)
+		return
+	}
+
+	// The run's context may already be canceled, the report is most useful
+	// precisely when the run failed
+	if ctx.Err() != nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+	}
+
+	backend := w.config.S3Backend
+	key := r.reportKey()
+	if err := backend.Objects().Put(ctx, backend.Bucket, key, data); err != nil {
+		log.Printf("error uploading execution report: %v", err)
+		return
+	}
+
+	log.Printf("execution report uploaded to s3://%s/%s", backend.Bucket, key)
+}
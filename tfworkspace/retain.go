@@ -0,0 +1,105 @@
+package tfworkspace
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/dynajoe/temporal-terraform-demo/s3object"
+	"github.com/dynajoe/temporal-terraform-demo/tfexec"
+	"github.com/dynajoe/temporal-terraform-demo/tfstate"
+)
+
+// RetainedResource is a resource a soft delete left in place, to be cleaned
+// up by hand once its retention period is over
+type RetainedResource struct {
+	Address string `json:"address"`
+	ID      string `json:"id,omitempty"`
+}
+
+// retainedResources finds the instances in the stack's state matching the
+// configured RetainOnDestroy addresses. An address without an index
+// matches every instance of the resource.
+func (w *Workspace) retainedResources(ctx context.Context) ([]RetainedResource, error) {
+	if len(w.config.RetainOnDestroy) == 0 {
+		return nil, nil
+	}
+
+	state, err := tfstate.Load(ctx, w.config.S3Backend)
+	if errors.Is(err, s3object.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading state for retained resources: %w", err)
+	}
+
+	var retained []RetainedResource
+	for _, resource := range state.Resources {
+		if resource.Mode != "managed" {
+			continue
+		}
+		address := resource.Type + "." + resource.Name
+		if resource.Module != "" {
+			address = resource.Module + "." + address
+		}
+		for _, instance := range resource.Instances {
+			instanceAddress := address + instanceIndex(instance.IndexKey)
+			if !retainsAddress(w.config.RetainOnDestroy, instanceAddress) {
+				continue
+			}
+			id, _ := instance.Attributes["id"].(string)
+			retained = append(retained, RetainedResource{Address: instanceAddress, ID: id})
+		}
+	}
+	return retained, nil
+}
+
+func retainsAddress(retain []string, address string) bool {
+	for _, r := range retain {
+		if address == r || strings.HasPrefix(address, r+"[") {
+			return true
+		}
+	}
+	return false
+}
+
+// instanceIndex renders an instance's index key as it appears in addresses
+func instanceIndex(key interface{}) string {
+	switch k := key.(type) {
+	case nil:
+		return ""
+	case string:
+		return fmt.Sprintf("[%q]", k)
+	case float64:
+		return fmt.Sprintf("[%d]", int(k))
+	default:
+		return fmt.Sprintf("[%v]", k)
+	}
+}
+
+// detachRetained removes the retained resources from the state so destroy
+// leaves them be, recording them on the report
+func (w *Workspace) detachRetained(ctx context.Context, tf tfexec.Executor, env map[string]string, lockTimeout time.Duration, report *Report) error {
+	retained, err := w.retainedResources(ctx)
+	if err != nil {
+		return err
+	}
+	if len(retained) == 0 {
+		return nil
+	}
+
+	addresses := make([]string, len(retained))
+	for i, r := range retained {
+		addresses[i] = r.Address
+	}
+	if err := tf.StateRm(ctx, tfexec.StateRmParams{Env: env, Addresses: addresses, LockTimeout: lockTimeout}); err != nil {
+		return fmt.Errorf("terraform state rm error: %w", err)
+	}
+
+	log.Printf("retained %d resources of %s: %s", len(retained), w.config.S3Backend.Key, strings.Join(addresses, ", "))
+	report.RetainedResources = retained
+	return nil
+}
@@ -0,0 +1,165 @@
+package tfworkspace
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dynajoe/temporal-terraform-demo/s3object"
+)
+
+type (
+	// RetentionPolicy says how long per-stack artifacts are kept, keyed by
+	// artifact type: reports, outputs or snapshots. Types without a rule
+	// are kept forever.
+	RetentionPolicy map[string]RetentionRule
+
+	RetentionRule struct {
+		// MaxAge is how long an artifact is kept, zero keeps it until it
+		// falls out of KeepLast
+		MaxAge time.Duration `json:"max_age,omitempty"`
+
+		// KeepLast is how many of the newest artifacts of the type each
+		// stack keeps regardless of age, zero keeps none past MaxAge
+		KeepLast int `json:"keep_last,omitempty"`
+	}
+
+	CleanupResult struct {
+		Deleted        []string
+		BytesReclaimed int64
+
+		// Protected counts expired artifacts kept for an open workflow
+		Protected int
+	}
+)
+
+// artifactExt is the extension of each artifact type's objects, anything
+// else under a stack's prefix is never cleaned up
+var artifactExt = map[string]string{
+	"reports":   ".json",
+	"outputs":   ".json",
+	"snapshots": ".tfstate",
+	"plans":     ".tfplan",
+}
+
+// Validate checks the policy only names known artifact types
+func (p RetentionPolicy) Validate() error {
+	for kind, rule := range p {
+		if _, ok := artifactExt[kind]; !ok {
+			return fmt.Errorf("unknown artifact type %q", kind)
+		}
+		if rule.MaxAge < 0 || rule.KeepLast < 0 {
+			return fmt.Errorf("artifact type %s: max age and keep last can't be negative", kind)
+		}
+		if rule.MaxAge == 0 && rule.KeepLast == 0 {
+			return fmt.Errorf("artifact type %s: needs a max age or keep last", kind)
+		}
+	}
+	return nil
+}
+
+// CleanupArtifacts deletes the artifacts in bucket the policy no longer
+// keeps. Artifacts written by a run of one of the open workflow IDs are
+// kept whatever their age, a workflow may still read its snapshot or
+// outputs. progress is called after each deletion.
+func CleanupArtifacts(ctx context.Context, client s3object.Store, bucket string, policy RetentionPolicy, openWorkflows map[string]bool, now time.Time, progress func(CleanupResult)) (CleanupResult, error) {
+	var result CleanupResult
+
+	objects, err := client.ListObjects(ctx, bucket, "")
+	if err != nil {
+		return result, fmt.Errorf("error listing artifacts: %w", err)
+	}
+
+	// Group artifacts by stack and type, e.g. vpc-demo/snapshots
+	groups := map[string][]s3object.ObjectInfo{}
+	for _, object := range objects {
+		dir, name := path.Split(object.Key)
+		stack, kind := path.Split(strings.TrimSuffix(dir, "/"))
+		if stack == "" || path.Ext(name) != artifactExt[kind] {
+			continue
+		}
+		if _, ok := policy[kind]; !ok {
+			continue
+		}
+		groups[dir] = append(groups[dir], object)
+	}
+
+	dirs := make([]string, 0, len(groups))
+	for dir := range groups {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	for _, dir := range dirs {
+		group := groups[dir]
+		rule := policy[path.Base(dir)]
+
+		// Newest first so the first KeepLast are kept
+		sort.SliceStable(group, func(i, j int) bool {
+			return group[i].LastModified.After(group[j].LastModified)
+		})
+
+		for i, object := range group {
+			if i < rule.KeepLast {
+				continue
+			}
+			if rule.MaxAge > 0 && now.Sub(object.LastModified) < rule.MaxAge {
+				continue
+			}
+
+			runID, err := artifactRunID(ctx, client, bucket, object.Key)
+			if err != nil {
+				return result, err
+			}
+			if openWorkflows[workflowIDOfRun(runID)] {
+				result.Protected++
+				continue
+			}
+
+			if err := client.Delete(ctx, bucket, object.Key); err != nil {
+				return result, fmt.Errorf("error deleting artifact %s: %w", object.Key, err)
+			}
+			result.Deleted = append(result.Deleted, object.Key)
+			result.BytesReclaimed += object.Size
+			if progress != nil {
+				progress(result)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// artifactRunID returns the run that wrote an artifact. Outputs and
+// snapshots are named after their run but reports after their start time.
+func artifactRunID(ctx context.Context, client s3object.Store, bucket string, key string) (string, error) {
+	name := path.Base(key)
+	if path.Base(path.Dir(key)) != "reports" {
+		return strings.TrimSuffix(name, path.Ext(name)), nil
+	}
+
+	data, err := client.Get(ctx, bucket, key)
+	if err != nil {
+		return "", fmt.Errorf("error reading execution report %s: %w", key, err)
+	}
+	var r Report
+	if err := json.Unmarshal(data, &r); err != nil {
+		// An unreadable report can't be referenced by anything
+		return "", nil
+	}
+	return r.RunID, nil
+}
+
+// workflowIDOfRun returns the workflow ID of a run ID of the form
+// <workflow id>_<run id>, temporal's run IDs don't contain underscores
+func workflowIDOfRun(runID string) string {
+	i := strings.LastIndex(runID, "_")
+	if i < 0 {
+		return ""
+	}
+	return runID[:i]
+}
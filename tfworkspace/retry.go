@@ -0,0 +1,99 @@
+package tfworkspace
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/dynajoe/temporal-terraform-demo/tfexec"
+)
+
+// retryBackoff is multiplied by the attempt number between targeted retries
+const retryBackoff = 15 * time.Second
+
+// retryFailedResources recovers from transient provider errors, such as
+// throttling, by re-applying only the resources the failed apply reported
+// errors for. It gives up as soon as the plan shows anything else would
+// change, since that means the failure wasn't isolated to those resources.
+// Reviewed plans are never retried, the retry's plan wasn't approved.
+func (w *Workspace) retryFailedResources(ctx context.Context, tf tfexec.Executor, input ApplyInput, env map[string]string, report *Report, applyErr error) error {
+	if input.TransientRetries > 0 && (input.PlanRef != nil || input.PlannedSerial != nil) {
+		var failed *tfexec.ApplyError
+		if errors.As(applyErr, &failed) && len(failed.Addresses) > 0 {
+			return &ReviewedPlanFailedError{Key: w.config.S3Backend.Key, Addresses: failed.Addresses, Err: applyErr}
+		}
+		return applyErr
+	}
+
+	for attempt := 1; attempt <= input.TransientRetries; attempt++ {
+		var failed *tfexec.ApplyError
+		if !errors.As(applyErr, &failed) || len(failed.Addresses) == 0 {
+			return applyErr
+		}
+
+		select {
+		case <-ctx.Done():
+			return applyErr
+		case <-time.After(time.Duration(attempt) * retryBackoff):
+		}
+
+		done := report.phase(fmt.Sprintf("retry plan %d", attempt))
+		changes, err := tf.Plan(ctx, tfexec.PlanParams{
+			Vars:        input.Vars,
+			Env:         env,
+			LockTimeout: input.LockTimeout,
+		})
+		done(err)
+		if err != nil {
+			return applyErr
+		}
+		if len(changes) == 0 {
+			return nil
+		}
+
+		targets, ok := onlyFailedChanges(changes, failed.Addresses)
+		if !ok {
+			log.Printf("not retrying apply, plan changes more than the failed resources %v", failed.Addresses)
+			return applyErr
+		}
+
+		log.Printf("retrying apply of failed resources %v (attempt %d of %d)", targets, attempt, input.TransientRetries)
+		done = report.phase(fmt.Sprintf("retry apply %d", attempt))
+		err = tf.Apply(ctx, tfexec.ApplyParams{
+			Vars:             input.Vars,
+			Env:              env,
+			Parallelism:      input.Parallelism,
+			ResourceTimeouts: input.ResourceTimeouts,
+			Targets:          targets,
+			InterruptTimeout: input.InterruptTimeout,
+			LockTimeout:      input.LockTimeout,
+			NoRefresh:        input.NoRefresh,
+		})
+		done(err)
+		if err == nil {
+			return nil
+		}
+		applyErr = err
+	}
+
+	return applyErr
+}
+
+// onlyFailedChanges returns the changed addresses if every one of them failed
+func onlyFailedChanges(changes []tfexec.ResourceChange, failed []string) ([]string, bool) {
+	failedSet := make(map[string]bool, len(failed))
+	for _, address := range failed {
+		failedSet[address] = true
+	}
+
+	targets := make([]string, 0, len(changes))
+	for _, change := range changes {
+		if !failedSet[change.Address] {
+			return nil, false
+		}
+		targets = append(targets, change.Address)
+	}
+	return targets, true
+}
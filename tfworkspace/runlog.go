@@ -0,0 +1,74 @@
+package tfworkspace
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/dynajoe/temporal-terraform-demo/runlog"
+)
+
+// logBundle logs the module files extracted for an operation
+func (w *Workspace) logBundle(operation string, moduleFS fs.FS, modulePath string) {
+	if w.config.OnRunLog == nil {
+		return
+	}
+
+	var files []string
+	_ = fs.WalkDir(moduleFS, modulePath, func(p string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			files = append(files, strings.TrimPrefix(p, path.Clean(modulePath)+"/"))
+		}
+		return err
+	})
+	w.config.OnRunLog(runlog.Entry{
+		Kind:     runlog.KindBundle,
+		StateKey: w.config.S3Backend.Key,
+		Summary:  fmt.Sprintf("%s built for %s, %d files", w.config.TerraformPath, operation, len(files)),
+		Details:  files,
+	})
+}
+
+// logPlan logs the changes a plan found, as "create aws_vpc.vpc" lines
+func (w *Workspace) logPlan(output PlanOutput, err error) {
+	if w.config.OnRunLog == nil {
+		return
+	}
+
+	entry := runlog.Entry{Kind: runlog.KindPlan, StateKey: w.config.S3Backend.Key}
+	switch {
+	case err != nil:
+		entry.Summary = "failed"
+		entry.Error = err.Error()
+	case output.Cached:
+		entry.Summary = "no changes (cached)"
+	default:
+		entry.Summary = fmt.Sprintf("%d changes at serial %d", len(output.Changes), output.StateSerial)
+		for _, change := range output.Changes {
+			entry.Details = append(entry.Details, fmt.Sprintf("%s %s", strings.Join(change.Actions, ","), change.Address))
+		}
+	}
+	w.config.OnRunLog(entry)
+}
+
+func (w *Workspace) logApply(output ApplyOutput, err error) {
+	summary := "applied"
+	if err == nil && !output.Changed {
+		summary = "applied, no changes"
+	}
+	w.logResult(runlog.KindApply, summary, err)
+}
+
+func (w *Workspace) logResult(kind string, summary string, err error) {
+	if w.config.OnRunLog == nil {
+		return
+	}
+
+	entry := runlog.Entry{Kind: kind, StateKey: w.config.S3Backend.Key, Summary: summary}
+	if err != nil {
+		entry.Summary = "failed"
+		entry.Error = err.Error()
+	}
+	w.config.OnRunLog(entry)
+}
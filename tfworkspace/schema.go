@@ -0,0 +1,37 @@
+package tfworkspace
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dynajoe/temporal-terraform-demo/tfexec"
+)
+
+// ProviderSchemas initializes the module and returns the schemas of the
+// providers it requires. The stack's state isn't read.
+func (w *Workspace) ProviderSchemas(ctx context.Context) (_ *tfexec.ProviderSchemas, err error) {
+	workDir, cleanup, err := w.newWorkDir("schema")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { cleanup(err) }()
+
+	moduleFS, modulePath, err := w.module()
+	if err != nil {
+		return nil, err
+	}
+	if err = extractEmbeddedTerraform(moduleFS, modulePath, workDir); err != nil {
+		return nil, fmt.Errorf("error extracting terraform: %w", err)
+	}
+
+	tf, err := w.init(ctx, workDir)
+	if err != nil {
+		return nil, err
+	}
+
+	schemas, err := tf.ProvidersSchema(ctx, tfexec.ProvidersSchemaParams{})
+	if err != nil {
+		return nil, fmt.Errorf("terraform providers schema error: %w", err)
+	}
+	return schemas, nil
+}
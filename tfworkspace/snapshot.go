@@ -0,0 +1,124 @@
+package tfworkspace
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+
+	"github.com/dynajoe/temporal-terraform-demo/s3object"
+	"github.com/dynajoe/temporal-terraform-demo/tfexec"
+	"github.com/dynajoe/temporal-terraform-demo/tfstate"
+)
+
+// ErrNoSnapshot is returned when restoring a run that left no snapshot
+var ErrNoSnapshot = errors.New("no pre-change state snapshot")
+
+// snapshotKey is where the state is copied before a run changes it, e.g.
+// vpc-demo.tfstate -> vpc-demo/snapshots/<run>.tfstate
+func snapshotKey(stateKey string, runID string) string {
+	return path.Join(statePrefix(stateKey), "snapshots", runID+".tfstate")
+}
+
+// snapshotState copies the state aside before an apply or destroy. A
+// snapshot already taken by the run is kept, so a retried activity can't
+// replace it with state its first attempt corrupted.
+func (w *Workspace) snapshotState(ctx context.Context) error {
+	// Restores find the snapshot by run, and a retried attempt must find
+	// the one its first attempt took
+	runID := w.config.RunID
+	if runID == "" {
+		return fmt.Errorf("state can only be snapshotted for a run")
+	}
+
+	backend := w.config.S3Backend
+	client := backend.Objects()
+	key := snapshotKey(backend.Key, runID)
+
+	_, err := client.Get(ctx, backend.Bucket, key)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, s3object.ErrNotFound) {
+		return fmt.Errorf("error checking state snapshot: %w", err)
+	}
+
+	state, err := client.Get(ctx, backend.Bucket, backend.Key)
+	if errors.Is(err, s3object.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading state to snapshot: %w", err)
+	}
+
+	if err := client.Put(ctx, backend.Bucket, key, state); err != nil {
+		return fmt.Errorf("error storing state snapshot: %w", err)
+	}
+	return nil
+}
+
+// RestoreSnapshot puts back the state as it was before the run changed it.
+// The restored state keeps its lineage but is written at a serial after the
+// current one, so plans made against the bad state are seen as stale. It is
+// written holding the state lock and with its digest updated, like terraform
+// writes it. Returns the serial written.
+func RestoreSnapshot(ctx context.Context, backend tfexec.S3BackendConfig, runID string) (serial int64, err error) {
+	err = withStateLock(ctx, backend, backend.Key, "OperationTypeRestore", func() error {
+		serial, err = restoreSnapshot(ctx, backend, runID)
+		return err
+	})
+	return serial, err
+}
+
+func restoreSnapshot(ctx context.Context, backend tfexec.S3BackendConfig, runID string) (int64, error) {
+	client := backend.Objects()
+
+	data, err := client.Get(ctx, backend.Bucket, snapshotKey(backend.Key, runID))
+	if errors.Is(err, s3object.ErrNotFound) {
+		return 0, fmt.Errorf("%s run %s: %w", backend.Key, runID, ErrNoSnapshot)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error reading state snapshot: %w", err)
+	}
+	snapshot, err := tfstate.Parse(data)
+	if err != nil {
+		return 0, err
+	}
+
+	// The current state may be too damaged to parse, only its serial and
+	// lineage are needed
+	var current struct {
+		Serial  int64  `json:"serial"`
+		Lineage string `json:"lineage"`
+	}
+	currentData, err := client.Get(ctx, backend.Bucket, backend.Key)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(currentData, &current); err == nil && current.Lineage != "" && current.Lineage != snapshot.Lineage {
+			return 0, fmt.Errorf("snapshot lineage %s does not match the state's lineage %s", snapshot.Lineage, current.Lineage)
+		}
+	case !errors.Is(err, s3object.ErrNotFound):
+		return 0, fmt.Errorf("error reading state: %w", err)
+	}
+
+	// Rewrite only the serial, everything else is restored as it was
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return 0, fmt.Errorf("error parsing state snapshot: %w", err)
+	}
+	serial := snapshot.Serial
+	if current.Serial >= serial {
+		serial = current.Serial + 1
+	}
+	raw["serial"] = json.RawMessage(fmt.Sprint(serial))
+
+	restored, err := json.MarshalIndent(raw, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	if err := putState(ctx, backend, backend.Key, restored); err != nil {
+		return 0, fmt.Errorf("error restoring state: %w", err)
+	}
+	return serial, nil
+}
@@ -0,0 +1,20 @@
+package tfworkspace
+
+import (
+	"io/fs"
+)
+
+type (
+	// BundleSource resolves a terraform path to the filesystem holding the
+	// module and the module's path within it
+	BundleSource interface {
+		Resolve(terraformPath string) (fs.FS, string, error)
+	}
+
+	// BundleSourceFunc adapts a func to a BundleSource
+	BundleSourceFunc func(terraformPath string) (fs.FS, string, error)
+)
+
+func (f BundleSourceFunc) Resolve(terraformPath string) (fs.FS, string, error) {
+	return f(terraformPath)
+}
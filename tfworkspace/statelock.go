@@ -0,0 +1,81 @@
+package tfworkspace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/dynajoe/temporal-terraform-demo/locktable"
+	"github.com/dynajoe/temporal-terraform-demo/tfexec"
+)
+
+// withStateLock runs fn holding the lock terraform takes on the state at key,
+// so writes made outside terraform can't interleave with a plan or apply.
+// State without a lock table isn't locked.
+func withStateLock(ctx context.Context, backend tfexec.S3BackendConfig, key string, operation string, fn func() error) (err error) {
+	if !hasLockTable(backend) {
+		return fn()
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	who, _ := os.Hostname()
+	lockID := locktable.LockID(backend.Bucket, key)
+	info := locktable.LockInfo{
+		ID:        hex.EncodeToString(id),
+		Operation: operation,
+		Who:       who,
+		Created:   time.Now().UTC(),
+		Path:      lockID,
+	}
+
+	locks := locktable.New(backend.Credentials, backend.Region)
+	if err := locks.Lock(ctx, backend.DynamoDBTable, lockID, info); err != nil {
+		return err
+	}
+	defer func() {
+		// Released even if ctx was canceled, a leaked lock blocks the stack
+		// until someone force unlocks it
+		unlockCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if unlockErr := locks.Unlock(unlockCtx, backend.DynamoDBTable, lockID, info); unlockErr != nil && err == nil {
+			err = unlockErr
+		}
+	}()
+	return fn()
+}
+
+// putState writes the state at key and records its digest, which terraform
+// checks the state it reads against. Call it holding the state lock.
+func putState(ctx context.Context, backend tfexec.S3BackendConfig, key string, state []byte) error {
+	if err := backend.Objects().Put(ctx, backend.Bucket, key, state); err != nil {
+		return err
+	}
+	if !hasLockTable(backend) {
+		return nil
+	}
+	return locktable.New(backend.Credentials, backend.Region).PutDigest(ctx, backend.DynamoDBTable, locktable.LockID(backend.Bucket, key), state)
+}
+
+// deleteState removes the state at key and its digest. Call it holding the
+// state lock.
+func deleteState(ctx context.Context, backend tfexec.S3BackendConfig, key string) error {
+	if err := backend.Objects().Delete(ctx, backend.Bucket, key); err != nil {
+		return fmt.Errorf("error deleting state: %w", err)
+	}
+	if !hasLockTable(backend) {
+		return nil
+	}
+	return locktable.New(backend.Credentials, backend.Region).DeleteDigest(ctx, backend.DynamoDBTable, locktable.LockID(backend.Bucket, key))
+}
+
+// hasLockTable is true for state terraform locks, state in a local
+// directory or an injected store never is
+func hasLockTable(backend tfexec.S3BackendConfig) bool {
+	return backend.DynamoDBTable != "" && backend.Store == nil && backend.LocalDir == ""
+}
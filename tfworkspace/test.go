@@ -0,0 +1,84 @@
+package tfworkspace
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+
+	"github.com/dynajoe/temporal-terraform-demo/tfexec"
+)
+
+// ErrNoTests is returned by Test for modules that don't ship tests
+var ErrNoTests = errors.New("module has no terraform tests")
+
+type TestInput struct {
+	Env                map[string]string
+	Vars               map[string]interface{}
+	AwsCredentials     aws.CredentialsProvider
+	AwsCredentialsMode CredentialsMode
+
+	// Filter limits the run to the given test files
+	Filter []string
+}
+
+// Test runs the module's terraform tests in a working directory of their
+// own. Tests keep their state in memory, the stack's state isn't touched.
+func (w *Workspace) Test(ctx context.Context, input TestInput) (_ tfexec.TestReport, err error) {
+	moduleFS, modulePath, err := w.module()
+	if err != nil {
+		return tfexec.TestReport{}, err
+	}
+	if !hasTests(moduleFS, modulePath) {
+		return tfexec.TestReport{}, ErrNoTests
+	}
+
+	input.Vars = w.withMetadata(input.Vars)
+
+	workDir, cleanup, err := w.newWorkDir("test")
+	if err != nil {
+		return tfexec.TestReport{}, err
+	}
+	defer func() { cleanup(err) }()
+
+	if err = extractEmbeddedTerraform(moduleFS, modulePath, workDir); err != nil {
+		return tfexec.TestReport{}, fmt.Errorf("error extracting terraform: %w", err)
+	}
+
+	tf, err := w.init(ctx, workDir)
+	if err != nil {
+		return tfexec.TestReport{}, err
+	}
+
+	env, cleanupCreds, err := w.terraformEnv(ctx, input.Env, input.AwsCredentials, input.AwsCredentialsMode)
+	if err != nil {
+		return tfexec.TestReport{}, err
+	}
+	defer cleanupCreds()
+
+	report, err := tf.Test(ctx, tfexec.TestParams{
+		Vars:   input.Vars,
+		Env:    env,
+		Filter: input.Filter,
+	})
+	if err != nil {
+		return tfexec.TestReport{}, fmt.Errorf("terraform test error: %w", err)
+	}
+	return report, nil
+}
+
+// hasTests reports whether the module contains .tftest.hcl files
+func hasTests(fsys fs.FS, dir string) bool {
+	found := false
+	_ = fs.WalkDir(fsys, dir, func(p string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() && strings.HasSuffix(p, ".tftest.hcl") {
+			found = true
+			return fs.SkipDir
+		}
+		return nil
+	})
+	return found
+}
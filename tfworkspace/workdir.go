@@ -0,0 +1,64 @@
+package tfworkspace
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"regexp"
+
+	"github.com/dynajoe/temporal-terraform-demo/tfexec"
+)
+
+// unsafeDirChars are replaced when naming a working directory after a run
+var unsafeDirChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// newWorkDir creates the working directory for an operation under the
+// configured root, named after the run. Call the returned func with the
+// operation's result to remove it, failed workspaces are kept if configured.
+func (w *Workspace) newWorkDir(operation string) (string, func(error), error) {
+	root := w.config.WorkspaceRoot
+	if root != "" {
+		if err := os.MkdirAll(root, 0700); err != nil {
+			return "", nil, fmt.Errorf("error creating workspace root: %w", err)
+		}
+	}
+
+	prefix := "tf-" + operation + "-"
+	if w.config.RunID != "" {
+		prefix = unsafeDirChars.ReplaceAllString(w.config.RunID, "_") + "-" + operation + "-"
+	}
+
+	workDir, err := ioutil.TempDir(root, prefix)
+	if err != nil {
+		return "", nil, fmt.Errorf("error creating terraform workspace: %w", err)
+	}
+
+	return workDir, func(err error) {
+		if err != nil && w.config.KeepFailedWorkspaces {
+			scrubWorkDir(workDir)
+			log.Printf("keeping failed terraform workspace: %s", workDir)
+			return
+		}
+		os.RemoveAll(workDir)
+	}, nil
+}
+
+// scrubWorkDir removes files holding backend and registry credentials, and
+// the vars and saved plan holding sensitive values, from a workspace that is
+// kept for inspection
+func scrubWorkDir(workDir string) {
+	for _, name := range []string{
+		"_backend.tf",
+		tfexec.BackendCredentialsDir,
+		"_terraformrc",
+		path.Join(".terraform", "terraform.tfstate"),
+		tfexec.VarsFile,
+		tfexec.PlanFile,
+	} {
+		if err := os.RemoveAll(path.Join(workDir, name)); err != nil {
+			log.Printf("error removing %s from kept workspace: %v", name, err)
+		}
+	}
+}
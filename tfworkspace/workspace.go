@@ -1,40 +1,192 @@
+// Package tfworkspace runs terraform operations for a stack in a temporary
+// working directory, from extracting the module through reporting. Workspace
+// and BundleSource are the stable API.
 package tfworkspace
 
 import (
+	"bytes"
 	"context"
-	"embed"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"io/fs"
 	"log"
 	"os"
 	"path"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+
+	"github.com/dynajoe/temporal-terraform-demo/providercreds"
+	"github.com/dynajoe/temporal-terraform-demo/runlog"
+	"github.com/dynajoe/temporal-terraform-demo/s3object"
+	"github.com/dynajoe/temporal-terraform-demo/terraform"
+	"github.com/dynajoe/temporal-terraform-demo/tfconfig"
 	"github.com/dynajoe/temporal-terraform-demo/tfexec"
+	"github.com/dynajoe/temporal-terraform-demo/tfstate"
 )
 
 type (
 	Config struct {
+		// TerraformPath is the module directory, optionally prefixed with the
+		// namespace it was registered under, e.g. "core:aws/vpc"
 		TerraformPath string
-		TerraformFS   embed.FS
-		S3Backend     tfexec.S3BackendConfig
+
+		// TerraformFS overrides resolving TerraformPath from the registered
+		// module trees
+		TerraformFS fs.FS
+
+		// Source resolves TerraformPath when TerraformFS is not set, defaults
+		// to the registered module trees
+		Source BundleSource
+
+		// NewExecutor creates the terraform executor, defaults to the
+		// terraform CLI found on the PATH
+		NewExecutor tfexec.NewTerraformFunc
+
+		S3Backend tfexec.S3BackendConfig
+
+		// Outputs the stack is expected to produce after a successful apply
+		Outputs map[string]OutputType
+
+		// RunID identifies the run in execution reports
+		RunID string
+
+		// Reports enables uploading an execution report next to the state
+		Reports bool
+
+		// CachePlans skips plans that would repeat the last plan to find no
+		// changes, keyed by the module files, vars and state serial
+		CachePlans bool
+
+		// OffloadOutputsOver is the encoded size in bytes above which outputs
+		// are uploaded next to the state and returned by reference. Zero
+		// always returns outputs inline.
+		OffloadOutputsOver int
+
+		// WorkspaceRoot is where working directories are created, defaults
+		// to the system temp directory
+		WorkspaceRoot string
+
+		// KeepFailedWorkspaces leaves the working directory of a failed
+		// operation in place for post-mortem
+		KeepFailedWorkspaces bool
+
+		// RedactOutputs are globs over output names whose values are kept
+		// out of results and reports, e.g. "*_endpoint". They can still be
+		// read from the stack's state.
+		RedactOutputs []string
+
+		// ProviderCredentials are resolved for each run and passed to
+		// terraform as environment variables
+		ProviderCredentials []providercreds.Provider
+
+		// Metadata is passed to modules that declare MetadataVar
+		Metadata map[string]string
+
+		// RetainOnDestroy are resource addresses a soft delete removes from
+		// the state rather than destroying, e.g. a bucket holding logs that
+		// must be kept
+		RetainOnDestroy []string
+
+		// UniqueSuffixSeed derives the suffix passed to modules that declare
+		// UniqueSuffixVar, e.g. the workflow ID
+		UniqueSuffixSeed string
+
+		// CLIConfig configures terraform itself, e.g. a ProviderMirror
+		// populated by MirrorProviders
+		CLIConfig tfexec.CLIConfig
+
+		// OnPluginCache is told how each init used CLIConfig.PluginCacheDir,
+		// e.g. to record cache hit rates
+		OnPluginCache func(tfexec.PluginCacheUsage)
+
+		// OffloadPlans uploads plans that found changes next to the state
+		// and removes the local copy, PlanOutput.PlanRef then lets the apply
+		// run exactly the reviewed plan
+		OffloadPlans bool
+
+		// OnDiskUsage is told how much disk each plan and apply used
+		OnDiskUsage func(DiskUsage)
+
+		// OnRunLog is given the bundle built for each operation and the
+		// result of plans, applies and destroys, e.g. to append them to a
+		// runlog.Log
+		OnRunLog func(runlog.Entry)
 	}
 
 	ApplyInput struct {
-		Env            map[string]string
-		Vars           map[string]interface{}
-		AttemptImport  map[string]string
-		AwsCredentials aws.CredentialsProvider
+		Env                map[string]string
+		Vars               map[string]interface{}
+		AttemptImport      map[string]string
+		AwsCredentials     aws.CredentialsProvider
+		AwsCredentialsMode CredentialsMode
+
+		// Parallelism and ResourceTimeouts tune the apply, see tfexec.ApplyParams
+		Parallelism      int
+		ResourceTimeouts map[string]tfexec.ResourceTimeouts
+
+		// TransientRetries is how many times resources that failed to apply
+		// are re-applied on their own before the apply fails. Applies of a
+		// reviewed plan fail with a ReviewedPlanFailedError instead.
+		TransientRetries int
+
+		// InterruptTimeout is how long terraform has to exit after the
+		// context is canceled, see tfexec.ApplyParams
+		InterruptTimeout time.Duration
+
+		// LockTimeout and NoRefresh, see tfexec.PlanParams
+		LockTimeout time.Duration
+		NoRefresh   bool
+
+		// PlannedSerial, if set, fails the apply with a StaleStateError
+		// unless the state is still at the serial the plan was made against
+		PlannedSerial *int64
+
+		// PlannedVersions, if set, fails the apply with a
+		// VersionMismatchError unless terraform and the providers are at the
+		// versions the plan was made with
+		PlannedVersions *tfexec.Versions
+
+		// PlanRef, if set, downloads the offloaded plan and applies it
+		// instead of planning again. AttemptImport is skipped, imports would
+		// make the plan stale.
+		PlanRef *PlanRef
 	}
 
 	ApplyOutput struct {
 		Output map[string]interface{}
+
+		// OutputRef is set instead of Output when outputs were offloaded,
+		// OutputNames summarizes what is behind the reference
+		OutputRef   *OutputRef
+		OutputNames []string
+
+		// RedactedOutputs are the outputs whose values were withheld
+		RedactedOutputs []string
+
+		// Changed is false when the apply left the state untouched, i.e.
+		// there was nothing to change
+		Changed bool
+
+		// Inventory is what the stack manages after the apply
+		Inventory Inventory
 	}
 
 	DestroyInput struct {
-		Env            map[string]string
-		Vars           map[string]interface{}
-		AwsCredentials aws.CredentialsProvider
+		Env                map[string]string
+		Vars               map[string]interface{}
+		AwsCredentials     aws.CredentialsProvider
+		AwsCredentialsMode CredentialsMode
+		Parallelism        int
+
+		// LockTimeout, see tfexec.PlanParams
+		LockTimeout time.Duration
+
+		// SoftDelete keeps the RetainOnDestroy resources, they are recorded
+		// in the execution report for cleanup
+		SoftDelete bool
 	}
 
 	Workspace struct {
@@ -44,48 +196,114 @@ type (
 )
 
 func New(config Config) *Workspace {
-	return &Workspace{config: config, tf: tfexec.LazyFromPath()}
+	tf := config.NewExecutor
+	if tf == nil {
+		tf = tfexec.LazyFromPath()
+	}
+	return &Workspace{config: config, tf: tf}
 }
 
 func (w *Workspace) Apply(ctx context.Context, input ApplyInput) (ApplyOutput, error) {
+	report := w.newReport("apply", input.Vars, input.Env)
+	output, err := w.apply(ctx, input, report)
+	report.finish(err)
+	w.publishReport(ctx, report)
+	w.logApply(output, err)
+	return output, err
+}
+
+func (w *Workspace) apply(ctx context.Context, input ApplyInput, report *Report) (_ ApplyOutput, err error) {
+	input.Vars, err = w.withUniqueSuffix(ctx, input.Vars, true)
+	if err != nil {
+		return ApplyOutput{}, err
+	}
+	input.Vars = w.withMetadata(input.Vars)
+
+	if err := validateRedactOutputs(w.config.RedactOutputs); err != nil {
+		return ApplyOutput{}, err
+	}
+
 	// Create temporary workspace
-	workDir, err := ioutil.TempDir("", "tf-apply-")
+	workDir, cleanup, err := w.newWorkDir("apply")
 	if err != nil {
-		return ApplyOutput{}, fmt.Errorf("error creating terraform workspace: %w", err)
+		return ApplyOutput{}, err
 	}
-	defer os.RemoveAll(workDir)
+	defer func() { cleanup(err) }()
 
 	// Extract embedded terraform to the workspace
-	if err = extractEmbeddedTerraform(w.config.TerraformFS, w.config.TerraformPath, workDir); err != nil {
+	moduleFS, modulePath, err := w.module()
+	if err != nil {
+		return ApplyOutput{}, err
+	}
+	if err = extractEmbeddedTerraform(moduleFS, modulePath, workDir); err != nil {
 		return ApplyOutput{}, fmt.Errorf("error extracting terraform: %w", err)
 	}
+	w.logBundle("apply", moduleFS, modulePath)
 
 	log.Printf("initializing terraform in directory: %s", workDir)
 
 	// Initialize terraform workspace
+	done := report.phase("init")
 	tf, err := w.init(ctx, workDir)
+	done(err)
 	if err != nil {
 		return ApplyOutput{}, err
 	}
 
-	// Copy env to a new map
-	env := make(map[string]string, len(input.Env))
-	for k, v := range input.Env {
-		env[k] = v
+	// Add AWS creds to environment
+	env, cleanupCreds, err := w.terraformEnv(ctx, input.Env, input.AwsCredentials, input.AwsCredentialsMode)
+	if err != nil {
+		return ApplyOutput{}, err
 	}
+	defer cleanupCreds()
 
-	// Add AWS creds to environment
-	if input.AwsCredentials != nil {
-		creds, err := input.AwsCredentials.Retrieve(ctx)
+	if input.PlannedVersions != nil {
+		versions, err := tf.Version(ctx, env)
+		if err != nil {
+			return ApplyOutput{}, fmt.Errorf("terraform version error: %w", err)
+		}
+		if diffs := input.PlannedVersions.Diff(versions); len(diffs) > 0 {
+			return ApplyOutput{}, &VersionMismatchError{Key: w.config.S3Backend.Key, Diffs: diffs}
+		}
+	}
+
+	// Checked before imports, which bump the serial
+	serialBefore, serialKnown := w.stateSerial(ctx)
+	if input.PlannedSerial != nil {
+		if !serialKnown {
+			return ApplyOutput{}, fmt.Errorf("unable to verify state %s is unchanged since the plan", w.config.S3Backend.Key)
+		}
+		if serialBefore != *input.PlannedSerial {
+			return ApplyOutput{}, &StaleStateError{
+				Key:           w.config.S3Backend.Key,
+				PlannedSerial: *input.PlannedSerial,
+				CurrentSerial: serialBefore,
+			}
+		}
+	}
+
+	// Keep the state as it was before imports and the apply touch it
+	done = report.phase("snapshot")
+	err = w.snapshotState(ctx)
+	done(err)
+	if err != nil {
+		return ApplyOutput{}, err
+	}
+
+	var planFile string
+	if input.PlanRef != nil {
+		done = report.phase("download")
+		err = w.fetchPlan(ctx, workDir, *input.PlanRef)
+		done(err)
 		if err != nil {
 			return ApplyOutput{}, err
 		}
-		env["AWS_ACCESS_KEY_ID"] = creds.AccessKeyID
-		env["AWS_SECRET_ACCESS_KEY"] = creds.SecretAccessKey
-		env["AWS_SESSION_TOKEN"] = creds.SessionToken
+		planFile = tfexec.PlanFile
+		input.AttemptImport = nil
 	}
 
 	// Attempt to import resources that may have not had state pushed on failure
+	done = report.phase("import")
 	for k, v := range input.AttemptImport {
 		// Intentionally ignoring error
 		_ = tf.Import(ctx, tfexec.ImportParams{
@@ -97,47 +315,140 @@ func (w *Workspace) Apply(ctx context.Context, input ApplyInput) (ApplyOutput, e
 
 		// Check for context cancel
 		if ctx.Err() != nil {
+			done(ctx.Err())
 			return ApplyOutput{}, ctx.Err()
 		}
 	}
+	done(nil)
+
+	// Imports change the state, what the apply changes is measured after them
+	if len(input.AttemptImport) > 0 {
+		serialBefore, serialKnown = w.stateSerial(ctx)
+	}
 
-	if err := tf.Apply(ctx, tfexec.ApplyParams{
-		Vars: input.Vars,
-		Env:  env,
-	}); err != nil {
+	done = report.phase("apply")
+	err = tf.Apply(ctx, tfexec.ApplyParams{
+		Vars:             input.Vars,
+		Env:              env,
+		Parallelism:      input.Parallelism,
+		ResourceTimeouts: input.ResourceTimeouts,
+		InterruptTimeout: input.InterruptTimeout,
+		LockTimeout:      input.LockTimeout,
+		NoRefresh:        input.NoRefresh,
+		PlanFile:         planFile,
+	})
+	done(err)
+	w.reportDiskUsage("apply", workDir)
+	if err != nil {
+		err = w.retryFailedResources(ctx, tf, input, env, report, err)
+	}
+	if err == nil && input.PlanRef != nil {
+		w.removePlan(ctx, *input.PlanRef)
+	}
+	var applyErr *tfexec.ApplyError
+	if errors.As(err, &applyErr) {
+		report.CompletedResources = applyErr.Completed
+		report.InterruptedResources = applyErr.Interrupted
+	}
+	if err != nil {
 		return ApplyOutput{}, fmt.Errorf("terraform apply error: %w", err)
 	}
 
 	// Extract output from successful Terraform Apply
+	done = report.phase("output")
 	tfOutput, err := tf.Output(ctx, tfexec.OutputParams{
 		Env: env,
 	})
+	done(err)
 	if err != nil {
 		return ApplyOutput{}, fmt.Errorf("terraform output error: %w", err)
 	}
 
 	output := make(map[string]interface{}, len(tfOutput))
+	report.Outputs = make(map[string]interface{}, len(tfOutput))
 	for k, v := range tfOutput {
 		output[k] = v.Value
+		report.Outputs[k] = v.Value
+		if v.Sensitive {
+			report.Outputs[k] = Redacted
+		}
+	}
+
+	// Fail with a clear error rather than leaving consumers to trip over missing keys
+	if err := verifyOutputs(w.config.TerraformPath, w.config.Outputs, output); err != nil {
+		return ApplyOutput{}, err
+	}
+
+	// Withhold outputs that policy treats as secrets before they leave the activity
+	redactedOutputs := redactOutputs(w.config.RedactOutputs, output)
+
+	// Assume a change when the state can't be read
+	serialAfter, _ := w.stateSerial(ctx)
+	changed := !serialKnown || serialAfter != serialBefore
+	inventory := w.inventory(ctx, workDir)
+	redactOutputs(w.config.RedactOutputs, report.Outputs)
+
+	// Large outputs would exceed the activity result payload size limit
+	if w.config.OffloadOutputsOver > 0 {
+		data, err := json.Marshal(output)
+		if err != nil {
+			return ApplyOutput{}, err
+		}
+		if len(data) > w.config.OffloadOutputsOver {
+			ref, err := w.offloadOutputs(ctx, data)
+			if err != nil {
+				return ApplyOutput{}, err
+			}
+			return ApplyOutput{
+				OutputRef:       ref,
+				OutputNames:     outputNames(output),
+				RedactedOutputs: redactedOutputs,
+				Changed:         changed,
+				Inventory:       inventory,
+			}, nil
+		}
 	}
 
 	return ApplyOutput{
-		Output: output,
+		Output:          output,
+		OutputNames:     outputNames(output),
+		RedactedOutputs: redactedOutputs,
+		Changed:         changed,
+		Inventory:       inventory,
 	}, nil
 }
 
 func (w *Workspace) Destroy(ctx context.Context, input DestroyInput) error {
+	report := w.newReport("destroy", input.Vars, input.Env)
+	err := w.destroy(ctx, input, report)
+	report.finish(err)
+	w.publishReport(ctx, report)
+	w.logResult(runlog.KindDestroy, "destroyed", err)
+	return err
+}
+
+func (w *Workspace) destroy(ctx context.Context, input DestroyInput, report *Report) (err error) {
+	input.Vars, err = w.withUniqueSuffix(ctx, input.Vars, false)
+	if err != nil {
+		return err
+	}
+	input.Vars = w.withMetadata(input.Vars)
+
 	// Create temporary workspace
-	workDir, err := ioutil.TempDir("", "tf-destroy-")
+	workDir, cleanup, err := w.newWorkDir("destroy")
 	if err != nil {
-		return fmt.Errorf("error creating terraform workspace: %w", err)
+		return err
 	}
-	defer os.RemoveAll(workDir)
+	defer func() { cleanup(err) }()
 
 	// Only extract versions.tf for destroy because it's needed to determine
 	// the versions of terraform providers. Every terraform directory should
 	// have a versions.tf at the top level.
-	versionsFileData, err := w.config.TerraformFS.ReadFile(path.Join(w.config.TerraformPath, "versions.tf"))
+	moduleFS, modulePath, err := w.module()
+	if err != nil {
+		return err
+	}
+	versionsFileData, err := fs.ReadFile(moduleFS, path.Join(modulePath, "versions.tf"))
 	if err != nil {
 		return err
 	}
@@ -148,46 +459,95 @@ func (w *Workspace) Destroy(ctx context.Context, input DestroyInput) error {
 	}
 
 	// Initialize terraform workspace
+	done := report.phase("init")
 	tf, err := w.init(ctx, workDir)
+	done(err)
 	if err != nil {
 		return err
 	}
 
-	// Copy env to a new map
-	env := make(map[string]string, len(input.Env))
-	for k, v := range input.Env {
-		env[k] = v
+	// Add AWS creds to environment
+	env, cleanupCreds, err := w.terraformEnv(ctx, input.Env, input.AwsCredentials, input.AwsCredentialsMode)
+	if err != nil {
+		return err
 	}
+	defer cleanupCreds()
 
-	// Add AWS creds to environment
-	if input.AwsCredentials != nil {
-		creds, err := input.AwsCredentials.Retrieve(ctx)
+	done = report.phase("snapshot")
+	err = w.snapshotState(ctx)
+	done(err)
+	if err != nil {
+		return err
+	}
+
+	if input.SoftDelete {
+		done = report.phase("retain")
+		err = w.detachRetained(ctx, tf, env, input.LockTimeout, report)
+		done(err)
 		if err != nil {
 			return err
 		}
-		env["AWS_ACCESS_KEY_ID"] = creds.AccessKeyID
-		env["AWS_SECRET_ACCESS_KEY"] = creds.SecretAccessKey
-		env["AWS_SESSION_TOKEN"] = creds.SessionToken
 	}
 
-	if err := tf.Destroy(ctx, tfexec.DestroyParams{
-		Vars: input.Vars,
-		Env:  env,
-	}); err != nil {
+	done = report.phase("destroy")
+	err = tf.Destroy(ctx, tfexec.DestroyParams{
+		Vars:        input.Vars,
+		Env:         env,
+		Parallelism: input.Parallelism,
+		LockTimeout: input.LockTimeout,
+	})
+	done(err)
+	if err != nil {
 		return fmt.Errorf("terraform destroy error: %w", err)
 	}
 
 	return nil
 }
 
-func (w *Workspace) init(ctx context.Context, workDir string) (*tfexec.Terraform, error) {
+// stateSerial returns the serial of the stack's state, zero if there is no
+// state yet. ok is false if the state couldn't be read.
+func (w *Workspace) stateSerial(ctx context.Context) (serial int64, ok bool) {
+	data, err := w.config.S3Backend.Objects().Get(ctx, w.config.S3Backend.Bucket, w.config.S3Backend.Key)
+	if errors.Is(err, s3object.ErrNotFound) || (err == nil && len(bytes.TrimSpace(data)) == 0) {
+		return 0, true
+	}
+	if err != nil {
+		log.Printf("unable to read state serial: %v", err)
+		return 0, false
+	}
+	state, err := tfstate.Parse(data)
+	if err != nil {
+		log.Printf("unable to read state serial: %v", err)
+		return 0, false
+	}
+	return state.Serial, true
+}
+
+// module returns the filesystem containing the configured terraform module
+// and the module's path within it
+func (w *Workspace) module() (fs.FS, string, error) {
+	if w.config.TerraformFS != nil {
+		return w.config.TerraformFS, w.config.TerraformPath, nil
+	}
+	if w.config.Source != nil {
+		return w.config.Source.Resolve(w.config.TerraformPath)
+	}
+	return terraform.Resolve(w.config.TerraformPath)
+}
+
+func (w *Workspace) init(ctx context.Context, workDir string) (tfexec.Executor, error) {
 	tf, err := w.tf(workDir)
 	if err != nil {
 		return nil, err
 	}
 
 	initParams := tfexec.InitParams{
-		Backend: w.config.S3Backend,
+		Backend:       w.config.S3Backend,
+		CLIConfig:     w.config.CLIConfig,
+		OnPluginCache: w.config.OnPluginCache,
+	}
+	if w.config.CLIConfig.PluginCacheDir != "" {
+		initParams.Providers = requiredProviders(os.DirFS(workDir), ".")
 	}
 	err = tf.Init(ctx, initParams)
 	if err != nil {
@@ -210,3 +570,86 @@ func (o ApplyOutput) String(key string) (string, error) {
 
 	return s, nil
 }
+
+func (o ApplyOutput) StringList(key string) ([]string, error) {
+	v, ok := o.Output[key]
+	if !ok {
+		return nil, fmt.Errorf("missing key [%s] in output", key)
+	}
+
+	ss, ok := stringList(v)
+	if !ok {
+		return nil, fmt.Errorf("output [%s] is not a list of strings", key)
+	}
+
+	return ss, nil
+}
+
+func (o ApplyOutput) Number(key string) (float64, error) {
+	v, ok := o.Output[key]
+	if !ok {
+		return 0, fmt.Errorf("missing key [%s] in output", key)
+	}
+
+	n, ok := v.(float64)
+	if !ok {
+		return 0, fmt.Errorf("output [%s] is not a number", key)
+	}
+
+	return n, nil
+}
+
+func (o ApplyOutput) Bool(key string) (bool, error) {
+	v, ok := o.Output[key]
+	if !ok {
+		return false, fmt.Errorf("missing key [%s] in output", key)
+	}
+
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("output [%s] is not a bool", key)
+	}
+
+	return b, nil
+}
+
+func (o ApplyOutput) StringMap(key string) (map[string]string, error) {
+	v, ok := o.Output[key]
+	if !ok {
+		return nil, fmt.Errorf("missing key [%s] in output", key)
+	}
+
+	m, ok := stringMap(v)
+	if !ok {
+		return nil, fmt.Errorf("output [%s] is not a map of strings", key)
+	}
+
+	return m, nil
+}
+
+// requiredProviders lists the provider sources the module and its local
+// child modules require. It returns nil when they can't be known before
+// init, i.e. a child module is fetched or a module relies on implied
+// providers.
+func requiredProviders(fsys fs.FS, dir string) []string {
+	module, err := tfconfig.LoadModule(fsys, dir)
+	if err != nil {
+		return nil
+	}
+
+	var sources []string
+	for _, req := range module.RequiredProviders {
+		sources = append(sources, req.Source)
+	}
+	for _, call := range module.ModuleCalls {
+		if !strings.HasPrefix(call.Source, "./") && !strings.HasPrefix(call.Source, "../") {
+			return nil
+		}
+		child := requiredProviders(fsys, path.Join(dir, call.Source))
+		if child == nil {
+			return nil
+		}
+		sources = append(sources, child...)
+	}
+	return sources
+}
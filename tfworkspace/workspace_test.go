@@ -0,0 +1,328 @@
+package tfworkspace
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/dynajoe/temporal-terraform-demo/s3object"
+	"github.com/dynajoe/temporal-terraform-demo/tfexec"
+	"github.com/dynajoe/temporal-terraform-demo/tfstate"
+)
+
+// fakeTerraform stands in for the terraform CLI. Applies write the state
+// to the backend the way terraform would, bumping its serial.
+type fakeTerraform struct {
+	workDir string
+	backend tfexec.S3BackendConfig
+	changes []tfexec.ResourceChange
+	outputs map[string]tfexec.Output
+
+	// applyErr fails every apply
+	applyErr error
+
+	applies  []tfexec.ApplyParams
+	destroys []tfexec.DestroyParams
+}
+
+func (f *fakeTerraform) Init(ctx context.Context, params tfexec.InitParams) error {
+	f.backend = params.Backend
+	return nil
+}
+
+func (f *fakeTerraform) Import(ctx context.Context, params tfexec.ImportParams) error {
+	return nil
+}
+
+func (f *fakeTerraform) Plan(ctx context.Context, params tfexec.PlanParams) ([]tfexec.ResourceChange, error) {
+	if err := os.WriteFile(filepath.Join(f.workDir, tfexec.PlanFile), []byte("plan"), 0600); err != nil {
+		return nil, err
+	}
+	return f.changes, nil
+}
+
+func (f *fakeTerraform) Apply(ctx context.Context, params tfexec.ApplyParams) error {
+	f.applies = append(f.applies, params)
+	if f.applyErr != nil {
+		return f.applyErr
+	}
+	if len(f.changes) == 0 {
+		return nil
+	}
+
+	var serial int64
+	if state, err := tfstate.Load(ctx, f.backend); err == nil {
+		serial = state.Serial
+	} else if !errors.Is(err, s3object.ErrNotFound) {
+		return err
+	}
+
+	outputs := make(map[string]interface{}, len(f.outputs))
+	for k, v := range f.outputs {
+		outputs[k] = map[string]interface{}{"value": v.Value, "sensitive": v.Sensitive}
+	}
+	data, err := json.Marshal(map[string]interface{}{
+		"version": 4,
+		"serial":  serial + 1,
+		"lineage": "test",
+		"outputs": outputs,
+		"resources": []interface{}{map[string]interface{}{
+			"mode": "managed", "type": "aws_vpc", "name": "vpc",
+			"instances": []interface{}{map[string]interface{}{"attributes": map[string]interface{}{"id": "vpc-123"}}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	return f.backend.Objects().Put(ctx, f.backend.Bucket, f.backend.Key, data)
+}
+
+func (f *fakeTerraform) Destroy(ctx context.Context, params tfexec.DestroyParams) error {
+	f.destroys = append(f.destroys, params)
+	return nil
+}
+
+func (f *fakeTerraform) StateRm(ctx context.Context, params tfexec.StateRmParams) error {
+	return nil
+}
+
+func (f *fakeTerraform) Output(ctx context.Context, params tfexec.OutputParams) (map[string]tfexec.Output, error) {
+	return f.outputs, nil
+}
+
+func (f *fakeTerraform) Test(ctx context.Context, params tfexec.TestParams) (tfexec.TestReport, error) {
+	return tfexec.TestReport{}, nil
+}
+
+func (f *fakeTerraform) ProvidersSchema(ctx context.Context, params tfexec.ProvidersSchemaParams) (*tfexec.ProviderSchemas, error) {
+	return &tfexec.ProviderSchemas{}, nil
+}
+
+func (f *fakeTerraform) Version(ctx context.Context, env map[string]string) (tfexec.Versions, error) {
+	return tfexec.Versions{Terraform: "1.0.11"}, nil
+}
+
+var testModule = fstest.MapFS{
+	"vpc/main.tf":     {Data: []byte(`resource "aws_vpc" "vpc" {}` + "\n")},
+	"vpc/versions.tf": {Data: []byte(`terraform {}` + "\n")},
+}
+
+// testWorkspace returns a workspace for the vpc module whose terraform is
+// the returned fake
+func testWorkspace(t *testing.T, backend tfexec.S3BackendConfig) (*Workspace, *fakeTerraform) {
+	tf := &fakeTerraform{
+		changes: []tfexec.ResourceChange{{Address: "aws_vpc.vpc", Actions: []string{"create"}}},
+		outputs: map[string]tfexec.Output{"vpc_id": {Value: "vpc-123"}},
+	}
+	w := New(Config{
+		TerraformPath: "vpc",
+		TerraformFS:   testModule,
+		NewExecutor: func(workDir string) (tfexec.Executor, error) {
+			tf.workDir = workDir
+			return tf, nil
+		},
+		S3Backend:     backend,
+		Outputs:       map[string]OutputType{"vpc_id": OutputString},
+		RunID:         "run-1",
+		Reports:       true,
+		WorkspaceRoot: t.TempDir(),
+	})
+	return w, tf
+}
+
+// testBackends are the backends a workspace can keep state in without AWS
+func testBackends(t *testing.T) map[string]tfexec.S3BackendConfig {
+	return map[string]tfexec.S3BackendConfig{
+		"memory": {Bucket: "state", Key: "network/vpc.tfstate", Store: s3object.NewMemory()},
+		"local":  {Bucket: "state", Key: "network/vpc.tfstate", LocalDir: t.TempDir()},
+	}
+}
+
+func TestApply(t *testing.T) {
+	for name, backend := range testBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			w, _ := testWorkspace(t, backend)
+
+			output, err := w.Apply(ctx, ApplyInput{})
+			require.NoError(t, err)
+			require.True(t, output.Changed)
+			require.Equal(t, 1, output.Inventory.ResourceCount)
+			vpcID, err := output.String("vpc_id")
+			require.NoError(t, err)
+			require.Equal(t, "vpc-123", vpcID)
+
+			state, err := tfstate.Load(ctx, backend)
+			require.NoError(t, err)
+			require.Equal(t, int64(1), state.Serial)
+
+			reports, err := backend.Objects().List(ctx, backend.Bucket, "network/vpc/reports/")
+			require.NoError(t, err)
+			require.Len(t, reports, 1)
+		})
+	}
+}
+
+func TestApplyUnchanged(t *testing.T) {
+	for name, backend := range testBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			w, tf := testWorkspace(t, backend)
+			_, err := w.Apply(ctx, ApplyInput{})
+			require.NoError(t, err)
+
+			tf.changes = nil
+			output, err := w.Apply(ctx, ApplyInput{})
+			require.NoError(t, err)
+			require.False(t, output.Changed)
+		})
+	}
+}
+
+func TestApplyStaleState(t *testing.T) {
+	for name, backend := range testBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			w, tf := testWorkspace(t, backend)
+
+			plan, err := w.Plan(ctx, PlanInput{})
+			require.NoError(t, err)
+			require.Equal(t, int64(0), plan.StateSerial)
+			require.Equal(t, tf.changes, plan.Changes)
+
+			// Another run applies between the plan and the apply
+			_, err = w.Apply(ctx, ApplyInput{})
+			require.NoError(t, err)
+
+			_, err = w.Apply(ctx, ApplyInput{PlannedSerial: &plan.StateSerial})
+			var staleErr *StaleStateError
+			require.True(t, errors.As(err, &staleErr))
+			require.Equal(t, int64(1), staleErr.CurrentSerial)
+			require.Len(t, tf.applies, 1)
+		})
+	}
+}
+
+func TestApplyContractViolation(t *testing.T) {
+	for name, backend := range testBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			w, tf := testWorkspace(t, backend)
+			tf.outputs = map[string]tfexec.Output{"vpc_id": {Value: []interface{}{"vpc-123"}}}
+
+			_, err := w.Apply(context.Background(), ApplyInput{})
+			var contractErr *ContractViolationError
+			require.True(t, errors.As(err, &contractErr))
+			require.Equal(t, []string{"output [vpc_id] is not of type string"}, contractErr.Violations)
+		})
+	}
+}
+
+func TestApplyOffloadedPlan(t *testing.T) {
+	for name, backend := range testBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			w, tf := testWorkspace(t, backend)
+			w.config.OffloadPlans = true
+
+			plan, err := w.Plan(ctx, PlanInput{})
+			require.NoError(t, err)
+			require.NotNil(t, plan.PlanRef)
+
+			_, err = w.Apply(ctx, ApplyInput{PlannedSerial: &plan.StateSerial, PlanRef: plan.PlanRef})
+			require.NoError(t, err)
+			require.Equal(t, tfexec.PlanFile, tf.applies[0].PlanFile)
+
+			// A plan is applied once
+			exists, err := backend.Objects().Exists(ctx, plan.PlanRef.Bucket, plan.PlanRef.Key)
+			require.NoError(t, err)
+			require.False(t, exists)
+		})
+	}
+}
+
+func TestPlanCached(t *testing.T) {
+	for name, backend := range testBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			w, tf := testWorkspace(t, backend)
+			w.config.CachePlans = true
+			_, err := w.Apply(ctx, ApplyInput{})
+			require.NoError(t, err)
+
+			tf.changes = nil
+			plan, err := w.Plan(ctx, PlanInput{})
+			require.NoError(t, err)
+			require.False(t, plan.Cached)
+
+			plan, err = w.Plan(ctx, PlanInput{})
+			require.NoError(t, err)
+			require.True(t, plan.Cached)
+			require.Equal(t, int64(1), plan.StateSerial)
+
+			plan, err = w.Plan(ctx, PlanInput{Vars: map[string]interface{}{"name": "main"}})
+			require.NoError(t, err)
+			require.False(t, plan.Cached)
+		})
+	}
+}
+
+func TestDestroyWaitsForLock(t *testing.T) {
+	for name, backend := range testBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			w, tf := testWorkspace(t, backend)
+
+			err := w.Destroy(context.Background(), DestroyInput{LockTimeout: 5 * time.Minute})
+			require.NoError(t, err)
+			require.Len(t, tf.destroys, 1)
+			require.Equal(t, 5*time.Minute, tf.destroys[0].LockTimeout)
+		})
+	}
+}
+
+func TestReportsNotOverwritten(t *testing.T) {
+	for name, backend := range testBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			w, _ := testWorkspace(t, backend)
+
+			// Runs in the same second keep their own reports
+			for i := 0; i < 3; i++ {
+				_, err := w.Apply(ctx, ApplyInput{})
+				require.NoError(t, err)
+			}
+
+			history, err := History(ctx, backend)
+			require.NoError(t, err)
+			require.Len(t, history, 3)
+			for i := 1; i < len(history); i++ {
+				require.False(t, history[i].StartedAt.Before(history[i-1].StartedAt))
+			}
+		})
+	}
+}
+
+func TestApplyReviewedPlanNotRetried(t *testing.T) {
+	for name, backend := range testBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			w, tf := testWorkspace(t, backend)
+
+			plan, err := w.Plan(ctx, PlanInput{})
+			require.NoError(t, err)
+
+			tf.applyErr = &tfexec.ApplyError{Err: errors.New("throttled"), Addresses: []string{"aws_vpc.vpc"}}
+			_, err = w.Apply(ctx, ApplyInput{PlannedSerial: &plan.StateSerial, TransientRetries: 2})
+			var reviewedErr *ReviewedPlanFailedError
+			require.True(t, errors.As(err, &reviewedErr))
+			require.Equal(t, []string{"aws_vpc.vpc"}, reviewedErr.Addresses)
+			require.Len(t, tf.applies, 1)
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package timeline
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/interceptor"
+	"go.temporal.io/sdk/workflow"
+
+	"github.com/dynajoe/temporal-terraform-demo/tfworkspace"
+)
+
+type (
+	Options struct {
+		// DataConverter decodes signal payloads, defaults to the default
+		// data converter
+		DataConverter converter.DataConverter
+
+		// ApprovalSignal is the signal approving or rejecting a plan, its
+		// payload has Approved, By and Reason fields
+		ApprovalSignal string
+
+		// IgnoreWorkflowTypes aren't exported, e.g. long running helpers
+		// that don't change stacks
+		IgnoreWorkflowTypes []string
+	}
+
+	workerInterceptor struct {
+		interceptor.WorkerInterceptorBase
+		exporter *Exporter
+		options  Options
+		ignore   map[string]bool
+	}
+
+	workflowInterceptor struct {
+		interceptor.WorkflowInboundInterceptorBase
+		root     *workerInterceptor
+		stateKey string
+	}
+
+	activityInterceptor struct {
+		interceptor.ActivityInboundInterceptorBase
+		root *workerInterceptor
+	}
+)
+
+// NewInterceptor returns a worker interceptor that exports the start,
+// approval and end of workflows and the plans, applies and destroys of
+// their activities. Replayed workflow code exports nothing.
+func NewInterceptor(exporter *Exporter, options Options) interceptor.WorkerInterceptor {
+	if options.DataConverter == nil {
+		options.DataConverter = converter.GetDefaultDataConverter()
+	}
+	ignore := map[string]bool{}
+	for _, name := range options.IgnoreWorkflowTypes {
+		ignore[name] = true
+	}
+	return &workerInterceptor{exporter: exporter, options: options, ignore: ignore}
+}
+
+func (w *workerInterceptor) InterceptWorkflow(ctx workflow.Context, next interceptor.WorkflowInboundInterceptor) interceptor.WorkflowInboundInterceptor {
+	if w.ignore[workflow.GetInfo(ctx).WorkflowType.Name] {
+		return next
+	}
+	i := &workflowInterceptor{root: w}
+	i.Next = next
+	return i
+}
+
+func (w *workerInterceptor) InterceptActivity(ctx context.Context, next interceptor.ActivityInboundInterceptor) interceptor.ActivityInboundInterceptor {
+	i := &activityInterceptor{root: w}
+	i.Next = next
+	return i
+}
+
+func (w *workflowInterceptor) ExecuteWorkflow(ctx workflow.Context, in *interceptor.ExecuteWorkflowInput) (interface{}, error) {
+	w.stateKey = stateKeyOf(in.Args)
+	w.emit(ctx, EventStarted, "")
+
+	result, err := w.Next.ExecuteWorkflow(ctx, in)
+	if err != nil {
+		w.emit(ctx, EventFailed, err.Error())
+	} else {
+		w.emit(ctx, EventCompleted, "")
+	}
+	return result, err
+}
+
+func (w *workflowInterceptor) HandleSignal(ctx workflow.Context, in *interceptor.HandleSignalInput) error {
+	if in.SignalName == w.root.options.ApprovalSignal && w.root.options.ApprovalSignal != "" {
+		var approval struct {
+			Approved bool
+			By       string
+			Reason   string
+		}
+		if err := w.root.options.DataConverter.FromPayloads(in.Arg, &approval); err == nil {
+			kind := EventRejected
+			if approval.Approved {
+				kind = EventApproved
+			}
+			w.emit(ctx, kind, strings.TrimSpace(approval.By+" "+approval.Reason))
+		}
+	}
+	return w.Next.HandleSignal(ctx, in)
+}
+
+func (w *workflowInterceptor) emit(ctx workflow.Context, kind string, detail string) {
+	if workflow.IsReplaying(ctx) {
+		return
+	}
+	info := workflow.GetInfo(ctx)
+	w.root.exporter.emit(Event{
+		At:           workflow.Now(ctx),
+		Kind:         kind,
+		WorkflowID:   info.WorkflowExecution.ID,
+		RunID:        info.WorkflowExecution.RunID,
+		WorkflowType: info.WorkflowType.Name,
+		StateKey:     w.stateKey,
+		Detail:       detail,
+	})
+}
+
+func (a *activityInterceptor) ExecuteActivity(ctx context.Context, in *interceptor.ExecuteActivityInput) (interface{}, error) {
+	result, err := a.Next.ExecuteActivity(ctx, in)
+	if err != nil {
+		return result, err
+	}
+
+	info := activity.GetInfo(ctx)
+	var workflowType string
+	if info.WorkflowType != nil {
+		workflowType = info.WorkflowType.Name
+	}
+	if a.root.ignore[workflowType] {
+		return result, err
+	}
+
+	var kind, detail string
+	name := info.ActivityType.Name
+	switch {
+	case strings.HasPrefix(name, "Plan"):
+		plan, ok := result.(tfworkspace.PlanOutput)
+		if !ok {
+			return result, err
+		}
+		kind = EventPlanReady
+		detail = fmt.Sprintf("%d changes", len(plan.Changes))
+	case strings.HasPrefix(name, "Apply") || strings.HasPrefix(name, "Create"):
+		kind = EventApplied
+	case strings.HasPrefix(name, "Destroy"):
+		kind = EventDestroyed
+	default:
+		return result, err
+	}
+
+	a.root.exporter.emit(Event{
+		At:           time.Now(),
+		Kind:         kind,
+		WorkflowID:   info.WorkflowExecution.ID,
+		RunID:        info.WorkflowExecution.RunID,
+		WorkflowType: workflowType,
+		StateKey:     stateKeyOf(in.Args),
+		Detail:       detail,
+	})
+	return result, err
+}
+
+// stateKeyOf returns the stack named by the first argument, either as its
+// StateKey or as Stack.StateKey
+func stateKeyOf(args []interface{}) string {
+	if len(args) == 0 {
+		return ""
+	}
+	data, err := json.Marshal(args[0])
+	if err != nil {
+		return ""
+	}
+
+	var input struct {
+		StateKey string
+		Stack    struct {
+			StateKey string
+		}
+	}
+	if err := json.Unmarshal(data, &input); err != nil {
+		return ""
+	}
+	if input.StateKey != "" {
+		return input.StateKey
+	}
+	return input.Stack.StateKey
+}
@@ -0,0 +1,148 @@
+// Package timeline exports key events of terraform workflows, e.g. a plan
+// becoming ready or an apply finishing, to an object store as they happen so
+// dashboards can follow stacks without polling Temporal visibility
+package timeline
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/dynajoe/temporal-terraform-demo/s3object"
+)
+
+const (
+	EventStarted   = "started"
+	EventPlanReady = "plan-ready"
+	EventApproved  = "approved"
+	EventRejected  = "rejected"
+	EventApplied   = "applied"
+	EventDestroyed = "destroyed"
+	EventCompleted = "completed"
+	EventFailed    = "failed"
+)
+
+// exportBuffer is how many events may wait for the sink before new ones
+// are dropped, a slow sink must never hold up workflows
+const exportBuffer = 1000
+
+type (
+	Event struct {
+		At           time.Time `json:"at"`
+		Kind         string    `json:"kind"`
+		WorkflowID   string    `json:"workflow_id"`
+		RunID        string    `json:"run_id"`
+		WorkflowType string    `json:"workflow_type"`
+
+		// StateKey is the stack the event is about, empty for events of
+		// workflows that don't take a single stack
+		StateKey string `json:"state_key,omitempty"`
+
+		// Detail is e.g. the approver or the error
+		Detail string `json:"detail,omitempty"`
+	}
+
+	// Sink stores exported events
+	Sink interface {
+		Export(ctx context.Context, event Event) error
+	}
+
+	// S3Sink stores each event as an object under the workflow's prefix and,
+	// if it has one, the stack's prefix, see WorkflowPrefix and StackPrefix
+	S3Sink struct {
+		Store  s3object.Store
+		Bucket string
+	}
+
+	// Exporter hands events to its sink in the background
+	Exporter struct {
+		sink   Sink
+		events chan Event
+	}
+)
+
+// StackPrefix is where the events of a stack are stored, e.g.
+// vpc-demo.tfstate -> timeline/stacks/vpc-demo/
+func StackPrefix(stateKey string) string {
+	return path.Join("timeline", "stacks", strings.TrimSuffix(stateKey, path.Ext(stateKey))) + "/"
+}
+
+// WorkflowPrefix is where the events of a workflow are stored
+func WorkflowPrefix(workflowID string) string {
+	return path.Join("timeline", "workflows", workflowID) + "/"
+}
+
+func (s S3Sink) Export(ctx context.Context, event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	// Keys start with the time so listing a prefix returns events in order
+	name := fmt.Sprintf("%s-%s.json", event.At.UTC().Format("20060102T150405.000000000Z"), event.Kind)
+	prefixes := []string{WorkflowPrefix(event.WorkflowID)}
+	if event.StateKey != "" {
+		prefixes = append(prefixes, StackPrefix(event.StateKey))
+	}
+	for _, prefix := range prefixes {
+		if err := s.Store.Put(ctx, s.Bucket, prefix+name, data); err != nil {
+			return fmt.Errorf("error exporting timeline event: %w", err)
+		}
+	}
+	return nil
+}
+
+// List returns the events stored under prefix, oldest first
+func List(ctx context.Context, store s3object.Store, bucket string, prefix string) ([]Event, error) {
+	keys, err := store.List(ctx, bucket, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("error listing timeline events: %w", err)
+	}
+
+	events := make([]Event, 0, len(keys))
+	for _, key := range keys {
+		data, err := store.Get(ctx, bucket, key)
+		if err != nil {
+			return nil, err
+		}
+		var event Event
+		if err := json.Unmarshal(data, &event); err != nil {
+			return nil, fmt.Errorf("error decoding timeline event %s: %w", key, err)
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+func NewExporter(sink Sink) *Exporter {
+	return &Exporter{sink: sink, events: make(chan Event, exportBuffer)}
+}
+
+// Run exports events until ctx is done
+func (e *Exporter) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-e.events:
+			exportCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+			if err := e.sink.Export(exportCtx, event); err != nil {
+				log.Printf("timeline: %s event of %s dropped: %v", event.Kind, event.WorkflowID, err)
+			}
+			cancel()
+		}
+	}
+}
+
+// emit queues an event without blocking
+func (e *Exporter) emit(event Event) {
+	select {
+	case e.events <- event:
+	default:
+		log.Printf("timeline: %s event of %s dropped, export is behind", event.Kind, event.WorkflowID)
+	}
+}
@@ -0,0 +1,160 @@
+package workflows
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.temporal.io/sdk/temporal"
+
+	"github.com/dynajoe/temporal-terraform-demo/providercreds"
+	"github.com/dynajoe/temporal-terraform-demo/terraform"
+	"github.com/dynajoe/temporal-terraform-demo/tfconfig"
+	"github.com/dynajoe/temporal-terraform-demo/tfexec"
+)
+
+// AllowedStack is a module callers may run through the module activities
+type AllowedStack struct {
+	TerraformPath string `json:"terraform_path"`
+
+	// Vars callers may set, empty allows every variable the module declares
+	Vars []string `json:"vars,omitempty"`
+
+	// ProviderCredentials maps environment variables to the secrets they
+	// are set from, e.g. CLOUDFLARE_API_TOKEN: cloudflare/api-token
+	ProviderCredentials map[string]string `json:"provider_credentials,omitempty"`
+
+	// TimeoutProfile sizes the stack's activity timeouts: small, medium or
+	// large. Defaults to medium.
+	TimeoutProfile string `json:"timeout_profile,omitempty"`
+
+	// CLIConfig overrides the worker's terraform CLI config for the stack
+	CLIConfig tfexec.CLIConfig `json:"cli_config,omitempty"`
+
+	// RegistryCredentials maps private registry hosts to the secrets
+	// holding their tokens, e.g. app.terraform.io: terraform-cloud/token
+	RegistryCredentials map[string]string `json:"registry_credentials,omitempty"`
+
+	// RetainOnDestroy are resource addresses a soft delete detaches from
+	// the state instead of destroying, e.g. aws_s3_bucket.flow_logs
+	RetainOnDestroy []string `json:"retain_on_destroy,omitempty"`
+}
+
+var (
+	// secretStore resolves the provider credentials stacks declare
+	secretStore providercreds.SecretStore = providercreds.EnvStore{}
+
+	allowedStacksMu sync.RWMutex
+	allowedStacks   = map[string]AllowedStack{
+		"core:aws/vpc":    {TerraformPath: "core:aws/vpc", TimeoutProfile: "small"},
+		"core:aws/subnet": {TerraformPath: "core:aws/subnet", TimeoutProfile: "small"},
+
+		"core:aws/route53_zone":   {TerraformPath: "core:aws/route53_zone", TimeoutProfile: "small"},
+		"core:aws/route53_record": {TerraformPath: "core:aws/route53_record", TimeoutProfile: "small"},
+	}
+)
+
+// ConfigureAllowedStacks replaces the modules callers may run
+func ConfigureAllowedStacks(stacks []AllowedStack) error {
+	allowed := make(map[string]AllowedStack, len(stacks))
+	for _, stack := range stacks {
+		terraformPath, err := canonicalTerraformPath(stack.TerraformPath)
+		if err != nil {
+			return err
+		}
+		stack.TerraformPath = terraformPath
+		if _, ok := timeoutProfiles[stack.TimeoutProfile]; stack.TimeoutProfile != "" && !ok {
+			return fmt.Errorf("unknown timeout profile %q for %s", stack.TimeoutProfile, terraformPath)
+		}
+		allowed[terraformPath] = stack
+	}
+
+	allowedStacksMu.Lock()
+	defer allowedStacksMu.Unlock()
+	allowedStacks = allowed
+	return nil
+}
+
+// ConfigureSecretStore sets where provider credentials are resolved from
+func ConfigureSecretStore(store providercreds.SecretStore) {
+	secretStore = store
+}
+
+// providerCredentials returns the provider credentials the stack declares
+func (s AllowedStack) providerCredentials() []providercreds.Provider {
+	if len(s.ProviderCredentials) == 0 {
+		return nil
+	}
+	return []providercreds.Provider{providercreds.Secrets{
+		Store: secretStore,
+		Names: s.ProviderCredentials,
+	}}
+}
+
+// LoadAllowedStacks reads a JSON file containing a list of allowed stacks
+func LoadAllowedStacks(path string) ([]AllowedStack, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var stacks []AllowedStack
+	if err := json.Unmarshal(data, &stacks); err != nil {
+		return nil, fmt.Errorf("error decoding allowed stacks: %w", err)
+	}
+	return stacks, nil
+}
+
+// canonicalTerraformPath qualifies a terraform path with its namespace and
+// rejects paths that could escape the module tree
+func canonicalTerraformPath(terraformPath string) (string, error) {
+	namespace, modulePath := terraform.SplitPath(terraformPath)
+	if modulePath == "" || path.IsAbs(modulePath) || path.Clean(modulePath) != modulePath || strings.HasPrefix(modulePath, "..") {
+		return "", fmt.Errorf("invalid terraform path: %s", terraformPath)
+	}
+	return namespace + ":" + modulePath, nil
+}
+
+// allowedStack returns the stack definition, failing unless the module is
+// allowed and the vars are ones callers may set on it
+func allowedStack(module *tfconfig.Module, terraformPath string, vars map[string]interface{}) (AllowedStack, error) {
+	canonical, err := canonicalTerraformPath(terraformPath)
+	if err != nil {
+		return AllowedStack{}, temporal.NewNonRetryableApplicationError(err.Error(), "StackNotAllowed", nil)
+	}
+
+	allowedStacksMu.RLock()
+	stack, ok := allowedStacks[canonical]
+	allowedStacksMu.RUnlock()
+	if !ok {
+		return AllowedStack{}, temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("terraform path %s is not an allowed stack", terraformPath), "StackNotAllowed", nil)
+	}
+
+	allowedVars := map[string]bool{}
+	for _, v := range module.Variables {
+		allowedVars[v.Name] = len(stack.Vars) == 0
+	}
+	for _, name := range stack.Vars {
+		if _, declared := allowedVars[name]; declared {
+			allowedVars[name] = true
+		}
+	}
+
+	var denied []string
+	for name := range vars {
+		if !allowedVars[name] {
+			denied = append(denied, name)
+		}
+	}
+	if len(denied) > 0 {
+		sort.Strings(denied)
+		return AllowedStack{}, temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("vars [%s] may not be set on %s", strings.Join(denied, ", "), terraformPath), "StackNotAllowed", nil)
+	}
+	return stack, nil
+}
@@ -0,0 +1,38 @@
+package workflows
+
+import (
+	"time"
+
+	"go.temporal.io/sdk/workflow"
+)
+
+// AnnotateSignal attaches an operator's note to a running workflow, e.g.
+// "holding apply until after the marketing launch"
+const AnnotateSignal = "annotate"
+
+// Annotation is the payload of AnnotateSignal, At is set by the workflow
+// when the signal is received
+type Annotation struct {
+	By   string
+	Note string
+	At   time.Time
+}
+
+// trackAnnotations records annotations on the status for the rest of the
+// workflow
+func trackAnnotations(ctx workflow.Context, status *Status) {
+	annotateCh := workflow.GetSignalChannel(ctx, AnnotateSignal)
+
+	workflow.Go(ctx, func(ctx workflow.Context) {
+		for {
+			var annotation Annotation
+			annotateCh.Receive(ctx, &annotation)
+			if ctx.Err() != nil {
+				return
+			}
+			annotation.At = workflow.Now(ctx)
+			status.Annotations = append(status.Annotations, annotation)
+			workflow.GetLogger(ctx).Info("Annotated", "By", annotation.By, "Note", annotation.Note, "Phase", status.Phase)
+		}
+	})
+}
@@ -0,0 +1,412 @@
+package workflows
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+
+	"github.com/dynajoe/temporal-terraform-demo/config/awsconfig"
+	"github.com/dynajoe/temporal-terraform-demo/tfexec"
+)
+
+const (
+	// QueueChangeSignal queues an approved patch with a stack's
+	// ApplyQueueWorkflow
+	QueueChangeSignal = "queue-change"
+
+	// applyQueueIdleTimeout is how long an ApplyQueueWorkflow waits for
+	// another change before it completes
+	applyQueueIdleTimeout = 10 * time.Minute
+
+	// applyQueueBatchesPerRun bounds the history of a single run before it
+	// continues as new
+	applyQueueBatchesPerRun = 50
+
+	// applyQueueRecentRequests is how many request IDs a run carries over
+	// to recognize redelivered changes
+	applyQueueRecentRequests = 100
+)
+
+type (
+	ApplyQueueInput struct {
+		Stack  StackRef
+		Region string
+
+		// Window is how long the queue collects changes after the first
+		// one arrives before applying them together
+		Window time.Duration
+
+		// Pending are changes received but not applied before the queue
+		// continued as new, Seen the request IDs of the last changes it
+		// received
+		Pending []QueuedChange
+		Seen    []string
+	}
+
+	// QueuedChange is the payload of QueueChangeSignal
+	QueuedChange struct {
+		RequestID  string
+		WorkflowID string
+		RunID      string
+		Patch      map[string]interface{}
+
+		// Approved are the changes of the plan the requester approved. A
+		// merged plan changing anything else is reviewed again.
+		Approved []tfexec.ResourceChange
+
+		// Queue is the queue the change was meant for. A running queue
+		// ignores the start input of later requesters, so changes for
+		// another role, key, region or window are refused instead of
+		// applied with the queue's.
+		Queue ApplyQueueInput
+	}
+
+	// CoalescedResult is sent to each requester once the apply covering
+	// its change finished
+	CoalescedResult struct {
+		RequestID string
+		Status    ResultStatus
+		Error     string
+
+		// CombinedWith are the workflows whose changes were applied in the
+		// same apply
+		CombinedWith []string
+
+		// Overridden are vars of the change a later change in the batch set
+		// to another value
+		Overridden []string
+	}
+
+	QueueChangeInput struct {
+		Queue  ApplyQueueInput
+		Change QueuedChange
+	}
+)
+
+// ApplyQueueWorkflow coalesces the approved changes queued against one
+// stack. Changes arriving within the window are merged in arrival order,
+// planned once and applied once, and every requester is told the result.
+// It completes once idle and continues as new after a number of batches.
+func ApplyQueueWorkflow(ctx workflow.Context, input ApplyQueueInput) error {
+	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: time.Hour,
+		HeartbeatTimeout:    time.Minute,
+		RetryPolicy: &temporal.RetryPolicy{
+			InitialInterval:    5 * time.Second,
+			BackoffCoefficient: 1.3,
+			MaximumInterval:    time.Minute,
+			MaximumAttempts:    5,
+		},
+	})
+
+	status, err := trackStatus(ctx)
+	if err != nil {
+		return err
+	}
+	queueCh := workflow.GetSignalChannel(ctx, QueueChangeSignal)
+
+	// Activity retries can deliver a change more than once
+	queued := map[string]bool{}
+	seen := append([]string(nil), input.Seen...)
+	for _, requestID := range seen {
+		queued[requestID] = true
+	}
+	receive := func(change QueuedChange, batch []QueuedChange) []QueuedChange {
+		if queued[change.RequestID] {
+			return batch
+		}
+		queued[change.RequestID] = true
+		seen = append(seen, change.RequestID)
+		if !change.Queue.sameQueue(input) {
+			replyToChange(ctx, input, change, CoalescedResult{
+				RequestID: change.RequestID,
+				Error: fmt.Sprintf("the apply queue of %s runs as %s in %s with a %s window, the change was queued for %s in %s with a %s window",
+					input.Stack.StateKey, input.Stack.RoleARN, input.Region, input.Window,
+					change.Queue.Stack.RoleARN, change.Queue.Region, change.Queue.Window),
+			})
+			return batch
+		}
+		return append(batch, change)
+	}
+
+	pending := input.Pending
+	for batches := 0; batches < applyQueueBatchesPerRun; {
+		batch := pending
+		pending = nil
+		if len(batch) == 0 {
+			status.Phase = "waiting for changes"
+			var first QueuedChange
+			if !receiveUntilIdle(ctx, queueCh, &first, applyQueueIdleTimeout) {
+				return nil
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if batch = receive(first, nil); len(batch) == 0 {
+				continue
+			}
+		}
+		batches++
+
+		status.Phase = "collecting changes"
+		windowCtx, cancelWindow := workflow.WithCancel(ctx)
+		window := workflow.NewTimer(windowCtx, input.Window)
+		for open := true; open; {
+			selector := workflow.NewSelector(ctx)
+			selector.AddReceive(queueCh, func(c workflow.ReceiveChannel, more bool) {
+				var change QueuedChange
+				c.Receive(ctx, &change)
+				batch = receive(change, batch)
+			})
+			selector.AddFuture(window, func(workflow.Future) {
+				open = false
+			})
+			selector.Select(ctx)
+		}
+		cancelWindow()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		result := applyBatch(ctx, status, input, batch)
+		for _, change := range batch {
+			own := result
+			own.RequestID = change.RequestID
+			own.Overridden = overriddenVars(change, batch)
+			for _, other := range batch {
+				if other.RequestID != change.RequestID {
+					own.CombinedWith = append(own.CombinedWith, other.WorkflowID)
+				}
+			}
+			replyToChange(ctx, input, change, own)
+		}
+	}
+
+	// Changes already signaled would be lost with this run
+	next := ApplyQueueInput{Stack: input.Stack, Region: input.Region, Window: input.Window}
+	var change QueuedChange
+	for queueCh.ReceiveAsync(&change) {
+		next.Pending = receive(change, next.Pending)
+		change = QueuedChange{}
+	}
+	if len(seen) > applyQueueRecentRequests {
+		seen = seen[len(seen)-applyQueueRecentRequests:]
+	}
+	next.Seen = seen
+	return workflow.NewContinueAsNewError(ctx, ApplyQueueWorkflow, next)
+}
+
+// sameQueue is true if the changes of q can be applied by a queue started
+// with other
+func (q ApplyQueueInput) sameQueue(other ApplyQueueInput) bool {
+	return q.Stack == other.Stack && q.Region == other.Region && q.Window == other.Window
+}
+
+// replyToChange tells the requester of a change how it was applied
+func replyToChange(ctx workflow.Context, input ApplyQueueInput, change QueuedChange, result CoalescedResult) {
+	if err := workflow.SignalExternalWorkflow(ctx, change.WorkflowID, change.RunID, changeAppliedSignal(change.RequestID), result).Get(ctx, nil); err != nil {
+		workflow.GetLogger(ctx).Warn("Change requester is gone", "StateKey", input.Stack.StateKey, "WorkflowID", change.WorkflowID, "Error", err)
+	}
+}
+
+// applyBatch plans the merged patches of the batch and applies the plan.
+// A merged plan making changes none of the requesters approved goes through
+// annotation, the budget check and approval again.
+func applyBatch(ctx workflow.Context, status *Status, input ApplyQueueInput, batch []QueuedChange) CoalescedResult {
+	merged := map[string]interface{}{}
+	for _, change := range batch {
+		for name, v := range change.Patch {
+			merged[name] = v
+		}
+	}
+	workflow.GetLogger(ctx).Info("Applying coalesced changes", "StateKey", input.Stack.StateKey, "Changes", len(batch))
+
+	status.Phase = "planning coalesced changes"
+	info := workflow.GetInfo(ctx)
+	childCtx := workflow.WithChildOptions(ctx, workflow.ChildWorkflowOptions{
+		WorkflowID: fmt.Sprintf("%s-plan-%d", info.WorkflowExecution.ID, workflow.Now(ctx).UnixNano()),
+	})
+	var plan StackUpdatePlan
+	if err := workflow.ExecuteChildWorkflow(childCtx, PlanStackUpdateWorkflow, PlanStackUpdateInput{
+		Stack:  input.Stack,
+		Region: input.Region,
+		Patch:  merged,
+	}).Get(ctx, &plan); err != nil {
+		return CoalescedResult{Error: err.Error()}
+	}
+	if !plan.Affected || len(plan.Plan.Changes) == 0 {
+		return CoalescedResult{Status: StatusNoChanges}
+	}
+	status.Plan = planSummary(plan.Plan.Changes)
+
+	if unapproved := unapprovedChanges(plan.Plan.Changes, batch); len(unapproved) > 0 {
+		workflow.GetLogger(ctx).Warn("Coalesced plan makes unapproved changes", "StateKey", input.Stack.StateKey, "Changes", unapproved)
+		annotatePlan(ctx, status, []PlannedStack{{
+			TerraformPath: plan.TerraformPath,
+			StateKey:      input.Stack.StateKey,
+			Changes:       plan.Plan.Changes,
+		}})
+		if err := checkBudget(ctx, status, []CostEstimate{{Stack: input.Stack, Changes: plan.Plan.Changes}}); err != nil {
+			return CoalescedResult{Error: err.Error()}
+		}
+		if err := awaitApproval(ctx, status, planSummary(plan.Plan.Changes)); err != nil {
+			return CoalescedResult{Error: err.Error()}
+		}
+	}
+
+	status.Phase = "checking change freeze"
+	if err := waitForThaw(ctx, status); err != nil {
+		return CoalescedResult{Error: err.Error()}
+	}
+
+	status.Phase = "applying coalesced changes"
+	applyCtx, err := withStackTimeouts(ctx, plan.TerraformPath, "", nil)
+	if err != nil {
+		return CoalescedResult{Error: err.Error()}
+	}
+	serial := plan.Plan.StateSerial
+	var output ModuleOutput
+	if err := workflow.ExecuteActivity(applyCtx, ApplyModuleActivity, ModuleInput{
+		TerraformPath:   plan.TerraformPath,
+		StateKey:        input.Stack.StateKey,
+		Region:          input.Region,
+		RoleARN:         input.Stack.RoleARN,
+		KMSKeyID:        input.Stack.KMSKeyID,
+		Vars:            plan.Vars,
+		PlannedSerial:   &serial,
+		PlannedVersions: plan.Plan.Versions,
+		PlanRef:         plan.Plan.PlanRef,
+	}).Get(ctx, &output); err != nil {
+		return CoalescedResult{Error: err.Error()}
+	}
+	status.Plan = nil
+	return CoalescedResult{Status: output.Status}
+}
+
+// unapprovedChanges returns the changes of the merged plan that no plan
+// approved for a change in the batch made with the same actions
+func unapprovedChanges(changes []tfexec.ResourceChange, batch []QueuedChange) []string {
+	approved := map[string]bool{}
+	for _, change := range batch {
+		for _, c := range change.Approved {
+			approved[strings.Join(c.Actions, ",")+" "+c.Address] = true
+		}
+	}
+
+	var unapproved []string
+	for _, line := range planSummary(changes) {
+		if !approved[line] {
+			unapproved = append(unapproved, line)
+		}
+	}
+	return unapproved
+}
+
+// overriddenVars lists the vars of change that a later change in the batch
+// set to another value
+func overriddenVars(change QueuedChange, batch []QueuedChange) []string {
+	later := false
+	overridden := map[string]bool{}
+	for _, other := range batch {
+		if other.RequestID == change.RequestID {
+			later = true
+			continue
+		}
+		if !later {
+			continue
+		}
+		for name, v := range other.Patch {
+			if own, ok := change.Patch[name]; ok && !sameVar(own, v) {
+				overridden[name] = true
+			}
+		}
+	}
+
+	names := make([]string, 0, len(overridden))
+	for name := range overridden {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// QueueChangeActivity queues a change with the stack's ApplyQueueWorkflow,
+// starting it if it isn't running. There is one queue per state object, so
+// stacks with the same key in different buckets aren't coalesced.
+func QueueChangeActivity(ctx context.Context, input QueueChangeInput) error {
+	if temporalClient == nil {
+		return temporal.NewNonRetryableApplicationError("no temporal client configured for apply queues", "ApplyQueueUnavailable", nil)
+	}
+
+	backend, err := StateBackend(ctx, awsconfig.LoadConfig().Credentials, input.Queue.Stack)
+	if err != nil {
+		return err
+	}
+	workflowID := applyQueueWorkflowID(backend.Bucket, input.Queue.Stack.StateKey)
+
+	change := input.Change
+	change.Queue = input.Queue
+	_, err = temporalClient.SignalWithStartWorkflow(ctx, workflowID, QueueChangeSignal, change,
+		client.StartWorkflowOptions{
+			ID:        workflowID,
+			TaskQueue: workflowTaskQueue(ctx),
+		}, ApplyQueueWorkflow, input.Queue)
+	return err
+}
+
+// queueChange queues a patch and the changes approved for it with the
+// stack's apply queue, the returned future is set to the CoalescedResult
+// once the combined apply finished
+func queueChange(ctx workflow.Context, queue ApplyQueueInput, patch map[string]interface{}, approved []tfexec.ResourceChange) workflow.Future {
+	info := workflow.GetInfo(ctx)
+	change := QueuedChange{
+		RequestID:  fmt.Sprintf("%s-%s", info.WorkflowExecution.RunID, queue.Stack.StateKey),
+		WorkflowID: info.WorkflowExecution.ID,
+		RunID:      info.WorkflowExecution.RunID,
+		Patch:      patch,
+		Approved:   approved,
+	}
+
+	future, settable := workflow.NewFuture(ctx)
+	workflow.Go(ctx, func(ctx workflow.Context) {
+		activityCtx := workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+			StartToCloseTimeout: 30 * time.Second,
+			RetryPolicy: &temporal.RetryPolicy{
+				InitialInterval:    5 * time.Second,
+				BackoffCoefficient: 1.3,
+				MaximumInterval:    time.Minute,
+			},
+		})
+		if err := workflow.ExecuteActivity(activityCtx, QueueChangeActivity, QueueChangeInput{
+			Queue:  queue,
+			Change: change,
+		}).Get(ctx, nil); err != nil {
+			settable.Set(nil, err)
+			return
+		}
+
+		var result CoalescedResult
+		workflow.GetSignalChannel(ctx, changeAppliedSignal(change.RequestID)).Receive(ctx, &result)
+		if ctx.Err() != nil {
+			settable.Set(nil, ctx.Err())
+			return
+		}
+		settable.Set(result, nil)
+	})
+	return future
+}
+
+func applyQueueWorkflowID(bucket string, stateKey string) string {
+	return "apply-queue-" + bucket + "/" + stateKey
+}
+
+// changeAppliedSignal tells the requester of a change its apply finished
+func changeAppliedSignal(requestID string) string {
+	return "change-applied-" + requestID
+}
@@ -0,0 +1,155 @@
+package workflows
+
+import (
+	"time"
+
+	"github.com/stretchr/testify/mock"
+
+	"github.com/dynajoe/temporal-terraform-demo/tfexec"
+)
+
+var testQueue = ApplyQueueInput{
+	Stack:  testStack,
+	Region: "us-east-1",
+	Window: 5 * time.Minute,
+}
+
+// queueMocks records what the apply queue planned and told the requesters
+type queueMocks struct {
+	*planMocks
+	results map[string][]CoalescedResult
+}
+
+// mockQueue plans changes to be the given ones, and records the results
+// the queue sends
+func (s *workflowTestSuite) mockQueue(changes []tfexec.ResourceChange) *queueMocks {
+	m := &queueMocks{planMocks: s.mockPlans(changes), results: map[string][]CoalescedResult{}}
+	s.env.OnSignalExternalWorkflow(mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(
+		func(namespace, workflowID, runID, signalName string, arg interface{}) error {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			m.results[workflowID] = append(m.results[workflowID], arg.(CoalescedResult))
+			return nil
+		}).Maybe()
+	return m
+}
+
+func queuedChange(id string, patch map[string]interface{}, approved []tfexec.ResourceChange) QueuedChange {
+	return QueuedChange{
+		RequestID:  id,
+		WorkflowID: "requester-" + id,
+		RunID:      "run-" + id,
+		Patch:      patch,
+		Approved:   approved,
+		Queue:      testQueue,
+	}
+}
+
+func (s *workflowTestSuite) TestApplyQueueCoalescesChanges() {
+	changes := []tfexec.ResourceChange{{Address: "aws_vpc.vpc", Actions: []string{"update"}}}
+	m := s.mockQueue(changes)
+	s.env.OnActivity(ApplyModuleActivity, mock.Anything, mock.MatchedBy(func(input ModuleInput) bool {
+		return input.StateKey == testStack.StateKey && *input.PlannedSerial == 4
+	})).Return(ModuleOutput{Status: StatusApplied}, nil).Once()
+
+	first := queuedChange("a", map[string]interface{}{"cidr": "10.0.0.0/16", "name": "main"}, changes)
+	second := queuedChange("b", map[string]interface{}{"cidr": "10.1.0.0/16"}, changes)
+	s.signalAfter(0, QueueChangeSignal, first)
+	s.signalAfter(time.Minute, QueueChangeSignal, second)
+	// Redelivered by a retried activity
+	s.signalAfter(2*time.Minute, QueueChangeSignal, first)
+
+	s.env.ExecuteWorkflow(ApplyQueueWorkflow, testQueue)
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+	s.Equal([]map[string]interface{}{{"cidr": "10.1.0.0/16", "name": "main"}}, m.patches)
+	s.Equal([]CoalescedResult{{
+		RequestID:    "a",
+		Status:       StatusApplied,
+		CombinedWith: []string{"requester-b"},
+		Overridden:   []string{"cidr"},
+	}}, m.results["requester-a"])
+	s.Require().Len(m.results["requester-b"], 1)
+	s.Equal(StatusApplied, m.results["requester-b"][0].Status)
+	s.Equal([]string{"requester-a"}, m.results["requester-b"][0].CombinedWith)
+	s.Empty(m.results["requester-b"][0].Overridden)
+}
+
+func (s *workflowTestSuite) TestApplyQueueBatchesByWindow() {
+	changes := []tfexec.ResourceChange{{Address: "aws_vpc.vpc", Actions: []string{"update"}}}
+	m := s.mockQueue(changes)
+	s.env.OnActivity(ApplyModuleActivity, mock.Anything, mock.Anything).Return(ModuleOutput{Status: StatusApplied}, nil).Twice()
+
+	s.signalAfter(0, QueueChangeSignal, queuedChange("a", map[string]interface{}{"name": "a"}, changes))
+	// After the first window closed but before the queue is idle
+	s.signalAfter(7*time.Minute, QueueChangeSignal, queuedChange("b", map[string]interface{}{"name": "b"}, changes))
+
+	s.env.ExecuteWorkflow(ApplyQueueWorkflow, testQueue)
+
+	s.NoError(s.env.GetWorkflowError())
+	s.Len(m.patches, 2)
+	s.Require().Len(m.results["requester-a"], 1)
+	s.Require().Len(m.results["requester-b"], 1)
+	s.Empty(m.results["requester-a"][0].CombinedWith)
+	s.Empty(m.results["requester-b"][0].CombinedWith)
+}
+
+func (s *workflowTestSuite) TestApplyQueueRefusesOtherQueues() {
+	m := s.mockQueue(nil)
+
+	change := queuedChange("a", map[string]interface{}{"name": "a"}, nil)
+	change.Queue.Region = "eu-west-1"
+	s.signalAfter(0, QueueChangeSignal, change)
+
+	s.env.ExecuteWorkflow(ApplyQueueWorkflow, testQueue)
+
+	s.NoError(s.env.GetWorkflowError())
+	s.Empty(m.patches)
+	s.Require().Len(m.results["requester-a"], 1)
+	s.Contains(m.results["requester-a"][0].Error, "was queued for")
+}
+
+func (s *workflowTestSuite) TestApplyQueueReviewsUnapprovedChanges() {
+	approved := []tfexec.ResourceChange{{Address: "aws_vpc.vpc", Actions: []string{"update"}}}
+	planned := append(approved, tfexec.ResourceChange{Address: "aws_subnet.a", Actions: []string{"delete"}})
+	m := s.mockQueue(planned)
+
+	s.signalAfter(0, QueueChangeSignal, queuedChange("a", map[string]interface{}{"name": "a"}, approved))
+	s.env.RegisterDelayedCallback(func() {
+		status := s.status()
+		s.Equal(PhaseAwaitingApproval, status.Phase)
+		s.Equal([]string{"update aws_vpc.vpc", "delete aws_subnet.a"}, status.Plan)
+	}, 10*time.Minute)
+	s.signalAfter(20*time.Minute, ApprovalSignal, Approval{Approved: false, By: "alice", Reason: "subnet in use"})
+
+	s.env.ExecuteWorkflow(ApplyQueueWorkflow, testQueue)
+
+	s.NoError(s.env.GetWorkflowError())
+	s.Require().Len(m.results["requester-a"], 1)
+	s.Contains(m.results["requester-a"][0].Error, "subnet in use")
+}
+
+func (s *workflowTestSuite) TestApplyQueueBudgetsUnapprovedChanges() {
+	approved := []tfexec.ResourceChange{{Address: "aws_vpc.vpc", Actions: []string{"update"}}}
+	planned := append(approved, tfexec.ResourceChange{Address: "aws_nat_gateway.a", Actions: []string{"create"}})
+	m := s.mockQueue(planned)
+	s.Require().NoError(ConfigureBudgetPolicy(testBudget))
+	s.env.OnActivity(EstimateStackCostsActivity, mock.Anything, mock.Anything).
+		Return([]StackCost{{StateKey: testStack.StateKey, Current: 35, Projected: 70, Cap: 50}}, nil).Once()
+	s.env.OnActivity(ApplyModuleActivity, mock.Anything, mock.Anything).Return(ModuleOutput{Status: StatusApplied}, nil).Once()
+
+	s.signalAfter(0, QueueChangeSignal, queuedChange("a", map[string]interface{}{"name": "a"}, approved))
+	s.signalAfter(10*time.Minute, ApprovalSignal, Approval{Approved: true, By: "alice"})
+	s.env.RegisterDelayedCallback(func() {
+		s.Len(s.status().OverBudget, 1)
+		s.Empty(m.results["requester-a"])
+	}, 15*time.Minute)
+	s.signalAfter(20*time.Minute, ApprovalSignal, Approval{Approved: true, By: "alice", OverrideBudget: true, Reason: "nat for the migration"})
+
+	s.env.ExecuteWorkflow(ApplyQueueWorkflow, testQueue)
+
+	s.NoError(s.env.GetWorkflowError())
+	s.Require().Len(m.results["requester-a"], 1)
+	s.Equal(StatusApplied, m.results["requester-a"][0].Status)
+}
@@ -0,0 +1,77 @@
+package workflows
+
+import (
+	"fmt"
+	"strings"
+
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+
+	"github.com/dynajoe/temporal-terraform-demo/tfexec"
+)
+
+const (
+	// ApprovalSignal approves or rejects a plan a workflow is waiting on
+	ApprovalSignal = "approval"
+
+	// PhaseAwaitingApproval is reported by StatusQuery while a plan waits
+	// for ApprovalSignal
+	PhaseAwaitingApproval = "awaiting approval"
+)
+
+// Approval is the payload of ApprovalSignal
+type Approval struct {
+	Approved bool
+	By       string
+	Reason   string
+
+	// OverrideBudget approves a plan over a stack's budget cap, the
+	// reason is required as its justification
+	OverrideBudget bool
+
+	// Tier is the escalation tier the decision was accepted for, set by
+	// the workflow
+	Tier string
+}
+
+// awaitApproval publishes the plan summary on the status and blocks until
+// an operator the approval policy accepts approves or rejects it
+func awaitApproval(ctx workflow.Context, status *Status, summary []string) error {
+	status.Plan = summary
+	status.Phase = PhaseAwaitingApproval
+
+	// The policy is worker configuration, recorded so replays see it
+	var policy ApprovalPolicy
+	if hasChange(ctx, approvalEscalationVersion) {
+		if err := workflow.SideEffect(ctx, func(workflow.Context) interface{} {
+			return currentApprovalPolicy()
+		}).Get(&policy); err != nil {
+			return err
+		}
+	}
+
+	overBudget := len(status.OverBudget) > 0
+	approval, err := receiveApproval(ctx, status, policy, overBudget)
+	if err != nil {
+		return err
+	}
+	if overBudget && approval.Approved {
+		workflow.GetLogger(ctx).Warn("Budget cap overridden", "By", approval.By, "Reason", approval.Reason, "OverBudget", status.OverBudget)
+	}
+
+	workflow.GetLogger(ctx).Info("Plan reviewed", "Approved", approval.Approved, "By", approval.By, "Reason", approval.Reason, "Tier", approval.Tier)
+	if !approval.Approved {
+		return temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("plan rejected by %s: %s", approval.By, approval.Reason), "PlanRejected", nil, approval)
+	}
+	return nil
+}
+
+// planSummary renders changes as "create aws_vpc.vpc" lines
+func planSummary(changes []tfexec.ResourceChange) []string {
+	summary := make([]string, 0, len(changes))
+	for _, change := range changes {
+		summary = append(summary, fmt.Sprintf("%s %s", strings.Join(change.Actions, ","), change.Address))
+	}
+	return summary
+}
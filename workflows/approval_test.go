@@ -0,0 +1,88 @@
+package workflows
+
+import (
+	"errors"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"go.temporal.io/sdk/temporal"
+)
+
+func (s *workflowTestSuite) TestApprovalApplies() {
+	s.mockPlans(testChanges)
+	s.env.OnActivity(ApplyModuleActivity, mock.Anything, mock.Anything).Return(ModuleOutput{Status: StatusApplied}, nil).Once()
+
+	s.env.RegisterDelayedCallback(func() {
+		status := s.status()
+		s.Equal(PhaseAwaitingApproval, status.Phase)
+		s.Equal([]string{"network/vpc.tfstate: create aws_vpc.vpc"}, status.Plan)
+	}, time.Minute)
+	s.signalAfter(time.Hour, ApprovalSignal, Approval{Approved: true, By: "alice"})
+
+	s.env.ExecuteWorkflow(UpdateVarAcrossStacksWorkflow, testUpdate)
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+	var output UpdateVarAcrossStacksOutput
+	s.NoError(s.env.GetWorkflowResult(&output))
+	s.Equal(StatusApplied, output.Status)
+}
+
+func (s *workflowTestSuite) TestApprovalRejected() {
+	s.mockPlans(testChanges)
+	s.signalAfter(time.Minute, ApprovalSignal, Approval{Approved: false, By: "alice", Reason: "wrong region"})
+
+	s.env.ExecuteWorkflow(UpdateVarAcrossStacksWorkflow, testUpdate)
+
+	s.True(s.env.IsWorkflowCompleted())
+	var appErr *temporal.ApplicationError
+	s.Require().True(errors.As(s.env.GetWorkflowError(), &appErr))
+	s.Equal("PlanRejected", appErr.Type())
+	s.Contains(appErr.Error(), "wrong region")
+}
+
+func (s *workflowTestSuite) TestApprovalEscalates() {
+	s.Require().NoError(ConfigureApprovalPolicy(ApprovalPolicy{
+		Tiers: []ApprovalTier{
+			{Name: "team", Approvers: []string{"alice"}, Timeout: time.Hour},
+			{Name: "leads", Approvers: []string{"bob"}},
+		},
+	}))
+	s.mockPlans(testChanges)
+	s.env.OnActivity(ApplyModuleActivity, mock.Anything, mock.Anything).Return(ModuleOutput{Status: StatusApplied}, nil).Once()
+
+	// bob may only decide once the plan escalated to the leads
+	s.signalAfter(time.Minute, ApprovalSignal, Approval{Approved: false, By: "bob"})
+	s.env.RegisterDelayedCallback(func() {
+		s.False(s.env.IsWorkflowCompleted())
+		s.Equal("team", s.status().ApprovalTier)
+	}, 30*time.Minute)
+	s.env.RegisterDelayedCallback(func() {
+		s.Equal("leads", s.status().ApprovalTier)
+	}, 90*time.Minute)
+	s.signalAfter(2*time.Hour, ApprovalSignal, Approval{Approved: true, By: "bob"})
+
+	s.env.ExecuteWorkflow(UpdateVarAcrossStacksWorkflow, testUpdate)
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+	s.Equal("leads", s.status().ApprovalTier)
+}
+
+func (s *workflowTestSuite) TestApprovalKeepsEarlierTiers() {
+	s.Require().NoError(ConfigureApprovalPolicy(ApprovalPolicy{
+		Tiers: []ApprovalTier{
+			{Name: "team", Approvers: []string{"alice"}, Timeout: time.Hour},
+			{Name: "leads", Approvers: []string{"bob"}},
+		},
+	}))
+	s.mockPlans(testChanges)
+	s.env.OnActivity(ApplyModuleActivity, mock.Anything, mock.Anything).Return(ModuleOutput{Status: StatusApplied}, nil).Once()
+	s.signalAfter(2*time.Hour, ApprovalSignal, Approval{Approved: true, By: "alice"})
+
+	s.env.ExecuteWorkflow(UpdateVarAcrossStacksWorkflow, testUpdate)
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+	s.Equal("team", s.status().ApprovalTier)
+}
@@ -0,0 +1,192 @@
+package workflows
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"go.temporal.io/api/workflowservice/v1"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+
+	"github.com/dynajoe/temporal-terraform-demo/config/awsconfig"
+	"github.com/dynajoe/temporal-terraform-demo/tfworkspace"
+)
+
+// ArtifactCleanupWorkflowID is the ID the worker schedules cleanup under
+const ArtifactCleanupWorkflowID = "artifact-cleanup"
+
+type (
+	ArtifactCleanupInput struct {
+		// Routes are the buckets to clean up, defaults to the default
+		// state bucket and every configured route
+		Routes []StateRoute
+	}
+
+	ArtifactCleanupOutput struct {
+		Deleted        int
+		BytesReclaimed int64
+		Protected      int
+	}
+
+	CleanupBucketInput struct {
+		Bucket string
+		Region string
+	}
+)
+
+var (
+	artifactRetentionMu sync.RWMutex
+	artifactRetention   tfworkspace.RetentionPolicy
+)
+
+// LoadArtifactRetention reads the artifact retention policy from a JSON file
+func LoadArtifactRetention(path string) (tfworkspace.RetentionPolicy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var policy tfworkspace.RetentionPolicy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, fmt.Errorf("error decoding artifact retention policy: %w", err)
+	}
+	return policy, nil
+}
+
+// ConfigureArtifactRetention enables artifact cleanup, artifacts are kept
+// forever until a policy is configured
+func ConfigureArtifactRetention(policy tfworkspace.RetentionPolicy) error {
+	if err := policy.Validate(); err != nil {
+		return fmt.Errorf("artifact retention policy: %w", err)
+	}
+
+	artifactRetentionMu.Lock()
+	defer artifactRetentionMu.Unlock()
+	artifactRetention = policy
+	return nil
+}
+
+// ArtifactCleanupWorkflow deletes the execution reports, outputs and state
+// snapshots the retention policy no longer keeps. It is meant to run on a
+// cron schedule.
+func ArtifactCleanupWorkflow(ctx workflow.Context, input ArtifactCleanupInput) (ArtifactCleanupOutput, error) {
+	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: time.Hour,
+		HeartbeatTimeout:    time.Minute,
+		RetryPolicy: &temporal.RetryPolicy{
+			InitialInterval:        time.Minute,
+			MaximumAttempts:        3,
+			NonRetryableErrorTypes: []string{"NoRetentionPolicy"},
+		},
+	})
+
+	routes := input.Routes
+	if len(routes) == 0 {
+		// Routes are worker configuration, recorded so replays see them
+		if err := workflow.SideEffect(ctx, func(ctx workflow.Context) interface{} {
+			return cleanupRoutes()
+		}).Get(&routes); err != nil {
+			return ArtifactCleanupOutput{}, err
+		}
+	}
+
+	var output ArtifactCleanupOutput
+	for _, route := range routes {
+		var result tfworkspace.CleanupResult
+		err := workflow.ExecuteActivity(ctx, CleanupArtifactsActivity, CleanupBucketInput{
+			Bucket: route.Bucket,
+			Region: route.Region,
+		}).Get(ctx, &result)
+		if err != nil {
+			return output, fmt.Errorf("error cleaning up %s: %w", route.Bucket, err)
+		}
+
+		output.Deleted += len(result.Deleted)
+		output.BytesReclaimed += result.BytesReclaimed
+		output.Protected += result.Protected
+	}
+
+	workflow.GetLogger(ctx).Info("artifact cleanup finished", "Deleted", output.Deleted, "BytesReclaimed", output.BytesReclaimed, "Protected", output.Protected)
+	return output, nil
+}
+
+// CleanupArtifactsActivity applies the configured retention policy to a
+// bucket, keeping artifacts of runs whose workflow is still open
+func CleanupArtifactsActivity(ctx context.Context, input CleanupBucketInput) (tfworkspace.CleanupResult, error) {
+	artifactRetentionMu.RLock()
+	policy := artifactRetention
+	artifactRetentionMu.RUnlock()
+	if policy == nil {
+		return tfworkspace.CleanupResult{}, temporal.NewNonRetryableApplicationError("no artifact retention policy is configured", "NoRetentionPolicy", nil)
+	}
+
+	openWorkflows, err := openWorkflowIDs(ctx)
+	if err != nil {
+		return tfworkspace.CleanupResult{}, err
+	}
+
+	metrics := activity.GetMetricsHandler(ctx).WithTags(map[string]string{"bucket": input.Bucket})
+	var reported tfworkspace.CleanupResult
+	client := stateObjects(awsconfig.LoadConfig().Credentials, input.Region)
+
+	result, err := tfworkspace.CleanupArtifacts(ctx, client, input.Bucket, policy, openWorkflows, time.Now(), func(progress tfworkspace.CleanupResult) {
+		metrics.Counter("artifact_cleanup_deleted").Inc(int64(len(progress.Deleted) - len(reported.Deleted)))
+		metrics.Counter("artifact_cleanup_bytes_reclaimed").Inc(progress.BytesReclaimed - reported.BytesReclaimed)
+		reported = progress
+		activity.RecordHeartbeat(ctx, len(progress.Deleted))
+	})
+	metrics.Counter("artifact_cleanup_protected").Inc(int64(result.Protected))
+	return result, err
+}
+
+// openWorkflowIDs lists the IDs of every open workflow
+func openWorkflowIDs(ctx context.Context) (map[string]bool, error) {
+	if temporalClient == nil {
+		return nil, errors.New("no temporal client is configured")
+	}
+
+	ids := map[string]bool{}
+	var nextPageToken []byte
+	for {
+		resp, err := temporalClient.ListOpenWorkflow(ctx, &workflowservice.ListOpenWorkflowExecutionsRequest{
+			MaximumPageSize: 100,
+			NextPageToken:   nextPageToken,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error listing open workflows: %w", err)
+		}
+
+		for _, info := range resp.GetExecutions() {
+			ids[info.GetExecution().GetWorkflowId()] = true
+		}
+
+		nextPageToken = resp.GetNextPageToken()
+		if len(nextPageToken) == 0 {
+			return ids, nil
+		}
+	}
+}
+
+// cleanupRoutes returns the default route and every configured route,
+// once per bucket
+func cleanupRoutes() []StateRoute {
+	stateRoutesMu.RLock()
+	defer stateRoutesMu.RUnlock()
+
+	seen := map[string]bool{defaultStateRoute.Bucket: true}
+	routes := []StateRoute{defaultStateRoute}
+	for _, route := range stateRoutes {
+		if seen[route.Bucket] {
+			continue
+		}
+		seen[route.Bucket] = true
+		routes = append(routes, route)
+	}
+	return routes
+}
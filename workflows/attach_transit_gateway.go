@@ -0,0 +1,205 @@
+package workflows
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+
+	"github.com/dynajoe/temporal-terraform-demo/config/awsconfig"
+	"github.com/dynajoe/temporal-terraform-demo/s3object"
+	"github.com/dynajoe/temporal-terraform-demo/stacks"
+	"github.com/dynajoe/temporal-terraform-demo/tfactivity"
+	"github.com/dynajoe/temporal-terraform-demo/tfstate"
+	"github.com/dynajoe/temporal-terraform-demo/tfworkspace"
+)
+
+const (
+	// attachmentTimeout bounds the attachment's apply, retries and their
+	// backoff included
+	attachmentTimeout          = time.Hour
+	attachmentHeartbeatTimeout = time.Minute
+
+	// attachmentInterruptTimeout is how long terraform has to persist the
+	// state of an attempt that is canceled
+	attachmentInterruptTimeout = 2 * time.Minute
+
+	// attachmentLease bounds how long an attachment holds the transit
+	// gateway lock. An attempt still running at attachmentTimeout learns it
+	// was canceled with its next heartbeat and then interrupts terraform, the
+	// lease outlasts both so the next attachment can't start alongside it.
+	attachmentLease = attachmentTimeout + attachmentHeartbeatTimeout + attachmentInterruptTimeout + time.Minute
+)
+
+type (
+	// AttachTransitGatewayInput attaches a network created by
+	// CreateDemoNetworkWorkflow to an existing transit gateway
+	AttachTransitGatewayInput struct {
+		Network          string
+		Region           string
+		TransitGatewayID string
+	}
+
+	AttachTransitGatewayOutput struct {
+		Status       ResultStatus
+		AttachmentID string
+		Inventory    tfworkspace.Inventory
+	}
+
+	CreateAttachmentInput struct {
+		Network          string
+		Region           string
+		TransitGatewayID string
+		VpcID            string
+		SubnetIDs        []string
+	}
+)
+
+// AttachTransitGatewayWorkflow attaches a network to a transit gateway. The
+// gateway's route tables are shared by every attachment, so attachments to
+// the same gateway are applied one at a time.
+func AttachTransitGatewayWorkflow(ctx workflow.Context, input AttachTransitGatewayInput) (AttachTransitGatewayOutput, error) {
+	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: attachmentTimeout,
+		HeartbeatTimeout:    attachmentHeartbeatTimeout,
+		RetryPolicy: &temporal.RetryPolicy{
+			InitialInterval:    5 * time.Second,
+			BackoffCoefficient: 1.3,
+			MaximumInterval:    time.Minute,
+		},
+	})
+
+	if input.Network == "" || input.TransitGatewayID == "" {
+		return AttachTransitGatewayOutput{}, temporal.NewNonRetryableApplicationError("attachment needs a network and a transit gateway", "InvalidInput", nil)
+	}
+
+	status, err := trackStatus(ctx)
+	if err != nil {
+		return AttachTransitGatewayOutput{}, err
+	}
+
+	status.Phase = "checking change freeze"
+	if err := waitForThaw(ctx, status); err != nil {
+		return AttachTransitGatewayOutput{}, err
+	}
+
+	status.Phase = "resolving network"
+	var vpcID string
+	if err := workflow.ExecuteActivity(ctx, ResolveNetworkActivity, input.Network).Get(ctx, &vpcID); err != nil {
+		return AttachTransitGatewayOutput{}, err
+	}
+	var subnetIDs []string
+	if err := workflow.ExecuteActivity(ctx, ResolveNetworkSubnetsActivity, input.Network).Get(ctx, &subnetIDs); err != nil {
+		return AttachTransitGatewayOutput{}, err
+	}
+
+	status.Phase = "waiting for transit gateway lock"
+	unlock, err := lockResource(ctx, "tgw-"+input.TransitGatewayID, attachmentLease)
+	if err != nil {
+		return AttachTransitGatewayOutput{}, err
+	}
+	defer unlock()
+
+	status.Phase = "attaching"
+	attachCtx := workflow.WithScheduleToCloseTimeout(ctx, attachmentTimeout)
+	var output AttachTransitGatewayOutput
+	if err := workflow.ExecuteActivity(attachCtx, CreateAttachmentActivity, CreateAttachmentInput{
+		Network:          input.Network,
+		Region:           input.Region,
+		TransitGatewayID: input.TransitGatewayID,
+		VpcID:            vpcID,
+		SubnetIDs:        subnetIDs,
+	}).Get(ctx, &output); err != nil {
+		return AttachTransitGatewayOutput{}, err
+	}
+	if err := recordInventory(ctx, output.Inventory); err != nil {
+		return AttachTransitGatewayOutput{}, err
+	}
+
+	status.Phase = "completed"
+	return output, nil
+}
+
+// ResolveNetworkSubnetsActivity returns the subnet ids a network's subnet
+// stack output
+func ResolveNetworkSubnetsActivity(ctx context.Context, network string) ([]string, error) {
+	awsConfig := awsconfig.LoadConfig()
+
+	stack := StackRef{TerraformPath: "core:aws/subnet", StateKey: fmt.Sprintf("subnets-%s.tfstate", network)}
+	backend, err := StateBackend(ctx, awsConfig.Credentials, stack)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := tfstate.Load(ctx, backend)
+	if errors.Is(err, s3object.ErrNotFound) {
+		return nil, temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("network %s has no subnet state at %s", network, stack.StateKey), "NetworkNotFound", nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var subnetIDs []string
+	if err := state.OutputValue("subnet_ids", &subnetIDs); err != nil {
+		return nil, temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("network %s: %v", network, err), "NetworkNotFound", nil)
+	}
+	return subnetIDs, nil
+}
+
+func CreateAttachmentActivity(ctx context.Context, input CreateAttachmentInput) (AttachTransitGatewayOutput, error) {
+	awsConfig := awsconfig.LoadConfig()
+
+	stack := StackRef{
+		TerraformPath: "core:aws/tgw_attachment",
+		StateKey:      fmt.Sprintf("tgw-attachment-%s-%s.tfstate", input.TransitGatewayID, input.Network),
+	}
+	backend, err := StateBackend(ctx, awsConfig.Credentials, stack)
+	if err != nil {
+		return AttachTransitGatewayOutput{}, err
+	}
+
+	credentials, err := terraformCredentials(ctx, awsConfig, "")
+	if err != nil {
+		return AttachTransitGatewayOutput{}, err
+	}
+
+	tfa := tfactivity.New(tfworkspace.Config{
+		TerraformPath: stack.TerraformPath,
+		S3Backend:     backend,
+		Reports:       true,
+		Outputs:       stacks.TgwAttachmentOutputContract(),
+	})
+
+	applyOutput, err := tfa.Apply(ctx, tfworkspace.ApplyInput{
+		AwsCredentials: credentials,
+		Env: map[string]string{
+			"AWS_REGION": input.Region,
+		},
+		InterruptTimeout: attachmentInterruptTimeout,
+		Vars: stacks.TgwAttachmentVars{
+			Name:             input.Network,
+			TransitGatewayID: input.TransitGatewayID,
+			VpcID:            input.VpcID,
+			SubnetIDs:        input.SubnetIDs,
+		}.Vars(),
+	})
+	if err != nil {
+		return AttachTransitGatewayOutput{}, err
+	}
+
+	attachmentOutputs, err := stacks.DecodeTgwAttachmentOutputs(applyOutput)
+	if err != nil {
+		return AttachTransitGatewayOutput{}, err
+	}
+
+	return AttachTransitGatewayOutput{
+		Status:       appliedStatus(applyOutput.Changed),
+		AttachmentID: attachmentOutputs.AttachmentID,
+		Inventory:    applyOutput.Inventory,
+	}, nil
+}
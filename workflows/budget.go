@@ -0,0 +1,216 @@
+package workflows
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"sync"
+	"time"
+
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+
+	"github.com/dynajoe/temporal-terraform-demo/config/awsconfig"
+	"github.com/dynajoe/temporal-terraform-demo/s3object"
+	"github.com/dynajoe/temporal-terraform-demo/tfexec"
+	"github.com/dynajoe/temporal-terraform-demo/tfstate"
+)
+
+type (
+	// BudgetPolicy caps the estimated monthly cost of stacks. A plan that
+	// pushes a stack over its cap is only applied when the approval
+	// overrides the budget with a reason.
+	BudgetPolicy struct {
+		// ResourceCosts are monthly costs by resource type, types without
+		// one are free
+		ResourceCosts map[string]float64 `json:"resource_costs"`
+		Caps          []BudgetCap        `json:"caps"`
+	}
+
+	BudgetCap struct {
+		// StateKey is a glob of the state keys capped, the first cap
+		// matching a stack applies
+		StateKey    string  `json:"state_key"`
+		MonthlyCost float64 `json:"monthly_cost"`
+	}
+
+	EstimateStackCostsInput struct {
+		Policy BudgetPolicy
+		Stacks []CostEstimate
+	}
+
+	CostEstimate struct {
+		Stack   StackRef
+		Changes []tfexec.ResourceChange
+	}
+
+	// StackCost is the estimated monthly cost of a stack before and after
+	// its plan is applied
+	StackCost struct {
+		StateKey  string
+		Current   float64
+		Projected float64
+		Cap       float64
+	}
+)
+
+var (
+	budgetPolicyMu sync.RWMutex
+	budgetPolicy   BudgetPolicy
+)
+
+// LoadBudgetPolicy reads the stack budget caps from a JSON file
+func LoadBudgetPolicy(path string) (BudgetPolicy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return BudgetPolicy{}, err
+	}
+
+	var policy BudgetPolicy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return BudgetPolicy{}, fmt.Errorf("error decoding budget policy: %w", err)
+	}
+	return policy, nil
+}
+
+// ConfigureBudgetPolicy sets the budget caps checked by approval gates
+// reached from now on
+func ConfigureBudgetPolicy(policy BudgetPolicy) error {
+	for i, c := range policy.Caps {
+		if _, err := path.Match(c.StateKey, ""); err != nil || c.StateKey == "" {
+			return fmt.Errorf("budget cap %d has an invalid state key pattern %q", i, c.StateKey)
+		}
+		if c.MonthlyCost <= 0 {
+			return fmt.Errorf("budget cap %s needs a positive monthly cost", c.StateKey)
+		}
+	}
+
+	budgetPolicyMu.Lock()
+	defer budgetPolicyMu.Unlock()
+	budgetPolicy = policy
+	return nil
+}
+
+func currentBudgetPolicy() BudgetPolicy {
+	budgetPolicyMu.RLock()
+	defer budgetPolicyMu.RUnlock()
+	return budgetPolicy
+}
+
+// capFor returns the cap of the stack, zero if it isn't capped
+func (p BudgetPolicy) capFor(stateKey string) float64 {
+	for _, c := range p.Caps {
+		if ok, _ := path.Match(c.StateKey, stateKey); ok {
+			return c.MonthlyCost
+		}
+	}
+	return 0
+}
+
+// costDelta is how much the plan changes the monthly cost, replacements
+// are assumed to cost the same
+func (p BudgetPolicy) costDelta(changes []tfexec.ResourceChange) float64 {
+	var delta float64
+	for _, change := range changes {
+		creates, deletes := contains(change.Actions, "create"), contains(change.Actions, "delete")
+		switch {
+		case creates && !deletes:
+			delta += p.ResourceCosts[resourceType(change.Address)]
+		case deletes && !creates:
+			delta -= p.ResourceCosts[resourceType(change.Address)]
+		}
+	}
+	return delta
+}
+
+// checkBudget records on the status the stacks the plans push over their
+// budget cap. A cost that can't be estimated is treated as over budget.
+func checkBudget(ctx workflow.Context, status *Status, stacks []CostEstimate) error {
+	status.OverBudget = nil
+	if !hasChange(ctx, budgetCheckVersion) {
+		return nil
+	}
+
+	// The policy is worker configuration, recorded so replays see it
+	var policy BudgetPolicy
+	if err := workflow.SideEffect(ctx, func(workflow.Context) interface{} {
+		return currentBudgetPolicy()
+	}).Get(&policy); err != nil {
+		return err
+	}
+	if len(policy.Caps) == 0 {
+		return nil
+	}
+
+	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: 2 * time.Minute,
+		RetryPolicy: &temporal.RetryPolicy{
+			MaximumAttempts: 3,
+		},
+	})
+	var over []StackCost
+	if err := workflow.ExecuteActivity(ctx, EstimateStackCostsActivity, EstimateStackCostsInput{
+		Policy: policy,
+		Stacks: stacks,
+	}).Get(ctx, &over); err != nil {
+		workflow.GetLogger(ctx).Warn("Unable to estimate stack costs", "Error", err)
+		status.OverBudget = []string{fmt.Sprintf("unable to estimate cost: %v", err)}
+		return nil
+	}
+
+	for _, cost := range over {
+		status.OverBudget = append(status.OverBudget, fmt.Sprintf("%s: estimated %.2f/month, up from %.2f, exceeds cap %.2f",
+			cost.StateKey, cost.Projected, cost.Current, cost.Cap))
+	}
+	return nil
+}
+
+// EstimateStackCostsActivity returns the capped stacks the plans push over
+// their cap. The current cost is estimated from the managed resources in
+// the stack's state.
+func EstimateStackCostsActivity(ctx context.Context, input EstimateStackCostsInput) ([]StackCost, error) {
+	awsConfig := awsconfig.LoadConfig()
+
+	var over []StackCost
+	for _, stack := range input.Stacks {
+		limit := input.Policy.capFor(stack.Stack.StateKey)
+		if limit == 0 {
+			continue
+		}
+		delta := input.Policy.costDelta(stack.Changes)
+		if delta <= 0 {
+			continue
+		}
+
+		backend, err := StateBackend(ctx, awsConfig.Credentials, stack.Stack)
+		if err != nil {
+			return nil, err
+		}
+		var current float64
+		state, err := tfstate.Load(ctx, backend)
+		switch {
+		case errors.Is(err, s3object.ErrNotFound):
+		case err != nil:
+			return nil, fmt.Errorf("error reading state of %s: %w", stack.Stack.StateKey, err)
+		default:
+			for _, r := range state.Resources {
+				if r.Mode == "managed" {
+					current += input.Policy.ResourceCosts[r.Type] * float64(len(r.Instances))
+				}
+			}
+		}
+
+		if current+delta > limit {
+			over = append(over, StackCost{
+				StateKey:  stack.Stack.StateKey,
+				Current:   current,
+				Projected: current + delta,
+				Cap:       limit,
+			})
+		}
+	}
+	return over, nil
+}
@@ -0,0 +1,100 @@
+package workflows
+
+import (
+	"errors"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"go.temporal.io/sdk/temporal"
+
+	"github.com/dynajoe/temporal-terraform-demo/tfexec"
+)
+
+var testBudget = BudgetPolicy{
+	ResourceCosts: map[string]float64{"aws_nat_gateway": 35, "aws_vpc": 0},
+	Caps:          []BudgetCap{{StateKey: "network/*", MonthlyCost: 50}},
+}
+
+func (s *workflowTestSuite) TestBudgetOverrideRequired() {
+	s.Require().NoError(ConfigureBudgetPolicy(testBudget))
+	s.mockPlans(testChanges)
+	s.env.OnActivity(ApplyModuleActivity, mock.Anything, mock.Anything).Return(ModuleOutput{Status: StatusApplied}, nil).Once()
+	s.env.OnActivity(EstimateStackCostsActivity, mock.Anything, EstimateStackCostsInput{
+		Policy: testBudget,
+		Stacks: []CostEstimate{{Stack: testStack, Changes: testChanges}},
+	}).Return([]StackCost{{StateKey: testStack.StateKey, Current: 35, Projected: 70, Cap: 50}}, nil).Once()
+
+	// Approving without overriding the budget with a reason is ignored
+	s.signalAfter(time.Minute, ApprovalSignal, Approval{Approved: true, By: "alice"})
+	s.signalAfter(2*time.Minute, ApprovalSignal, Approval{Approved: true, By: "alice", OverrideBudget: true})
+	s.env.RegisterDelayedCallback(func() {
+		s.False(s.env.IsWorkflowCompleted())
+		s.Equal([]string{"network/vpc.tfstate: estimated 70.00/month, up from 35.00, exceeds cap 50.00"}, s.status().OverBudget)
+	}, 3*time.Minute)
+	s.signalAfter(time.Hour, ApprovalSignal, Approval{Approved: true, By: "alice", OverrideBudget: true, Reason: "temporary capacity"})
+
+	s.env.ExecuteWorkflow(UpdateVarAcrossStacksWorkflow, testUpdate)
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+}
+
+func (s *workflowTestSuite) TestBudgetRejectionNeedsNoOverride() {
+	s.Require().NoError(ConfigureBudgetPolicy(testBudget))
+	s.mockPlans(testChanges)
+	s.env.OnActivity(EstimateStackCostsActivity, mock.Anything, mock.Anything).
+		Return([]StackCost{{StateKey: testStack.StateKey, Current: 35, Projected: 70, Cap: 50}}, nil)
+	s.signalAfter(time.Minute, ApprovalSignal, Approval{Approved: false, By: "alice"})
+
+	s.env.ExecuteWorkflow(UpdateVarAcrossStacksWorkflow, testUpdate)
+
+	var appErr *temporal.ApplicationError
+	s.Require().True(errors.As(s.env.GetWorkflowError(), &appErr))
+	s.Equal("PlanRejected", appErr.Type())
+}
+
+func (s *workflowTestSuite) TestBudgetUnestimatedCostIsOverBudget() {
+	s.Require().NoError(ConfigureBudgetPolicy(testBudget))
+	s.mockPlans(testChanges)
+	s.env.OnActivity(ApplyModuleActivity, mock.Anything, mock.Anything).Return(ModuleOutput{Status: StatusApplied}, nil).Once()
+	s.env.OnActivity(EstimateStackCostsActivity, mock.Anything, mock.Anything).
+		Return(nil, temporal.NewNonRetryableApplicationError("state unreadable", "StateUnreadable", nil))
+
+	s.env.RegisterDelayedCallback(func() {
+		over := s.status().OverBudget
+		s.Require().Len(over, 1)
+		s.Contains(over[0], "unable to estimate cost")
+	}, time.Minute)
+	s.signalAfter(time.Hour, ApprovalSignal, Approval{Approved: true, By: "alice", OverrideBudget: true, Reason: "estimate later"})
+
+	s.env.ExecuteWorkflow(UpdateVarAcrossStacksWorkflow, testUpdate)
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+}
+
+func (s *workflowTestSuite) TestBudgetUncappedSkipsEstimate() {
+	s.mockPlans(testChanges)
+	s.env.OnActivity(ApplyModuleActivity, mock.Anything, mock.Anything).Return(ModuleOutput{Status: StatusApplied}, nil).Once()
+	s.signalAfter(time.Minute, ApprovalSignal, Approval{Approved: true, By: "alice"})
+
+	s.env.ExecuteWorkflow(UpdateVarAcrossStacksWorkflow, testUpdate)
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+	s.Empty(s.status().OverBudget)
+}
+
+func (s *workflowTestSuite) TestBudgetCostDelta() {
+	s.Equal(0.0, testBudget.costDelta([]tfexec.ResourceChange{
+		{Address: "aws_nat_gateway.a", Actions: []string{"delete", "create"}},
+		{Address: "aws_vpc.vpc", Actions: []string{"update"}},
+	}))
+	s.Equal(35.0, testBudget.costDelta([]tfexec.ResourceChange{
+		{Address: "aws_nat_gateway.a", Actions: []string{"create"}},
+		{Address: "module.nat.aws_nat_gateway.b", Actions: []string{"create"}},
+		{Address: "aws_nat_gateway.c", Actions: []string{"delete"}},
+	}))
+	s.Equal(50.0, testBudget.capFor("network/vpc.tfstate"))
+	s.Equal(0.0, testBudget.capFor("dns/zone.tfstate"))
+}
@@ -0,0 +1,167 @@
+package workflows
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+
+	"github.com/dynajoe/temporal-terraform-demo/config/awsconfig"
+	"github.com/dynajoe/temporal-terraform-demo/tfworkspace"
+)
+
+type (
+	CloneStackInput struct {
+		// Source identifies the stack to copy
+		Source StackRef
+
+		// StateKey is where the new stack's state is stored
+		StateKey string
+
+		Region  string
+		RoleARN string
+
+		// Vars override the vars recorded for the source stack, e.g. to give
+		// the copy its own name
+		Vars map[string]interface{}
+
+		// TimeoutProfile and Timeouts override the activity timeouts of the
+		// stack's allowed stack definition for the apply
+		TimeoutProfile string
+		Timeouts       *ActivityTimeouts
+	}
+
+	CloneStackOutput struct {
+		Status        ResultStatus
+		TerraformPath string
+		Output        map[string]interface{}
+	}
+
+	// StackRecord is what was last applied to a stack
+	StackRecord struct {
+		TerraformPath string
+		Vars          map[string]interface{}
+		RedactedVars  []string
+	}
+)
+
+// CloneStackWorkflow provisions an independent copy of an existing stack
+// using the vars it was last applied with
+func CloneStackWorkflow(ctx workflow.Context, input CloneStackInput) (CloneStackOutput, error) {
+	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: time.Hour,
+		HeartbeatTimeout:    time.Minute,
+		RetryPolicy: &temporal.RetryPolicy{
+			InitialInterval:    5 * time.Second,
+			BackoffCoefficient: 1.3,
+			MaximumInterval:    time.Minute,
+		},
+	})
+
+	if input.StateKey == "" || input.StateKey == input.Source.StateKey {
+		return CloneStackOutput{}, temporal.NewNonRetryableApplicationError("clone needs its own state key", "InvalidInput", nil)
+	}
+
+	status, err := trackStatus(ctx)
+	if err != nil {
+		return CloneStackOutput{}, err
+	}
+
+	status.Phase = "loading source stack"
+	var source StackRecord
+	if err := workflow.ExecuteActivity(ctx, LoadStackRecordActivity, input.Source).Get(ctx, &source); err != nil {
+		return CloneStackOutput{}, err
+	}
+
+	vars, err := source.withVars(input.Vars)
+	if err != nil {
+		return CloneStackOutput{}, err
+	}
+
+	status.Phase = "checking change freeze"
+	if err := waitForThaw(ctx, status); err != nil {
+		return CloneStackOutput{}, err
+	}
+
+	applyCtx, err := withStackTimeouts(ctx, source.TerraformPath, input.TimeoutProfile, input.Timeouts)
+	if err != nil {
+		return CloneStackOutput{}, err
+	}
+
+	status.Phase = "applying clone"
+	var output ModuleOutput
+	if err := workflow.ExecuteActivity(applyCtx, ApplyModuleActivity, ModuleInput{
+		TerraformPath: source.TerraformPath,
+		StateKey:      input.StateKey,
+		Region:        input.Region,
+		RoleARN:       input.RoleARN,
+		Vars:          vars,
+	}).Get(applyCtx, &output); err != nil {
+		return CloneStackOutput{}, err
+	}
+	if err := recordInventory(ctx, output.Inventory); err != nil {
+		return CloneStackOutput{}, err
+	}
+
+	status.Phase = "completed"
+	return CloneStackOutput{
+		Status:        output.Status,
+		TerraformPath: source.TerraformPath,
+		Output:        output.Output,
+	}, nil
+}
+
+// withVars merges overrides into the recorded vars. Secrets aren't recorded,
+// so they have to be provided again.
+func (r StackRecord) withVars(overrides map[string]interface{}) (map[string]interface{}, error) {
+	vars := make(map[string]interface{}, len(r.Vars)+len(overrides))
+	for k, v := range r.Vars {
+		vars[k] = v
+	}
+	for k, v := range overrides {
+		vars[k] = v
+	}
+
+	var missing []string
+	for _, name := range r.RedactedVars {
+		if _, ok := overrides[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("vars [%s] were not recorded and must be provided", strings.Join(missing, ", ")), "InvalidInput", nil)
+	}
+	return vars, nil
+}
+
+// LoadStackRecordActivity finds the module and vars a stack was last
+// successfully applied with
+func LoadStackRecordActivity(ctx context.Context, stack StackRef) (StackRecord, error) {
+	awsConfig := awsconfig.LoadConfig()
+
+	backend, err := StateBackend(ctx, awsConfig.Credentials, stack)
+	if err != nil {
+		return StackRecord{}, err
+	}
+
+	history, err := tfworkspace.History(ctx, backend)
+	if err != nil {
+		return StackRecord{}, err
+	}
+
+	report, ok := tfworkspace.LastApplied(history)
+	if !ok {
+		return StackRecord{}, temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("no successful apply recorded for %s", stack.StateKey), "StackNotFound", nil)
+	}
+
+	return StackRecord{
+		TerraformPath: report.TerraformPath,
+		Vars:          report.Vars,
+		RedactedVars:  report.RedactedVars(),
+	}, nil
+}
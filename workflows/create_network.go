@@ -2,7 +2,10 @@ package workflows
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"reflect"
+	"sort"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -12,9 +15,11 @@ import (
 	"go.temporal.io/sdk/workflow"
 
 	"github.com/dynajoe/temporal-terraform-demo/config/awsconfig"
-	"github.com/dynajoe/temporal-terraform-demo/terraform"
+	"github.com/dynajoe/temporal-terraform-demo/s3object"
+	"github.com/dynajoe/temporal-terraform-demo/stacks"
 	"github.com/dynajoe/temporal-terraform-demo/tfactivity"
 	"github.com/dynajoe/temporal-terraform-demo/tfexec"
+	"github.com/dynajoe/temporal-terraform-demo/tfstate"
 	"github.com/dynajoe/temporal-terraform-demo/tfworkspace"
 )
 
@@ -24,10 +29,15 @@ type (
 		Region    string
 		CIDRBlock string
 		Subnets   []Subnet
+
+		// RequireApproval plans the VPC and waits for ApprovalSignal before
+		// applying anything
+		RequireApproval bool
 	}
 
 	CreateDemoNetworkOutput struct {
-		VpcID string
+		Status ResultStatus
+		VpcID  string
 	}
 
 	Subnet struct {
@@ -39,10 +49,16 @@ type (
 		Name      string
 		Region    string
 		CIDRBlock string
+
+		// Reviewed is the approved plan, the apply fails with a StaleState
+		// error if the state or the resources to import changed since
+		Reviewed *NetworkPlan
 	}
 
 	CreateVPCOutput struct {
-		VpcID string
+		Status    ResultStatus
+		VpcID     string
+		Inventory tfworkspace.Inventory
 	}
 
 	CreateSubnetsInput struct {
@@ -50,12 +66,25 @@ type (
 		Region  string
 		VpcID   string
 		Subnets []Subnet
+
+		// Reviewed, see CreateVPCInput
+		Reviewed *NetworkPlan
+	}
+
+	CreateSubnetsOutput struct {
+		Status    ResultStatus
+		Inventory tfworkspace.Inventory
 	}
 
-	CreateSubnetsOutput struct{}
+	// NetworkPlan is the plan of a network stack along with the resources
+	// outside of its state the apply imports first, by address
+	NetworkPlan struct {
+		tfworkspace.PlanOutput
+		Imports map[string]string
+	}
 )
 
-func CreateDemoNetworkWorkflow(ctx workflow.Context, input CreateDemoNetworkInput) (CreateDemoNetworkOutput, error) {
+func CreateDemoNetworkWorkflow(ctx workflow.Context, input CreateDemoNetworkInput) (_ CreateDemoNetworkOutput, err error) {
 	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
 		StartToCloseTimeout: time.Hour,
 		HeartbeatTimeout:    time.Minute,
@@ -66,133 +95,393 @@ func CreateDemoNetworkWorkflow(ctx workflow.Context, input CreateDemoNetworkInpu
 		},
 	})
 
+	status, err := trackStatus(ctx)
+	if err != nil {
+		return CreateDemoNetworkOutput{}, err
+	}
+	trackPause(ctx, status)
+	defer func() { recordOutcome(ctx, status, err, networkStacks(input.Name)...) }()
+
+	// Hold changes while a change freeze is in effect
+	status.Phase = "checking change freeze"
+	if err := waitForThaw(ctx, status); err != nil {
+		return CreateDemoNetworkOutput{}, err
+	}
+
+	vpcInput := CreateVPCInput{Name: input.Name, Region: input.Region, CIDRBlock: input.CIDRBlock}
+	if input.RequireApproval && hasChange(ctx, networkApprovalVersion) {
+		status.Phase = "planning vpc"
+		if err := pausePoint(ctx, status); err != nil {
+			return CreateDemoNetworkOutput{}, err
+		}
+		var plan NetworkPlan
+		if err := workflow.ExecuteActivity(ctx, PlanVPCActivity, vpcInput).Get(ctx, &plan); err != nil {
+			return CreateDemoNetworkOutput{}, err
+		}
+		if err := reviewNetworkPlan(ctx, status, networkStacks(input.Name)[0], plan); err != nil {
+			return CreateDemoNetworkOutput{}, err
+		}
+		vpcInput.Reviewed = &plan
+	}
+
 	// Create the VPC
+	status.Phase = "creating vpc"
+	if err := pausePoint(ctx, status); err != nil {
+		return CreateDemoNetworkOutput{}, err
+	}
 	var vpcOutput CreateVPCOutput
-	if err := workflow.ExecuteActivity(ctx, CreateVPCActivity, input).Get(ctx, &vpcOutput); err != nil {
+	if err := workflow.ExecuteActivity(ctx, CreateVPCActivity, vpcInput).Get(ctx, &vpcOutput); err != nil {
 		return CreateDemoNetworkOutput{}, err
 	}
 
-	// Create subnets
-	var subnetOutput CreateSubnetsOutput
-	if err := workflow.ExecuteActivity(ctx, CreateSubnetsActivity, CreateSubnetsInput{
+	// The subnets can only be planned once the VPC exists
+	subnetsInput := CreateSubnetsInput{
 		Name:    input.Name,
 		VpcID:   vpcOutput.VpcID,
 		Region:  input.Region,
 		Subnets: input.Subnets,
-	}).Get(ctx, &subnetOutput); err != nil {
+	}
+	if input.RequireApproval && hasChange(ctx, subnetApprovalVersion) {
+		status.Phase = "planning subnets"
+		if err := pausePoint(ctx, status); err != nil {
+			return CreateDemoNetworkOutput{}, err
+		}
+		var plan NetworkPlan
+		if err := workflow.ExecuteActivity(ctx, PlanSubnetsActivity, subnetsInput).Get(ctx, &plan); err != nil {
+			return CreateDemoNetworkOutput{}, err
+		}
+		if err := reviewNetworkPlan(ctx, status, networkStacks(input.Name)[1], plan); err != nil {
+			return CreateDemoNetworkOutput{}, err
+		}
+		subnetsInput.Reviewed = &plan
+	}
+
+	// A freeze may have started while the VPC was being created
+	status.Phase = "checking change freeze"
+	if err := waitForThaw(ctx, status); err != nil {
+		return CreateDemoNetworkOutput{}, err
+	}
+
+	// Create subnets
+	status.Phase = "creating subnets"
+	if err := pausePoint(ctx, status); err != nil {
+		return CreateDemoNetworkOutput{}, err
+	}
+	var subnetOutput CreateSubnetsOutput
+	if err := workflow.ExecuteActivity(ctx, CreateSubnetsActivity, subnetsInput).Get(ctx, &subnetOutput); err != nil {
+		return CreateDemoNetworkOutput{}, err
+	}
+	if err := recordInventory(ctx, vpcOutput.Inventory, subnetOutput.Inventory); err != nil {
 		return CreateDemoNetworkOutput{}, err
 	}
 
+	status.Phase = "completed"
 	return CreateDemoNetworkOutput{
-		VpcID: vpcOutput.VpcID,
+		Status: vpcOutput.Status.combine(subnetOutput.Status),
+		VpcID:  vpcOutput.VpcID,
 	}, nil
 }
 
 func CreateVPCActivity(ctx context.Context, input CreateVPCInput) (CreateVPCOutput, error) {
 	awsConfig := awsconfig.LoadConfig()
 
-	attemptImport := make(map[string]string)
+	credentials, err := terraformCredentials(ctx, awsConfig, "")
+	if err != nil {
+		return CreateVPCOutput{}, err
+	}
 
-	// Lookup vpc by name for import
-	foundVpc, err := findVpcByName(ctx, awsConfig, input.Name)
+	stack := networkStacks(input.Name)[0]
+	backend, err := StateBackend(ctx, awsConfig.Credentials, stack)
 	if err != nil {
 		return CreateVPCOutput{}, err
 	}
-	if foundVpc.VpcId != nil {
-		attemptImport["aws_vpc.vpc"] = *foundVpc.VpcId
+
+	attemptImport, err := vpcImports(ctx, awsConfig, credentials, backend, input.Name)
+	if err != nil {
+		return CreateVPCOutput{}, err
 	}
 
 	// Temporal activity aware Terraform workspace wrapper
 	tfa := tfactivity.New(tfworkspace.Config{
-		TerraformPath: "aws/vpc",
-		TerraformFS:   terraform.FS,
-		S3Backend: tfexec.S3BackendConfig{
-			Credentials: awsConfig.Credentials,
-			Region:      "us-west-2",
-			Bucket:      "temporal-terraform-demo-state",
-			Key:         fmt.Sprintf("vpc-%s.tfstate", input.Name),
-		},
+		TerraformPath: stack.TerraformPath,
+		S3Backend:     backend,
+		Reports:       true,
+		Outputs:       stacks.VpcOutputContract(),
 	})
 
 	// Apply Terraform
-	applyOutput, err := tfa.Apply(ctx, tfworkspace.ApplyInput{
+	applyInput := tfworkspace.ApplyInput{
 		AttemptImport:  attemptImport,
-		AwsCredentials: awsConfig.Credentials,
+		AwsCredentials: credentials,
 		Env: map[string]string{
 			"AWS_REGION": input.Region,
 		},
-		Vars: map[string]interface{}{
-			"cidr_block": input.CIDRBlock,
-			"name":       input.Name,
-		},
-	})
+		Vars: stacks.VpcVars{
+			CIDRBlock: input.CIDRBlock,
+			Name:      input.Name,
+		}.Vars(),
+	}
+	if err := input.Reviewed.bind(&applyInput); err != nil {
+		return CreateVPCOutput{}, err
+	}
+	applyOutput, err := tfa.Apply(ctx, applyInput)
 	if err != nil {
 		return CreateVPCOutput{}, err
 	}
 
 	// Extract output from Terraform
-	vpcID, err := applyOutput.String("vpc_id")
+	vpcOutputs, err := stacks.DecodeVpcOutputs(applyOutput)
 	if err != nil {
 		return CreateVPCOutput{}, err
 	}
 
 	return CreateVPCOutput{
-		VpcID: vpcID,
+		Status:    appliedStatus(applyOutput.Changed),
+		VpcID:     vpcOutputs.VpcID,
+		Inventory: applyOutput.Inventory,
 	}, nil
 }
 
-func CreateSubnetsActivity(ctx context.Context, input CreateSubnetsInput) (CreateSubnetsOutput, error) {
+// PlanVPCActivity returns the changes CreateVPCActivity would make and the
+// resources it would import first. The changes are planned without the
+// imports, which would write the state.
+func PlanVPCActivity(ctx context.Context, input CreateVPCInput) (NetworkPlan, error) {
 	awsConfig := awsconfig.LoadConfig()
 
-	attemptImport := make(map[string]string)
+	stack := networkStacks(input.Name)[0]
+	backend, err := StateBackend(ctx, awsConfig.Credentials, stack)
+	if err != nil {
+		return NetworkPlan{}, err
+	}
 
-	// Fetch existing subnets for import
-	existingSubnets, err := listSubnets(ctx, awsConfig, input.VpcID)
+	credentials, err := planCredentials(ctx, awsConfig, "", "")
 	if err != nil {
-		return CreateSubnetsOutput{}, err
+		return NetworkPlan{}, err
 	}
-	for _, s := range existingSubnets {
-		key := fmt.Sprintf(`aws_subnet.subnet["%s"]`, *s.AvailabilityZone)
-		attemptImport[key] = *s.SubnetId
+
+	imports, err := vpcImports(ctx, awsConfig, credentials, backend, input.Name)
+	if err != nil {
+		return NetworkPlan{}, err
 	}
 
-	// Temporal activity aware Terraform workspace wrapper
 	tfa := tfactivity.New(tfworkspace.Config{
-		TerraformPath: "aws/subnet",
-		TerraformFS:   terraform.FS,
-		S3Backend: tfexec.S3BackendConfig{
-			Credentials: awsConfig.Credentials,
-			Region:      "us-west-2",
-			Bucket:      "temporal-terraform-demo-state",
-			Key:         fmt.Sprintf("subnets-%s.tfstate", input.Name),
+		TerraformPath: stack.TerraformPath,
+		S3Backend:     backend,
+	})
+
+	plan, err := tfa.Plan(ctx, tfworkspace.PlanInput{
+		AwsCredentials: credentials,
+		Env: map[string]string{
+			"AWS_REGION": input.Region,
 		},
+		Vars: stacks.VpcVars{
+			CIDRBlock: input.CIDRBlock,
+			Name:      input.Name,
+		}.Vars(),
 	})
+	return NetworkPlan{PlanOutput: plan, Imports: imports}, err
+}
 
-	var subnets []map[string]string
-	for _, s := range input.Subnets {
-		subnets = append(subnets, map[string]string{
-			"cidr_block":        s.CIDRBlock,
-			"name":              fmt.Sprintf("%s-%s", input.Name, s.AvailabilityZone),
-			"availability_zone": input.Region + s.AvailabilityZone,
-		})
+// PlanSubnetsActivity returns the changes CreateSubnetsActivity would make
+// and the resources it would import first, see PlanVPCActivity
+func PlanSubnetsActivity(ctx context.Context, input CreateSubnetsInput) (NetworkPlan, error) {
+	awsConfig := awsconfig.LoadConfig()
+
+	stack := networkStacks(input.Name)[1]
+	backend, err := StateBackend(ctx, awsConfig.Credentials, stack)
+	if err != nil {
+		return NetworkPlan{}, err
+	}
+
+	credentials, err := planCredentials(ctx, awsConfig, "", "")
+	if err != nil {
+		return NetworkPlan{}, err
+	}
+
+	imports, err := subnetImports(ctx, awsConfig, credentials, backend, input.VpcID)
+	if err != nil {
+		return NetworkPlan{}, err
+	}
+
+	tfa := tfactivity.New(tfworkspace.Config{
+		TerraformPath: stack.TerraformPath,
+		S3Backend:     backend,
+	})
+
+	plan, err := tfa.Plan(ctx, tfworkspace.PlanInput{
+		AwsCredentials: credentials,
+		Env: map[string]string{
+			"AWS_REGION": input.Region,
+		},
+		Vars: subnetVars(input),
+	})
+	return NetworkPlan{PlanOutput: plan, Imports: imports}, err
+}
+
+func CreateSubnetsActivity(ctx context.Context, input CreateSubnetsInput) (CreateSubnetsOutput, error) {
+	awsConfig := awsconfig.LoadConfig()
+
+	credentials, err := terraformCredentials(ctx, awsConfig, "")
+	if err != nil {
+		return CreateSubnetsOutput{}, err
+	}
+
+	stack := networkStacks(input.Name)[1]
+	backend, err := StateBackend(ctx, awsConfig.Credentials, stack)
+	if err != nil {
+		return CreateSubnetsOutput{}, err
+	}
+
+	attemptImport, err := subnetImports(ctx, awsConfig, credentials, backend, input.VpcID)
+	if err != nil {
+		return CreateSubnetsOutput{}, err
 	}
 
+	// Temporal activity aware Terraform workspace wrapper
+	tfa := tfactivity.New(tfworkspace.Config{
+		TerraformPath: stack.TerraformPath,
+		S3Backend:     backend,
+		Reports:       true,
+		Outputs:       stacks.SubnetOutputContract(),
+	})
+
 	// Apply Terraform to create subnets
-	if _, err := tfa.Apply(ctx, tfworkspace.ApplyInput{
-		AwsCredentials: awsConfig.Credentials,
+	applyInput := tfworkspace.ApplyInput{
+		AwsCredentials: credentials,
 		AttemptImport:  attemptImport,
 		Env: map[string]string{
 			"AWS_REGION": input.Region,
 		},
-		Vars: map[string]interface{}{
-			"vpc_id":  input.VpcID,
-			"subnets": subnets,
-		},
-	}); err != nil {
+		Vars: subnetVars(input),
+	}
+	if err := input.Reviewed.bind(&applyInput); err != nil {
+		return CreateSubnetsOutput{}, err
+	}
+	applyOutput, err := tfa.Apply(ctx, applyInput)
+	if err != nil {
 		return CreateSubnetsOutput{}, err
 	}
 
-	return CreateSubnetsOutput{}, nil
+	return CreateSubnetsOutput{
+		Status:    appliedStatus(applyOutput.Changed),
+		Inventory: applyOutput.Inventory,
+	}, nil
+}
+
+// subnetVars are the vars of the subnets stack, subnets are named after the
+// network and their availability zone
+func subnetVars(input CreateSubnetsInput) map[string]interface{} {
+	var subnets []stacks.SubnetSubnet
+	for _, s := range input.Subnets {
+		subnets = append(subnets, stacks.SubnetSubnet{
+			CIDRBlock:        s.CIDRBlock,
+			Name:             fmt.Sprintf("%s-%s", input.Name, s.AvailabilityZone),
+			AvailabilityZone: input.Region + s.AvailabilityZone,
+		})
+	}
+	return stacks.SubnetVars{
+		VpcID:   input.VpcID,
+		Subnets: subnets,
+	}.Vars()
+}
+
+// reviewNetworkPlan annotates the plan of a network stack, checks it against
+// the budget and waits for its approval
+func reviewNetworkPlan(ctx workflow.Context, status *Status, stack StackRef, plan NetworkPlan) error {
+	annotatePlan(ctx, status, []PlannedStack{{
+		TerraformPath: stack.TerraformPath,
+		StateKey:      stack.StateKey,
+		Changes:       plan.Changes,
+	}})
+	if err := checkBudget(ctx, status, []CostEstimate{{Stack: stack, Changes: plan.Changes}}); err != nil {
+		return err
+	}
+	return awaitApproval(ctx, status, plan.summary())
+}
+
+// summary lists the imports ahead of the changes
+func (p NetworkPlan) summary() []string {
+	addresses := make([]string, 0, len(p.Imports))
+	for address := range p.Imports {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+
+	summary := make([]string, 0, len(addresses)+len(p.Changes))
+	for _, address := range addresses {
+		summary = append(summary, fmt.Sprintf("import %s (%s)", address, p.Imports[address]))
+	}
+	return append(summary, planSummary(p.Changes)...)
+}
+
+// bind makes the apply the reviewed plan. The saved plan is only applied
+// when there is nothing to import, otherwise the apply imports and plans
+// again, still failing if the state changed since the review.
+func (p *NetworkPlan) bind(input *tfworkspace.ApplyInput) error {
+	if p == nil {
+		return nil
+	}
+	if !reflect.DeepEqual(p.Imports, input.AttemptImport) && (len(p.Imports) > 0 || len(input.AttemptImport) > 0) {
+		return temporal.NewNonRetryableApplicationError("the resources to import changed since the plan was reviewed, replan before applying", "StaleState", nil)
+	}
+
+	serial := p.StateSerial
+	input.PlannedSerial = &serial
+	input.PlannedVersions = p.Versions
+	if len(input.AttemptImport) == 0 {
+		input.PlanRef = p.PlanRef
+	}
+	return nil
+}
+
+// vpcImports returns the VPC named name if it exists outside of the state,
+// looked up in the account terraform manages
+func vpcImports(ctx context.Context, awsConfig aws.Config, credentials aws.CredentialsProvider, backend tfexec.S3BackendConfig, name string) (map[string]string, error) {
+	awsConfig.Credentials = credentials
+	foundVpc, err := findVpcByName(ctx, awsConfig, name)
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]string)
+	if foundVpc.VpcId != nil {
+		found["aws_vpc.vpc"] = *foundVpc.VpcId
+	}
+	return unmanaged(ctx, backend, found)
+}
+
+// subnetImports returns the subnets of the VPC that exist outside of the
+// state, see vpcImports
+func subnetImports(ctx context.Context, awsConfig aws.Config, credentials aws.CredentialsProvider, backend tfexec.S3BackendConfig, vpcID string) (map[string]string, error) {
+	awsConfig.Credentials = credentials
+	existingSubnets, err := listSubnets(ctx, awsConfig, vpcID)
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]string)
+	for _, s := range existingSubnets {
+		key := fmt.Sprintf(`aws_subnet.subnet["%s"]`, *s.AvailabilityZone)
+		found[key] = *s.SubnetId
+	}
+	return unmanaged(ctx, backend, found)
+}
+
+// unmanaged drops the resources already in the state from found
+func unmanaged(ctx context.Context, backend tfexec.S3BackendConfig, found map[string]string) (map[string]string, error) {
+	state, err := tfstate.Load(ctx, backend)
+	if errors.Is(err, s3object.ErrNotFound) {
+		return found, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading state: %w", err)
+	}
+	for _, r := range state.Resources {
+		for _, i := range r.Instances {
+			delete(found, r.InstanceAddress(i))
+		}
+	}
+	return found, nil
 }
 
 func listSubnets(ctx context.Context, awsConfig aws.Config, vpcID string) ([]types.Subnet, error) {
@@ -232,3 +521,11 @@ func findVpcByName(ctx context.Context, awsConfig aws.Config, name string) (type
 	}
 	return vpcOutput.Vpcs[0], nil
 }
+
+// networkStacks are the stacks making up a demo network
+func networkStacks(name string) []StackRef {
+	return []StackRef{
+		{TerraformPath: "core:aws/vpc", StateKey: fmt.Sprintf("vpc-%s.tfstate", name)},
+		{TerraformPath: "core:aws/subnet", StateKey: fmt.Sprintf("subnets-%s.tfstate", name)},
+	}
+}
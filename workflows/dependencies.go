@@ -0,0 +1,121 @@
+package workflows
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+
+	"github.com/dynajoe/temporal-terraform-demo/config/awsconfig"
+)
+
+// DependencyReadySignal wakes a workflow waiting on other stacks to check
+// them again right away, e.g. sent once a dependency's apply completes
+const DependencyReadySignal = "dependency-ready"
+
+const (
+	dependencyCheckInterval    = 10 * time.Second
+	maxDependencyCheckInterval = 5 * time.Minute
+)
+
+// waitForDependencies blocks until the stacks the refs point at have been
+// applied with the referenced outputs. Checks back off up to
+// maxDependencyCheckInterval and fail with a DependencyTimeout error once
+// timeout has passed.
+func waitForDependencies(ctx workflow.Context, status *Status, refs []StackOutputRef, timeout time.Duration) error {
+	deadline := workflow.Now(ctx).Add(timeout)
+	interval := dependencyCheckInterval
+	ready := workflow.GetSignalChannel(ctx, DependencyReadySignal)
+
+	for {
+		futures := make([]workflow.Future, len(refs))
+		for i, ref := range refs {
+			futures[i] = workflow.ExecuteActivity(ctx, StackOutputReadyActivity, ref)
+		}
+		var missing []string
+		for i, future := range futures {
+			var ok bool
+			if err := future.Get(ctx, &ok); err != nil {
+				return err
+			}
+			if !ok {
+				missing = append(missing, refs[i].Stack.StateKey+":"+refs[i].Output)
+			}
+		}
+		status.WaitingFor = missing
+		if len(missing) == 0 {
+			return nil
+		}
+
+		remaining := deadline.Sub(workflow.Now(ctx))
+		if remaining <= 0 {
+			return temporal.NewNonRetryableApplicationError(
+				fmt.Sprintf("dependencies not applied after %s: %s", timeout, strings.Join(missing, ", ")), "DependencyTimeout", nil)
+		}
+		if interval > remaining {
+			interval = remaining
+		}
+
+		timerCtx, cancelTimer := workflow.WithCancel(ctx)
+		selector := workflow.NewSelector(ctx)
+		selector.AddFuture(workflow.NewTimer(timerCtx, interval), func(workflow.Future) {})
+		selector.AddReceive(ready, func(c workflow.ReceiveChannel, more bool) {
+			c.Receive(ctx, nil)
+		})
+		selector.Select(ctx)
+		cancelTimer()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		interval *= 2
+		if interval > maxDependencyCheckInterval {
+			interval = maxDependencyCheckInterval
+		}
+	}
+}
+
+// waitForVarRefs waits for the stacks the input's VarRefs point at, if it
+// has a WaitForRefs
+func waitForVarRefs(ctx workflow.Context, status *Status, input ModuleInput) error {
+	if len(input.VarRefs) == 0 || input.WaitForRefs <= 0 {
+		return nil
+	}
+	return waitForDependencies(ctx, status, input.varRefs(), input.WaitForRefs)
+}
+
+// varRefs returns the input's references in a stable order
+func (input ModuleInput) varRefs() []StackOutputRef {
+	names := make([]string, 0, len(input.VarRefs))
+	for name := range input.VarRefs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	refs := make([]StackOutputRef, len(names))
+	for i, name := range names {
+		refs[i] = input.VarRefs[name]
+	}
+	return refs
+}
+
+// StackOutputReadyActivity reports whether the referenced stack has been
+// applied with the referenced output
+func StackOutputReadyActivity(ctx context.Context, ref StackOutputRef) (bool, error) {
+	awsConfig := awsconfig.LoadConfig()
+
+	_, err := resolveStackOutput(ctx, awsConfig, ref)
+	var appErr *temporal.ApplicationError
+	if errors.As(err, &appErr) && appErr.Type() == "UnresolvedReference" {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,41 @@
+package workflows
+
+import (
+	"context"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+)
+
+func (s *workflowTestSuite) TestValidateModuleWaitsForVarRefs() {
+	vpc := StackOutputRef{Stack: StackRef{TerraformPath: "core:aws/vpc", StateKey: "vpc-demo.tfstate"}, Output: "vpc_id"}
+	s.env.OnActivity(CheckChangeFreezeActivity, mock.Anything).Return(ChangeFreeze{}, nil)
+	s.env.OnActivity(StackOutputReadyActivity, mock.Anything, vpc).Return(false, nil).Once()
+	s.env.OnActivity(StackOutputReadyActivity, mock.Anything, vpc).Return(true, nil).Once()
+
+	applied := false
+	s.env.OnActivity(ApplyModuleActivity, mock.Anything, mock.MatchedBy(func(input ModuleInput) bool {
+		return input.VarRefs["vpc_id"] == vpc
+	})).Return(func(ctx context.Context, input ModuleInput) (ModuleOutput, error) {
+		applied = true
+		return ModuleOutput{Status: StatusApplied}, nil
+	}).Once()
+	s.env.OnActivity(DestroyModuleActivity, mock.Anything, mock.Anything).Return(nil).Once()
+
+	s.env.RegisterDelayedCallback(func() {
+		s.False(applied)
+		s.Equal([]string{"vpc-demo.tfstate:vpc_id"}, s.status().WaitingFor)
+	}, 5*time.Second)
+
+	s.env.ExecuteWorkflow(ValidateModuleWorkflow, ValidateModuleInput{
+		TerraformPath: "core:aws/route53_zone",
+		Region:        "us-east-1",
+		Vars:          map[string]interface{}{"zone_name": "example.com"},
+		VarRefs:       map[string]StackOutputRef{"vpc_id": vpc},
+		WaitForRefs:   time.Hour,
+	})
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+	s.True(applied)
+}
@@ -9,9 +9,7 @@ import (
 	"go.temporal.io/sdk/workflow"
 
 	"github.com/dynajoe/temporal-terraform-demo/config/awsconfig"
-	"github.com/dynajoe/temporal-terraform-demo/terraform"
 	"github.com/dynajoe/temporal-terraform-demo/tfactivity"
-	"github.com/dynajoe/temporal-terraform-demo/tfexec"
 	"github.com/dynajoe/temporal-terraform-demo/tfworkspace"
 )
 
@@ -20,7 +18,7 @@ type DestroyDemoNetworkInput struct {
 	Region string
 }
 
-func DestroyDemoNetworkWorkflow(ctx workflow.Context, input DestroyDemoNetworkInput) error {
+func DestroyDemoNetworkWorkflow(ctx workflow.Context, input DestroyDemoNetworkInput) (err error) {
 	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
 		StartToCloseTimeout: time.Hour,
 		HeartbeatTimeout:    time.Minute,
@@ -31,33 +29,67 @@ func DestroyDemoNetworkWorkflow(ctx workflow.Context, input DestroyDemoNetworkIn
 		},
 	})
 
+	status, err := trackStatus(ctx)
+	if err != nil {
+		return err
+	}
+	trackPause(ctx, status)
+	defer func() { recordOutcome(ctx, status, err, networkStacks(input.Name)...) }()
+
+	status.Phase = "checking change freeze"
+	if err := refuseIfFrozen(ctx); err != nil {
+		return err
+	}
+
+	if hasChange(ctx, peeringCheckVersion) {
+		status.Phase = "checking peerings"
+		if err := refuseIfPeered(ctx, input.Name); err != nil {
+			return err
+		}
+	}
+
+	status.Phase = "destroying subnets"
+	if err := pausePoint(ctx, status); err != nil {
+		return err
+	}
 	if err := workflow.ExecuteActivity(ctx, DestroySubnetsActivity, input).Get(ctx, nil); err != nil {
 		return err
 	}
 
+	status.Phase = "destroying vpc"
+	if err := pausePoint(ctx, status); err != nil {
+		return err
+	}
 	if err := workflow.ExecuteActivity(ctx, DestroyVPCActivity, input).Get(ctx, nil); err != nil {
 		return err
 	}
 
+	status.Phase = "completed"
 	return nil
 }
 
 func DestroyVPCActivity(ctx context.Context, input DestroyDemoNetworkInput) error {
 	awsConfig := awsconfig.LoadConfig()
 
+	stack := StackRef{TerraformPath: "core:aws/vpc", StateKey: fmt.Sprintf("vpc-%s.tfstate", input.Name)}
+	backend, err := StateBackend(ctx, awsConfig.Credentials, stack)
+	if err != nil {
+		return err
+	}
+
+	credentials, err := terraformCredentials(ctx, awsConfig, "")
+	if err != nil {
+		return err
+	}
+
 	tfa := tfactivity.New(tfworkspace.Config{
-		TerraformPath: "aws/vpc",
-		TerraformFS:   terraform.FS,
-		S3Backend: tfexec.S3BackendConfig{
-			Credentials: awsConfig.Credentials,
-			Region:      "us-west-2",
-			Bucket:      "temporal-terraform-demo-state",
-			Key:         fmt.Sprintf("vpc-%s.tfstate", input.Name),
-		},
+		TerraformPath: stack.TerraformPath,
+		S3Backend:     backend,
+		Reports:       true,
 	})
 
 	if err := tfa.Destroy(ctx, tfworkspace.DestroyInput{
-		AwsCredentials: awsConfig.Credentials,
+		AwsCredentials: credentials,
 		Env: map[string]string{
 			"AWS_REGION": input.Region,
 		},
@@ -70,19 +102,25 @@ func DestroyVPCActivity(ctx context.Context, input DestroyDemoNetworkInput) erro
 func DestroySubnetsActivity(ctx context.Context, input DestroyDemoNetworkInput) error {
 	awsConfig := awsconfig.LoadConfig()
 
+	stack := StackRef{TerraformPath: "core:aws/subnet", StateKey: fmt.Sprintf("subnets-%s.tfstate", input.Name)}
+	backend, err := StateBackend(ctx, awsConfig.Credentials, stack)
+	if err != nil {
+		return err
+	}
+
+	credentials, err := terraformCredentials(ctx, awsConfig, "")
+	if err != nil {
+		return err
+	}
+
 	tfa := tfactivity.New(tfworkspace.Config{
-		TerraformPath: "aws/subnet",
-		TerraformFS:   terraform.FS,
-		S3Backend: tfexec.S3BackendConfig{
-			Credentials: awsConfig.Credentials,
-			Region:      "us-west-2",
-			Bucket:      "temporal-terraform-demo-state",
-			Key:         fmt.Sprintf("subnets-%s.tfstate", input.Name),
-		},
+		TerraformPath: stack.TerraformPath,
+		S3Backend:     backend,
+		Reports:       true,
 	})
 
 	if err := tfa.Destroy(ctx, tfworkspace.DestroyInput{
-		AwsCredentials: awsConfig.Credentials,
+		AwsCredentials: credentials,
 		Env: map[string]string{
 			"AWS_REGION": input.Region,
 		},
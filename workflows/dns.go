@@ -0,0 +1,194 @@
+package workflows
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+
+	"github.com/dynajoe/temporal-terraform-demo/stacks"
+	"github.com/dynajoe/temporal-terraform-demo/tfworkspace"
+)
+
+type (
+	// DemoDNSInput gives a network a private hosted zone with records, the
+	// network is one created by CreateDemoNetworkWorkflow
+	DemoDNSInput struct {
+		Network string
+		Region  string
+		Domain  string
+		Records []DNSRecord
+
+		// WaitForNetwork is how long to wait for the network's vpc to be
+		// applied if it doesn't exist yet, zero fails right away
+		WaitForNetwork time.Duration
+	}
+
+	DNSRecord struct {
+		Name    string
+		Type    string
+		TTL     int
+		Records []string
+	}
+
+	CreateDemoDNSOutput struct {
+		Status ResultStatus
+		ZoneID string
+		FQDNs  []string
+	}
+)
+
+// CreateDemoDNSWorkflow applies the zone stack, then a stack per record
+// wired to the zone's zone_id output
+func CreateDemoDNSWorkflow(ctx workflow.Context, input DemoDNSInput) (CreateDemoDNSOutput, error) {
+	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: time.Hour,
+		HeartbeatTimeout:    time.Minute,
+		RetryPolicy: &temporal.RetryPolicy{
+			InitialInterval:    5 * time.Second,
+			BackoffCoefficient: 1.3,
+			MaximumInterval:    time.Minute,
+		},
+	})
+
+	if input.Network == "" || input.Domain == "" {
+		return CreateDemoDNSOutput{}, temporal.NewNonRetryableApplicationError("dns needs a network and a domain", "InvalidInput", nil)
+	}
+
+	status, err := trackStatus(ctx)
+	if err != nil {
+		return CreateDemoDNSOutput{}, err
+	}
+
+	status.Phase = "checking change freeze"
+	if err := waitForThaw(ctx, status); err != nil {
+		return CreateDemoDNSOutput{}, err
+	}
+
+	if input.WaitForNetwork > 0 {
+		status.Phase = "waiting for network"
+		if err := waitForVarRefs(ctx, status, input.zoneInput()); err != nil {
+			return CreateDemoDNSOutput{}, err
+		}
+	}
+
+	status.Phase = "creating zone"
+	var zoneOutput ModuleOutput
+	if err := workflow.ExecuteActivity(ctx, ApplyModuleActivity, input.zoneInput()).Get(ctx, &zoneOutput); err != nil {
+		return CreateDemoDNSOutput{}, err
+	}
+
+	status.Phase = "creating records"
+	var futures []workflow.Future
+	for _, record := range input.Records {
+		futures = append(futures, workflow.ExecuteActivity(ctx, ApplyModuleActivity, input.recordInput(record)))
+	}
+	result := zoneOutput.Status
+	inventories := []tfworkspace.Inventory{zoneOutput.Inventory}
+	var fqdns []string
+	for _, future := range futures {
+		var recordOutput ModuleOutput
+		if err := future.Get(ctx, &recordOutput); err != nil {
+			return CreateDemoDNSOutput{}, err
+		}
+		result = result.combine(recordOutput.Status)
+		inventories = append(inventories, recordOutput.Inventory)
+		if fqdn, ok := recordOutput.Output["fqdn"].(string); ok {
+			fqdns = append(fqdns, fqdn)
+		}
+	}
+
+	if err := recordInventory(ctx, inventories...); err != nil {
+		return CreateDemoDNSOutput{}, err
+	}
+
+	status.Phase = "completed"
+	zoneID, _ := zoneOutput.Output["zone_id"].(string)
+	return CreateDemoDNSOutput{
+		Status: result,
+		ZoneID: zoneID,
+		FQDNs:  fqdns,
+	}, nil
+}
+
+// DestroyDemoDNSWorkflow destroys the record stacks and then the zone
+func DestroyDemoDNSWorkflow(ctx workflow.Context, input DemoDNSInput) error {
+	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: time.Hour,
+		HeartbeatTimeout:    time.Minute,
+		RetryPolicy: &temporal.RetryPolicy{
+			InitialInterval:    10 * time.Second,
+			BackoffCoefficient: 1.3,
+			MaximumInterval:    2 * time.Minute,
+		},
+	})
+
+	status, err := trackStatus(ctx)
+	if err != nil {
+		return err
+	}
+
+	status.Phase = "checking change freeze"
+	if err := refuseIfFrozen(ctx); err != nil {
+		return err
+	}
+
+	status.Phase = "destroying records"
+	var futures []workflow.Future
+	for _, record := range input.Records {
+		futures = append(futures, workflow.ExecuteActivity(ctx, DestroyModuleActivity, input.recordInput(record)))
+	}
+	for _, future := range futures {
+		if err := future.Get(ctx, nil); err != nil {
+			return err
+		}
+	}
+
+	status.Phase = "destroying zone"
+	if err := workflow.ExecuteActivity(ctx, DestroyModuleActivity, input.zoneInput()).Get(ctx, nil); err != nil {
+		return err
+	}
+
+	status.Phase = "completed"
+	return nil
+}
+
+func (input DemoDNSInput) zoneStack() StackRef {
+	return StackRef{TerraformPath: "core:aws/route53_zone", StateKey: fmt.Sprintf("dns-%s-zone.tfstate", input.Domain)}
+}
+
+func (input DemoDNSInput) zoneInput() ModuleInput {
+	zone := input.zoneStack()
+	return ModuleInput{
+		TerraformPath: zone.TerraformPath,
+		StateKey:      zone.StateKey,
+		Region:        input.Region,
+		Vars:          stacks.Route53ZoneVars{ZoneName: input.Domain}.Vars(),
+		VarRefs: map[string]StackOutputRef{
+			"vpc_id": {
+				Stack:  StackRef{TerraformPath: "core:aws/vpc", StateKey: fmt.Sprintf("vpc-%s.tfstate", input.Network)},
+				Output: "vpc_id",
+			},
+		},
+		WaitForRefs: input.WaitForNetwork,
+	}
+}
+
+func (input DemoDNSInput) recordInput(record DNSRecord) ModuleInput {
+	return ModuleInput{
+		TerraformPath: "core:aws/route53_record",
+		StateKey:      fmt.Sprintf("dns-%s-record-%s-%s.tfstate", input.Domain, record.Name, strings.ToLower(record.Type)),
+		Region:        input.Region,
+		Vars: stacks.Route53RecordVars{
+			Name:    record.Name,
+			Type:    record.Type,
+			TTL:     record.TTL,
+			Records: record.Records,
+		}.Vars(),
+		VarRefs: map[string]StackOutputRef{
+			"zone_id": {Stack: input.zoneStack(), Output: "zone_id"},
+		},
+	}
+}
@@ -0,0 +1,262 @@
+package workflows
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+)
+
+type (
+	// ApprovalPolicy escalates a plan nobody decided on through tiers of
+	// approvers, e.g. the owning team, then platform leads, then an on-call
+	// override. Without one anyone may decide and the gate waits forever.
+	ApprovalPolicy struct {
+		Tiers []ApprovalTier `json:"tiers"`
+	}
+
+	ApprovalTier struct {
+		Name string `json:"name"`
+
+		// Approvers may decide once the tier is reached, and keep deciding
+		// after it escalates. Empty allows anyone.
+		Approvers []string `json:"approvers,omitempty"`
+
+		// Timeout is how long the tier has to decide before the next tier
+		// is notified, required for all but the last tier
+		Timeout time.Duration `json:"timeout,omitempty"`
+
+		// NotifyURL is posted an ApprovalNotice when the tier is reached
+		NotifyURL string `json:"notify_url,omitempty"`
+	}
+
+	NotifyApproversInput struct {
+		URL    string
+		Notice ApprovalNotice
+	}
+
+	// ApprovalNotice tells a tier a plan is waiting for it
+	ApprovalNotice struct {
+		WorkflowID string
+		RunID      string
+		Tier       string
+		Approvers  []string
+		Plan       []string
+	}
+)
+
+var (
+	approvalPolicyMu sync.RWMutex
+	approvalPolicy   ApprovalPolicy
+)
+
+// LoadApprovalPolicy reads the approval escalation policy from a JSON file
+func LoadApprovalPolicy(path string) (ApprovalPolicy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ApprovalPolicy{}, err
+	}
+
+	var policy ApprovalPolicy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return ApprovalPolicy{}, fmt.Errorf("error decoding approval policy: %w", err)
+	}
+	return policy, nil
+}
+
+// ConfigureApprovalPolicy sets the escalation policy of approval gates
+// reached from now on
+func ConfigureApprovalPolicy(policy ApprovalPolicy) error {
+	seen := map[string]bool{}
+	for i, tier := range policy.Tiers {
+		if tier.Name == "" || seen[tier.Name] {
+			return fmt.Errorf("approval tier %d needs a unique name", i)
+		}
+		seen[tier.Name] = true
+		if i < len(policy.Tiers)-1 && tier.Timeout <= 0 {
+			return fmt.Errorf("approval tier %s needs a timeout to escalate", tier.Name)
+		}
+	}
+
+	approvalPolicyMu.Lock()
+	defer approvalPolicyMu.Unlock()
+	approvalPolicy = policy
+	return nil
+}
+
+func currentApprovalPolicy() ApprovalPolicy {
+	approvalPolicyMu.RLock()
+	defer approvalPolicyMu.RUnlock()
+	return approvalPolicy
+}
+
+// decidingTier returns the first tier up to reached that by may decide for
+func (p ApprovalPolicy) decidingTier(by string, reached int) (string, bool) {
+	for _, tier := range p.Tiers[:reached+1] {
+		if len(tier.Approvers) == 0 {
+			return tier.Name, true
+		}
+		for _, approver := range tier.Approvers {
+			if approver == by {
+				return tier.Name, true
+			}
+		}
+	}
+	return "", false
+}
+
+// receiveApproval waits for a decision the policy accepts. Each tier that
+// times out hands over to the next, decisions from approvers of tiers not
+// reached yet are ignored, as are approvals of an over budget plan that
+// don't override the budget with a reason.
+func receiveApproval(ctx workflow.Context, status *Status, policy ApprovalPolicy, overBudget bool) (Approval, error) {
+	signals := workflow.GetSignalChannel(ctx, ApprovalSignal)
+	if len(policy.Tiers) == 0 {
+		for {
+			var approval Approval
+			signals.Receive(ctx, &approval)
+			if ctx.Err() != nil {
+				return Approval{}, ctx.Err()
+			}
+			if overBudget && !budgetOverridden(ctx, approval) {
+				continue
+			}
+			return approval, nil
+		}
+	}
+
+	// The pending escalation is canceled once a decision is accepted
+	timerCtx, cancelTimer := workflow.WithCancel(ctx)
+	defer cancelTimer()
+
+	reached := -1
+	var escalation workflow.Future
+	escalate := func() {
+		reached++
+		tier := policy.Tiers[reached]
+		status.ApprovalTier = tier.Name
+		notifyTier(ctx, tier, status.Plan)
+
+		escalation = nil
+		if reached < len(policy.Tiers)-1 {
+			escalation = workflow.NewTimer(timerCtx, tier.Timeout)
+		}
+	}
+	escalate()
+
+	for {
+		var approval Approval
+		escalated := false
+		selector := workflow.NewSelector(ctx)
+		selector.AddReceive(signals, func(c workflow.ReceiveChannel, more bool) {
+			c.Receive(ctx, &approval)
+		})
+		if escalation != nil {
+			selector.AddFuture(escalation, func(workflow.Future) {
+				escalated = true
+			})
+		}
+		selector.Select(ctx)
+		if ctx.Err() != nil {
+			return Approval{}, ctx.Err()
+		}
+
+		if escalated {
+			workflow.GetLogger(ctx).Info("Plan approval escalated",
+				"From", policy.Tiers[reached].Name, "To", policy.Tiers[reached+1].Name)
+			escalate()
+			continue
+		}
+
+		tier, ok := policy.decidingTier(approval.By, reached)
+		if !ok {
+			workflow.GetLogger(ctx).Warn("Ignoring plan decision from approver outside the reached tiers",
+				"By", approval.By, "Tier", policy.Tiers[reached].Name)
+			continue
+		}
+		if overBudget && !budgetOverridden(ctx, approval) {
+			continue
+		}
+		approval.Tier = tier
+		status.ApprovalTier = tier
+		return approval, nil
+	}
+}
+
+// budgetOverridden reports whether the decision may settle an over budget
+// plan, rejections always may
+func budgetOverridden(ctx workflow.Context, approval Approval) bool {
+	if !approval.Approved || (approval.OverrideBudget && strings.TrimSpace(approval.Reason) != "") {
+		return true
+	}
+	workflow.GetLogger(ctx).Warn("Ignoring approval of an over budget plan without a budget override and reason", "By", approval.By)
+	return false
+}
+
+// notifyTier tells a tier it may decide, a failed notification is logged
+// rather than holding up the gate
+func notifyTier(ctx workflow.Context, tier ApprovalTier, plan []string) {
+	if tier.NotifyURL == "" {
+		return
+	}
+
+	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: time.Minute,
+		RetryPolicy: &temporal.RetryPolicy{
+			MaximumAttempts: 3,
+		},
+	})
+	info := workflow.GetInfo(ctx)
+	err := workflow.ExecuteActivity(ctx, NotifyApproversActivity, NotifyApproversInput{
+		URL: tier.NotifyURL,
+		Notice: ApprovalNotice{
+			WorkflowID: info.WorkflowExecution.ID,
+			RunID:      info.WorkflowExecution.RunID,
+			Tier:       tier.Name,
+			Approvers:  tier.Approvers,
+			Plan:       plan,
+		},
+	}).Get(ctx, nil)
+	if err != nil {
+		workflow.GetLogger(ctx).Error("Unable to notify approvers", "Tier", tier.Name, "Error", err)
+	}
+}
+
+// NotifyApproversActivity posts the notice to the tier's URL
+func NotifyApproversActivity(ctx context.Context, input NotifyApproversInput) error {
+	if input.URL == "" {
+		return errors.New("approval notice has no url")
+	}
+	body, err := json.Marshal(input.Notice)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, input.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("approval notification failed with status %d: %s", resp.StatusCode, msg)
+	}
+	return nil
+}
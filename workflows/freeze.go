@@ -0,0 +1,166 @@
+package workflows
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+
+	"github.com/dynajoe/temporal-terraform-demo/config/awsconfig"
+	"github.com/dynajoe/temporal-terraform-demo/s3object"
+)
+
+const (
+	// ChangeFreezeKey is the object in the state bucket that, when frozen,
+	// holds applies and refuses destroys
+	ChangeFreezeKey = "change-freeze.json"
+
+	// ThawSignal lets a single workflow proceed through a change freeze
+	ThawSignal = "thaw"
+
+	// freezeRecheckInterval is how often a waiting workflow checks whether
+	// the freeze has been lifted
+	freezeRecheckInterval = time.Minute
+)
+
+// ChangeFreeze is the operator controlled kill switch for terraform changes
+type ChangeFreeze struct {
+	Frozen bool
+	Reason string
+	By     string
+	Since  time.Time
+}
+
+// LoadChangeFreeze reads the current change freeze, which is lifted if it has
+// never been set
+func LoadChangeFreeze(ctx context.Context, client s3object.Store) (ChangeFreeze, error) {
+	return loadChangeFreeze(ctx, client, stateBucket)
+}
+
+func loadChangeFreeze(ctx context.Context, client s3object.Store, bucket string) (ChangeFreeze, error) {
+	data, err := client.Get(ctx, bucket, ChangeFreezeKey)
+	if errors.Is(err, s3object.ErrNotFound) {
+		return ChangeFreeze{}, nil
+	}
+	if err != nil {
+		return ChangeFreeze{}, fmt.Errorf("error reading change freeze: %w", err)
+	}
+
+	var freeze ChangeFreeze
+	if err := json.Unmarshal(data, &freeze); err != nil {
+		return ChangeFreeze{}, fmt.Errorf("error decoding change freeze: %w", err)
+	}
+	return freeze, nil
+}
+
+// StoreChangeFreeze sets or lifts the change freeze
+func StoreChangeFreeze(ctx context.Context, client s3object.Store, freeze ChangeFreeze) error {
+	data, err := json.Marshal(freeze)
+	if err != nil {
+		return err
+	}
+	if err := client.Put(ctx, stateBucket, ChangeFreezeKey, data); err != nil {
+		return fmt.Errorf("error writing change freeze: %w", err)
+	}
+	return nil
+}
+
+// NewStateClient returns a client for the bucket holding state and operator
+// controls
+func NewStateClient() s3object.Store {
+	return stateObjects(awsconfig.LoadConfig().Credentials, stateRegion)
+}
+
+// CheckChangeFreezeActivity returns the change freeze. Tenants with their own
+// bucket are also frozen by the freeze stored in it.
+func CheckChangeFreezeActivity(ctx context.Context) (ChangeFreeze, error) {
+	freeze, err := LoadChangeFreeze(ctx, NewStateClient())
+	if err != nil || freeze.Frozen {
+		return freeze, err
+	}
+
+	route := controlRoute(ctx)
+	if route.Bucket == stateBucket {
+		return freeze, nil
+	}
+	return loadChangeFreeze(ctx, route.objects(awsconfig.LoadConfig().Credentials), route.Bucket)
+}
+
+func checkChangeFreeze(ctx workflow.Context) (ChangeFreeze, error) {
+	if !hasChange(ctx, changeFreezeVersion) {
+		return ChangeFreeze{}, nil
+	}
+
+	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: 30 * time.Second,
+		RetryPolicy: &temporal.RetryPolicy{
+			InitialInterval:    5 * time.Second,
+			BackoffCoefficient: 1.3,
+			MaximumInterval:    time.Minute,
+		},
+	})
+
+	var freeze ChangeFreeze
+	err := workflow.ExecuteActivity(ctx, CheckChangeFreezeActivity).Get(ctx, &freeze)
+	return freeze, err
+}
+
+// waitForThaw blocks while a change freeze is in effect. The wait ends when
+// the freeze is lifted or an operator sends ThawSignal to this workflow.
+func waitForThaw(ctx workflow.Context, status *Status) error {
+	thawed := false
+	thawCh := workflow.GetSignalChannel(ctx, ThawSignal)
+
+	for {
+		freeze, err := checkChangeFreeze(ctx)
+		if err != nil {
+			return err
+		}
+		if !freeze.Frozen {
+			break
+		}
+
+		status.Frozen = true
+		status.FrozenReason = freeze.Reason
+
+		timerCtx, cancelTimer := workflow.WithCancel(ctx)
+		selector := workflow.NewSelector(ctx)
+		selector.AddReceive(thawCh, func(c workflow.ReceiveChannel, more bool) {
+			c.Receive(ctx, nil)
+			thawed = true
+		})
+		selector.AddFuture(workflow.NewTimer(timerCtx, freezeRecheckInterval), func(f workflow.Future) {})
+		selector.Select(ctx)
+		cancelTimer()
+
+		if thawed {
+			workflow.GetLogger(ctx).Info("Thawed by signal during change freeze", "Reason", freeze.Reason)
+			break
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+
+	status.Frozen = false
+	status.FrozenReason = ""
+	return nil
+}
+
+// refuseIfFrozen fails the workflow when a change freeze is in effect
+func refuseIfFrozen(ctx workflow.Context) error {
+	freeze, err := checkChangeFreeze(ctx)
+	if err != nil {
+		return err
+	}
+	if freeze.Frozen {
+		return temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("change freeze in effect since %s: %s", freeze.Since.Format(time.RFC3339), freeze.Reason),
+			"ChangeFreeze", nil, freeze)
+	}
+	return nil
+}
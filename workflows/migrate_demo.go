@@ -0,0 +1,377 @@
+package workflows
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+
+	"github.com/dynajoe/temporal-terraform-demo/config/awsconfig"
+	"github.com/dynajoe/temporal-terraform-demo/stacks"
+	"github.com/dynajoe/temporal-terraform-demo/tfstate"
+	"github.com/dynajoe/temporal-terraform-demo/tfworkspace"
+)
+
+// demoStateKey matches the state keys of networks created by
+// CreateDemoNetworkWorkflow, e.g. vpc-demo.tfstate
+var demoStateKey = regexp.MustCompile(`^(vpc|subnets)-([^/]+)\.tfstate$`)
+
+type (
+	MigrateDemoInput struct {
+		// Region the networks were created in
+		Region string
+
+		// Networks limits the migration to the named networks, defaults to
+		// every network found in the state bucket
+		Networks []string
+
+		// Consolidate copies state left in the state bucket to the bucket
+		// its state route now selects. The old copy is removed once the
+		// stack plans clean from the new one.
+		Consolidate bool
+	}
+
+	MigrateDemoOutput struct {
+		Networks []MigratedNetwork
+	}
+
+	MigratedNetwork struct {
+		Name   string
+		Stacks []MigratedStack
+	}
+
+	MigratedStack struct {
+		StateKey string
+		Bucket   string
+
+		// Adopted is set if the stack had no history and an adoption report
+		// was recorded for it
+		Adopted bool
+
+		// Relocated is set if the state was copied to its routed bucket
+		Relocated bool
+
+		// Changes are what a plan would change, the stack is only migrated
+		// cleanly if there are none. Unverified says why it wasn't planned.
+		Changes    []string
+		Unverified string
+	}
+
+	// DemoNetwork is a network found in the state bucket with the inputs
+	// that reproduce its stacks, recovered from their state
+	DemoNetwork struct {
+		Name    string
+		VPC     *DemoStack
+		Subnets *DemoStack
+
+		VPCInput     CreateVPCInput
+		SubnetsInput CreateSubnetsInput
+	}
+
+	DemoStack struct {
+		Stack StackRef
+
+		// From is where the state is, To where it is routed
+		From StateRoute
+		To   StateRoute
+
+		HasHistory    bool
+		ResourceCount int
+	}
+
+	AdoptStackInput struct {
+		Stack StackRef
+		Route StateRoute
+		Vars  map[string]interface{}
+	}
+
+	CopyStateInput struct {
+		StateKey string
+		From     StateRoute
+		To       StateRoute
+	}
+)
+
+// MigrateDemoWorkflow brings networks created before execution reports and
+// state routes onto them. Each network's stacks get an adoption report so
+// they can be cloned and renamed, are optionally copied to the bucket their
+// route selects, and are planned to verify they still match what they
+// manage.
+func MigrateDemoWorkflow(ctx workflow.Context, input MigrateDemoInput) (MigrateDemoOutput, error) {
+	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: time.Hour,
+		HeartbeatTimeout:    time.Minute,
+		RetryPolicy: &temporal.RetryPolicy{
+			InitialInterval:        5 * time.Second,
+			BackoffCoefficient:     1.3,
+			MaximumInterval:        time.Minute,
+			NonRetryableErrorTypes: []string{"StateExists"},
+		},
+	})
+
+	status, err := trackStatus(ctx)
+	if err != nil {
+		return MigrateDemoOutput{}, err
+	}
+
+	status.Phase = "discovering networks"
+	var networks []DemoNetwork
+	if err := workflow.ExecuteActivity(ctx, DiscoverDemoNetworksActivity, input).Get(ctx, &networks); err != nil {
+		return MigrateDemoOutput{}, err
+	}
+
+	var output MigrateDemoOutput
+	var inventories []tfworkspace.Inventory
+	for _, network := range networks {
+		status.Phase = "migrating network " + network.Name
+		migrated, err := migrateDemoNetwork(ctx, input, network)
+		if err != nil {
+			return output, fmt.Errorf("error migrating network %s: %w", network.Name, err)
+		}
+		output.Networks = append(output.Networks, migrated)
+
+		for _, stack := range []*DemoStack{network.VPC, network.Subnets} {
+			if stack != nil {
+				inventories = append(inventories, tfworkspace.Inventory{ResourceCount: stack.ResourceCount})
+			}
+		}
+	}
+	if err := recordInventory(ctx, inventories...); err != nil {
+		return output, err
+	}
+
+	status.Phase = "completed"
+	return output, nil
+}
+
+func migrateDemoNetwork(ctx workflow.Context, input MigrateDemoInput, network DemoNetwork) (MigratedNetwork, error) {
+	migrated := MigratedNetwork{Name: network.Name}
+
+	for _, demoStack := range []*DemoStack{network.VPC, network.Subnets} {
+		if demoStack == nil {
+			continue
+		}
+		stack := MigratedStack{StateKey: demoStack.Stack.StateKey, Bucket: demoStack.From.Bucket}
+
+		// Adopt first so a consolidated copy carries the report
+		if !demoStack.HasHistory {
+			vars := stacks.VpcVars{CIDRBlock: network.VPCInput.CIDRBlock, Name: network.Name}.Vars()
+			if demoStack == network.Subnets {
+				vars = subnetVars(network.SubnetsInput)
+			}
+			if err := workflow.ExecuteActivity(ctx, AdoptStackActivity, AdoptStackInput{
+				Stack: demoStack.Stack,
+				Route: demoStack.From,
+				Vars:  vars,
+			}).Get(ctx, nil); err != nil {
+				return migrated, err
+			}
+			stack.Adopted = true
+		}
+
+		misrouted := demoStack.From.Bucket != demoStack.To.Bucket
+		if misrouted && !input.Consolidate {
+			stack.Unverified = fmt.Sprintf("state is in %s but routed to %s, migrate with Consolidate", demoStack.From.Bucket, demoStack.To.Bucket)
+			migrated.Stacks = append(migrated.Stacks, stack)
+			continue
+		}
+		if misrouted {
+			if err := workflow.ExecuteActivity(ctx, CopyStateActivity, CopyStateInput{
+				StateKey: demoStack.Stack.StateKey,
+				From:     demoStack.From,
+				To:       demoStack.To,
+			}).Get(ctx, nil); err != nil {
+				return migrated, err
+			}
+			stack.Relocated = true
+			stack.Bucket = demoStack.To.Bucket
+		}
+
+		// The state resolves to the copy now, if there is one
+		var plan tfworkspace.PlanOutput
+		var err error
+		if demoStack == network.VPC {
+			err = workflow.ExecuteActivity(ctx, PlanVPCActivity, network.VPCInput).Get(ctx, &plan)
+		} else {
+			err = workflow.ExecuteActivity(ctx, PlanSubnetsActivity, network.SubnetsInput).Get(ctx, &plan)
+		}
+		if err != nil {
+			return migrated, err
+		}
+		stack.Changes = planSummary(plan.Changes)
+
+		// The old copy is only removed once the stack plans clean from the new
+		if stack.Relocated && len(stack.Changes) == 0 {
+			if err := workflow.ExecuteActivity(ctx, RemoveCopiedStateActivity, CopyStateInput{
+				StateKey: demoStack.Stack.StateKey,
+				From:     demoStack.From,
+				To:       demoStack.To,
+			}).Get(ctx, nil); err != nil {
+				return migrated, err
+			}
+		}
+
+		migrated.Stacks = append(migrated.Stacks, stack)
+	}
+
+	return migrated, nil
+}
+
+// DiscoverDemoNetworksActivity finds the demo networks in the state bucket
+// and recovers the inputs that created them from their state
+func DiscoverDemoNetworksActivity(ctx context.Context, input MigrateDemoInput) ([]DemoNetwork, error) {
+	awsConfig := awsconfig.LoadConfig()
+	from := controlRoute(ctx)
+	client := from.objects(awsConfig.Credentials)
+
+	keys, err := client.List(ctx, from.Bucket, "")
+	if err != nil {
+		return nil, fmt.Errorf("error listing state bucket: %w", err)
+	}
+
+	wanted := map[string]bool{}
+	for _, name := range input.Networks {
+		wanted[name] = true
+	}
+
+	routes := allStateRoutes()
+	networks := map[string]*DemoNetwork{}
+	for _, key := range keys {
+		match := demoStateKey.FindStringSubmatch(key)
+		if match == nil || (len(wanted) > 0 && !wanted[match[2]]) {
+			continue
+		}
+		kind, name := match[1], match[2]
+
+		network, ok := networks[name]
+		if !ok {
+			network = &DemoNetwork{Name: name}
+			networks[name] = network
+		}
+
+		stack := networkStacks(name)[0]
+		if kind == "subnets" {
+			stack = networkStacks(name)[1]
+		}
+		backend := from.backend(awsConfig.Credentials, key)
+
+		state, err := tfstate.Load(ctx, backend)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", key, err)
+		}
+		reports, err := tfworkspace.ReportKeys(ctx, backend)
+		if err != nil {
+			return nil, err
+		}
+
+		demoStack := &DemoStack{
+			Stack:      stack,
+			From:       from,
+			To:         routeFor(routes, stack),
+			HasHistory: len(reports) > 0,
+		}
+		for _, r := range state.Resources {
+			if r.Mode == "managed" {
+				demoStack.ResourceCount += len(r.Instances)
+			}
+		}
+
+		switch kind {
+		case "vpc":
+			network.VPC = demoStack
+			network.VPCInput = demoVPCInput(name, input.Region, state)
+		case "subnets":
+			network.Subnets = demoStack
+			network.SubnetsInput = demoSubnetsInput(name, input.Region, state)
+		}
+		activity.RecordHeartbeat(ctx, key)
+	}
+
+	names := make([]string, 0, len(networks))
+	for name := range networks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := make([]DemoNetwork, 0, len(names))
+	for _, name := range names {
+		result = append(result, *networks[name])
+	}
+	return result, nil
+}
+
+// AdoptStackActivity records the module and vars a stack is managed with
+func AdoptStackActivity(ctx context.Context, input AdoptStackInput) error {
+	awsConfig := awsconfig.LoadConfig()
+
+	info := activity.GetInfo(ctx)
+	runID := info.WorkflowExecution.ID + "_" + info.WorkflowExecution.RunID
+	backend := input.Route.backend(awsConfig.Credentials, input.Stack.StateKey)
+	return tfworkspace.RecordAdoption(ctx, backend, input.Stack.TerraformPath, runID, input.Vars)
+}
+
+// CopyStateActivity copies a stack's state and artifacts between buckets
+func CopyStateActivity(ctx context.Context, input CopyStateInput) error {
+	awsConfig := awsconfig.LoadConfig()
+
+	err := tfworkspace.CopyState(ctx,
+		input.From.backend(awsConfig.Credentials, input.StateKey),
+		input.To.backend(awsConfig.Credentials, input.StateKey))
+	if errors.Is(err, tfworkspace.ErrStateExists) {
+		return temporal.NewNonRetryableApplicationError(err.Error(), "StateExists", err)
+	}
+	return err
+}
+
+// RemoveCopiedStateActivity deletes the source of a CopyStateActivity once
+// the copy is known to be in place
+func RemoveCopiedStateActivity(ctx context.Context, input CopyStateInput) error {
+	awsConfig := awsconfig.LoadConfig()
+
+	if _, err := tfstate.Load(ctx, input.To.backend(awsConfig.Credentials, input.StateKey)); err != nil {
+		return fmt.Errorf("error checking copied state: %w", err)
+	}
+	return tfworkspace.RemoveState(ctx, input.From.backend(awsConfig.Credentials, input.StateKey))
+}
+
+// demoVPCInput recovers the input of the vpc stack from its state
+func demoVPCInput(name string, region string, state *tfstate.State) CreateVPCInput {
+	input := CreateVPCInput{Name: name, Region: region}
+	for _, r := range state.Resources {
+		if r.Mode == "managed" && r.Type == "aws_vpc" && len(r.Instances) > 0 {
+			input.CIDRBlock, _ = r.Instances[0].Attributes["cidr_block"].(string)
+		}
+	}
+	return input
+}
+
+// demoSubnetsInput recovers the input of the subnets stack from its state,
+// subnets are keyed by availability zone
+func demoSubnetsInput(name string, region string, state *tfstate.State) CreateSubnetsInput {
+	input := CreateSubnetsInput{Name: name, Region: region}
+	for _, r := range state.Resources {
+		if r.Mode != "managed" || r.Type != "aws_subnet" {
+			continue
+		}
+		for _, instance := range r.Instances {
+			zone, _ := instance.Attributes["availability_zone"].(string)
+			cidr, _ := instance.Attributes["cidr_block"].(string)
+			input.VpcID, _ = instance.Attributes["vpc_id"].(string)
+			input.Subnets = append(input.Subnets, Subnet{
+				AvailabilityZone: strings.TrimPrefix(zone, region),
+				CIDRBlock:        cidr,
+			})
+		}
+	}
+	sort.Slice(input.Subnets, func(i, j int) bool {
+		return input.Subnets[i].AvailabilityZone < input.Subnets[j].AvailabilityZone
+	})
+	return input
+}
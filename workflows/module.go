@@ -0,0 +1,241 @@
+package workflows
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+
+	"github.com/dynajoe/temporal-terraform-demo/config/awsconfig"
+	"github.com/dynajoe/temporal-terraform-demo/terraform"
+	"github.com/dynajoe/temporal-terraform-demo/tfactivity"
+	"github.com/dynajoe/temporal-terraform-demo/tfconfig"
+	"github.com/dynajoe/temporal-terraform-demo/tfexec"
+	"github.com/dynajoe/temporal-terraform-demo/tfworkspace"
+)
+
+type (
+	// ModuleInput applies or destroys any embedded module
+	ModuleInput struct {
+		TerraformPath string
+		StateKey      string
+		Region        string
+
+		// RoleARN is assumed by terraform, e.g. to provision into a sandbox
+		// account. State is always stored with the worker's credentials.
+		RoleARN string
+
+		// PlanRoleARN is assumed by plans instead of RoleARN, see
+		// StackRef.PlanRoleARN
+		PlanRoleARN string
+
+		// KMSKeyID encrypts the state with a customer managed key, see
+		// StackRef.KMSKeyID
+		KMSKeyID string
+
+		Vars map[string]interface{}
+
+		// VarRefs set vars from the outputs of other stacks, overriding Vars.
+		// WaitForRefs waits up to this long for those stacks to be applied,
+		// zero fails right away on an unresolved reference.
+		VarRefs     map[string]StackOutputRef
+		WaitForRefs time.Duration
+
+		// TemplateData is what templates in Vars may reference besides the
+		// stack itself, e.g. {"Name": "demo"} for "{{ .Name }}-{{ .Region }}"
+		TemplateData map[string]string
+
+		// Parallelism and ResourceTimeouts tune terraform for large stacks or
+		// throttled APIs
+		Parallelism      int
+		ResourceTimeouts map[string]tfexec.ResourceTimeouts
+
+		// TransientRetries re-applies resources that failed, e.g. due to
+		// throttling, before failing the activity. Reviewed plans fail with
+		// a ReviewedPlanFailed error instead, retrying needs a new approval.
+		TransientRetries int
+
+		// RedactOutputs are globs over output names withheld from the result
+		RedactOutputs []string
+
+		// ForceReplan bypasses the plan cache
+		ForceReplan bool
+
+		// LockTimeout waits for a busy state's lock instead of failing.
+		// NoRefresh skips checking for drift. PlanWithoutLock lets plans run
+		// while the state is locked.
+		LockTimeout     time.Duration
+		NoRefresh       bool
+		PlanWithoutLock bool
+
+		// PlannedSerial is the StateSerial of a reviewed plan, the apply
+		// fails with a StaleState error if someone else applied since
+		PlannedSerial *int64
+
+		// PlannedVersions are the Versions of a reviewed plan, the apply
+		// fails with a VersionMismatch error if this worker runs others
+		PlannedVersions *tfexec.Versions
+
+		// PlanRef is the offloaded plan of a reviewed plan, the apply runs
+		// it instead of planning again
+		PlanRef *tfworkspace.PlanRef
+
+		// SoftDelete keeps the resources the allowed stack retains on
+		// destroy, see AllowedStack.RetainOnDestroy
+		SoftDelete bool
+	}
+
+	ModuleOutput struct {
+		Status    ResultStatus
+		Output    map[string]interface{}
+		Inventory tfworkspace.Inventory
+	}
+)
+
+func ApplyModuleActivity(ctx context.Context, input ModuleInput) (ModuleOutput, error) {
+	awsConfig := awsconfig.LoadConfig()
+
+	input, err := input.withVarRefs(ctx, awsConfig)
+	if err != nil {
+		return ModuleOutput{}, err
+	}
+	input, err = input.withRenderedVars(ctx)
+	if err != nil {
+		return ModuleOutput{}, err
+	}
+
+	config, err := moduleConfig(ctx, awsConfig, input)
+	if err != nil {
+		return ModuleOutput{}, err
+	}
+
+	credentials, err := terraformCredentials(ctx, awsConfig, input.RoleARN)
+	if err != nil {
+		return ModuleOutput{}, err
+	}
+
+	applyOutput, err := tfactivity.New(config).Apply(ctx, tfworkspace.ApplyInput{
+		AwsCredentials: credentials,
+		// Long applies outlive session credentials
+		AwsCredentialsMode: tfworkspace.CredentialsEndpoint,
+		Env: map[string]string{
+			"AWS_REGION": input.Region,
+		},
+		Vars:             input.Vars,
+		Parallelism:      input.Parallelism,
+		ResourceTimeouts: input.ResourceTimeouts,
+		TransientRetries: input.TransientRetries,
+		LockTimeout:      input.LockTimeout,
+		NoRefresh:        input.NoRefresh,
+		PlannedSerial:    input.PlannedSerial,
+		PlannedVersions:  input.PlannedVersions,
+		PlanRef:          input.PlanRef,
+	})
+	if err != nil {
+		return ModuleOutput{}, err
+	}
+
+	applyOutput, err = applyOutput.Resolve(ctx, awsConfig.Credentials)
+	if err != nil {
+		return ModuleOutput{}, err
+	}
+
+	return ModuleOutput{
+		Status:    appliedStatus(applyOutput.Changed),
+		Output:    applyOutput.Output,
+		Inventory: applyOutput.Inventory,
+	}, nil
+}
+
+func DestroyModuleActivity(ctx context.Context, input ModuleInput) error {
+	awsConfig := awsconfig.LoadConfig()
+
+	input, err := input.withVarRefs(ctx, awsConfig)
+	if err != nil {
+		return err
+	}
+	input, err = input.withRenderedVars(ctx)
+	if err != nil {
+		return err
+	}
+
+	config, err := moduleConfig(ctx, awsConfig, input)
+	if err != nil {
+		return err
+	}
+
+	credentials, err := terraformCredentials(ctx, awsConfig, input.RoleARN)
+	if err != nil {
+		return err
+	}
+
+	return tfactivity.New(config).Destroy(ctx, tfworkspace.DestroyInput{
+		AwsCredentials: credentials,
+		// Long applies outlive session credentials
+		AwsCredentialsMode: tfworkspace.CredentialsEndpoint,
+		Env: map[string]string{
+			"AWS_REGION": input.Region,
+		},
+		Vars:        input.Vars,
+		Parallelism: input.Parallelism,
+		LockTimeout: input.LockTimeout,
+		SoftDelete:  input.SoftDelete,
+	})
+}
+
+func (input ModuleInput) stack() StackRef {
+	return StackRef{
+		TerraformPath: input.TerraformPath,
+		RoleARN:       input.RoleARN,
+		PlanRoleARN:   input.PlanRoleARN,
+		StateKey:      input.StateKey,
+		KMSKeyID:      input.KMSKeyID,
+	}
+}
+
+// moduleConfig builds the workspace config for a module from its allowed
+// stack definition, enforcing the output types the module declares
+func moduleConfig(ctx context.Context, awsConfig aws.Config, input ModuleInput) (tfworkspace.Config, error) {
+	moduleFS, modulePath, err := terraform.Resolve(input.TerraformPath)
+	if err != nil {
+		return tfworkspace.Config{}, err
+	}
+
+	backend, err := StateBackend(ctx, awsConfig.Credentials, input.stack())
+	if err != nil {
+		return tfworkspace.Config{}, err
+	}
+
+	module, err := tfconfig.LoadModule(moduleFS, modulePath)
+	if err != nil {
+		return tfworkspace.Config{}, err
+	}
+
+	stack, err := allowedStack(module, input.TerraformPath, input.Vars)
+	if err != nil {
+		return tfworkspace.Config{}, err
+	}
+
+	registryCredentials, err := stack.registryCredentials(ctx)
+	if err != nil {
+		return tfworkspace.Config{}, err
+	}
+	cliConfig := stack.CLIConfig.Merge(tfexec.CLIConfig{Credentials: registryCredentials})
+
+	if err := checkModuleSources(moduleFS, modulePath, module, cliConfig); err != nil {
+		return tfworkspace.Config{}, err
+	}
+
+	return tfworkspace.Config{
+		TerraformPath: input.TerraformPath,
+		S3Backend:     backend,
+		Outputs:       tfworkspace.ModuleOutputContract(module),
+		Reports:       true,
+		CachePlans:    true,
+		RedactOutputs: input.RedactOutputs,
+
+		ProviderCredentials: stack.providerCredentials(),
+		CLIConfig:           cliConfig,
+		RetainOnDestroy:     stack.RetainOnDestroy,
+	}, nil
+}
@@ -0,0 +1,141 @@
+package workflows
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/dynajoe/temporal-terraform-demo/stacks"
+	"github.com/dynajoe/temporal-terraform-demo/terraform"
+	"github.com/dynajoe/temporal-terraform-demo/tfconfig"
+	"github.com/dynajoe/temporal-terraform-demo/tfworkspace"
+)
+
+// typedStack is what the workflows expect of a module they apply through
+// the generated types in package stacks
+type typedStack struct {
+	vars    interface{}
+	outputs map[string]tfworkspace.OutputType
+}
+
+var typedStacks = map[string]typedStack{
+	"core:aws/vpc":                {stacks.VpcVars{}, stacks.VpcOutputContract()},
+	"core:aws/subnet":             {stacks.SubnetVars{}, stacks.SubnetOutputContract()},
+	"core:aws/vpc_peering":        {stacks.VpcPeeringVars{}, stacks.VpcPeeringOutputContract()},
+	"core:aws/vpc_peering_routes": {stacks.VpcPeeringRoutesVars{}, stacks.VpcPeeringRoutesOutputContract()},
+	"core:aws/tgw_attachment":     {stacks.TgwAttachmentVars{}, stacks.TgwAttachmentOutputContract()},
+	"core:aws/route53_zone":       {stacks.Route53ZoneVars{}, stacks.Route53ZoneOutputContract()},
+	"core:aws/route53_record":     {stacks.Route53RecordVars{}, stacks.Route53RecordOutputContract()},
+
+	"core:aws/state_bucket_security": {stacks.StateBucketSecurityVars{}, stacks.StateBucketSecurityOutputContract()},
+}
+
+// ValidateModules checks every module of the registered terraform
+// namespaces parses and pins its versions in versions.tf, and that the
+// modules the workflows and allowed stacks use declare the variables and
+// outputs expected of them. A broken module fails the worker at startup
+// rather than the first apply using it.
+func ValidateModules() error {
+	var problems []string
+	modules := map[string]*tfconfig.Module{}
+	for _, namespace := range terraform.Namespaces() {
+		fsys, _, err := terraform.Resolve(namespace + ":.")
+		if err != nil {
+			return err
+		}
+		dirs, err := moduleDirs(fsys)
+		if err != nil {
+			return fmt.Errorf("error reading terraform namespace %s: %w", namespace, err)
+		}
+		for _, dir := range dirs {
+			terraformPath := namespace + ":" + dir
+			module, err := tfconfig.LoadModule(fsys, dir)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", terraformPath, err))
+				continue
+			}
+			if _, err := fs.Stat(fsys, path.Join(dir, "versions.tf")); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: no versions.tf", terraformPath))
+			}
+			modules[terraformPath] = module
+		}
+	}
+
+	for terraformPath, expected := range typedStacks {
+		module, ok := modules[terraformPath]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s: module not embedded", terraformPath))
+			continue
+		}
+		for _, name := range varNames(expected.vars) {
+			if _, ok := module.Variable(name); !ok {
+				problems = append(problems, fmt.Sprintf("%s: variable %s is not declared", terraformPath, name))
+			}
+		}
+		declared := tfworkspace.ModuleOutputContract(module)
+		for name, want := range expected.outputs {
+			if got, ok := declared[name]; !ok {
+				problems = append(problems, fmt.Sprintf("%s: output %s is not declared with a type", terraformPath, name))
+			} else if got != want {
+				problems = append(problems, fmt.Sprintf("%s: output %s is %s, not %s", terraformPath, name, got, want))
+			}
+		}
+	}
+
+	allowedStacksMu.RLock()
+	for terraformPath, stack := range allowedStacks {
+		module, ok := modules[terraformPath]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s: allowed stack has no module", terraformPath))
+			continue
+		}
+		for _, name := range stack.Vars {
+			if _, ok := module.Variable(name); !ok {
+				problems = append(problems, fmt.Sprintf("%s: allowed variable %s is not declared", terraformPath, name))
+			}
+		}
+	}
+	allowedStacksMu.RUnlock()
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("invalid terraform modules:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// moduleDirs returns the directories of fsys holding .tf files
+func moduleDirs(fsys fs.FS) ([]string, error) {
+	seen := map[string]bool{}
+	var dirs []string
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && strings.HasPrefix(d.Name(), ".") && p != "." {
+			return fs.SkipDir
+		}
+		if !d.IsDir() && path.Ext(p) == ".tf" && !seen[path.Dir(p)] {
+			seen[path.Dir(p)] = true
+			dirs = append(dirs, path.Dir(p))
+		}
+		return nil
+	})
+	return dirs, err
+}
+
+// varNames returns the variable names of a generated vars struct
+func varNames(vars interface{}) []string {
+	t := reflect.TypeOf(vars)
+	names := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
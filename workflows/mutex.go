@@ -0,0 +1,260 @@
+package workflows
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+)
+
+const (
+	// AcquireLockSignal asks a resource's MutexWorkflow for its lock
+	AcquireLockSignal = "acquire-lock"
+
+	// LockGrantedSignal prefixes the signal telling the requesting
+	// workflow it holds the lock, see lockGrantedSignal
+	LockGrantedSignal = "lock-granted"
+
+	// mutexIdleTimeout is how long a MutexWorkflow waits for another
+	// request before it completes
+	mutexIdleTimeout = 10 * time.Minute
+
+	// mutexGrantsPerRun bounds the history of a single run before it
+	// continues as new
+	mutexGrantsPerRun = 100
+
+	// mutexRecentRequests is how many request IDs a run carries over to
+	// recognize redelivered requests
+	mutexRecentRequests = 100
+)
+
+type (
+	// LockRequest is the payload of AcquireLockSignal. The lock is taken
+	// back after Lease if it hasn't been released.
+	LockRequest struct {
+		RequestID  string
+		WorkflowID string
+		RunID      string
+		Lease      time.Duration
+	}
+
+	// LockGrant is the payload of LockGrantedSignal
+	LockGrant struct {
+		RequestID     string
+		ReleaseSignal string
+	}
+
+	RequestLockInput struct {
+		ResourceID string
+		Request    LockRequest
+	}
+
+	MutexInput struct {
+		ResourceID string
+
+		// Pending are requests received but not granted before the mutex
+		// continued as new, Seen the IDs of the last requests it received
+		Pending []LockRequest
+		Seen    []string
+	}
+)
+
+// temporalClient starts mutex workflows, see ConfigureClient
+var temporalClient client.Client
+
+// ConfigureClient sets the client activities use to coordinate with other
+// workflows
+func ConfigureClient(c client.Client) {
+	temporalClient = c
+}
+
+// MutexWorkflow grants the lock on a shared resource to one workflow at a
+// time, in the order requests arrive. A request released before it was
+// granted was withdrawn and is skipped. It completes once idle and continues
+// as new after a number of grants.
+func MutexWorkflow(ctx workflow.Context, input MutexInput) error {
+	logger := workflow.GetLogger(ctx)
+	resourceID := input.ResourceID
+	acquireCh := workflow.GetSignalChannel(ctx, AcquireLockSignal)
+
+	// Activity retries can deliver a request more than once
+	received := map[string]bool{}
+	seen := append([]string(nil), input.Seen...)
+	for _, requestID := range seen {
+		received[requestID] = true
+	}
+	receive := func(request LockRequest) bool {
+		if received[request.RequestID] {
+			return false
+		}
+		received[request.RequestID] = true
+		seen = append(seen, request.RequestID)
+		return true
+	}
+	withdrawn := func(request LockRequest) bool {
+		return workflow.GetSignalChannel(ctx, releaseSignal(request.RequestID)).ReceiveAsync(nil)
+	}
+
+	pending := input.Pending
+	for grants := 0; grants < mutexGrantsPerRun; {
+		var request LockRequest
+		if len(pending) > 0 {
+			request, pending = pending[0], pending[1:]
+		} else {
+			if !receiveUntilIdle(ctx, acquireCh, &request, mutexIdleTimeout) {
+				return nil
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if !receive(request) {
+				continue
+			}
+		}
+		if withdrawn(request) {
+			logger.Info("Lock request withdrawn", "Resource", resourceID, "WorkflowID", request.WorkflowID)
+			continue
+		}
+
+		grants++
+		if err := workflow.SignalExternalWorkflow(ctx, request.WorkflowID, request.RunID, lockGrantedSignal(request.RequestID), LockGrant{
+			RequestID:     request.RequestID,
+			ReleaseSignal: releaseSignal(request.RequestID),
+		}).Get(ctx, nil); err != nil {
+			logger.Warn("Lock requester is gone", "Resource", resourceID, "WorkflowID", request.WorkflowID, "Error", err)
+			continue
+		}
+
+		logger.Info("Lock granted", "Resource", resourceID, "WorkflowID", request.WorkflowID)
+		if !receiveWithTimeout(ctx, workflow.GetSignalChannel(ctx, releaseSignal(request.RequestID)), nil, request.Lease) {
+			logger.Warn("Lock lease expired", "Resource", resourceID, "WorkflowID", request.WorkflowID, "Lease", request.Lease)
+		}
+	}
+
+	// Requests already signaled would be lost with this run, and so would
+	// their withdrawals
+	next := MutexInput{ResourceID: resourceID}
+	for _, request := range pending {
+		if !withdrawn(request) {
+			next.Pending = append(next.Pending, request)
+		}
+	}
+	var request LockRequest
+	for acquireCh.ReceiveAsync(&request) {
+		if receive(request) && !withdrawn(request) {
+			next.Pending = append(next.Pending, request)
+		}
+		request = LockRequest{}
+	}
+	if len(seen) > mutexRecentRequests {
+		seen = seen[len(seen)-mutexRecentRequests:]
+	}
+	next.Seen = seen
+	return workflow.NewContinueAsNewError(ctx, MutexWorkflow, next)
+}
+
+// receiveWithTimeout receives from ch into v, returning false if nothing
+// arrived within timeout
+func receiveWithTimeout(ctx workflow.Context, ch workflow.ReceiveChannel, v interface{}, timeout time.Duration) bool {
+	received := false
+	timerCtx, cancelTimer := workflow.WithCancel(ctx)
+	defer cancelTimer()
+
+	selector := workflow.NewSelector(ctx)
+	selector.AddReceive(ch, func(c workflow.ReceiveChannel, more bool) {
+		c.Receive(ctx, v)
+		received = true
+	})
+	selector.AddFuture(workflow.NewTimer(timerCtx, timeout), func(f workflow.Future) {})
+	selector.Select(ctx)
+	return received
+}
+
+// receiveUntilIdle receives from ch into v, returning false if nothing
+// arrived within timeout. A signal that raced the timeout is still
+// received, a workflow completing on false would drop it.
+func receiveUntilIdle(ctx workflow.Context, ch workflow.ReceiveChannel, v interface{}, timeout time.Duration) bool {
+	return receiveWithTimeout(ctx, ch, v, timeout) || ch.ReceiveAsync(v)
+}
+
+// RequestLockActivity queues a lock request with the resource's
+// MutexWorkflow, starting it if it isn't running
+func RequestLockActivity(ctx context.Context, input RequestLockInput) error {
+	if temporalClient == nil {
+		return temporal.NewNonRetryableApplicationError("no temporal client configured for locking", "MutexUnavailable", nil)
+	}
+
+	_, err := temporalClient.SignalWithStartWorkflow(ctx, mutexWorkflowID(input.ResourceID), AcquireLockSignal, input.Request,
+		client.StartWorkflowOptions{
+			ID:        mutexWorkflowID(input.ResourceID),
+			TaskQueue: workflowTaskQueue(ctx),
+		}, MutexWorkflow, MutexInput{ResourceID: input.ResourceID})
+	return err
+}
+
+// lockResource blocks until the workflow holds the lock on resourceID. The
+// lock is held until unlock is called or lease runs out. A workflow canceled
+// while waiting withdraws its request.
+func lockResource(ctx workflow.Context, resourceID string, lease time.Duration) (unlock func(), err error) {
+	info := workflow.GetInfo(ctx)
+	request := LockRequest{
+		RequestID:  fmt.Sprintf("%s-%d", info.WorkflowExecution.RunID, workflow.Now(ctx).UnixNano()),
+		WorkflowID: info.WorkflowExecution.ID,
+		RunID:      info.WorkflowExecution.RunID,
+		Lease:      lease,
+	}
+
+	activityCtx := workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: 30 * time.Second,
+		RetryPolicy: &temporal.RetryPolicy{
+			InitialInterval:    5 * time.Second,
+			BackoffCoefficient: 1.3,
+			MaximumInterval:    time.Minute,
+		},
+	})
+	// Releasing a request that wasn't granted yet withdraws it, so a
+	// canceled workflow never holds the lock until the lease runs out
+	unlock = func() {
+		// Released even if the workflow was canceled while holding the lock
+		releaseCtx, cancel := workflow.NewDisconnectedContext(ctx)
+		defer cancel()
+		if err := workflow.SignalExternalWorkflow(releaseCtx, mutexWorkflowID(resourceID), "", releaseSignal(request.RequestID), nil).Get(releaseCtx, nil); err != nil {
+			workflow.GetLogger(ctx).Warn("Unable to release lock, it will expire", "Resource", resourceID, "Error", err)
+		}
+	}
+
+	if err := workflow.ExecuteActivity(activityCtx, RequestLockActivity, RequestLockInput{
+		ResourceID: resourceID,
+		Request:    request,
+	}).Get(ctx, nil); err != nil {
+		if ctx.Err() != nil {
+			unlock()
+		}
+		return nil, err
+	}
+
+	workflow.GetSignalChannel(ctx, lockGrantedSignal(request.RequestID)).Receive(ctx, nil)
+	if ctx.Err() != nil {
+		unlock()
+		return nil, ctx.Err()
+	}
+	return unlock, nil
+}
+
+// lockGrantedSignal tells the requester of a lock it holds it, each request
+// has its own so grants meant for other requests aren't consumed
+func lockGrantedSignal(requestID string) string {
+	return LockGrantedSignal + "-" + requestID
+}
+
+// releaseSignal releases a granted lock or withdraws a request
+func releaseSignal(requestID string) string {
+	return "release-" + requestID
+}
+
+func mutexWorkflowID(resourceID string) string {
+	return "mutex-" + resourceID
+}
@@ -0,0 +1,99 @@
+package workflows
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	enumspb "go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/serviceerror"
+	"go.temporal.io/api/workflowservice/v1"
+	"go.temporal.io/sdk/client"
+)
+
+// requiredSearchAttributes are the types the inventory search attributes
+// are upserted with
+var requiredSearchAttributes = map[string]enumspb.IndexedValueType{
+	ResourceCountAttribute: enumspb.INDEXED_VALUE_TYPE_INT,
+	ProvidersAttribute:     enumspb.INDEXED_VALUE_TYPE_KEYWORD,
+}
+
+// EnsureNamespace registers the namespace of options with the given
+// workflow retention unless it exists, and fails if it exists but was
+// deprecated. Newly registered namespaces can take a few seconds to reach
+// every frontend, pollers retry until then.
+func EnsureNamespace(ctx context.Context, options client.Options, retention time.Duration) error {
+	namespaces, err := client.NewNamespaceClient(options)
+	if err != nil {
+		return fmt.Errorf("error connecting to temporal: %w", err)
+	}
+	defer namespaces.Close()
+
+	described, err := namespaces.Describe(ctx, options.Namespace)
+	var notFound *serviceerror.NotFound
+	switch {
+	case errors.As(err, &notFound):
+		err = namespaces.Register(ctx, &workflowservice.RegisterNamespaceRequest{
+			Namespace:                        options.Namespace,
+			Description:                      "terraform workflows",
+			WorkflowExecutionRetentionPeriod: &retention,
+		})
+		var exists *serviceerror.NamespaceAlreadyExists
+		if err != nil && !errors.As(err, &exists) {
+			return fmt.Errorf("error registering namespace %s: %w", options.Namespace, err)
+		}
+		log.Printf("registered namespace %s with %s retention", options.Namespace, retention)
+		return nil
+	case err != nil:
+		return fmt.Errorf("error describing namespace %s: %w", options.Namespace, err)
+	}
+
+	if state := described.GetNamespaceInfo().GetState(); state != enumspb.NAMESPACE_STATE_REGISTERED {
+		return fmt.Errorf("namespace %s is %s, workflows can't be started in it", options.Namespace, state)
+	}
+	return nil
+}
+
+// VerifyCluster checks the cluster is reachable and, if the worker upserts
+// search attributes, that they are registered with the right types. The
+// worker can't register them itself, see `make search-attributes`.
+func VerifyCluster(ctx context.Context, c client.Client, searchAttributes bool) error {
+	info, err := c.WorkflowService().GetClusterInfo(ctx, &workflowservice.GetClusterInfoRequest{})
+	var unimplemented *serviceerror.Unimplemented
+	switch {
+	case errors.As(err, &unimplemented):
+		log.Print("temporal server doesn't report its version")
+	case err != nil:
+		return fmt.Errorf("error reaching temporal: %w", err)
+	default:
+		log.Printf("connected to temporal %s cluster %s", info.GetServerVersion(), info.GetClusterName())
+	}
+
+	if !searchAttributes {
+		return nil
+	}
+
+	registered, err := c.GetSearchAttributes(ctx)
+	if err != nil {
+		return fmt.Errorf("error listing search attributes: %w", err)
+	}
+	var problems []string
+	for name, want := range requiredSearchAttributes {
+		got, ok := registered.GetKeys()[name]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("%s is not registered", name))
+		case got != want:
+			problems = append(problems, fmt.Sprintf("%s is %s, not %s", name, got, want))
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("search attributes aren't usable (%s), register them with `make search-attributes`", strings.Join(problems, ", "))
+	}
+	return nil
+}
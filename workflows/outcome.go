@@ -0,0 +1,121 @@
+package workflows
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+
+	"github.com/dynajoe/temporal-terraform-demo/config/awsconfig"
+	"github.com/dynajoe/temporal-terraform-demo/tfworkspace"
+)
+
+// Outcome kinds of runs that were stopped rather than failed
+const (
+	OutcomeCanceled = "canceled"
+	OutcomeRejected = "rejected"
+	OutcomeBlocked  = "blocked"
+)
+
+// blockingErrors are the error types raised when a policy refuses a run
+var blockingErrors = map[string]bool{
+	"ChangeFreeze":           true,
+	"NetworkPeered":          true,
+	"StackNotAllowed":        true,
+	"SandboxPolicyViolation": true,
+	"TenantPathDenied":       true,
+	"TenantRoleDenied":       true,
+}
+
+type RecordOutcomeInput struct {
+	Stack   StackRef
+	Outcome tfworkspace.Outcome
+}
+
+// recordOutcome publishes why the run stopped on its status and in the
+// history of its stacks. Failures other than cancellations, rejections and
+// policy refusals are left to the execution reports.
+func recordOutcome(ctx workflow.Context, status *Status, err error, stacks ...StackRef) {
+	outcome, ok := runOutcome(err, status.Phase)
+	if !ok {
+		return
+	}
+	status.Outcome = &outcome
+	if !hasChange(ctx, recordOutcomeVersion) {
+		return
+	}
+
+	// A canceled run's context can't schedule activities anymore
+	ctx, _ = workflow.NewDisconnectedContext(ctx)
+	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: time.Minute,
+		RetryPolicy: &temporal.RetryPolicy{
+			MaximumAttempts: 5,
+		},
+	})
+
+	var futures []workflow.Future
+	for _, stack := range stacks {
+		futures = append(futures, workflow.ExecuteActivity(ctx, RecordOutcomeActivity, RecordOutcomeInput{
+			Stack:   stack,
+			Outcome: outcome,
+		}))
+	}
+	for _, future := range futures {
+		if err := future.Get(ctx, nil); err != nil {
+			workflow.GetLogger(ctx).Error("Unable to record run outcome", "Kind", outcome.Kind, "Error", err)
+		}
+	}
+}
+
+// runOutcome classifies the error a workflow stopped with, ok is false for
+// plain failures
+func runOutcome(err error, phase string) (_ tfworkspace.Outcome, ok bool) {
+	if err == nil {
+		return tfworkspace.Outcome{}, false
+	}
+
+	if temporal.IsCanceledError(err) {
+		return tfworkspace.Outcome{Kind: OutcomeCanceled, Reason: "workflow canceled", Phase: phase}, true
+	}
+
+	var appErr *temporal.ApplicationError
+	if !errors.As(err, &appErr) {
+		return tfworkspace.Outcome{}, false
+	}
+	switch {
+	case appErr.Type() == "PlanRejected":
+		outcome := tfworkspace.Outcome{Kind: OutcomeRejected, Reason: appErr.Error(), Phase: phase}
+		var approval Approval
+		if appErr.HasDetails() && appErr.Details(&approval) == nil {
+			outcome.By = approval.By
+			outcome.Reason = approval.Reason
+		}
+		return outcome, true
+	case blockingErrors[appErr.Type()]:
+		outcome := tfworkspace.Outcome{Kind: OutcomeBlocked, Reason: appErr.Error(), Phase: phase}
+		var freeze ChangeFreeze
+		if appErr.Type() == "ChangeFreeze" && appErr.HasDetails() && appErr.Details(&freeze) == nil {
+			outcome.By = freeze.By
+		}
+		return outcome, true
+	}
+	return tfworkspace.Outcome{}, false
+}
+
+func RecordOutcomeActivity(ctx context.Context, input RecordOutcomeInput) error {
+	awsConfig := awsconfig.LoadConfig()
+
+	backend, err := StateBackend(ctx, awsConfig.Credentials, input.Stack)
+	if err != nil {
+		return err
+	}
+
+	// Matches the run id tfactivity gives the run's own reports
+	info := activity.GetInfo(ctx)
+	runID := info.WorkflowExecution.ID + "_" + info.WorkflowExecution.RunID
+	return tfworkspace.RecordOutcome(ctx, backend, input.Stack.TerraformPath, runID, input.Outcome)
+}
@@ -0,0 +1,80 @@
+package workflows
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/dynajoe/temporal-terraform-demo/tfexec"
+	"github.com/dynajoe/temporal-terraform-demo/tfstate"
+)
+
+type (
+	// outputCache holds the outputs of stack states read to resolve
+	// StackOutputRefs, keyed by bucket and state key
+	outputCache struct {
+		mu      sync.Mutex
+		ttl     time.Duration
+		entries map[string]cachedOutputs
+
+		// generation changes whenever entries are dropped, a state read
+		// that overlapped it may be stale and isn't cached
+		generation uint64
+	}
+
+	cachedOutputs struct {
+		outputs map[string]tfstate.Output
+		expires time.Time
+	}
+)
+
+var stackOutputs = &outputCache{}
+
+// ConfigureOutputCache keeps the outputs read from a stack's state for up
+// to ttl, zero disables the cache. Applies and destroys run by this worker
+// drop the stack's entry right away, changes made through other workers
+// are seen once it expires.
+func ConfigureOutputCache(ttl time.Duration) {
+	stackOutputs.mu.Lock()
+	defer stackOutputs.mu.Unlock()
+	stackOutputs.ttl = ttl
+	stackOutputs.entries = map[string]cachedOutputs{}
+	stackOutputs.generation++
+}
+
+// InvalidateStackOutputs drops the cached outputs of a state, see
+// tfactivity.WorkerOptions.OnStateWritten
+func InvalidateStackOutputs(bucket string, key string) {
+	stackOutputs.mu.Lock()
+	defer stackOutputs.mu.Unlock()
+	delete(stackOutputs.entries, bucket+"/"+key)
+	stackOutputs.generation++
+}
+
+// loadStackOutputs returns the outputs of the backend's state, reading the
+// state only if they aren't cached
+func loadStackOutputs(ctx context.Context, backend tfexec.S3BackendConfig) (map[string]tfstate.Output, error) {
+	id := backend.Bucket + "/" + backend.Key
+	now := time.Now()
+
+	stackOutputs.mu.Lock()
+	ttl, generation := stackOutputs.ttl, stackOutputs.generation
+	entry, ok := stackOutputs.entries[id]
+	stackOutputs.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.outputs, nil
+	}
+
+	state, err := tfstate.Load(ctx, backend)
+	if err != nil {
+		return nil, err
+	}
+	if ttl > 0 {
+		stackOutputs.mu.Lock()
+		if stackOutputs.generation == generation {
+			stackOutputs.entries[id] = cachedOutputs{outputs: state.Outputs, expires: now.Add(ttl)}
+		}
+		stackOutputs.mu.Unlock()
+	}
+	return state.Outputs, nil
+}
@@ -0,0 +1,65 @@
+package workflows
+
+import (
+	"go.temporal.io/sdk/workflow"
+)
+
+const (
+	// PauseSignal holds a workflow at its next safe point, before it plans
+	// or applies, until ResumeSignal is received
+	PauseSignal = "pause"
+
+	// ResumeSignal lets a paused workflow continue
+	ResumeSignal = "resume"
+)
+
+// PauseRequest is the payload of PauseSignal
+type PauseRequest struct {
+	By     string
+	Reason string
+}
+
+// trackPause handles pause and resume signals for the rest of the workflow,
+// reporting them on the status. Pausing takes effect at the next call to
+// pausePoint.
+func trackPause(ctx workflow.Context, status *Status) {
+	pauseCh := workflow.GetSignalChannel(ctx, PauseSignal)
+	resumeCh := workflow.GetSignalChannel(ctx, ResumeSignal)
+
+	workflow.Go(ctx, func(ctx workflow.Context) {
+		for {
+			selector := workflow.NewSelector(ctx)
+			selector.AddReceive(pauseCh, func(c workflow.ReceiveChannel, more bool) {
+				var request PauseRequest
+				c.Receive(ctx, &request)
+				status.Paused = true
+				status.PausedBy = request.By
+				status.PausedReason = request.Reason
+				workflow.GetLogger(ctx).Info("Pause requested", "By", request.By, "Reason", request.Reason)
+			})
+			selector.AddReceive(resumeCh, func(c workflow.ReceiveChannel, more bool) {
+				c.Receive(ctx, nil)
+				status.Paused = false
+				status.PausedBy = ""
+				status.PausedReason = ""
+				workflow.GetLogger(ctx).Info("Resumed")
+			})
+			selector.Select(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+		}
+	})
+}
+
+// pausePoint blocks while the workflow is paused
+func pausePoint(ctx workflow.Context, status *Status) error {
+	if !status.Paused || !hasChange(ctx, pauseVersion) {
+		return nil
+	}
+
+	phase := status.Phase
+	status.Phase = "paused before " + phase
+	defer func() { status.Phase = phase }()
+	return workflow.Await(ctx, func() bool { return !status.Paused })
+}
@@ -0,0 +1,705 @@
+package workflows
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+
+	"github.com/dynajoe/temporal-terraform-demo/config/awsconfig"
+	"github.com/dynajoe/temporal-terraform-demo/s3object"
+	"github.com/dynajoe/temporal-terraform-demo/stacks"
+	"github.com/dynajoe/temporal-terraform-demo/tfactivity"
+	"github.com/dynajoe/temporal-terraform-demo/tfstate"
+	"github.com/dynajoe/temporal-terraform-demo/tfworkspace"
+)
+
+type (
+	// PeerNetworksInput names two networks created by
+	// CreateDemoNetworkWorkflow in the same account and region
+	PeerNetworksInput struct {
+		Requester string
+		Accepter  string
+		Region    string
+	}
+
+	PeerNetworksOutput struct {
+		Status              ResultStatus
+		PeeringConnectionID string
+	}
+
+	CreatePeeringInput struct {
+		Requester      string
+		Accepter       string
+		Region         string
+		RequesterVpcID string
+		AccepterVpcID  string
+	}
+
+	CreatePeeringOutput struct {
+		Status              ResultStatus
+		PeeringConnectionID string
+		Inventory           tfworkspace.Inventory
+	}
+
+	// PeerRoutesInput routes Network's traffic for Peer over the peering
+	PeerRoutesInput struct {
+		Peering             string
+		Network             string
+		Peer                string
+		Region              string
+		VpcID               string
+		PeerVpcID           string
+		PeeringConnectionID string
+	}
+
+	PeerRoutesOutput struct {
+		Status    ResultStatus
+		Inventory tfworkspace.Inventory
+	}
+
+	// NetworkPeerings records the networks a network is peered with, which
+	// keeps it from being destroyed
+	NetworkPeerings struct {
+		Network string
+		Peers   []string
+	}
+
+	// UnpeerNetworksInput names the networks of a peering made by
+	// PeerNetworksWorkflow, in either order
+	UnpeerNetworksInput struct {
+		Requester string
+		Accepter  string
+		Region    string
+	}
+
+	UnpeerNetworksOutput struct {
+		Status ResultStatus
+	}
+
+	// Peering is a peering found in state, with the networks in the order
+	// it was created. PeeringConnectionID is empty if none was provisioned.
+	Peering struct {
+		Requester           string
+		Accepter            string
+		PeeringConnectionID string
+	}
+
+	DestroyPeeringInput struct {
+		Requester      string
+		Accepter       string
+		Region         string
+		RequesterVpcID string
+		AccepterVpcID  string
+	}
+)
+
+const (
+	// peeringRecordTimeout bounds updating the peering records of both
+	// networks, retries included
+	peeringRecordTimeout = 5 * time.Minute
+
+	// peeringRecordLease is how long the records' locks are held at most,
+	// longer than the update may take
+	peeringRecordLease = 2 * peeringRecordTimeout
+)
+
+// PeerNetworksWorkflow peers two networks and routes between them. The
+// peering is recorded on both networks before anything is provisioned so
+// neither can be destroyed underneath it.
+func PeerNetworksWorkflow(ctx workflow.Context, input PeerNetworksInput) (PeerNetworksOutput, error) {
+	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: time.Hour,
+		HeartbeatTimeout:    time.Minute,
+		RetryPolicy: &temporal.RetryPolicy{
+			InitialInterval:    5 * time.Second,
+			BackoffCoefficient: 1.3,
+			MaximumInterval:    time.Minute,
+		},
+	})
+
+	if input.Requester == "" || input.Accepter == "" || input.Requester == input.Accepter {
+		return PeerNetworksOutput{}, temporal.NewNonRetryableApplicationError("peering needs two different networks", "InvalidInput", nil)
+	}
+
+	status, err := trackStatus(ctx)
+	if err != nil {
+		return PeerNetworksOutput{}, err
+	}
+
+	status.Phase = "checking change freeze"
+	if err := waitForThaw(ctx, status); err != nil {
+		return PeerNetworksOutput{}, err
+	}
+
+	status.Phase = "resolving networks"
+	requesterFuture := workflow.ExecuteActivity(ctx, ResolveNetworkActivity, input.Requester)
+	accepterFuture := workflow.ExecuteActivity(ctx, ResolveNetworkActivity, input.Accepter)
+	var requesterVpcID, accepterVpcID string
+	if err := requesterFuture.Get(ctx, &requesterVpcID); err != nil {
+		return PeerNetworksOutput{}, err
+	}
+	if err := accepterFuture.Get(ctx, &accepterVpcID); err != nil {
+		return PeerNetworksOutput{}, err
+	}
+
+	status.Phase = "recording peering"
+	if err := updatePeerings(ctx, RecordPeeringActivity, input); err != nil {
+		return PeerNetworksOutput{}, err
+	}
+
+	status.Phase = "creating peering"
+	var peeringOutput CreatePeeringOutput
+	if err := workflow.ExecuteActivity(ctx, CreatePeeringActivity, CreatePeeringInput{
+		Requester:      input.Requester,
+		Accepter:       input.Accepter,
+		Region:         input.Region,
+		RequesterVpcID: requesterVpcID,
+		AccepterVpcID:  accepterVpcID,
+	}).Get(ctx, &peeringOutput); err != nil {
+		return PeerNetworksOutput{}, err
+	}
+
+	// Each side's routes are their own stack, updated side by side
+	status.Phase = "updating routes"
+	peering := peeringName(input.Requester, input.Accepter)
+	workflowID := workflow.GetInfo(ctx).WorkflowExecution.ID
+	sides := []PeerRoutesInput{
+		{Network: input.Requester, Peer: input.Accepter, VpcID: requesterVpcID, PeerVpcID: accepterVpcID},
+		{Network: input.Accepter, Peer: input.Requester, VpcID: accepterVpcID, PeerVpcID: requesterVpcID},
+	}
+	var futures []workflow.ChildWorkflowFuture
+	for _, side := range sides {
+		side.Peering = peering
+		side.Region = input.Region
+		side.PeeringConnectionID = peeringOutput.PeeringConnectionID
+		childCtx := workflow.WithChildOptions(ctx, workflow.ChildWorkflowOptions{
+			WorkflowID: fmt.Sprintf("%s-routes-%s", workflowID, side.Network),
+		})
+		futures = append(futures, workflow.ExecuteChildWorkflow(childCtx, PeerRoutesWorkflow, side))
+	}
+	result := peeringOutput.Status
+	inventories := []tfworkspace.Inventory{peeringOutput.Inventory}
+	for _, future := range futures {
+		var routesOutput PeerRoutesOutput
+		if err := future.Get(ctx, &routesOutput); err != nil {
+			return PeerNetworksOutput{}, err
+		}
+		result = result.combine(routesOutput.Status)
+		inventories = append(inventories, routesOutput.Inventory)
+	}
+	if err := recordInventory(ctx, inventories...); err != nil {
+		return PeerNetworksOutput{}, err
+	}
+
+	status.Phase = "completed"
+	return PeerNetworksOutput{
+		Status:              result,
+		PeeringConnectionID: peeringOutput.PeeringConnectionID,
+	}, nil
+}
+
+// PeerRoutesWorkflow routes one side of a peering
+func PeerRoutesWorkflow(ctx workflow.Context, input PeerRoutesInput) (PeerRoutesOutput, error) {
+	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: time.Hour,
+		HeartbeatTimeout:    time.Minute,
+		RetryPolicy: &temporal.RetryPolicy{
+			InitialInterval:    5 * time.Second,
+			BackoffCoefficient: 1.3,
+			MaximumInterval:    time.Minute,
+		},
+	})
+
+	status, err := trackStatus(ctx)
+	if err != nil {
+		return PeerRoutesOutput{}, err
+	}
+
+	status.Phase = "updating routes"
+	var output PeerRoutesOutput
+	if err := workflow.ExecuteActivity(ctx, ApplyPeerRoutesActivity, input).Get(ctx, &output); err != nil {
+		return PeerRoutesOutput{}, err
+	}
+
+	status.Phase = "completed"
+	return output, nil
+}
+
+// UnpeerNetworksWorkflow removes the routes and the peering connection made
+// by PeerNetworksWorkflow, then the peering's records, so the networks can
+// be destroyed
+func UnpeerNetworksWorkflow(ctx workflow.Context, input UnpeerNetworksInput) (UnpeerNetworksOutput, error) {
+	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: time.Hour,
+		HeartbeatTimeout:    time.Minute,
+		RetryPolicy: &temporal.RetryPolicy{
+			InitialInterval:    5 * time.Second,
+			BackoffCoefficient: 1.3,
+			MaximumInterval:    time.Minute,
+		},
+	})
+
+	if input.Requester == "" || input.Accepter == "" || input.Requester == input.Accepter {
+		return UnpeerNetworksOutput{}, temporal.NewNonRetryableApplicationError("peering needs two different networks", "InvalidInput", nil)
+	}
+
+	status, err := trackStatus(ctx)
+	if err != nil {
+		return UnpeerNetworksOutput{}, err
+	}
+
+	status.Phase = "checking change freeze"
+	if err := waitForThaw(ctx, status); err != nil {
+		return UnpeerNetworksOutput{}, err
+	}
+
+	status.Phase = "finding peering"
+	var peering Peering
+	if err := workflow.ExecuteActivity(ctx, ResolvePeeringActivity, input).Get(ctx, &peering); err != nil {
+		return UnpeerNetworksOutput{}, err
+	}
+
+	result := StatusNoChanges
+	if peering.PeeringConnectionID != "" {
+		status.Phase = "resolving networks"
+		requesterFuture := workflow.ExecuteActivity(ctx, ResolveNetworkActivity, peering.Requester)
+		accepterFuture := workflow.ExecuteActivity(ctx, ResolveNetworkActivity, peering.Accepter)
+		var requesterVpcID, accepterVpcID string
+		if err := requesterFuture.Get(ctx, &requesterVpcID); err != nil {
+			return UnpeerNetworksOutput{}, err
+		}
+		if err := accepterFuture.Get(ctx, &accepterVpcID); err != nil {
+			return UnpeerNetworksOutput{}, err
+		}
+
+		// The routes point at the peering connection, they go first
+		status.Phase = "removing routes"
+		name := peeringName(peering.Requester, peering.Accepter)
+		sides := []PeerRoutesInput{
+			{Network: peering.Requester, Peer: peering.Accepter, VpcID: requesterVpcID, PeerVpcID: accepterVpcID},
+			{Network: peering.Accepter, Peer: peering.Requester, VpcID: accepterVpcID, PeerVpcID: requesterVpcID},
+		}
+		var futures []workflow.Future
+		for _, side := range sides {
+			side.Peering = name
+			side.Region = input.Region
+			side.PeeringConnectionID = peering.PeeringConnectionID
+			futures = append(futures, workflow.ExecuteActivity(ctx, DestroyPeerRoutesActivity, side))
+		}
+		for _, future := range futures {
+			if err := future.Get(ctx, nil); err != nil {
+				return UnpeerNetworksOutput{}, err
+			}
+		}
+
+		status.Phase = "destroying peering"
+		if err := workflow.ExecuteActivity(ctx, DestroyPeeringActivity, DestroyPeeringInput{
+			Requester:      peering.Requester,
+			Accepter:       peering.Accepter,
+			Region:         input.Region,
+			RequesterVpcID: requesterVpcID,
+			AccepterVpcID:  accepterVpcID,
+		}).Get(ctx, nil); err != nil {
+			return UnpeerNetworksOutput{}, err
+		}
+		result = StatusApplied
+	}
+
+	status.Phase = "removing peering records"
+	if err := updatePeerings(ctx, RemovePeeringActivity, PeerNetworksInput(input)); err != nil {
+		return UnpeerNetworksOutput{}, err
+	}
+
+	status.Phase = "completed"
+	return UnpeerNetworksOutput{Status: result}, nil
+}
+
+// ResolveNetworkActivity returns the vpc id a network's vpc stack output
+func ResolveNetworkActivity(ctx context.Context, network string) (string, error) {
+	awsConfig := awsconfig.LoadConfig()
+
+	stack := StackRef{TerraformPath: "core:aws/vpc", StateKey: fmt.Sprintf("vpc-%s.tfstate", network)}
+	backend, err := StateBackend(ctx, awsConfig.Credentials, stack)
+	if err != nil {
+		return "", err
+	}
+
+	state, err := tfstate.Load(ctx, backend)
+	if errors.Is(err, s3object.ErrNotFound) {
+		return "", temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("network %s has no vpc state at %s", network, stack.StateKey), "NetworkNotFound", nil)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var vpcID string
+	if err := state.OutputValue("vpc_id", &vpcID); err != nil {
+		return "", temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("network %s: %v", network, err), "NetworkNotFound", nil)
+	}
+	return vpcID, nil
+}
+
+func CreatePeeringActivity(ctx context.Context, input CreatePeeringInput) (CreatePeeringOutput, error) {
+	awsConfig := awsconfig.LoadConfig()
+
+	peering := peeringName(input.Requester, input.Accepter)
+	stack := peeringStack(input.Requester, input.Accepter)
+	backend, err := StateBackend(ctx, awsConfig.Credentials, stack)
+	if err != nil {
+		return CreatePeeringOutput{}, err
+	}
+
+	credentials, err := terraformCredentials(ctx, awsConfig, "")
+	if err != nil {
+		return CreatePeeringOutput{}, err
+	}
+
+	tfa := tfactivity.New(tfworkspace.Config{
+		TerraformPath: stack.TerraformPath,
+		S3Backend:     backend,
+		Reports:       true,
+		Outputs:       stacks.VpcPeeringOutputContract(),
+	})
+
+	applyOutput, err := tfa.Apply(ctx, tfworkspace.ApplyInput{
+		AwsCredentials: credentials,
+		Env: map[string]string{
+			"AWS_REGION": input.Region,
+		},
+		Vars: stacks.VpcPeeringVars{
+			Name:           peering,
+			RequesterVpcID: input.RequesterVpcID,
+			AccepterVpcID:  input.AccepterVpcID,
+		}.Vars(),
+	})
+	if err != nil {
+		return CreatePeeringOutput{}, err
+	}
+
+	peeringOutputs, err := stacks.DecodeVpcPeeringOutputs(applyOutput)
+	if err != nil {
+		return CreatePeeringOutput{}, err
+	}
+
+	return CreatePeeringOutput{
+		Status:              appliedStatus(applyOutput.Changed),
+		PeeringConnectionID: peeringOutputs.PeeringConnectionID,
+		Inventory:           applyOutput.Inventory,
+	}, nil
+}
+
+func ApplyPeerRoutesActivity(ctx context.Context, input PeerRoutesInput) (PeerRoutesOutput, error) {
+	awsConfig := awsconfig.LoadConfig()
+
+	stack := peerRoutesStack(input.Peering, input.Network)
+	backend, err := StateBackend(ctx, awsConfig.Credentials, stack)
+	if err != nil {
+		return PeerRoutesOutput{}, err
+	}
+
+	credentials, err := terraformCredentials(ctx, awsConfig, "")
+	if err != nil {
+		return PeerRoutesOutput{}, err
+	}
+
+	tfa := tfactivity.New(tfworkspace.Config{
+		TerraformPath: stack.TerraformPath,
+		S3Backend:     backend,
+		Reports:       true,
+		Outputs:       stacks.VpcPeeringRoutesOutputContract(),
+	})
+
+	applyOutput, err := tfa.Apply(ctx, tfworkspace.ApplyInput{
+		AwsCredentials: credentials,
+		Env: map[string]string{
+			"AWS_REGION": input.Region,
+		},
+		Vars: stacks.VpcPeeringRoutesVars{
+			VpcID:               input.VpcID,
+			PeerVpcID:           input.PeerVpcID,
+			PeeringConnectionID: input.PeeringConnectionID,
+		}.Vars(),
+	})
+	if err != nil {
+		return PeerRoutesOutput{}, err
+	}
+
+	return PeerRoutesOutput{
+		Status:    appliedStatus(applyOutput.Changed),
+		Inventory: applyOutput.Inventory,
+	}, nil
+}
+
+// ResolvePeeringActivity finds the peering stack of two networks, which is
+// named for the order they were peered in
+func ResolvePeeringActivity(ctx context.Context, input UnpeerNetworksInput) (Peering, error) {
+	awsConfig := awsconfig.LoadConfig()
+
+	for _, peering := range []Peering{
+		{Requester: input.Requester, Accepter: input.Accepter},
+		{Requester: input.Accepter, Accepter: input.Requester},
+	} {
+		stack := peeringStack(peering.Requester, peering.Accepter)
+		backend, err := StateBackend(ctx, awsConfig.Credentials, stack)
+		if err != nil {
+			return Peering{}, err
+		}
+
+		state, err := tfstate.Load(ctx, backend)
+		if errors.Is(err, s3object.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return Peering{}, err
+		}
+
+		// A destroyed stack keeps its state without outputs
+		if err := state.OutputValue("peering_connection_id", &peering.PeeringConnectionID); err != nil {
+			continue
+		}
+		return peering, nil
+	}
+	return Peering{Requester: input.Requester, Accepter: input.Accepter}, nil
+}
+
+func DestroyPeeringActivity(ctx context.Context, input DestroyPeeringInput) error {
+	awsConfig := awsconfig.LoadConfig()
+
+	stack := peeringStack(input.Requester, input.Accepter)
+	backend, err := StateBackend(ctx, awsConfig.Credentials, stack)
+	if err != nil {
+		return err
+	}
+
+	credentials, err := terraformCredentials(ctx, awsConfig, "")
+	if err != nil {
+		return err
+	}
+
+	tfa := tfactivity.New(tfworkspace.Config{
+		TerraformPath: stack.TerraformPath,
+		S3Backend:     backend,
+		Reports:       true,
+	})
+
+	return tfa.Destroy(ctx, tfworkspace.DestroyInput{
+		AwsCredentials: credentials,
+		Env: map[string]string{
+			"AWS_REGION": input.Region,
+		},
+		Vars: stacks.VpcPeeringVars{
+			Name:           peeringName(input.Requester, input.Accepter),
+			RequesterVpcID: input.RequesterVpcID,
+			AccepterVpcID:  input.AccepterVpcID,
+		}.Vars(),
+	})
+}
+
+func DestroyPeerRoutesActivity(ctx context.Context, input PeerRoutesInput) error {
+	awsConfig := awsconfig.LoadConfig()
+
+	stack := peerRoutesStack(input.Peering, input.Network)
+	backend, err := StateBackend(ctx, awsConfig.Credentials, stack)
+	if err != nil {
+		return err
+	}
+
+	credentials, err := terraformCredentials(ctx, awsConfig, "")
+	if err != nil {
+		return err
+	}
+
+	tfa := tfactivity.New(tfworkspace.Config{
+		TerraformPath: stack.TerraformPath,
+		S3Backend:     backend,
+		Reports:       true,
+	})
+
+	return tfa.Destroy(ctx, tfworkspace.DestroyInput{
+		AwsCredentials: credentials,
+		Env: map[string]string{
+			"AWS_REGION": input.Region,
+		},
+		Vars: stacks.VpcPeeringRoutesVars{
+			VpcID:               input.VpcID,
+			PeerVpcID:           input.PeerVpcID,
+			PeeringConnectionID: input.PeeringConnectionID,
+		}.Vars(),
+	})
+}
+
+// updatePeerings runs an activity changing the peering records of both
+// networks while holding their locks, so concurrent peerings of a network
+// don't overwrite each other's records
+func updatePeerings(ctx workflow.Context, activity interface{}, input PeerNetworksInput) error {
+	// Taken in a fixed order so two updates can't each hold one lock
+	networks := []string{input.Requester, input.Accepter}
+	sort.Strings(networks)
+	var unlocks []func()
+	defer func() {
+		for i := len(unlocks) - 1; i >= 0; i-- {
+			unlocks[i]()
+		}
+	}()
+	for _, network := range networks {
+		unlock, err := lockResource(ctx, "peerings-"+network, peeringRecordLease)
+		if err != nil {
+			return err
+		}
+		unlocks = append(unlocks, unlock)
+	}
+
+	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout:    30 * time.Second,
+		ScheduleToCloseTimeout: peeringRecordTimeout,
+		RetryPolicy: &temporal.RetryPolicy{
+			InitialInterval:    5 * time.Second,
+			BackoffCoefficient: 1.3,
+			MaximumInterval:    time.Minute,
+		},
+	})
+	return workflow.ExecuteActivity(ctx, activity, input).Get(ctx, nil)
+}
+
+// RecordPeeringActivity records the peering on both networks
+func RecordPeeringActivity(ctx context.Context, input PeerNetworksInput) error {
+	route := controlRoute(ctx)
+	client := route.objects(awsconfig.LoadConfig().Credentials)
+	for network, peer := range map[string]string{input.Requester: input.Accepter, input.Accepter: input.Requester} {
+		peerings, err := LoadNetworkPeerings(ctx, client, route.Bucket, network)
+		if err != nil {
+			return err
+		}
+		if peerings.has(peer) {
+			continue
+		}
+		peerings.Peers = append(peerings.Peers, peer)
+		sort.Strings(peerings.Peers)
+		if err := storeNetworkPeerings(ctx, client, route.Bucket, peerings); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemovePeeringActivity removes the peering from the records of both
+// networks
+func RemovePeeringActivity(ctx context.Context, input PeerNetworksInput) error {
+	route := controlRoute(ctx)
+	client := route.objects(awsconfig.LoadConfig().Credentials)
+	for network, peer := range map[string]string{input.Requester: input.Accepter, input.Accepter: input.Requester} {
+		peerings, err := LoadNetworkPeerings(ctx, client, route.Bucket, network)
+		if err != nil {
+			return err
+		}
+		if !peerings.has(peer) {
+			continue
+		}
+		peers := peerings.Peers[:0]
+		for _, p := range peerings.Peers {
+			if p != peer {
+				peers = append(peers, p)
+			}
+		}
+		peerings.Peers = peers
+		if err := storeNetworkPeerings(ctx, client, route.Bucket, peerings); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func CheckNetworkPeeringsActivity(ctx context.Context, network string) (NetworkPeerings, error) {
+	route := controlRoute(ctx)
+	return LoadNetworkPeerings(ctx, route.objects(awsconfig.LoadConfig().Credentials), route.Bucket, network)
+}
+
+// refuseIfPeered fails the workflow when the network is peered with another
+func refuseIfPeered(ctx workflow.Context, network string) error {
+	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: 30 * time.Second,
+		RetryPolicy: &temporal.RetryPolicy{
+			InitialInterval:    5 * time.Second,
+			BackoffCoefficient: 1.3,
+			MaximumInterval:    time.Minute,
+		},
+	})
+
+	var peerings NetworkPeerings
+	if err := workflow.ExecuteActivity(ctx, CheckNetworkPeeringsActivity, network).Get(ctx, &peerings); err != nil {
+		return err
+	}
+	if len(peerings.Peers) > 0 {
+		return temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("network %s is peered with %v, remove each peering with UnpeerNetworksWorkflow first", network, peerings.Peers), "NetworkPeered", nil)
+	}
+	return nil
+}
+
+// LoadNetworkPeerings reads the peerings recorded for a network in the
+// bucket, which has none if nothing was recorded
+func LoadNetworkPeerings(ctx context.Context, client s3object.Store, bucket string, network string) (NetworkPeerings, error) {
+	data, err := client.Get(ctx, bucket, networkPeeringsKey(network))
+	if errors.Is(err, s3object.ErrNotFound) {
+		return NetworkPeerings{Network: network}, nil
+	}
+	if err != nil {
+		return NetworkPeerings{}, fmt.Errorf("error reading peerings of %s: %w", network, err)
+	}
+
+	var peerings NetworkPeerings
+	if err := json.Unmarshal(data, &peerings); err != nil {
+		return NetworkPeerings{}, fmt.Errorf("error decoding peerings of %s: %w", network, err)
+	}
+	return peerings, nil
+}
+
+func storeNetworkPeerings(ctx context.Context, client s3object.Store, bucket string, peerings NetworkPeerings) error {
+	data, err := json.Marshal(peerings)
+	if err != nil {
+		return err
+	}
+	if err := client.Put(ctx, bucket, networkPeeringsKey(peerings.Network), data); err != nil {
+		return fmt.Errorf("error writing peerings of %s: %w", peerings.Network, err)
+	}
+	return nil
+}
+
+func (p NetworkPeerings) has(network string) bool {
+	for _, peer := range p.Peers {
+		if peer == network {
+			return true
+		}
+	}
+	return false
+}
+
+func networkPeeringsKey(network string) string {
+	return fmt.Sprintf("peerings/%s.json", network)
+}
+
+func peeringName(requester, accepter string) string {
+	return fmt.Sprintf("%s-%s", requester, accepter)
+}
+
+func peeringStack(requester, accepter string) StackRef {
+	return StackRef{
+		TerraformPath: "core:aws/vpc_peering",
+		StateKey:      fmt.Sprintf("peering-%s.tfstate", peeringName(requester, accepter)),
+	}
+}
+
+func peerRoutesStack(peering, network string) StackRef {
+	return StackRef{
+		TerraformPath: "core:aws/vpc_peering_routes",
+		StateKey:      fmt.Sprintf("peering-routes-%s-%s.tfstate", peering, network),
+	}
+}
@@ -0,0 +1,102 @@
+package workflows
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/stretchr/testify/mock"
+	"go.temporal.io/sdk/temporal"
+)
+
+// mockLocks grants every lock as soon as it is requested
+func (s *workflowTestSuite) mockLocks() {
+	s.env.OnActivity(RequestLockActivity, mock.Anything, mock.Anything).Return(
+		func(ctx context.Context, input RequestLockInput) error {
+			s.env.SignalWorkflow(lockGrantedSignal(input.Request.RequestID), LockGrant{
+				RequestID:     input.Request.RequestID,
+				ReleaseSignal: releaseSignal(input.Request.RequestID),
+			})
+			return nil
+		})
+	s.env.OnSignalExternalWorkflow(mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil).Maybe()
+}
+
+var testUnpeer = UnpeerNetworksInput{Requester: "a", Accepter: "b", Region: "us-east-1"}
+
+func (s *workflowTestSuite) TestUnpeerNetworks() {
+	s.mockLocks()
+	s.env.OnActivity(CheckChangeFreezeActivity, mock.Anything).Return(ChangeFreeze{}, nil)
+	// Peered the other way around
+	s.env.OnActivity(ResolvePeeringActivity, mock.Anything, testUnpeer).
+		Return(Peering{Requester: "b", Accepter: "a", PeeringConnectionID: "pcx-1"}, nil)
+	s.env.OnActivity(ResolveNetworkActivity, mock.Anything, "a").Return("vpc-a", nil)
+	s.env.OnActivity(ResolveNetworkActivity, mock.Anything, "b").Return("vpc-b", nil)
+
+	var mu sync.Mutex
+	var steps []string
+	step := func(name string) {
+		mu.Lock()
+		defer mu.Unlock()
+		steps = append(steps, name)
+	}
+	s.env.OnActivity(DestroyPeerRoutesActivity, mock.Anything, mock.MatchedBy(func(input PeerRoutesInput) bool {
+		return input.Peering == "b-a" && input.PeeringConnectionID == "pcx-1"
+	})).Return(func(ctx context.Context, input PeerRoutesInput) error {
+		step("routes " + input.Network)
+		return nil
+	}).Twice()
+	s.env.OnActivity(DestroyPeeringActivity, mock.Anything, DestroyPeeringInput{
+		Requester:      "b",
+		Accepter:       "a",
+		Region:         "us-east-1",
+		RequesterVpcID: "vpc-b",
+		AccepterVpcID:  "vpc-a",
+	}).Return(func(ctx context.Context, input DestroyPeeringInput) error {
+		step("peering")
+		return nil
+	}).Once()
+	s.env.OnActivity(RemovePeeringActivity, mock.Anything, PeerNetworksInput(testUnpeer)).Return(
+		func(ctx context.Context, input PeerNetworksInput) error {
+			step("records")
+			return nil
+		}).Once()
+
+	s.env.ExecuteWorkflow(UnpeerNetworksWorkflow, testUnpeer)
+
+	s.True(s.env.IsWorkflowCompleted())
+	s.NoError(s.env.GetWorkflowError())
+	var output UnpeerNetworksOutput
+	s.NoError(s.env.GetWorkflowResult(&output))
+	s.Equal(StatusApplied, output.Status)
+	s.Require().Len(steps, 4)
+	s.ElementsMatch([]string{"routes a", "routes b"}, steps[:2])
+	s.Equal([]string{"peering", "records"}, steps[2:])
+}
+
+func (s *workflowTestSuite) TestUnpeerNetworksNothingProvisioned() {
+	s.mockLocks()
+	s.env.OnActivity(CheckChangeFreezeActivity, mock.Anything).Return(ChangeFreeze{}, nil)
+	s.env.OnActivity(ResolvePeeringActivity, mock.Anything, testUnpeer).Return(Peering{Requester: "a", Accepter: "b"}, nil)
+	s.env.OnActivity(RemovePeeringActivity, mock.Anything, PeerNetworksInput(testUnpeer)).Return(nil).Once()
+
+	s.env.ExecuteWorkflow(UnpeerNetworksWorkflow, testUnpeer)
+
+	s.NoError(s.env.GetWorkflowError())
+	var output UnpeerNetworksOutput
+	s.NoError(s.env.GetWorkflowResult(&output))
+	s.Equal(StatusNoChanges, output.Status)
+}
+
+func (s *workflowTestSuite) TestDestroyNetworkRefusesPeered() {
+	s.env.OnActivity(CheckChangeFreezeActivity, mock.Anything).Return(ChangeFreeze{}, nil)
+	s.env.OnActivity(CheckNetworkPeeringsActivity, mock.Anything, "a").Return(NetworkPeerings{Network: "a", Peers: []string{"b"}}, nil)
+	s.env.OnActivity(RecordOutcomeActivity, mock.Anything, mock.Anything).Return(nil).Maybe()
+
+	s.env.ExecuteWorkflow(DestroyDemoNetworkWorkflow, DestroyDemoNetworkInput{Name: "a", Region: "us-east-1"})
+
+	var appErr *temporal.ApplicationError
+	s.Require().True(errors.As(s.env.GetWorkflowError(), &appErr))
+	s.Equal("NetworkPeered", appErr.Type())
+	s.Contains(appErr.Error(), "UnpeerNetworksWorkflow")
+}
@@ -0,0 +1,155 @@
+package workflows
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+
+	"github.com/dynajoe/temporal-terraform-demo/tfexec"
+)
+
+const (
+	SeverityInfo    = "info"
+	SeverityWarning = "warning"
+)
+
+type (
+	// PlannedStack is the plan of one stack as passed to annotators
+	PlannedStack struct {
+		TerraformPath string
+		StateKey      string
+		Changes       []tfexec.ResourceChange
+	}
+
+	// PlanAnnotation is a finding about a plan shown to the approver, e.g.
+	// "this subnet overlaps the on-prem range". Annotations don't block an
+	// approval, the approver decides.
+	PlanAnnotation struct {
+		Annotator string `json:"annotator"`
+		Severity  string `json:"severity"`
+		StateKey  string `json:"state_key,omitempty"`
+		Address   string `json:"address,omitempty"`
+		Message   string `json:"message"`
+	}
+
+	// PlanAnnotator checks a plan against organization rules. It runs in an
+	// activity so it may do I/O.
+	PlanAnnotator func(ctx context.Context, plans []PlannedStack) ([]PlanAnnotation, error)
+)
+
+var (
+	planAnnotatorsMu sync.RWMutex
+	planAnnotators   = map[string]PlanAnnotator{}
+)
+
+// RegisterPlanAnnotator adds an annotator run on every plan awaiting
+// approval, registering a name again replaces its annotator
+func RegisterPlanAnnotator(name string, annotator PlanAnnotator) {
+	planAnnotatorsMu.Lock()
+	defer planAnnotatorsMu.Unlock()
+	planAnnotators[name] = annotator
+}
+
+// PlanAnnotationWebhook returns an annotator that posts the plans as JSON to
+// url and expects a JSON list of annotations in response
+func PlanAnnotationWebhook(url string) PlanAnnotator {
+	return func(ctx context.Context, plans []PlannedStack) ([]PlanAnnotation, error) {
+		body, err := json.Marshal(plans)
+		if err != nil {
+			return nil, err
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+			return nil, fmt.Errorf("plan annotation webhook failed with status %d: %s", resp.StatusCode, msg)
+		}
+
+		var annotations []PlanAnnotation
+		if err := json.NewDecoder(resp.Body).Decode(&annotations); err != nil {
+			return nil, fmt.Errorf("error decoding plan annotations: %w", err)
+		}
+		return annotations, nil
+	}
+}
+
+// AnnotatePlanActivity runs every registered annotator over the plans. An
+// annotator that fails is reported as a warning rather than failing the
+// activity, a broken check shouldn't hold up every approval.
+func AnnotatePlanActivity(ctx context.Context, plans []PlannedStack) ([]PlanAnnotation, error) {
+	planAnnotatorsMu.RLock()
+	names := make([]string, 0, len(planAnnotators))
+	for name := range planAnnotators {
+		names = append(names, name)
+	}
+	annotators := make(map[string]PlanAnnotator, len(planAnnotators))
+	for name, annotator := range planAnnotators {
+		annotators[name] = annotator
+	}
+	planAnnotatorsMu.RUnlock()
+	sort.Strings(names)
+
+	var annotations []PlanAnnotation
+	for _, name := range names {
+		found, err := annotators[name](ctx, plans)
+		if err != nil {
+			annotations = append(annotations, PlanAnnotation{
+				Annotator: name,
+				Severity:  SeverityWarning,
+				Message:   fmt.Sprintf("annotator failed: %v", err),
+			})
+			continue
+		}
+		for _, annotation := range found {
+			annotation.Annotator = name
+			if annotation.Severity == "" {
+				annotation.Severity = SeverityInfo
+			}
+			annotations = append(annotations, annotation)
+		}
+	}
+	return annotations, nil
+}
+
+// annotatePlan publishes the annotators' findings on the status ahead of
+// the approval. Approval doesn't wait on annotators that can't be reached.
+func annotatePlan(ctx workflow.Context, status *Status, plans []PlannedStack) {
+	if !hasChange(ctx, planAnnotationVersion) {
+		return
+	}
+
+	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: 2 * time.Minute,
+		RetryPolicy: &temporal.RetryPolicy{
+			MaximumAttempts: 3,
+		},
+	})
+
+	var annotations []PlanAnnotation
+	if err := workflow.ExecuteActivity(ctx, AnnotatePlanActivity, plans).Get(ctx, &annotations); err != nil {
+		workflow.GetLogger(ctx).Warn("Unable to annotate plan", "Error", err)
+		return
+	}
+	status.PlanAnnotations = annotations
+}
@@ -0,0 +1,80 @@
+package workflows
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"go.temporal.io/sdk/temporal"
+
+	"github.com/dynajoe/temporal-terraform-demo/config/awsconfig"
+	"github.com/dynajoe/temporal-terraform-demo/terraform"
+	"github.com/dynajoe/temporal-terraform-demo/tfactivity"
+	"github.com/dynajoe/temporal-terraform-demo/tfconfig"
+	"github.com/dynajoe/temporal-terraform-demo/tfexec"
+)
+
+type (
+	ProviderSchemaInput struct {
+		TerraformPath string
+
+		// ResourceTypes limits the result, defaults to the resource types
+		// the module declares. Full provider schemas are too large to return.
+		ResourceTypes []string
+	}
+
+	ProviderSchemaOutput struct {
+		// Resources are keyed by resource type, e.g. aws_vpc
+		Resources map[string]tfexec.Schema
+	}
+)
+
+// ProviderSchemaActivity returns the provider schemas of a module's resource
+// types, e.g. to tell which attributes are sensitive
+func ProviderSchemaActivity(ctx context.Context, input ProviderSchemaInput) (ProviderSchemaOutput, error) {
+	awsConfig := awsconfig.LoadConfig()
+
+	resourceTypes := input.ResourceTypes
+	if len(resourceTypes) == 0 {
+		moduleFS, modulePath, err := terraform.Resolve(input.TerraformPath)
+		if err != nil {
+			return ProviderSchemaOutput{}, err
+		}
+		module, err := tfconfig.LoadModule(moduleFS, modulePath)
+		if err != nil {
+			return ProviderSchemaOutput{}, err
+		}
+		resourceTypes = module.ResourceTypes
+	}
+
+	// Init needs a backend, nothing is read from or written to it
+	config, err := moduleConfig(ctx, awsConfig, ModuleInput{
+		TerraformPath: input.TerraformPath,
+		StateKey:      path.Join("schemas", strings.ReplaceAll(input.TerraformPath, ":", "/")+".tfstate"),
+	})
+	if err != nil {
+		return ProviderSchemaOutput{}, err
+	}
+
+	schemas, err := tfactivity.New(config).ProviderSchemas(ctx)
+	if err != nil {
+		return ProviderSchemaOutput{}, err
+	}
+
+	output := ProviderSchemaOutput{Resources: make(map[string]tfexec.Schema, len(resourceTypes))}
+	var unknown []string
+	for _, resourceType := range resourceTypes {
+		schema, ok := schemas.Resource(resourceType)
+		if !ok {
+			unknown = append(unknown, resourceType)
+			continue
+		}
+		output.Resources[resourceType] = schema
+	}
+	if len(unknown) > 0 {
+		return ProviderSchemaOutput{}, temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("no provider of %s defines [%s]", input.TerraformPath, strings.Join(unknown, ", ")), "InvalidInput", nil)
+	}
+	return output, nil
+}
@@ -0,0 +1,83 @@
+package workflows
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+
+	"go.temporal.io/sdk/temporal"
+
+	"github.com/dynajoe/temporal-terraform-demo/tfconfig"
+	"github.com/dynajoe/temporal-terraform-demo/tfexec"
+)
+
+// publicRegistry serves registry module sources that don't name a host
+const publicRegistry = "registry.terraform.io"
+
+// registryCredentials resolves the tokens for the private registries the
+// stack declares
+func (s AllowedStack) registryCredentials(ctx context.Context) (map[string]string, error) {
+	if len(s.RegistryCredentials) == 0 {
+		return nil, nil
+	}
+	credentials := make(map[string]string, len(s.RegistryCredentials))
+	for host, name := range s.RegistryCredentials {
+		token, err := secretStore.Secret(ctx, name)
+		if err != nil {
+			return nil, fmt.Errorf("error resolving registry credentials for %s: %w", host, err)
+		}
+		credentials[host] = token
+	}
+	return credentials, nil
+}
+
+// checkModuleSources fails unless every module the stack calls can be
+// fetched in the working directory: local modules have to be extracted with
+// the stack, and private registries need credentials
+func checkModuleSources(fsys fs.FS, modulePath string, module *tfconfig.Module, cliConfig tfexec.CLIConfig) error {
+	var problems []string
+	for _, call := range module.ModuleCalls {
+		switch {
+		case strings.HasPrefix(call.Source, "./") || strings.HasPrefix(call.Source, "../"):
+			// Only the stack's own directory is extracted
+			source := path.Join(modulePath, call.Source)
+			if source != modulePath && !strings.HasPrefix(source, modulePath+"/") {
+				problems = append(problems, fmt.Sprintf("module %s source %s is outside the stack directory", call.Name, call.Source))
+				continue
+			}
+			if info, err := fs.Stat(fsys, source); err != nil || !info.IsDir() {
+				problems = append(problems, fmt.Sprintf("module %s source %s does not exist", call.Name, call.Source))
+			}
+		default:
+			host, ok := registryHost(call.Source)
+			if ok && host != publicRegistry && cliConfig.Credentials[host] == "" {
+				problems = append(problems, fmt.Sprintf("module %s source %s needs registry credentials for %s", call.Name, call.Source, host))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("unresolvable module sources in %s: %s", modulePath, strings.Join(problems, "; ")), "InvalidModule", nil)
+	}
+	return nil
+}
+
+// registryHost returns the registry a module source such as
+// "app.terraform.io/org/vpc/aws" or "org/vpc/aws" is fetched from
+func registryHost(source string) (string, bool) {
+	if strings.Contains(source, "::") || strings.Contains(source, "://") {
+		return "", false
+	}
+	parts := strings.Split(source, "/")
+	switch {
+	case len(parts) == 3 && !strings.Contains(parts[0], "."):
+		return publicRegistry, true
+	case len(parts) == 4 && strings.Contains(parts[0], ".") && parts[0] != "github.com" && parts[0] != "bitbucket.org":
+		return parts[0], true
+	default:
+		return "", false
+	}
+}
@@ -0,0 +1,190 @@
+package workflows
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+
+	"github.com/dynajoe/temporal-terraform-demo/config/awsconfig"
+	"github.com/dynajoe/temporal-terraform-demo/tfactivity"
+	"github.com/dynajoe/temporal-terraform-demo/tfworkspace"
+)
+
+type (
+	RenameStackInput struct {
+		TerraformPath string
+		StateKey      string
+		NewStateKey   string
+		Region        string
+		RoleARN       string
+		PlanRoleARN   string
+		KMSKeyID      string
+
+		// Vars must provide any vars that were redacted from the stack's
+		// records, they are needed to verify the stack after the move
+		Vars map[string]interface{}
+	}
+
+	MoveStateInput struct {
+		Stack       StackRef
+		NewStateKey string
+	}
+)
+
+// RenameStackWorkflow moves a stack's state to a new key and proves the
+// stack is unchanged by planning against the new key before removing the
+// old state
+func RenameStackWorkflow(ctx workflow.Context, input RenameStackInput) error {
+	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: time.Hour,
+		HeartbeatTimeout:    time.Minute,
+		RetryPolicy: &temporal.RetryPolicy{
+			InitialInterval:    5 * time.Second,
+			BackoffCoefficient: 1.3,
+			MaximumInterval:    time.Minute,
+			MaximumAttempts:    5,
+		},
+	})
+
+	if input.NewStateKey == "" || input.NewStateKey == input.StateKey {
+		return temporal.NewNonRetryableApplicationError("rename needs a different state key", "InvalidInput", nil)
+	}
+
+	status, err := trackStatus(ctx)
+	if err != nil {
+		return err
+	}
+
+	status.Phase = "checking change freeze"
+	if err := refuseIfFrozen(ctx); err != nil {
+		return err
+	}
+
+	stack := StackRef{
+		TerraformPath: input.TerraformPath,
+		RoleARN:       input.RoleARN,
+		StateKey:      input.StateKey,
+		KMSKeyID:      input.KMSKeyID,
+	}
+
+	status.Phase = "loading stack"
+	var record StackRecord
+	if err := workflow.ExecuteActivity(ctx, LoadStackRecordActivity, stack).Get(ctx, &record); err != nil {
+		return err
+	}
+
+	vars, err := record.withVars(input.Vars)
+	if err != nil {
+		return err
+	}
+
+	status.Phase = "moving state"
+	if err := workflow.ExecuteActivity(ctx, MoveStateActivity, MoveStateInput{
+		Stack:       stack,
+		NewStateKey: input.NewStateKey,
+	}).Get(ctx, nil); err != nil {
+		return err
+	}
+
+	status.Phase = "verifying stack"
+	var plan tfworkspace.PlanOutput
+	if err := workflow.ExecuteActivity(ctx, PlanModuleActivity, ModuleInput{
+		TerraformPath: record.TerraformPath,
+		StateKey:      input.NewStateKey,
+		Region:        input.Region,
+		RoleARN:       input.RoleARN,
+		PlanRoleARN:   input.PlanRoleARN,
+		KMSKeyID:      input.KMSKeyID,
+		Vars:          vars,
+	}).Get(ctx, &plan); err != nil {
+		return err
+	}
+	if len(plan.Changes) > 0 {
+		addresses := make([]string, 0, len(plan.Changes))
+		for _, change := range plan.Changes {
+			addresses = append(addresses, change.Address)
+		}
+		return temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("state copied to %s but the plan is not empty, %s was left in place: [%s]",
+				input.NewStateKey, input.StateKey, strings.Join(addresses, ", ")), "RenameNotVerified", nil)
+	}
+
+	status.Phase = "removing old state"
+	if err := workflow.ExecuteActivity(ctx, RemoveStateActivity, stack).Get(ctx, nil); err != nil {
+		return err
+	}
+
+	status.Phase = "completed"
+	return nil
+}
+
+func MoveStateActivity(ctx context.Context, input MoveStateInput) error {
+	awsConfig := awsconfig.LoadConfig()
+
+	backend, err := StateBackend(ctx, awsConfig.Credentials, input.Stack)
+	if err != nil {
+		return err
+	}
+
+	err = tfworkspace.MoveState(ctx, backend, input.NewStateKey)
+	if errors.Is(err, tfworkspace.ErrStateExists) {
+		return temporal.NewNonRetryableApplicationError(err.Error(), "StateExists", err)
+	}
+	return err
+}
+
+func RemoveStateActivity(ctx context.Context, stack StackRef) error {
+	awsConfig := awsconfig.LoadConfig()
+
+	backend, err := StateBackend(ctx, awsConfig.Credentials, stack)
+	if err != nil {
+		return err
+	}
+	return tfworkspace.RemoveState(ctx, backend)
+}
+
+// PlanModuleActivity returns the changes applying the module would make
+func PlanModuleActivity(ctx context.Context, input ModuleInput) (tfworkspace.PlanOutput, error) {
+	awsConfig := awsconfig.LoadConfig()
+
+	input, err := input.withVarRefs(ctx, awsConfig)
+	if err != nil {
+		return tfworkspace.PlanOutput{}, err
+	}
+	input, err = input.withRenderedVars(ctx)
+	if err != nil {
+		return tfworkspace.PlanOutput{}, err
+	}
+
+	config, err := moduleConfig(ctx, awsConfig, input)
+	if err != nil {
+		return tfworkspace.PlanOutput{}, err
+	}
+
+	credentials, err := planCredentials(ctx, awsConfig, input.RoleARN, input.PlanRoleARN)
+	if err != nil {
+		return tfworkspace.PlanOutput{}, err
+	}
+	roleARN, err := tenantRole(ctx, input.RoleARN)
+	if err != nil {
+		return tfworkspace.PlanOutput{}, err
+	}
+
+	return tfactivity.New(config).Plan(ctx, tfworkspace.PlanInput{
+		AwsCredentials: credentials,
+		RoleARN:        roleARN,
+		Env: map[string]string{
+			"AWS_REGION": input.Region,
+		},
+		Vars:        input.Vars,
+		ForceReplan: input.ForceReplan,
+		LockTimeout: input.LockTimeout,
+		NoLock:      input.PlanWithoutLock,
+		NoRefresh:   input.NoRefresh,
+	})
+}
@@ -0,0 +1,82 @@
+package workflows
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+
+	"github.com/dynajoe/temporal-terraform-demo/config/awsconfig"
+	"github.com/dynajoe/temporal-terraform-demo/tfworkspace"
+)
+
+type (
+	RestorePreChangeStateInput struct {
+		Stack StackRef
+
+		// WorkflowID and RunID identify the run whose changes are rolled
+		// back, the state is restored to how it was before its first apply
+		// or destroy of the stack
+		WorkflowID string
+		RunID      string
+	}
+
+	RestorePreChangeStateOutput struct {
+		// Serial is the serial the restored state was written at
+		Serial int64
+	}
+)
+
+// RestorePreChangeStateWorkflow rolls a stack's state object back to the
+// snapshot taken before a run changed it. Only the state is restored, the
+// next apply brings the resources back in line with it.
+func RestorePreChangeStateWorkflow(ctx workflow.Context, input RestorePreChangeStateInput) (RestorePreChangeStateOutput, error) {
+	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: 5 * time.Minute,
+		RetryPolicy: &temporal.RetryPolicy{
+			InitialInterval:    5 * time.Second,
+			BackoffCoefficient: 1.3,
+			MaximumInterval:    time.Minute,
+			MaximumAttempts:    5,
+		},
+	})
+
+	if input.WorkflowID == "" || input.RunID == "" {
+		return RestorePreChangeStateOutput{}, temporal.NewNonRetryableApplicationError("workflow and run id are required", "InvalidInput", nil)
+	}
+
+	status, err := trackStatus(ctx)
+	if err != nil {
+		return RestorePreChangeStateOutput{}, err
+	}
+
+	status.Phase = "restoring state"
+	var output RestorePreChangeStateOutput
+	if err := workflow.ExecuteActivity(ctx, RestorePreChangeStateActivity, input).Get(ctx, &output); err != nil {
+		return RestorePreChangeStateOutput{}, err
+	}
+
+	status.Phase = "completed"
+	return output, nil
+}
+
+func RestorePreChangeStateActivity(ctx context.Context, input RestorePreChangeStateInput) (RestorePreChangeStateOutput, error) {
+	awsConfig := awsconfig.LoadConfig()
+
+	backend, err := StateBackend(ctx, awsConfig.Credentials, input.Stack)
+	if err != nil {
+		return RestorePreChangeStateOutput{}, err
+	}
+
+	// Snapshots are keyed by the run id tfactivity gives the workspace
+	serial, err := tfworkspace.RestoreSnapshot(ctx, backend, input.WorkflowID+"_"+input.RunID)
+	if errors.Is(err, tfworkspace.ErrNoSnapshot) {
+		return RestorePreChangeStateOutput{}, temporal.NewNonRetryableApplicationError(err.Error(), "NoSnapshot", err)
+	}
+	if err != nil {
+		return RestorePreChangeStateOutput{}, err
+	}
+	return RestorePreChangeStateOutput{Serial: serial}, nil
+}
@@ -0,0 +1,27 @@
+package workflows
+
+// ResultStatus says what a workflow did to the stacks it manages
+type ResultStatus string
+
+const (
+	// StatusApplied means at least one stack was changed
+	StatusApplied ResultStatus = "Applied"
+
+	// StatusNoChanges means every stack already matched its configuration
+	StatusNoChanges ResultStatus = "NoChanges"
+)
+
+func appliedStatus(changed bool) ResultStatus {
+	if changed {
+		return StatusApplied
+	}
+	return StatusNoChanges
+}
+
+// combine reports Applied if either result changed anything
+func (s ResultStatus) combine(other ResultStatus) ResultStatus {
+	if s == StatusApplied || other == StatusApplied {
+		return StatusApplied
+	}
+	return StatusNoChanges
+}
@@ -0,0 +1,235 @@
+package workflows
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+
+	"github.com/dynajoe/temporal-terraform-demo/s3object"
+	"github.com/dynajoe/temporal-terraform-demo/terraform"
+	"github.com/dynajoe/temporal-terraform-demo/tfexec"
+)
+
+type (
+	// StackRef identifies a stack's state
+	StackRef struct {
+		TerraformPath string
+
+		// RoleARN is the role terraform assumes, its account is used to
+		// route the stack's state
+		RoleARN string
+
+		// PlanRoleARN, if set, is assumed instead of RoleARN by plans, e.g. a
+		// role limited to reading. It must be in RoleARN's account.
+		PlanRoleARN string
+
+		StateKey string
+
+		// KMSKeyID, if set, is the customer managed key terraform encrypts
+		// the stack's state with
+		KMSKeyID string
+	}
+
+	// StateRoute sends the state of stacks matching Namespace and Account to
+	// a bucket, empty selectors match any stack
+	StateRoute struct {
+		Namespace string `json:"namespace,omitempty"`
+		Account   string `json:"account,omitempty"`
+		Bucket    string `json:"bucket"`
+		Region    string `json:"region"`
+		LockTable string `json:"lock_table,omitempty"`
+	}
+)
+
+// defaultStateRoute is used for stacks no configured route matches
+var defaultStateRoute = StateRoute{
+	Bucket: stateBucket,
+	Region: stateRegion,
+}
+
+var (
+	stateRoutesMu sync.RWMutex
+	stateRoutes   []StateRoute
+
+	// localStateDir keeps every bucket in a local directory instead of S3
+	localStateDir string
+)
+
+// ConfigureStateRoutes sets the routes stacks' state is sharded by, the first
+// matching route wins
+func ConfigureStateRoutes(routes []StateRoute) error {
+	for i, route := range routes {
+		if route.Bucket == "" || route.Region == "" {
+			return fmt.Errorf("state route %d: bucket and region are required", i)
+		}
+	}
+
+	stateRoutesMu.Lock()
+	defer stateRoutesMu.Unlock()
+	stateRoutes = routes
+	return nil
+}
+
+// LoadStateRoutes reads routes from a JSON file containing a list of routes
+func LoadStateRoutes(path string) ([]StateRoute, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var routes []StateRoute
+	if err := json.Unmarshal(data, &routes); err != nil {
+		return nil, fmt.Errorf("error decoding state routes: %w", err)
+	}
+	return routes, nil
+}
+
+// Account returns the account of the stack's role, or empty when terraform
+// runs with the worker's credentials
+func (s StackRef) Account() string {
+	return roleAccount(s.RoleARN)
+}
+
+// roleAccount returns the account of a role ARN, e.g.
+// arn:aws:iam::123456789012:role/name -> 123456789012
+func roleAccount(roleARN string) string {
+	parts := strings.Split(roleARN, ":")
+	if len(parts) < 5 {
+		return ""
+	}
+	return parts[4]
+}
+
+func (r StateRoute) matches(stack StackRef) bool {
+	namespace, _ := terraform.SplitPath(stack.TerraformPath)
+	return (r.Namespace == "" || r.Namespace == namespace) &&
+		(r.Account == "" || r.Account == stack.Account())
+}
+
+// controlRoute is the bucket holding operator controls and the records that
+// aren't a single stack's, e.g. peerings and run logs. It is the tenant's own
+// bucket when the activity runs for a tenant with one.
+func controlRoute(ctx context.Context) StateRoute {
+	if tenant, ok := tenantFromContext(ctx); ok {
+		return tenant.controlRoute()
+	}
+	return defaultStateRoute
+}
+
+func (t Tenant) controlRoute() StateRoute {
+	if t.StateBucket == "" {
+		return defaultStateRoute
+	}
+	return StateRoute{
+		Bucket:    t.StateBucket,
+		Region:    t.StateRegion,
+		LockTable: t.LockTable,
+	}
+}
+
+func (r StateRoute) objects(credentials aws.CredentialsProvider) s3object.Store {
+	return stateObjects(credentials, r.Region)
+}
+
+func (r StateRoute) backend(credentials aws.CredentialsProvider, key string) tfexec.S3BackendConfig {
+	return tfexec.S3BackendConfig{
+		Credentials:   credentials,
+		Region:        r.Region,
+		Bucket:        r.Bucket,
+		Key:           key,
+		DynamoDBTable: r.LockTable,
+		LocalDir:      localStateDir,
+	}
+}
+
+// ConfigureLocalState keeps the state and every artifact the workflows
+// store in S3 under dir instead, one directory per bucket, e.g. to demo
+// against a Temporal dev server without an AWS account. Modules still need
+// whatever credentials their providers require.
+func ConfigureLocalState(dir string) {
+	localStateDir = dir
+}
+
+// stateObjects returns the store for the state buckets
+func stateObjects(credentials aws.CredentialsProvider, region string) s3object.Store {
+	if localStateDir != "" {
+		return s3object.Dir{Root: localStateDir}
+	}
+	return s3object.New(credentials, region)
+}
+
+// routeFor returns the first of routes matching the stack
+func routeFor(routes []StateRoute, stack StackRef) StateRoute {
+	for _, route := range routes {
+		if route.matches(stack) {
+			return route
+		}
+	}
+	return defaultStateRoute
+}
+
+// allStateRoutes returns the configured routes followed by the default
+func allStateRoutes() []StateRoute {
+	stateRoutesMu.RLock()
+	defer stateRoutesMu.RUnlock()
+	return append(append([]StateRoute{}, stateRoutes...), defaultStateRoute)
+}
+
+// StateBackend returns the backend config for a stack's state in the bucket
+// it is routed to, or its tenant's bucket. A stack whose state already lives in a different bucket
+// is an error rather than silently starting over in the new one.
+func StateBackend(ctx context.Context, credentials aws.CredentialsProvider, stack StackRef) (tfexec.S3BackendConfig, error) {
+	backend, err := routeState(ctx, credentials, stack)
+	if err != nil {
+		return tfexec.S3BackendConfig{}, err
+	}
+	backend.KMSKeyID = stack.KMSKeyID
+	return backend, nil
+}
+
+func routeState(ctx context.Context, credentials aws.CredentialsProvider, stack StackRef) (tfexec.S3BackendConfig, error) {
+	if err := checkTenantPath(ctx, stack.TerraformPath); err != nil {
+		return tfexec.S3BackendConfig{}, err
+	}
+
+	// Tenants with their own bucket keep all their state there
+	if tenant, ok := tenantFromContext(ctx); ok && tenant.StateBucket != "" {
+		return tenant.controlRoute().backend(credentials, stack.StateKey), nil
+	}
+
+	routes := allStateRoutes()
+	routed := routeFor(routes, stack)
+
+	exists, err := stateObjects(credentials, routed.Region).Exists(ctx, routed.Bucket, stack.StateKey)
+	if err != nil {
+		return tfexec.S3BackendConfig{}, fmt.Errorf("error checking state route: %w", err)
+	}
+	if exists {
+		return routed.backend(credentials, stack.StateKey), nil
+	}
+
+	// New stacks have no state anywhere, existing ones must not have moved
+	checked := map[string]bool{routed.Bucket: true}
+	for _, route := range routes {
+		if checked[route.Bucket] {
+			continue
+		}
+		checked[route.Bucket] = true
+
+		exists, err := stateObjects(credentials, route.Region).Exists(ctx, route.Bucket, stack.StateKey)
+		if err != nil {
+			return tfexec.S3BackendConfig{}, fmt.Errorf("error checking state route: %w", err)
+		}
+		if exists {
+			return tfexec.S3BackendConfig{}, fmt.Errorf("state for %s is in bucket %s but the stack is routed to %s, move the state before changing routes",
+				stack.StateKey, route.Bucket, routed.Bucket)
+		}
+	}
+
+	return routed.backend(credentials, stack.StateKey), nil
+}
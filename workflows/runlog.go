@@ -0,0 +1,45 @@
+package workflows
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/dynajoe/temporal-terraform-demo/config/awsconfig"
+	"github.com/dynajoe/temporal-terraform-demo/runlog"
+)
+
+// NewRunLog returns a run log writing to dir and syncing to the bucket
+// holding operator controls. Segments are named after the worker's host, so
+// only one worker per host should log runs.
+func NewRunLog(dir string) (*runlog.Log, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "worker"
+	}
+	store := stateObjects(awsconfig.LoadConfig().Credentials, stateRegion)
+	return runlog.New(dir, store, stateBucket, host)
+}
+
+// NewTenantRunLog returns a run log for the runs of a tenant, writing to a
+// directory of its own under dir and syncing to the tenant's bucket
+func NewTenantRunLog(dir string, tenant Tenant) (*runlog.Log, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "worker"
+	}
+	route := tenant.controlRoute()
+	return runlog.New(filepath.Join(dir, tenant.Name), route.objects(awsconfig.LoadConfig().Credentials), route.Bucket, host)
+}
+
+// RunLogOptions logs the approvals of the workflows
+func RunLogOptions() runlog.Options {
+	return runlog.Options{ApprovalSignal: ApprovalSignal}
+}
+
+// ReadRunLog returns the entries logged for a run, runID is the workflow ID
+// and run ID joined by an underscore
+func ReadRunLog(ctx context.Context, runID string) ([]runlog.Entry, error) {
+	store := stateObjects(awsconfig.LoadConfig().Credentials, stateRegion)
+	return runlog.Read(ctx, store, stateBucket, runID)
+}
@@ -0,0 +1,331 @@
+package workflows
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+
+	"github.com/dynajoe/temporal-terraform-demo/tfexec"
+)
+
+const (
+	// SandboxQuery returns the SandboxState of a sandbox workflow
+	SandboxQuery = "sandbox"
+
+	// DestroySandboxSignal tears a sandbox down before its TTL expires
+	DestroySandboxSignal = "destroy-sandbox"
+)
+
+type (
+	// SandboxPolicy holds the guardrails for developer sandboxes
+	SandboxPolicy struct {
+		// DefaultTTL is used when a sandbox doesn't ask for one, no sandbox
+		// may live longer than MaxTTL
+		DefaultTTL time.Duration `json:"default_ttl"`
+		MaxTTL     time.Duration `json:"max_ttl"`
+
+		// AllowedRegions is required, sandboxes may only be created in them
+		AllowedRegions []string `json:"allowed_regions"`
+
+		// AllowedValues constrains vars, e.g. instance_type to small sizes
+		AllowedValues map[string][]string `json:"allowed_values,omitempty"`
+
+		// NameVars are prefixed with the user, defaults to "name"
+		NameVars []string `json:"name_vars,omitempty"`
+
+		// ResourceCosts are monthly costs by resource type used to estimate
+		// a sandbox's cost, which is warned about above MonthlyCostCap
+		ResourceCosts  map[string]float64 `json:"resource_costs,omitempty"`
+		MonthlyCostCap float64            `json:"monthly_cost_cap,omitempty"`
+	}
+
+	SandboxInput struct {
+		// User owns the sandbox, its state key and names are prefixed with it
+		User string
+		Name string
+
+		TerraformPath string
+		Region        string
+		RoleARN       string
+		Vars          map[string]interface{}
+
+		// TTL is how long the sandbox lives before it is destroyed, defaults
+		// to the policy's DefaultTTL
+		TTL time.Duration
+	}
+
+	// SandboxPlan is a sandbox that passed the policy checks
+	SandboxPlan struct {
+		Module               ModuleInput
+		TTL                  time.Duration
+		EstimatedMonthlyCost float64
+		Warnings             []string
+	}
+
+	SandboxState struct {
+		User                 string
+		StateKey             string
+		ExpiresAt            time.Time
+		EstimatedMonthlyCost float64
+		Warnings             []string
+		Output               map[string]interface{}
+		Destroyed            bool
+	}
+)
+
+var (
+	sandboxPolicyMu sync.RWMutex
+	sandboxPolicy   *SandboxPolicy
+)
+
+// LoadSandboxPolicy reads the sandbox policy from a JSON file
+func LoadSandboxPolicy(path string) (SandboxPolicy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return SandboxPolicy{}, err
+	}
+
+	var policy SandboxPolicy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return SandboxPolicy{}, fmt.Errorf("error decoding sandbox policy: %w", err)
+	}
+	return policy, nil
+}
+
+// ConfigureSandboxPolicy enables sandboxes, which are refused until a
+// policy is configured
+func ConfigureSandboxPolicy(policy SandboxPolicy) error {
+	if policy.DefaultTTL <= 0 || policy.MaxTTL < policy.DefaultTTL {
+		return errors.New("sandbox policy needs a default ttl no longer than its max ttl")
+	}
+	if len(policy.AllowedRegions) == 0 {
+		return errors.New("sandbox policy needs allowed regions")
+	}
+	if len(policy.NameVars) == 0 {
+		policy.NameVars = []string{"name"}
+	}
+
+	sandboxPolicyMu.Lock()
+	defer sandboxPolicyMu.Unlock()
+	sandboxPolicy = &policy
+	return nil
+}
+
+// SandboxWorkflow provisions a module for a developer and destroys it when
+// its TTL expires or DestroySandboxSignal is received
+func SandboxWorkflow(ctx workflow.Context, input SandboxInput) (SandboxState, error) {
+	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		// Destroy must not start until a canceled apply has exited
+		WaitForCancellation: true,
+		RetryPolicy: &temporal.RetryPolicy{
+			InitialInterval:    5 * time.Second,
+			BackoffCoefficient: 1.3,
+			MaximumInterval:    time.Minute,
+			MaximumAttempts:    5,
+		},
+	})
+
+	ctx, err := withStackTimeouts(ctx, input.TerraformPath, "", nil)
+	if err != nil {
+		return SandboxState{}, err
+	}
+
+	status, err := trackStatus(ctx)
+	if err != nil {
+		return SandboxState{}, err
+	}
+
+	state := SandboxState{User: input.User}
+	if err := workflow.SetQueryHandler(ctx, SandboxQuery, func() (SandboxState, error) {
+		return state, nil
+	}); err != nil {
+		return SandboxState{}, err
+	}
+
+	status.Phase = "checking change freeze"
+	if err := waitForThaw(ctx, status); err != nil {
+		return SandboxState{}, err
+	}
+
+	status.Phase = "planning sandbox"
+	var plan SandboxPlan
+	if err := workflow.ExecuteActivity(ctx, PlanSandboxActivity, input).Get(ctx, &plan); err != nil {
+		return SandboxState{}, err
+	}
+	state.StateKey = plan.Module.StateKey
+	state.ExpiresAt = workflow.Now(ctx).Add(plan.TTL)
+	state.EstimatedMonthlyCost = plan.EstimatedMonthlyCost
+	state.Warnings = plan.Warnings
+	for _, warning := range plan.Warnings {
+		workflow.GetLogger(ctx).Warn("Sandbox policy warning", "User", input.User, "Warning", warning)
+	}
+
+	status.Phase = "applying sandbox"
+	var output ModuleOutput
+	applyErr := workflow.ExecuteActivity(ctx, ApplyModuleActivity, plan.Module).Get(ctx, &output)
+	state.Output = output.Output
+
+	// Live until the TTL expires or the owner is done with it
+	if applyErr == nil {
+		applyErr = recordInventory(ctx, output.Inventory)
+	}
+	if applyErr == nil {
+		status.Phase = "running"
+		remaining := state.ExpiresAt.Sub(workflow.Now(ctx))
+		if receiveWithTimeout(ctx, workflow.GetSignalChannel(ctx, DestroySandboxSignal), nil, remaining) {
+			workflow.GetLogger(ctx).Info("Sandbox destroyed on request", "User", input.User)
+		}
+	}
+
+	// Always tear down, even if the apply failed part way or the workflow is
+	// being canceled
+	status.Phase = "destroying sandbox"
+	destroyCtx, cancelDestroy := workflow.NewDisconnectedContext(ctx)
+	defer cancelDestroy()
+	destroyErr := workflow.ExecuteActivity(destroyCtx, DestroyModuleActivity, plan.Module).Get(destroyCtx, nil)
+	state.Destroyed = destroyErr == nil
+
+	switch {
+	case destroyErr != nil && applyErr != nil:
+		return state, fmt.Errorf("sandbox failed to apply: %v; resources may be orphaned in state %s: %w", applyErr, state.StateKey, destroyErr)
+	case destroyErr != nil:
+		return state, fmt.Errorf("resources may be orphaned in state %s: %w", state.StateKey, destroyErr)
+	case applyErr != nil:
+		return state, fmt.Errorf("sandbox failed to apply: %w", applyErr)
+	}
+
+	status.Phase = "completed"
+	return state, nil
+}
+
+// PlanSandboxActivity checks a sandbox against the policy, prefixes it with
+// its user and estimates its cost from a plan
+func PlanSandboxActivity(ctx context.Context, input SandboxInput) (SandboxPlan, error) {
+	sandboxPolicyMu.RLock()
+	policy := sandboxPolicy
+	sandboxPolicyMu.RUnlock()
+	if policy == nil {
+		return SandboxPlan{}, temporal.NewNonRetryableApplicationError("sandboxes are not enabled on this worker", "SandboxDisabled", nil)
+	}
+
+	plan, err := policy.sandboxPlan(input)
+	if err != nil {
+		return SandboxPlan{}, temporal.NewNonRetryableApplicationError(err.Error(), "SandboxPolicyViolation", nil)
+	}
+
+	planOutput, err := PlanModuleActivity(ctx, plan.Module)
+	if err != nil {
+		return SandboxPlan{}, err
+	}
+
+	plan.EstimatedMonthlyCost = policy.estimateCost(planOutput.Changes)
+	if policy.MonthlyCostCap > 0 && plan.EstimatedMonthlyCost > policy.MonthlyCostCap {
+		plan.Warnings = append(plan.Warnings, fmt.Sprintf("estimated monthly cost %.2f exceeds the cap of %.2f",
+			plan.EstimatedMonthlyCost, policy.MonthlyCostCap))
+	}
+	return plan, nil
+}
+
+var sandboxUnsafe = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// sandboxPlan enforces the policy and scopes the sandbox to its user
+func (p SandboxPolicy) sandboxPlan(input SandboxInput) (SandboxPlan, error) {
+	user := strings.Trim(sandboxUnsafe.ReplaceAllString(strings.ToLower(input.User), "-"), "-")
+	name := strings.Trim(sandboxUnsafe.ReplaceAllString(strings.ToLower(input.Name), "-"), "-")
+	if user == "" || name == "" {
+		return SandboxPlan{}, errors.New("sandbox needs a user and a name")
+	}
+
+	ttl := input.TTL
+	if ttl == 0 {
+		ttl = p.DefaultTTL
+	}
+	if ttl < 0 || ttl > p.MaxTTL {
+		return SandboxPlan{}, fmt.Errorf("sandbox ttl %s is longer than the max of %s", ttl, p.MaxTTL)
+	}
+
+	if !contains(p.AllowedRegions, input.Region) {
+		return SandboxPlan{}, fmt.Errorf("region %q is not allowed for sandboxes, use one of [%s]",
+			input.Region, strings.Join(p.AllowedRegions, ", "))
+	}
+
+	vars := make(map[string]interface{}, len(input.Vars))
+	for k, v := range input.Vars {
+		vars[k] = v
+	}
+	var violations []string
+	// Templates are rendered after the policy checks and could hide values
+	for name, v := range vars {
+		if containsVarTemplate(v) {
+			violations = append(violations, fmt.Sprintf("%s may not be a template", name))
+		}
+	}
+	for name, allowed := range p.AllowedValues {
+		if v, ok := vars[name]; ok && !contains(allowed, fmt.Sprint(v)) {
+			violations = append(violations, fmt.Sprintf("%s=%v is not one of [%s]", name, v, strings.Join(allowed, ", ")))
+		}
+	}
+	if len(violations) > 0 {
+		sort.Strings(violations)
+		return SandboxPlan{}, fmt.Errorf("sandbox vars not allowed: %s", strings.Join(violations, "; "))
+	}
+	for _, name := range p.NameVars {
+		if v, ok := vars[name].(string); ok && !strings.HasPrefix(v, user+"-") {
+			vars[name] = user + "-" + v
+		}
+	}
+
+	return SandboxPlan{
+		Module: ModuleInput{
+			TerraformPath: input.TerraformPath,
+			StateKey:      fmt.Sprintf("sandbox/%s/%s.tfstate", user, name),
+			Region:        input.Region,
+			RoleARN:       input.RoleARN,
+			Vars:          vars,
+		},
+		TTL: ttl,
+	}, nil
+}
+
+// estimateCost sums the monthly cost of the resources a plan creates
+func (p SandboxPolicy) estimateCost(changes []tfexec.ResourceChange) float64 {
+	var cost float64
+	for _, change := range changes {
+		if contains(change.Actions, "create") {
+			cost += p.ResourceCosts[resourceType(change.Address)]
+		}
+	}
+	return cost
+}
+
+// resourceType returns the type of a managed resource address such as
+// module.app.aws_instance.web[0]
+func resourceType(address string) string {
+	parts := strings.Split(address, ".")
+	for len(parts) > 2 && parts[0] == "module" {
+		parts = parts[2:]
+	}
+	if len(parts) < 2 || parts[0] == "data" {
+		return ""
+	}
+	return parts[0]
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+package workflows
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"go.temporal.io/sdk/temporal"
+
+	"github.com/dynajoe/temporal-terraform-demo/s3object"
+	"github.com/dynajoe/temporal-terraform-demo/tfstate"
+)
+
+// StackOutputRef points at an output of another stack, read from its state
+// when the referencing stack is applied
+type StackOutputRef struct {
+	Stack  StackRef
+	Output string
+}
+
+// withVarRefs returns the input with its VarRefs resolved into Vars
+func (input ModuleInput) withVarRefs(ctx context.Context, awsConfig aws.Config) (ModuleInput, error) {
+	if len(input.VarRefs) == 0 {
+		return input, nil
+	}
+
+	vars := make(map[string]interface{}, len(input.Vars)+len(input.VarRefs))
+	for name, v := range input.Vars {
+		vars[name] = v
+	}
+	for name, ref := range input.VarRefs {
+		v, err := resolveStackOutput(ctx, awsConfig, ref)
+		if err != nil {
+			return ModuleInput{}, fmt.Errorf("var %s: %w", name, err)
+		}
+		vars[name] = v
+	}
+	input.Vars = vars
+	return input, nil
+}
+
+// resolveStackOutput reads the referenced output from the stack's state.
+// A stack that hasn't been applied can't be waited out by retrying.
+func resolveStackOutput(ctx context.Context, awsConfig aws.Config, ref StackOutputRef) (interface{}, error) {
+	backend, err := StateBackend(ctx, awsConfig.Credentials, ref.Stack)
+	if err != nil {
+		return nil, err
+	}
+
+	outputs, err := loadStackOutputs(ctx, backend)
+	if errors.Is(err, s3object.ErrNotFound) {
+		return nil, temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("referenced stack %s has no state", ref.Stack.StateKey), "UnresolvedReference", nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var v interface{}
+	state := tfstate.State{Outputs: outputs}
+	if err := state.OutputValue(ref.Output, &v); err != nil {
+		return nil, temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("referenced stack %s: %v", ref.Stack.StateKey, err), "UnresolvedReference", nil)
+	}
+	return v, nil
+}
@@ -0,0 +1,335 @@
+package workflows
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+
+	"github.com/dynajoe/temporal-terraform-demo/config/awsconfig"
+	"github.com/dynajoe/temporal-terraform-demo/locktable"
+	"github.com/dynajoe/temporal-terraform-demo/s3object"
+	"github.com/dynajoe/temporal-terraform-demo/stacks"
+	"github.com/dynajoe/temporal-terraform-demo/tfactivity"
+	"github.com/dynajoe/temporal-terraform-demo/tfworkspace"
+)
+
+// StateSecurityWorkflowID is the ID the worker schedules the state security
+// check under
+const StateSecurityWorkflowID = "state-security-check"
+
+// The security controls the state buckets and lock tables are checked for
+const (
+	ControlVersioning        = "versioning"
+	ControlEncryption        = "encryption"
+	ControlPublicAccessBlock = "public access block"
+	ControlTLSOnly           = "tls-only policy"
+	ControlPointInTime       = "lock table point in time recovery"
+)
+
+type (
+	StateSecurityOptions struct {
+		// AlertURL is posted a StateSecurityAlert when a check finds drift
+		AlertURL string
+
+		// Remediate restores drifted controls, the public access block and
+		// TLS-only policy by applying the core:aws/state_bucket_security
+		// stack
+		Remediate bool
+	}
+
+	StateSecurityInput struct {
+		// Routes are the buckets to check, defaults to the default state
+		// bucket and every configured route
+		Routes []StateRoute
+	}
+
+	StateSecurityOutput struct {
+		Findings []StateSecurityFinding
+	}
+
+	// StateSecurityFinding lists the controls a state bucket or its lock
+	// table no longer has
+	StateSecurityFinding struct {
+		Bucket    string
+		LockTable string
+		Drifted   []string
+
+		// Remediated is set once the drifted controls were restored,
+		// otherwise Error says why they weren't
+		Remediated bool
+		Error      string
+	}
+
+	RemediateStateSecurityInput struct {
+		Route   StateRoute
+		Drifted []string
+	}
+
+	StateSecurityAlertInput struct {
+		URL   string
+		Alert StateSecurityAlert
+	}
+
+	// StateSecurityAlert tells operators state buckets drifted
+	StateSecurityAlert struct {
+		WorkflowID string
+		RunID      string
+		Findings   []StateSecurityFinding
+	}
+)
+
+var (
+	stateSecurityMu sync.RWMutex
+	stateSecurity   StateSecurityOptions
+)
+
+// ConfigureStateSecurity sets where drift found by the state security check
+// is reported and whether it is remediated
+func ConfigureStateSecurity(options StateSecurityOptions) {
+	stateSecurityMu.Lock()
+	defer stateSecurityMu.Unlock()
+	stateSecurity = options
+}
+
+func currentStateSecurity() StateSecurityOptions {
+	stateSecurityMu.RLock()
+	defer stateSecurityMu.RUnlock()
+	return stateSecurity
+}
+
+// StateSecurityWorkflow verifies the state buckets still have versioning,
+// default encryption, a public access block and a TLS-only policy, and their
+// lock tables point in time recovery. Drift is alerted on, remediated if
+// configured, and fails the run while it remains. It is meant to run on a
+// cron schedule.
+func StateSecurityWorkflow(ctx workflow.Context, input StateSecurityInput) (StateSecurityOutput, error) {
+	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: time.Minute,
+		RetryPolicy: &temporal.RetryPolicy{
+			InitialInterval:        5 * time.Second,
+			MaximumAttempts:        3,
+			NonRetryableErrorTypes: []string{"LocalState"},
+		},
+	})
+
+	// Routes and options are worker configuration, recorded so replays see
+	// them
+	routes := input.Routes
+	if len(routes) == 0 {
+		if err := workflow.SideEffect(ctx, func(ctx workflow.Context) interface{} {
+			return cleanupRoutes()
+		}).Get(&routes); err != nil {
+			return StateSecurityOutput{}, err
+		}
+	}
+	var options StateSecurityOptions
+	if err := workflow.SideEffect(ctx, func(ctx workflow.Context) interface{} {
+		return currentStateSecurity()
+	}).Get(&options); err != nil {
+		return StateSecurityOutput{}, err
+	}
+
+	var output StateSecurityOutput
+	remaining := 0
+	for _, route := range routes {
+		finding := StateSecurityFinding{Bucket: route.Bucket, LockTable: route.LockTable}
+		if err := workflow.ExecuteActivity(ctx, CheckStateSecurityActivity, route).Get(ctx, &finding.Drifted); err != nil {
+			finding.Error = fmt.Sprintf("unable to check: %v", err)
+			output.Findings = append(output.Findings, finding)
+			remaining++
+			continue
+		}
+		if len(finding.Drifted) == 0 {
+			continue
+		}
+		workflow.GetLogger(ctx).Warn("State bucket security drifted", "Bucket", route.Bucket, "LockTable", route.LockTable, "Drifted", finding.Drifted)
+
+		if options.Remediate {
+			remediateCtx := workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+				StartToCloseTimeout: 15 * time.Minute,
+				HeartbeatTimeout:    time.Minute,
+				RetryPolicy: &temporal.RetryPolicy{
+					InitialInterval: 30 * time.Second,
+					MaximumAttempts: 3,
+				},
+			})
+			err := workflow.ExecuteActivity(remediateCtx, RemediateStateSecurityActivity, RemediateStateSecurityInput{
+				Route:   route,
+				Drifted: finding.Drifted,
+			}).Get(ctx, nil)
+			if err != nil {
+				finding.Error = fmt.Sprintf("unable to remediate: %v", err)
+			} else {
+				finding.Remediated = true
+				workflow.GetLogger(ctx).Info("State bucket security remediated", "Bucket", route.Bucket, "Remediated", finding.Drifted)
+			}
+		}
+		if !finding.Remediated {
+			remaining++
+		}
+		output.Findings = append(output.Findings, finding)
+	}
+
+	if len(output.Findings) > 0 && options.AlertURL != "" {
+		info := workflow.GetInfo(ctx)
+		if err := workflow.ExecuteActivity(ctx, StateSecurityAlertActivity, StateSecurityAlertInput{
+			URL: options.AlertURL,
+			Alert: StateSecurityAlert{
+				WorkflowID: info.WorkflowExecution.ID,
+				RunID:      info.WorkflowExecution.RunID,
+				Findings:   output.Findings,
+			},
+		}).Get(ctx, nil); err != nil {
+			return output, fmt.Errorf("error alerting on state security drift: %w", err)
+		}
+	}
+
+	if remaining > 0 {
+		return output, temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("%d state buckets are not secured as required", remaining), "StateSecurityDrift", nil, output)
+	}
+	return output, nil
+}
+
+// CheckStateSecurityActivity returns the controls the route's bucket and
+// lock table are missing
+func CheckStateSecurityActivity(ctx context.Context, route StateRoute) ([]string, error) {
+	if localStateDir != "" {
+		return nil, temporal.NewNonRetryableApplicationError("state is kept in a local directory, there are no buckets to check", "LocalState", nil)
+	}
+	credentials := awsconfig.LoadConfig().Credentials
+
+	security, err := s3object.New(credentials, route.Region).Security(ctx, route.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("error checking bucket %s: %w", route.Bucket, err)
+	}
+
+	var drifted []string
+	for _, check := range []struct {
+		control string
+		ok      bool
+	}{
+		{ControlVersioning, security.Versioning},
+		{ControlEncryption, security.Encryption},
+		{ControlPublicAccessBlock, security.PublicAccessBlocked},
+		{ControlTLSOnly, security.TLSOnly},
+	} {
+		if !check.ok {
+			drifted = append(drifted, check.control)
+		}
+	}
+
+	if route.LockTable != "" {
+		enabled, err := locktable.New(credentials, route.Region).PointInTimeRecovery(ctx, route.LockTable)
+		if err != nil {
+			return nil, err
+		}
+		if !enabled {
+			drifted = append(drifted, ControlPointInTime)
+		}
+	}
+	return drifted, nil
+}
+
+// RemediateStateSecurityActivity restores the drifted controls. Versioning,
+// encryption and point in time recovery are set on the bucket and table
+// directly, since the pinned AWS provider can only manage them on resources
+// it created.
+func RemediateStateSecurityActivity(ctx context.Context, input RemediateStateSecurityInput) error {
+	awsConfig := awsconfig.LoadConfig()
+	route := input.Route
+	s3Client := s3object.New(awsConfig.Credentials, route.Region)
+
+	applyStack := false
+	for _, control := range input.Drifted {
+		var err error
+		switch control {
+		case ControlVersioning:
+			err = s3Client.EnableVersioning(ctx, route.Bucket)
+		case ControlEncryption:
+			err = s3Client.EnableEncryption(ctx, route.Bucket)
+		case ControlPointInTime:
+			err = locktable.New(awsConfig.Credentials, route.Region).EnablePointInTimeRecovery(ctx, route.LockTable)
+		case ControlPublicAccessBlock, ControlTLSOnly:
+			applyStack = true
+		default:
+			err = fmt.Errorf("unknown control %q", control)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if !applyStack {
+		return nil
+	}
+
+	stack := StackRef{
+		TerraformPath: "core:aws/state_bucket_security",
+		StateKey:      fmt.Sprintf("state-security-%s.tfstate", route.Bucket),
+	}
+	backend, err := StateBackend(ctx, awsConfig.Credentials, stack)
+	if err != nil {
+		return err
+	}
+
+	credentials, err := terraformCredentials(ctx, awsConfig, "")
+	if err != nil {
+		return err
+	}
+
+	tfa := tfactivity.New(tfworkspace.Config{
+		TerraformPath: stack.TerraformPath,
+		S3Backend:     backend,
+		Reports:       true,
+		Outputs:       stacks.StateBucketSecurityOutputContract(),
+	})
+	_, err = tfa.Apply(ctx, tfworkspace.ApplyInput{
+		AwsCredentials: credentials,
+		Env: map[string]string{
+			"AWS_REGION": route.Region,
+		},
+		Vars: stacks.StateBucketSecurityVars{
+			Bucket: route.Bucket,
+		}.Vars(),
+	})
+	return err
+}
+
+// StateSecurityAlertActivity posts the alert to the configured URL
+func StateSecurityAlertActivity(ctx context.Context, input StateSecurityAlertInput) error {
+	if input.URL == "" {
+		return errors.New("state security alert has no url")
+	}
+	body, err := json.Marshal(input.Alert)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, input.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("state security alert failed with status %d: %s", resp.StatusCode, msg)
+	}
+	return nil
+}
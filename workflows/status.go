@@ -0,0 +1,67 @@
+package workflows
+
+import (
+	"go.temporal.io/sdk/workflow"
+
+	"github.com/dynajoe/temporal-terraform-demo/tfworkspace"
+)
+
+// StatusQuery is the query type used to ask a workflow what it is doing
+const StatusQuery = "status"
+
+// Status describes the current phase of a workflow for operators
+type Status struct {
+	Phase string
+
+	// Frozen is set while the workflow is waiting out a change freeze
+	Frozen       bool
+	FrozenReason string
+
+	// Plan summarizes the changes waiting for approval, PlanAnnotations
+	// are what the registered plan annotators found in it
+	Plan            []string
+	PlanAnnotations []PlanAnnotation
+
+	// ApprovalTier is the escalation tier the plan waits on, then the tier
+	// that decided on it
+	ApprovalTier string
+
+	// OverBudget lists the stacks the plan pushes over their budget cap,
+	// approving it then needs OverrideBudget and a reason
+	OverBudget []string
+
+	// Paused is set once an operator pauses the workflow, it holds at the
+	// next safe point until resumed
+	Paused       bool
+	PausedBy     string
+	PausedReason string
+
+	// WaitingFor lists the stack outputs the workflow is waiting on to be
+	// applied
+	WaitingFor []string
+
+	// Annotations are the notes operators attached to the run
+	Annotations []Annotation
+
+	// Outcome is set when the run was canceled, rejected or refused by
+	// policy rather than failing
+	Outcome *tfworkspace.Outcome
+
+	// ShadowEdits are the recent changes a state watcher found made
+	// outside the workflows
+	ShadowEdits []ShadowEdit
+}
+
+// trackStatus registers the status query handler and returns the status it
+// reports, which the workflow updates as it progresses
+func trackStatus(ctx workflow.Context) (*Status, error) {
+	status := &Status{Phase: "starting"}
+	if err := workflow.SetQueryHandler(ctx, StatusQuery, func() (Status, error) {
+		return *status, nil
+	}); err != nil {
+		return nil, err
+	}
+	trackAnnotations(ctx, status)
+
+	return status, nil
+}
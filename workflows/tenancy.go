@@ -0,0 +1,180 @@
+package workflows
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"go.temporal.io/sdk/temporal"
+
+	"github.com/dynajoe/temporal-terraform-demo/config/awsconfig"
+)
+
+// Tenant is a team served by the worker on its own task queue. Activities
+// run for the tenant are confined to its state bucket, role and modules.
+type Tenant struct {
+	Name      string `json:"name"`
+	TaskQueue string `json:"task_queue"`
+
+	// StateBucket stores all the tenant's state, overriding state routes
+	StateBucket string `json:"state_bucket,omitempty"`
+	StateRegion string `json:"state_region,omitempty"`
+	LockTable   string `json:"lock_table,omitempty"`
+
+	// RoleARN is assumed by terraform for all of the tenant's stacks
+	RoleARN string `json:"role_arn,omitempty"`
+
+	// PlanRoleARN is assumed by the tenant's plans instead of RoleARN,
+	// e.g. a role limited to reading
+	PlanRoleARN string `json:"plan_role_arn,omitempty"`
+
+	// AllowedPaths are globs over the terraform paths the tenant may use,
+	// e.g. "team-a:*". A trailing * also matches the directories below, so
+	// "team-a:*" allows "team-a:aws/vpc".
+	AllowedPaths []string `json:"allowed_paths"`
+}
+
+type tenantContextKey struct{}
+
+// LoadTenants reads tenants from a JSON file containing a list of tenants
+func LoadTenants(path string) ([]Tenant, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var tenants []Tenant
+	if err := json.Unmarshal(data, &tenants); err != nil {
+		return nil, fmt.Errorf("error decoding tenants: %w", err)
+	}
+
+	taskQueues := map[string]bool{}
+	for _, t := range tenants {
+		if err := t.validate(); err != nil {
+			return nil, err
+		}
+		if taskQueues[t.TaskQueue] {
+			return nil, fmt.Errorf("tenant %s: task queue %s is used by another tenant", t.Name, t.TaskQueue)
+		}
+		taskQueues[t.TaskQueue] = true
+	}
+	return tenants, nil
+}
+
+func (t Tenant) validate() error {
+	if t.Name == "" || t.TaskQueue == "" {
+		return errors.New("tenant name and task queue are required")
+	}
+	if (t.StateBucket == "") != (t.StateRegion == "") {
+		return fmt.Errorf("tenant %s: state bucket and region must be set together", t.Name)
+	}
+	if t.PlanRoleARN != "" && roleAccount(t.PlanRoleARN) != roleAccount(t.RoleARN) {
+		return fmt.Errorf("tenant %s: plan role needs a role in the same account", t.Name)
+	}
+	for _, pattern := range t.AllowedPaths {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("tenant %s: invalid allowed path [%s]: %w", t.Name, pattern, err)
+		}
+	}
+	return nil
+}
+
+// Allows reports whether the tenant may use the terraform path
+func (t Tenant) Allows(terraformPath string) bool {
+	for _, pattern := range t.AllowedPaths {
+		if ok, _ := path.Match(pattern, terraformPath); ok {
+			return true
+		}
+		if !strings.HasSuffix(pattern, "*") {
+			continue
+		}
+		// * doesn't match /, so match the directories the path is in
+		for i := range terraformPath {
+			if terraformPath[i] != '/' {
+				continue
+			}
+			if ok, _ := path.Match(pattern, terraformPath[:i]); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// WithTenant returns a context for activities run on the tenant's task queue,
+// used as the worker's background activity context
+func WithTenant(ctx context.Context, tenant Tenant) context.Context {
+	return context.WithValue(ctx, tenantContextKey{}, tenant)
+}
+
+func tenantFromContext(ctx context.Context) (Tenant, bool) {
+	tenant, ok := ctx.Value(tenantContextKey{}).(Tenant)
+	return tenant, ok
+}
+
+// checkTenantPath fails when the activity's tenant may not use the path
+func checkTenantPath(ctx context.Context, terraformPath string) error {
+	tenant, ok := tenantFromContext(ctx)
+	if !ok || tenant.Allows(terraformPath) {
+		return nil
+	}
+	return temporal.NewNonRetryableApplicationError(
+		fmt.Sprintf("tenant %s may not use terraform path %s", tenant.Name, terraformPath), "TenantPathDenied", nil)
+}
+
+// tenantRole returns the role terraform assumes: the tenant's role when
+// running for a tenant, otherwise the requested one
+func tenantRole(ctx context.Context, roleARN string) (string, error) {
+	tenant, ok := tenantFromContext(ctx)
+	if !ok || tenant.RoleARN == "" {
+		return roleARN, nil
+	}
+	if roleARN != "" && roleARN != tenant.RoleARN {
+		return "", temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("tenant %s may not assume role %s", tenant.Name, roleARN), "TenantRoleDenied", nil)
+	}
+	return tenant.RoleARN, nil
+}
+
+// terraformCredentials returns the credentials terraform runs with for the
+// requested role, honoring the tenant's role
+func terraformCredentials(ctx context.Context, awsConfig aws.Config, roleARN string) (aws.CredentialsProvider, error) {
+	roleARN, err := tenantRole(ctx, roleARN)
+	if err != nil {
+		return nil, err
+	}
+	return awsconfig.WithRole(awsConfig, roleARN).Credentials, nil
+}
+
+// planCredentials returns the credentials plans run with: the plan role of
+// the tenant or the stack if one is set, otherwise those applies run with.
+// Tenants with a role may only plan with their own plan role.
+func planCredentials(ctx context.Context, awsConfig aws.Config, roleARN string, planRoleARN string) (aws.CredentialsProvider, error) {
+	roleARN, err := tenantRole(ctx, roleARN)
+	if err != nil {
+		return nil, err
+	}
+
+	if tenant, ok := tenantFromContext(ctx); ok && tenant.RoleARN != "" {
+		if planRoleARN != "" && planRoleARN != tenant.PlanRoleARN {
+			return nil, temporal.NewNonRetryableApplicationError(
+				fmt.Sprintf("tenant %s may not plan with role %s", tenant.Name, planRoleARN), "TenantRoleDenied", nil)
+		}
+		planRoleARN = tenant.PlanRoleARN
+	}
+	if planRoleARN == "" {
+		return awsconfig.WithRole(awsConfig, roleARN).Credentials, nil
+	}
+
+	// The state is routed by the account of the role applies use
+	if roleAccount(planRoleARN) != roleAccount(roleARN) {
+		return nil, temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("plan role %s is not in the account of role %s", planRoleARN, roleARN), "PlanRoleDenied", nil)
+	}
+	return awsconfig.WithRole(awsConfig, planRoleARN).Credentials, nil
+}
@@ -0,0 +1,116 @@
+package workflows
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+
+	"github.com/dynajoe/temporal-terraform-demo/config/awsconfig"
+	"github.com/dynajoe/temporal-terraform-demo/tfactivity"
+	"github.com/dynajoe/temporal-terraform-demo/tfexec"
+	"github.com/dynajoe/temporal-terraform-demo/tfworkspace"
+)
+
+type (
+	ModuleTestInput struct {
+		TerraformPath string
+		Region        string
+		RoleARN       string
+		Vars          map[string]interface{}
+
+		// Filter limits the run to the given test files
+		Filter []string
+
+		// TimeoutProfile and Timeouts override the activity timeouts of the
+		// stack's allowed stack definition
+		TimeoutProfile string
+		Timeouts       *ActivityTimeouts
+	}
+
+	ModuleTestActivityInput struct {
+		Module ModuleInput
+		Filter []string
+	}
+
+	ModuleTestOutput struct {
+		Passed bool
+		Report tfexec.TestReport
+	}
+)
+
+// ModuleTestWorkflow runs the terraform tests a module ships. Failing tests
+// are reported in the output rather than failing the workflow.
+func ModuleTestWorkflow(ctx workflow.Context, input ModuleTestInput) (ModuleTestOutput, error) {
+	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		RetryPolicy: &temporal.RetryPolicy{
+			InitialInterval:    5 * time.Second,
+			BackoffCoefficient: 1.3,
+			MaximumInterval:    time.Minute,
+			MaximumAttempts:    5,
+		},
+	})
+
+	ctx, err := withStackTimeouts(ctx, input.TerraformPath, input.TimeoutProfile, input.Timeouts)
+	if err != nil {
+		return ModuleTestOutput{}, err
+	}
+
+	status, err := trackStatus(ctx)
+	if err != nil {
+		return ModuleTestOutput{}, err
+	}
+
+	// Tests keep their state in memory, the key is never written
+	moduleInput := ModuleInput{
+		TerraformPath: input.TerraformPath,
+		StateKey: fmt.Sprintf("test/%s/%s.tfstate",
+			strings.ReplaceAll(input.TerraformPath, ":", "/"), workflow.GetInfo(ctx).WorkflowExecution.ID),
+		Region:  input.Region,
+		RoleARN: input.RoleARN,
+		Vars:    input.Vars,
+	}
+
+	status.Phase = "testing module"
+	var report tfexec.TestReport
+	if err := workflow.ExecuteActivity(ctx, ModuleTestActivity, ModuleTestActivityInput{
+		Module: moduleInput,
+		Filter: input.Filter,
+	}).Get(ctx, &report); err != nil {
+		return ModuleTestOutput{}, err
+	}
+
+	status.Phase = "completed"
+	return ModuleTestOutput{
+		Passed: report.OK(),
+		Report: report,
+	}, nil
+}
+
+func ModuleTestActivity(ctx context.Context, input ModuleTestActivityInput) (tfexec.TestReport, error) {
+	awsConfig := awsconfig.LoadConfig()
+
+	config, err := moduleConfig(ctx, awsConfig, input.Module)
+	if err != nil {
+		return tfexec.TestReport{}, err
+	}
+
+	credentials, err := terraformCredentials(ctx, awsConfig, input.Module.RoleARN)
+	if err != nil {
+		return tfexec.TestReport{}, err
+	}
+
+	return tfactivity.New(config).Test(ctx, tfworkspace.TestInput{
+		AwsCredentials: credentials,
+		// Tests that apply outlive session credentials
+		AwsCredentialsMode: tfworkspace.CredentialsEndpoint,
+		Env: map[string]string{
+			"AWS_REGION": input.Module.Region,
+		},
+		Vars:   input.Module.Vars,
+		Filter: input.Filter,
+	})
+}
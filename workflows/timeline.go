@@ -0,0 +1,41 @@
+package workflows
+
+import (
+	"github.com/dynajoe/temporal-terraform-demo/config/awsconfig"
+	"github.com/dynajoe/temporal-terraform-demo/timeline"
+)
+
+// TimelineSink stores timeline events in the bucket holding operator
+// controls, so a stack's events stay in one place whichever bucket its
+// state is routed to
+func TimelineSink() timeline.Sink {
+	return timeline.S3Sink{
+		Store:  stateObjects(awsconfig.LoadConfig().Credentials, stateRegion),
+		Bucket: stateBucket,
+	}
+}
+
+// TenantTimelineSink stores the timeline events of a tenant's workflows in
+// the tenant's bucket
+func TenantTimelineSink(tenant Tenant) timeline.Sink {
+	route := tenant.controlRoute()
+	return timeline.S3Sink{
+		Store:  route.objects(awsconfig.LoadConfig().Credentials),
+		Bucket: route.Bucket,
+	}
+}
+
+// TimelineOptions exports the approvals of the workflows and skips the
+// helpers that don't change stacks
+func TimelineOptions() timeline.Options {
+	return timeline.Options{
+		ApprovalSignal: ApprovalSignal,
+		IgnoreWorkflowTypes: []string{
+			"MutexWorkflow",
+			"WatchStateWorkflow",
+			"ArtifactCleanupWorkflow",
+			"ApplyQueueWorkflow",
+			"StateSecurityWorkflow",
+		},
+	}
+}
@@ -0,0 +1,100 @@
+package workflows
+
+import (
+	"fmt"
+	"time"
+
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+)
+
+// ActivityTimeouts bound a terraform activity. Zero fields are taken from
+// the profile they override.
+type ActivityTimeouts struct {
+	StartToClose    time.Duration `json:"start_to_close,omitempty"`
+	Heartbeat       time.Duration `json:"heartbeat,omitempty"`
+	ScheduleToClose time.Duration `json:"schedule_to_close,omitempty"`
+}
+
+// defaultTimeoutProfile is used for stacks that don't name a profile
+const defaultTimeoutProfile = "medium"
+
+// timeoutProfiles size activity timeouts to how long stacks take to apply.
+// Heartbeats are sent every 10 seconds regardless of size.
+var timeoutProfiles = map[string]ActivityTimeouts{
+	"small": {
+		StartToClose:    15 * time.Minute,
+		Heartbeat:       time.Minute,
+		ScheduleToClose: time.Hour,
+	},
+	"medium": {
+		StartToClose:    time.Hour,
+		Heartbeat:       time.Minute,
+		ScheduleToClose: 4 * time.Hour,
+	},
+	"large": {
+		StartToClose:    3 * time.Hour,
+		Heartbeat:       2 * time.Minute,
+		ScheduleToClose: 12 * time.Hour,
+	},
+}
+
+func (t ActivityTimeouts) withOverrides(overrides *ActivityTimeouts) ActivityTimeouts {
+	if overrides == nil {
+		return t
+	}
+	if overrides.StartToClose > 0 {
+		t.StartToClose = overrides.StartToClose
+	}
+	if overrides.Heartbeat > 0 {
+		t.Heartbeat = overrides.Heartbeat
+	}
+	if overrides.ScheduleToClose > 0 {
+		t.ScheduleToClose = overrides.ScheduleToClose
+	}
+	return t
+}
+
+// withStackTimeouts sets the activity timeouts for a stack from the named
+// profile, falling back to the profile the allowed stack declares
+func withStackTimeouts(ctx workflow.Context, terraformPath string, profile string, overrides *ActivityTimeouts) (workflow.Context, error) {
+	if profile == "" {
+		// The allowed stacks are worker configuration, record the lookup so
+		// replays on reconfigured workers stay deterministic
+		encoded := workflow.SideEffect(ctx, func(workflow.Context) interface{} {
+			return stackTimeoutProfile(terraformPath)
+		})
+		if err := encoded.Get(&profile); err != nil {
+			return nil, err
+		}
+	}
+
+	timeouts, ok := timeoutProfiles[profile]
+	if !ok {
+		return nil, temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("unknown timeout profile %q", profile), "InvalidInput", nil)
+	}
+	timeouts = timeouts.withOverrides(overrides)
+
+	options := workflow.GetActivityOptions(ctx)
+	options.StartToCloseTimeout = timeouts.StartToClose
+	options.HeartbeatTimeout = timeouts.Heartbeat
+	options.ScheduleToCloseTimeout = timeouts.ScheduleToClose
+	return workflow.WithActivityOptions(ctx, options), nil
+}
+
+// stackTimeoutProfile returns the profile the allowed stack declares
+func stackTimeoutProfile(terraformPath string) string {
+	canonical, err := canonicalTerraformPath(terraformPath)
+	if err != nil {
+		return defaultTimeoutProfile
+	}
+
+	allowedStacksMu.RLock()
+	stack := allowedStacks[canonical]
+	allowedStacksMu.RUnlock()
+	if stack.TimeoutProfile == "" {
+		return defaultTimeoutProfile
+	}
+	return stack.TimeoutProfile
+}
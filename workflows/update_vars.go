@@ -0,0 +1,339 @@
+package workflows
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+
+	"github.com/dynajoe/temporal-terraform-demo/tfworkspace"
+)
+
+type (
+	UpdateVarAcrossStacksInput struct {
+		// Stacks are updated where Patch changes the vars they were last
+		// applied with
+		Stacks []StackRef
+		Region string
+
+		// Patch overrides the recorded vars of every stack, e.g. a new tag
+		// value or AMI ID
+		Patch map[string]interface{}
+
+		// Parallelism bounds how many stacks are applied at once, defaults
+		// to one at a time
+		Parallelism int
+
+		// MaxFailures is how many applies may fail before the remaining
+		// stacks are left untouched, zero halts on the first failure
+		MaxFailures int
+
+		// CoalesceWindow, if set, queues each approved stack change with the
+		// stack's ApplyQueueWorkflow instead of applying it. Changes other
+		// workflows queue within the window are replanned and applied
+		// together with it.
+		CoalesceWindow time.Duration
+	}
+
+	UpdateVarAcrossStacksOutput struct {
+		Status  ResultStatus
+		Results []StackUpdateResult
+	}
+
+	// StackUpdateResult is what the update did to one stack
+	StackUpdateResult struct {
+		StateKey string
+		Status   ResultStatus
+
+		// Skipped is set for stacks left untouched after the batch halted
+		Skipped bool
+		Error   string
+
+		// CombinedWith and Overridden report how a coalesced change was
+		// applied, see CoalescedResult
+		CombinedWith []string
+		Overridden   []string
+	}
+
+	PlanStackUpdateInput struct {
+		Stack  StackRef
+		Region string
+		Patch  map[string]interface{}
+	}
+
+	// StackUpdatePlan is a reviewed plan of patching one stack
+	StackUpdatePlan struct {
+		Stack         StackRef
+		TerraformPath string
+		Vars          map[string]interface{}
+
+		// Affected is false if the stack already has the patched vars
+		Affected bool
+		Plan     tfworkspace.PlanOutput
+	}
+)
+
+// UpdateVarAcrossStacksWorkflow patches a var on many stacks. Every stack is
+// planned first, the combined plan is approved once, then stacks are applied
+// with bounded parallelism until too many fail.
+func UpdateVarAcrossStacksWorkflow(ctx workflow.Context, input UpdateVarAcrossStacksInput) (UpdateVarAcrossStacksOutput, error) {
+	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: time.Hour,
+		HeartbeatTimeout:    time.Minute,
+		RetryPolicy: &temporal.RetryPolicy{
+			InitialInterval:    5 * time.Second,
+			BackoffCoefficient: 1.3,
+			MaximumInterval:    time.Minute,
+			MaximumAttempts:    5,
+		},
+	})
+
+	if len(input.Stacks) == 0 || len(input.Patch) == 0 {
+		return UpdateVarAcrossStacksOutput{}, temporal.NewNonRetryableApplicationError("update needs stacks and a patch", "InvalidInput", nil)
+	}
+	parallelism := input.Parallelism
+	if parallelism <= 0 {
+		parallelism = 1
+	}
+
+	status, err := trackStatus(ctx)
+	if err != nil {
+		return UpdateVarAcrossStacksOutput{}, err
+	}
+	trackPause(ctx, status)
+
+	status.Phase = "checking change freeze"
+	if err := refuseIfFrozen(ctx); err != nil {
+		return UpdateVarAcrossStacksOutput{}, err
+	}
+
+	// Plan every stack side by side
+	status.Phase = "planning stacks"
+	workflowID := workflow.GetInfo(ctx).WorkflowExecution.ID
+	futures := make([]workflow.ChildWorkflowFuture, len(input.Stacks))
+	for i, stack := range input.Stacks {
+		childCtx := workflow.WithChildOptions(ctx, workflow.ChildWorkflowOptions{
+			WorkflowID: fmt.Sprintf("%s-plan-%s", workflowID, stack.StateKey),
+		})
+		futures[i] = workflow.ExecuteChildWorkflow(childCtx, PlanStackUpdateWorkflow, PlanStackUpdateInput{
+			Stack:  stack,
+			Region: input.Region,
+			Patch:  input.Patch,
+		})
+	}
+	var plans []StackUpdatePlan
+	var failed []string
+	for i, future := range futures {
+		var plan StackUpdatePlan
+		if err := future.Get(ctx, &plan); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", input.Stacks[i].StateKey, err))
+			continue
+		}
+		plans = append(plans, plan)
+	}
+	if len(failed) > 0 {
+		return UpdateVarAcrossStacksOutput{}, temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("unable to plan %d of %d stacks: %s", len(failed), len(input.Stacks), strings.Join(failed, "; ")), "BatchPlanFailed", nil)
+	}
+
+	// One approval covers the combined plan
+	results := make([]StackUpdateResult, len(plans))
+	var pending []int
+	var summary []string
+	var planned []PlannedStack
+	var costs []CostEstimate
+	for i, plan := range plans {
+		results[i] = StackUpdateResult{StateKey: plan.Stack.StateKey, Status: StatusNoChanges}
+		if !plan.Affected || len(plan.Plan.Changes) == 0 {
+			continue
+		}
+		pending = append(pending, i)
+		planned = append(planned, PlannedStack{
+			TerraformPath: plan.TerraformPath,
+			StateKey:      plan.Stack.StateKey,
+			Changes:       plan.Plan.Changes,
+		})
+		costs = append(costs, CostEstimate{Stack: plan.Stack, Changes: plan.Plan.Changes})
+		for _, line := range planSummary(plan.Plan.Changes) {
+			summary = append(summary, plan.Stack.StateKey+": "+line)
+		}
+	}
+	if len(pending) == 0 {
+		status.Phase = "completed"
+		return UpdateVarAcrossStacksOutput{Status: StatusNoChanges, Results: results}, nil
+	}
+	annotatePlan(ctx, status, planned)
+	if err := checkBudget(ctx, status, costs); err != nil {
+		return UpdateVarAcrossStacksOutput{}, err
+	}
+	if err := awaitApproval(ctx, status, summary); err != nil {
+		return UpdateVarAcrossStacksOutput{}, err
+	}
+
+	status.Phase = "checking change freeze"
+	if err := waitForThaw(ctx, status); err != nil {
+		return UpdateVarAcrossStacksOutput{}, err
+	}
+
+	status.Phase = "applying stacks"
+	if err := pausePoint(ctx, status); err != nil {
+		return UpdateVarAcrossStacksOutput{}, err
+	}
+
+	var inventories []tfworkspace.Inventory
+	selector := workflow.NewSelector(ctx)
+	running, failures, next := 0, 0, 0
+	halted := false
+	fail := func(i int, err error) {
+		results[i].Error = err.Error()
+		failures++
+		if failures > input.MaxFailures {
+			halted = true
+		}
+	}
+	for {
+		for !halted && running < parallelism && next < len(pending) {
+			i := pending[next]
+			next++
+			plan := plans[i]
+
+			applyCtx, err := withStackTimeouts(ctx, plan.TerraformPath, "", nil)
+			if err != nil {
+				fail(i, err)
+				continue
+			}
+			running++
+			if input.CoalesceWindow > 0 {
+				selector.AddFuture(queueChange(ctx, ApplyQueueInput{
+					Stack:  plan.Stack,
+					Region: input.Region,
+					Window: input.CoalesceWindow,
+				}, input.Patch, plan.Plan.Changes), func(f workflow.Future) {
+					running--
+					var result CoalescedResult
+					if err := f.Get(ctx, &result); err != nil {
+						fail(i, err)
+						return
+					}
+					results[i].CombinedWith = result.CombinedWith
+					results[i].Overridden = result.Overridden
+					if result.Error != "" {
+						fail(i, errors.New(result.Error))
+						return
+					}
+					results[i].Status = result.Status
+				})
+				continue
+			}
+
+			serial := plan.Plan.StateSerial
+			selector.AddFuture(workflow.ExecuteActivity(applyCtx, ApplyModuleActivity, ModuleInput{
+				TerraformPath:   plan.TerraformPath,
+				StateKey:        plan.Stack.StateKey,
+				Region:          input.Region,
+				RoleARN:         plan.Stack.RoleARN,
+				KMSKeyID:        plan.Stack.KMSKeyID,
+				Vars:            plan.Vars,
+				PlannedSerial:   &serial,
+				PlannedVersions: plan.Plan.Versions,
+				PlanRef:         plan.Plan.PlanRef,
+			}), func(f workflow.Future) {
+				running--
+				var output ModuleOutput
+				if err := f.Get(ctx, &output); err != nil {
+					fail(i, err)
+					return
+				}
+				results[i].Status = output.Status
+				inventories = append(inventories, output.Inventory)
+			})
+		}
+		if running == 0 {
+			break
+		}
+		selector.Select(ctx)
+	}
+	for _, i := range pending[next:] {
+		results[i].Skipped = true
+	}
+	if err := recordInventory(ctx, inventories...); err != nil {
+		return UpdateVarAcrossStacksOutput{}, err
+	}
+
+	output := UpdateVarAcrossStacksOutput{Status: StatusNoChanges, Results: results}
+	for _, result := range results {
+		output.Status = output.Status.combine(result.Status)
+	}
+	if halted {
+		return output, temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("halted after %d failed applies, %d stacks were not applied", failures, len(pending)-next), "BatchHalted", nil, output)
+	}
+
+	status.Phase = "completed"
+	return output, nil
+}
+
+// PlanStackUpdateWorkflow plans patching the vars one stack was last applied
+// with
+func PlanStackUpdateWorkflow(ctx workflow.Context, input PlanStackUpdateInput) (StackUpdatePlan, error) {
+	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: time.Hour,
+		HeartbeatTimeout:    time.Minute,
+		RetryPolicy: &temporal.RetryPolicy{
+			InitialInterval:    5 * time.Second,
+			BackoffCoefficient: 1.3,
+			MaximumInterval:    time.Minute,
+			MaximumAttempts:    5,
+		},
+	})
+
+	var record StackRecord
+	if err := workflow.ExecuteActivity(ctx, LoadStackRecordActivity, input.Stack).Get(ctx, &record); err != nil {
+		return StackUpdatePlan{}, err
+	}
+
+	vars, err := record.withVars(input.Patch)
+	if err != nil {
+		return StackUpdatePlan{}, err
+	}
+
+	plan := StackUpdatePlan{
+		Stack:         input.Stack,
+		TerraformPath: record.TerraformPath,
+		Vars:          vars,
+	}
+	for name, v := range input.Patch {
+		if !sameVar(record.Vars[name], v) {
+			plan.Affected = true
+		}
+	}
+	if !plan.Affected {
+		return plan, nil
+	}
+
+	if err := workflow.ExecuteActivity(ctx, PlanModuleActivity, ModuleInput{
+		TerraformPath: record.TerraformPath,
+		StateKey:      input.Stack.StateKey,
+		Region:        input.Region,
+		RoleARN:       input.Stack.RoleARN,
+		PlanRoleARN:   input.Stack.PlanRoleARN,
+		KMSKeyID:      input.Stack.KMSKeyID,
+		Vars:          vars,
+		ForceReplan:   true,
+	}).Get(ctx, &plan.Plan); err != nil {
+		return StackUpdatePlan{}, err
+	}
+	return plan, nil
+}
+
+// sameVar compares var values by their JSON encoding, values read back from
+// reports don't keep their Go types
+func sameVar(a interface{}, b interface{}) bool {
+	encodedA, errA := json.Marshal(a)
+	encodedB, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(encodedA) == string(encodedB)
+}
@@ -0,0 +1,151 @@
+package workflows
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+
+	"github.com/dynajoe/temporal-terraform-demo/tfexec"
+)
+
+const defaultValidateModuleTTL = 30 * time.Minute
+
+type (
+	ValidateModuleInput struct {
+		TerraformPath string
+		Region        string
+		RoleARN       string
+		Vars          map[string]interface{}
+
+		// VarRefs set vars from the outputs of other stacks, WaitForRefs
+		// waits up to this long for them to be applied
+		VarRefs     map[string]StackOutputRef
+		WaitForRefs time.Duration
+
+		// TTL bounds how long the module may take to apply before it is
+		// torn down regardless of the outcome
+		TTL time.Duration
+
+		Parallelism      int
+		ResourceTimeouts map[string]tfexec.ResourceTimeouts
+
+		// TimeoutProfile and Timeouts override the activity timeouts of the
+		// stack's allowed stack definition
+		TimeoutProfile string
+		Timeouts       *ActivityTimeouts
+	}
+
+	ValidateModuleOutput struct {
+		Output map[string]interface{}
+	}
+)
+
+// ValidateModuleWorkflow proves a module actually applies by provisioning it,
+// verifying its outputs and destroying it again
+func ValidateModuleWorkflow(ctx workflow.Context, input ValidateModuleInput) (ValidateModuleOutput, error) {
+	ttl := input.TTL
+	if ttl == 0 {
+		ttl = defaultValidateModuleTTL
+	}
+
+	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		// Destroy must not start until a canceled apply has exited
+		WaitForCancellation: true,
+		RetryPolicy: &temporal.RetryPolicy{
+			InitialInterval:    5 * time.Second,
+			BackoffCoefficient: 1.3,
+			MaximumInterval:    time.Minute,
+			MaximumAttempts:    5,
+		},
+	})
+
+	ctx, err := withStackTimeouts(ctx, input.TerraformPath, input.TimeoutProfile, input.Timeouts)
+	if err != nil {
+		return ValidateModuleOutput{}, err
+	}
+
+	status, err := trackStatus(ctx)
+	if err != nil {
+		return ValidateModuleOutput{}, err
+	}
+
+	moduleInput := ModuleInput{
+		TerraformPath: input.TerraformPath,
+		StateKey: fmt.Sprintf("validate/%s/%s.tfstate",
+			strings.ReplaceAll(input.TerraformPath, ":", "/"), workflow.GetInfo(ctx).WorkflowExecution.ID),
+		Region:  input.Region,
+		RoleARN: input.RoleARN,
+		Vars:    input.Vars,
+
+		VarRefs:     input.VarRefs,
+		WaitForRefs: input.WaitForRefs,
+
+		Parallelism:      input.Parallelism,
+		ResourceTimeouts: input.ResourceTimeouts,
+	}
+
+	status.Phase = "checking change freeze"
+	if err := waitForThaw(ctx, status); err != nil {
+		return ValidateModuleOutput{}, err
+	}
+
+	if input.WaitForRefs > 0 {
+		status.Phase = "waiting for dependencies"
+		if err := waitForVarRefs(ctx, status, moduleInput); err != nil {
+			return ValidateModuleOutput{}, err
+		}
+	}
+
+	// Apply and verify outputs, giving up once the TTL expires
+	status.Phase = "applying module"
+	applyCtx, cancelApply := workflow.WithCancel(ctx)
+	applyFuture := workflow.ExecuteActivity(applyCtx, ApplyModuleActivity, moduleInput)
+
+	var output ModuleOutput
+	var applyErr error
+	timedOut := false
+	selector := workflow.NewSelector(ctx)
+	selector.AddFuture(applyFuture, func(f workflow.Future) {
+		applyErr = f.Get(ctx, &output)
+	})
+	selector.AddFuture(workflow.NewTimer(applyCtx, ttl), func(f workflow.Future) {
+		timedOut = true
+		cancelApply()
+		// Wait for terraform to exit before tearing down
+		_ = applyFuture.Get(ctx, nil)
+	})
+	selector.Select(ctx)
+	cancelApply()
+
+	// Always tear down, even if the apply failed part way or the workflow is
+	// being canceled
+	status.Phase = "destroying module"
+	destroyCtx, cancelDestroy := workflow.NewDisconnectedContext(ctx)
+	defer cancelDestroy()
+	destroyErr := workflow.ExecuteActivity(destroyCtx, DestroyModuleActivity, moduleInput).Get(destroyCtx, nil)
+
+	switch {
+	case timedOut:
+		applyErr = fmt.Errorf("module did not apply within %s", ttl)
+	case applyErr != nil:
+		applyErr = fmt.Errorf("module failed to apply: %w", applyErr)
+	}
+
+	if destroyErr != nil {
+		if applyErr != nil {
+			return ValidateModuleOutput{}, fmt.Errorf("%v; resources may be orphaned in state %s: %w", applyErr, moduleInput.StateKey, destroyErr)
+		}
+		return ValidateModuleOutput{}, fmt.Errorf("module applied but resources may be orphaned in state %s: %w", moduleInput.StateKey, destroyErr)
+	}
+	if applyErr != nil {
+		return ValidateModuleOutput{}, applyErr
+	}
+
+	status.Phase = "completed"
+	return ValidateModuleOutput{
+		Output: output.Output,
+	}, nil
+}
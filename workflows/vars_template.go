@@ -0,0 +1,134 @@
+package workflows
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"text/template"
+
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/temporal"
+)
+
+// varsTemplateFuncs are the only functions var templates may call
+var varsTemplateFuncs = template.FuncMap{
+	"lower":      strings.ToLower,
+	"upper":      strings.ToUpper,
+	"replace":    func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+	"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+}
+
+// withRenderedVars returns the input with template expressions in its Vars
+// rendered, e.g. "{{ .Name }}-{{ .Region }}". Templates see TemplateData and
+// the stack's Region, StateKey, Stack, TerraformPath and WorkflowID.
+func (input ModuleInput) withRenderedVars(ctx context.Context) (ModuleInput, error) {
+	data := map[string]string{
+		"Region":        input.Region,
+		"StateKey":      input.StateKey,
+		"Stack":         strings.TrimSuffix(input.StateKey, path.Ext(input.StateKey)),
+		"TerraformPath": input.TerraformPath,
+		"WorkflowID":    activity.GetInfo(ctx).WorkflowExecution.ID,
+	}
+	for k, v := range input.TemplateData {
+		if _, ok := data[k]; ok {
+			return ModuleInput{}, temporal.NewNonRetryableApplicationError(
+				fmt.Sprintf("template data %s is reserved", k), "InvalidVarsTemplate", nil)
+		}
+		data[k] = v
+	}
+
+	vars := make(map[string]interface{}, len(input.Vars))
+	for name, v := range input.Vars {
+		rendered, err := renderVar(name, v, data)
+		if err != nil {
+			return ModuleInput{}, temporal.NewNonRetryableApplicationError(err.Error(), "InvalidVarsTemplate", nil)
+		}
+		vars[name] = rendered
+	}
+	input.Vars = vars
+	return input, nil
+}
+
+// renderVar renders the strings in a var, descending into lists and maps
+func renderVar(name string, v interface{}, data map[string]string) (interface{}, error) {
+	switch value := v.(type) {
+	case string:
+		if !isVarTemplate(value) {
+			return value, nil
+		}
+		t, err := template.New(name).Funcs(varsTemplateFuncs).Option("missingkey=error").Parse(value)
+		if err != nil {
+			return nil, fmt.Errorf("var %s: %w", name, err)
+		}
+		var b strings.Builder
+		if err := t.Execute(&b, data); err != nil {
+			return nil, fmt.Errorf("var %s: %w", name, err)
+		}
+		return b.String(), nil
+	case []interface{}:
+		list := make([]interface{}, len(value))
+		for i, item := range value {
+			rendered, err := renderVar(fmt.Sprintf("%s[%d]", name, i), item, data)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = rendered
+		}
+		return list, nil
+	case []string:
+		list := make([]string, len(value))
+		for i, item := range value {
+			rendered, err := renderVar(fmt.Sprintf("%s[%d]", name, i), item, data)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = rendered.(string)
+		}
+		return list, nil
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(value))
+		for k, item := range value {
+			rendered, err := renderVar(name+"."+k, item, data)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = rendered
+		}
+		return m, nil
+	default:
+		return v, nil
+	}
+}
+
+func isVarTemplate(s string) bool {
+	return strings.Contains(s, "{{")
+}
+
+// containsVarTemplate reports whether any string in a var is a template
+func containsVarTemplate(v interface{}) bool {
+	switch value := v.(type) {
+	case string:
+		return isVarTemplate(value)
+	case []string:
+		for _, item := range value {
+			if isVarTemplate(item) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, item := range value {
+			if containsVarTemplate(item) {
+				return true
+			}
+		}
+	case map[string]interface{}:
+		for _, item := range value {
+			if containsVarTemplate(item) {
+				return true
+			}
+		}
+	}
+	return false
+}
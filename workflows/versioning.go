@@ -0,0 +1,27 @@
+package workflows
+
+import (
+	"go.temporal.io/sdk/workflow"
+)
+
+// Change IDs gate steps added to workflows after they were first deployed,
+// so histories started before a change replay without it
+const (
+	changeFreezeVersion        = "change-freeze"
+	networkApprovalVersion     = "network-plan-approval"
+	inventoryAttributesVersion = "inventory-search-attributes"
+	pauseVersion               = "pause-resume"
+	recordOutcomeVersion       = "record-outcome"
+	planAnnotationVersion      = "plan-annotations"
+	approvalEscalationVersion  = "approval-escalation"
+	budgetCheckVersion         = "budget-check"
+	subnetApprovalVersion      = "subnet-plan-approval"
+	shadowEditStatusVersion    = "shadow-edit-status"
+	peeringCheckVersion        = "network-peering-check"
+)
+
+// hasChange reports whether the running workflow takes the steps added with
+// changeID. Runs started before the change keep replaying without them.
+func hasChange(ctx workflow.Context, changeID string) bool {
+	return workflow.GetVersion(ctx, changeID, workflow.DefaultVersion, 1) == 1
+}
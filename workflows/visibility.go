@@ -0,0 +1,69 @@
+package workflows
+
+import (
+	"sort"
+
+	"go.temporal.io/sdk/workflow"
+
+	"github.com/dynajoe/temporal-terraform-demo/tfworkspace"
+)
+
+// Search attributes describing what a workflow's stacks manage. They have to
+// be registered with the cluster before ConfigureSearchAttributes enables
+// them, see `make search-attributes`.
+const (
+	// ResourceCountAttribute (Int) is the number of managed resources
+	ResourceCountAttribute = "TerraformResourceCount"
+
+	// ProvidersAttribute (Keyword) lists each provider both as source and
+	// as source@version, e.g. hashicorp/aws and hashicorp/aws@3.59.0
+	ProvidersAttribute = "TerraformProviders"
+)
+
+var searchAttributesEnabled bool
+
+// ConfigureSearchAttributes turns on upserting the inventory search
+// attributes after applies
+func ConfigureSearchAttributes(enabled bool) {
+	searchAttributesEnabled = enabled
+}
+
+// recordInventory upserts the search attributes for the stacks a workflow
+// applied
+func recordInventory(ctx workflow.Context, inventories ...tfworkspace.Inventory) error {
+	if !hasChange(ctx, inventoryAttributesVersion) {
+		return nil
+	}
+
+	// Recorded so replays don't depend on the worker's configuration
+	var enabled bool
+	if err := workflow.SideEffect(ctx, func(workflow.Context) interface{} {
+		return searchAttributesEnabled
+	}).Get(&enabled); err != nil {
+		return err
+	}
+	if !enabled {
+		return nil
+	}
+
+	count := 0
+	seen := map[string]bool{}
+	providers := []string{}
+	for _, inventory := range inventories {
+		count += inventory.ResourceCount
+		for source, version := range inventory.Providers {
+			for _, p := range []string{source, source + "@" + version} {
+				if !seen[p] {
+					seen[p] = true
+					providers = append(providers, p)
+				}
+			}
+		}
+	}
+	sort.Strings(providers)
+
+	return workflow.UpsertSearchAttributes(ctx, map[string]interface{}{
+		ResourceCountAttribute: count,
+		ProvidersAttribute:     providers,
+	})
+}
@@ -0,0 +1,229 @@
+package workflows
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+
+	"github.com/dynajoe/temporal-terraform-demo/config/awsconfig"
+	"github.com/dynajoe/temporal-terraform-demo/s3object"
+	"github.com/dynajoe/temporal-terraform-demo/tfstate"
+	"github.com/dynajoe/temporal-terraform-demo/tfworkspace"
+)
+
+const (
+	// shadowEditSignal was sent to NotifyWorkflowID by runs started before
+	// shadow edits were kept on the watcher's status
+	shadowEditSignal = "shadow-edit"
+
+	defaultWatchInterval = 5 * time.Minute
+	maxWatchInterval     = time.Hour
+
+	// watchPollsPerRun bounds the history of a single run before it
+	// continues as new
+	watchPollsPerRun = 500
+
+	// watchRecentEdits is how many shadow edits the status keeps
+	watchRecentEdits = 20
+)
+
+type (
+	WatchStateInput struct {
+		Stack StackRef
+
+		// Interval between polls, backed off while S3 is throttling
+		Interval time.Duration
+
+		// SettleTime is how long a change may go without an execution
+		// report before it is treated as a shadow edit. Terraform writes
+		// state during an apply but the report only once it finishes, so
+		// this defaults to the longest activity timeout.
+		SettleTime time.Duration
+
+		// NotifyWorkflowID is only signaled by runs started before shadow
+		// edits were kept on the status, nothing handled the signal
+		NotifyWorkflowID string
+
+		// Last is the version seen by the previous run, Edits the shadow
+		// edits it found
+		Last  *StateVersion
+		Edits []ShadowEdit
+	}
+
+	// StateVersion identifies the contents of a stack's state and the last
+	// execution report written for it
+	StateVersion struct {
+		Exists     bool
+		Lineage    string
+		Serial     int64
+		LastReport string
+	}
+
+	// ShadowEdit describes a state change made outside the workflows, e.g.
+	// by someone running terraform locally
+	ShadowEdit struct {
+		StateKey   string
+		Lineage    string
+		FromSerial int64
+		ToSerial   int64
+		DetectedAt time.Time
+	}
+)
+
+// WatchStateWorkflow polls a stack's state for changes that aren't
+// accompanied by an execution report, logs them and lists the recent ones
+// on its status. It runs until canceled.
+func WatchStateWorkflow(ctx workflow.Context, input WatchStateInput) error {
+	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: time.Minute,
+		RetryPolicy: &temporal.RetryPolicy{
+			InitialInterval:    5 * time.Second,
+			BackoffCoefficient: 2,
+			MaximumInterval:    time.Minute,
+			MaximumAttempts:    5,
+			// Throttling is handled by polling less often
+			NonRetryableErrorTypes: []string{"Throttled"},
+		},
+	})
+
+	status, err := trackStatus(ctx)
+	if err != nil {
+		return err
+	}
+	status.ShadowEdits = input.Edits
+
+	interval := input.Interval
+	if interval <= 0 {
+		interval = defaultWatchInterval
+	}
+	pollInterval := interval
+	settleTime := input.SettleTime
+	if settleTime <= 0 {
+		settleTime = timeoutProfiles["large"].StartToClose
+	}
+
+	last := input.Last
+	var pendingSince time.Time
+
+	for i := 0; i < watchPollsPerRun; i++ {
+		status.Phase = "polling state"
+		var current StateVersion
+		err := workflow.ExecuteActivity(ctx, StateVersionActivity, input.Stack).Get(ctx, &current)
+		var appErr *temporal.ApplicationError
+		switch {
+		case errors.As(err, &appErr) && appErr.Type() == "Throttled":
+			pollInterval *= 2
+			if pollInterval > maxWatchInterval {
+				pollInterval = maxWatchInterval
+			}
+			workflow.GetLogger(ctx).Warn("State polling throttled, backing off", "Interval", pollInterval)
+		case err != nil:
+			return err
+		default:
+			pollInterval = interval
+			switch {
+			case last == nil || !current.edited(*last):
+				last, pendingSince = &current, time.Time{}
+			case pendingSince.IsZero():
+				pendingSince = workflow.Now(ctx)
+			case workflow.Now(ctx).Sub(pendingSince) >= settleTime:
+				if err := notifyShadowEdit(ctx, input, status, *last, current); err != nil {
+					return err
+				}
+				last, pendingSince = &current, time.Time{}
+			}
+		}
+
+		status.Phase = "waiting"
+		if err := workflow.Sleep(ctx, pollInterval); err != nil {
+			return err
+		}
+	}
+
+	input.Last = last
+	input.Edits = status.ShadowEdits
+	return workflow.NewContinueAsNewError(ctx, WatchStateWorkflow, input)
+}
+
+// edited reports whether the state changed since last without a new
+// execution report
+func (v StateVersion) edited(last StateVersion) bool {
+	if v.Exists == last.Exists && v.Lineage == last.Lineage && v.Serial == last.Serial {
+		return false
+	}
+	return v.LastReport == last.LastReport
+}
+
+func notifyShadowEdit(ctx workflow.Context, input WatchStateInput, status *Status, from StateVersion, to StateVersion) error {
+	edit := ShadowEdit{
+		StateKey:   input.Stack.StateKey,
+		Lineage:    to.Lineage,
+		FromSerial: from.Serial,
+		ToSerial:   to.Serial,
+		DetectedAt: workflow.Now(ctx),
+	}
+	workflow.GetLogger(ctx).Warn("State changed outside of the workflows",
+		"StateKey", edit.StateKey, "FromSerial", edit.FromSerial, "ToSerial", edit.ToSerial)
+
+	if hasChange(ctx, shadowEditStatusVersion) {
+		status.ShadowEdits = append(status.ShadowEdits, edit)
+		if len(status.ShadowEdits) > watchRecentEdits {
+			status.ShadowEdits = status.ShadowEdits[len(status.ShadowEdits)-watchRecentEdits:]
+		}
+		return nil
+	}
+
+	if input.NotifyWorkflowID == "" {
+		return nil
+	}
+	err := workflow.SignalExternalWorkflow(ctx, input.NotifyWorkflowID, "", shadowEditSignal, edit).Get(ctx, nil)
+	if err != nil {
+		// The owner may have finished, keep watching regardless
+		workflow.GetLogger(ctx).Error("Unable to notify owning workflow of shadow edit",
+			"WorkflowID", input.NotifyWorkflowID, "Error", err)
+	}
+	return nil
+}
+
+// StateVersionActivity reads the version of a stack's state and the key of
+// its most recent execution report
+func StateVersionActivity(ctx context.Context, stack StackRef) (StateVersion, error) {
+	awsConfig := awsconfig.LoadConfig()
+
+	backend, err := StateBackend(ctx, awsConfig.Credentials, stack)
+	if err != nil {
+		return StateVersion{}, err
+	}
+
+	var version StateVersion
+	state, err := tfstate.Load(ctx, backend)
+	switch {
+	case errors.Is(err, s3object.ErrNotFound):
+	case err != nil:
+		return StateVersion{}, throttled(err)
+	default:
+		version.Exists = true
+		version.Lineage = state.Lineage
+		version.Serial = state.Serial
+	}
+
+	keys, err := tfworkspace.ReportKeys(ctx, backend)
+	if err != nil {
+		return StateVersion{}, throttled(err)
+	}
+	if len(keys) > 0 {
+		version.LastReport = keys[len(keys)-1]
+	}
+	return version, nil
+}
+
+// throttled marks S3 throttling errors so the caller can back off
+func throttled(err error) error {
+	if s3object.IsThrottled(err) {
+		return temporal.NewNonRetryableApplicationError(err.Error(), "Throttled", nil)
+	}
+	return err
+}
@@ -4,12 +4,121 @@ import (
 	"go.temporal.io/sdk/worker"
 )
 
+// stateBucket holds operator controls and the state of stacks that no
+// configured route matches
+const (
+	stateBucket = "temporal-terraform-demo-state"
+	stateRegion = "us-west-2"
+)
+
+// Register registers every workflow and activity. Activities that change
+// infrastructure or state are also registered here for the runs that
+// scheduled them before they moved to the write task queue.
 func Register(w worker.Worker) {
-	w.RegisterWorkflow(CreateDemoNetworkWorkflow)
-	w.RegisterActivity(CreateVPCActivity)
-	w.RegisterActivity(CreateSubnetsActivity)
+	registerWorkflows(w)
+	registerReadActivities(w)
+	registerWriteActivities(w)
+}
+
+// RegisterReadOnly registers every workflow, so the worker can answer
+// queries, but only the activities that leave infrastructure and state
+// untouched. NewWriteQueueInterceptor schedules the other activities on the
+// write task queue, which only workers registered with RegisterWrites poll.
+func RegisterReadOnly(w worker.Worker) {
+	registerWorkflows(w)
+	registerReadActivities(w)
+}
+
+// RegisterWrites registers the activities that change infrastructure or
+// state, for a worker polling WriteTaskQueue
+func RegisterWrites(w worker.Worker) {
+	registerWriteActivities(w)
+}
 
+func registerWorkflows(w worker.Worker) {
+	w.RegisterWorkflow(CreateDemoNetworkWorkflow)
 	w.RegisterWorkflow(DestroyDemoNetworkWorkflow)
-	w.RegisterActivity(DestroyVPCActivity)
-	w.RegisterActivity(DestroySubnetsActivity)
+	w.RegisterWorkflow(ValidateModuleWorkflow)
+	w.RegisterWorkflow(ModuleTestWorkflow)
+	w.RegisterWorkflow(CloneStackWorkflow)
+	w.RegisterWorkflow(RenameStackWorkflow)
+	w.RegisterWorkflow(UpdateVarAcrossStacksWorkflow)
+	w.RegisterWorkflow(PlanStackUpdateWorkflow)
+	w.RegisterWorkflow(RestorePreChangeStateWorkflow)
+	w.RegisterWorkflow(WatchStateWorkflow)
+	w.RegisterWorkflow(PeerNetworksWorkflow)
+	w.RegisterWorkflow(PeerRoutesWorkflow)
+	w.RegisterWorkflow(UnpeerNetworksWorkflow)
+	w.RegisterWorkflow(AttachTransitGatewayWorkflow)
+	w.RegisterWorkflow(CreateDemoDNSWorkflow)
+	w.RegisterWorkflow(DestroyDemoDNSWorkflow)
+	w.RegisterWorkflow(SandboxWorkflow)
+	w.RegisterWorkflow(MutexWorkflow)
+	w.RegisterWorkflow(ArtifactCleanupWorkflow)
+	w.RegisterWorkflow(MigrateDemoWorkflow)
+	w.RegisterWorkflow(ApplyQueueWorkflow)
+	w.RegisterWorkflow(StateSecurityWorkflow)
+}
+
+// registerReadActivities registers plans and lookups
+func registerReadActivities(w worker.Worker) {
+	w.RegisterActivity(PlanVPCActivity)
+	w.RegisterActivity(PlanModuleActivity)
+	w.RegisterActivity(PlanSandboxActivity)
+	w.RegisterActivity(LoadStackRecordActivity)
+	w.RegisterActivity(StateVersionActivity)
+	w.RegisterActivity(ResolveNetworkActivity)
+	w.RegisterActivity(ResolveNetworkSubnetsActivity)
+	w.RegisterActivity(CheckNetworkPeeringsActivity)
+	w.RegisterActivity(ResolvePeeringActivity)
+	w.RegisterActivity(CheckChangeFreezeActivity)
+	w.RegisterActivity(ProviderSchemaActivity)
+	w.RegisterActivity(StackOutputReadyActivity)
+	w.RegisterActivity(AnnotatePlanActivity)
+	w.RegisterActivity(EstimateStackCostsActivity)
+	w.RegisterActivity(CheckStateSecurityActivity)
+	w.RegisterActivity(StateSecurityAlertActivity)
+	w.RegisterActivity(NotifyApproversActivity)
+	w.RegisterActivity(DiscoverDemoNetworksActivity)
+	w.RegisterActivity(PlanSubnetsActivity)
+}
+
+// writeActivities are applies, destroys and anything else that changes
+// infrastructure or state
+var writeActivities = []interface{}{
+	CreateVPCActivity,
+	CreateSubnetsActivity,
+	DestroyVPCActivity,
+	DestroySubnetsActivity,
+
+	ApplyModuleActivity,
+	DestroyModuleActivity,
+	ModuleTestActivity,
+
+	MoveStateActivity,
+	RemediateStateSecurityActivity,
+	RemoveStateActivity,
+	RestorePreChangeStateActivity,
+	RecordOutcomeActivity,
+	CleanupArtifactsActivity,
+	QueueChangeActivity,
+	AdoptStackActivity,
+	CopyStateActivity,
+	RemoveCopiedStateActivity,
+
+	RecordPeeringActivity,
+	CreatePeeringActivity,
+	ApplyPeerRoutesActivity,
+	RemovePeeringActivity,
+	DestroyPeeringActivity,
+	DestroyPeerRoutesActivity,
+	CreateAttachmentActivity,
+
+	RequestLockActivity,
+}
+
+func registerWriteActivities(w worker.Worker) {
+	for _, a := range writeActivities {
+		w.RegisterActivity(a)
+	}
 }
@@ -0,0 +1,103 @@
+package workflows
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/suite"
+	"go.temporal.io/sdk/testsuite"
+	"go.temporal.io/sdk/workflow"
+
+	"github.com/dynajoe/temporal-terraform-demo/tfexec"
+	"github.com/dynajoe/temporal-terraform-demo/tfworkspace"
+)
+
+type workflowTestSuite struct {
+	suite.Suite
+	testsuite.WorkflowTestSuite
+
+	env *testsuite.TestWorkflowEnvironment
+}
+
+func TestWorkflows(t *testing.T) {
+	suite.Run(t, new(workflowTestSuite))
+}
+
+func (s *workflowTestSuite) SetupTest() {
+	s.env = s.NewTestWorkflowEnvironment()
+}
+
+func (s *workflowTestSuite) TearDownTest() {
+	s.env.AssertExpectations(s.T())
+
+	// The policies are worker configuration shared by every test
+	s.Require().NoError(ConfigureApprovalPolicy(ApprovalPolicy{}))
+	s.Require().NoError(ConfigureBudgetPolicy(BudgetPolicy{}))
+}
+
+// status returns what StatusQuery reports for the workflow under test
+func (s *workflowTestSuite) status() Status {
+	encoded, err := s.env.QueryWorkflow(StatusQuery)
+	s.Require().NoError(err)
+
+	var status Status
+	s.Require().NoError(encoded.Get(&status))
+	return status
+}
+
+// signalAfter sends the workflow under test a signal once delay passed
+func (s *workflowTestSuite) signalAfter(delay time.Duration, name string, arg interface{}) {
+	s.env.RegisterDelayedCallback(func() {
+		s.env.SignalWorkflow(name, arg)
+	}, delay)
+}
+
+// planMocks records the patches stacks were planned with
+type planMocks struct {
+	mu      sync.Mutex
+	patches []map[string]interface{}
+}
+
+// mockPlans plans every stack update to make changes, with no change
+// freeze in effect and no plan annotations
+func (s *workflowTestSuite) mockPlans(changes []tfexec.ResourceChange) *planMocks {
+	m := &planMocks{}
+
+	s.env.RegisterWorkflow(PlanStackUpdateWorkflow)
+	s.env.OnWorkflow(PlanStackUpdateWorkflow, mock.Anything, mock.Anything).Return(
+		func(ctx workflow.Context, input PlanStackUpdateInput) (StackUpdatePlan, error) {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			m.patches = append(m.patches, input.Patch)
+			return StackUpdatePlan{
+				Stack:         input.Stack,
+				TerraformPath: input.Stack.TerraformPath,
+				Vars:          input.Patch,
+				Affected:      true,
+				Plan:          tfworkspace.PlanOutput{Changes: changes, StateSerial: 4},
+			}, nil
+		}).Maybe()
+	s.env.OnActivity(CheckChangeFreezeActivity, mock.Anything).Return(ChangeFreeze{}, nil).Maybe()
+	s.env.OnActivity(AnnotatePlanActivity, mock.Anything, mock.Anything).Return(nil, nil).Maybe()
+	return m
+}
+
+var (
+	testStack = StackRef{
+		TerraformPath: "core:aws/vpc",
+		RoleARN:       "arn:aws:iam::123456789012:role/deploy",
+		StateKey:      "network/vpc.tfstate",
+	}
+
+	testChanges = []tfexec.ResourceChange{
+		{Address: "aws_vpc.vpc", Actions: []string{"create"}},
+	}
+
+	testUpdate = UpdateVarAcrossStacksInput{
+		Stacks: []StackRef{testStack},
+		Region: "us-east-1",
+		Patch:  map[string]interface{}{"name": "main"},
+	}
+)
@@ -0,0 +1,81 @@
+package workflows
+
+import (
+	"context"
+	"reflect"
+	"runtime"
+	"strings"
+
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/interceptor"
+	"go.temporal.io/sdk/workflow"
+)
+
+// writeTaskQueueSuffix names the write task queue of a task queue
+const writeTaskQueueSuffix = "-changes"
+
+type (
+	writeQueueInterceptor struct {
+		interceptor.WorkerInterceptorBase
+		names map[string]bool
+	}
+
+	writeQueueWorkflowInterceptor struct {
+		interceptor.WorkflowInboundInterceptorBase
+		root *writeQueueInterceptor
+	}
+
+	writeQueueOutboundInterceptor struct {
+		interceptor.WorkflowOutboundInterceptorBase
+		root *writeQueueInterceptor
+	}
+)
+
+// WriteTaskQueue is where workflows on taskQueue schedule the activities
+// that change infrastructure or state. Read-only workers don't poll it.
+func WriteTaskQueue(taskQueue string) string {
+	return taskQueue + writeTaskQueueSuffix
+}
+
+// NewWriteQueueInterceptor returns a worker interceptor scheduling the
+// activities registered by RegisterWrites on the WriteTaskQueue of the
+// workflow's task queue. Every worker of the task queue needs it, read-only
+// ones included.
+func NewWriteQueueInterceptor() interceptor.WorkerInterceptor {
+	names := make(map[string]bool, len(writeActivities))
+	for _, a := range writeActivities {
+		names[activityName(a)] = true
+	}
+	return &writeQueueInterceptor{names: names}
+}
+
+func (w *writeQueueInterceptor) InterceptWorkflow(ctx workflow.Context, next interceptor.WorkflowInboundInterceptor) interceptor.WorkflowInboundInterceptor {
+	i := &writeQueueWorkflowInterceptor{root: w}
+	i.Next = next
+	return i
+}
+
+func (w *writeQueueWorkflowInterceptor) Init(outbound interceptor.WorkflowOutboundInterceptor) error {
+	o := &writeQueueOutboundInterceptor{root: w.root}
+	o.Next = outbound
+	return w.Next.Init(o)
+}
+
+func (o *writeQueueOutboundInterceptor) ExecuteActivity(ctx workflow.Context, activityType string, args ...interface{}) workflow.Future {
+	if o.root.names[activityType] {
+		ctx = workflow.WithTaskQueue(ctx, WriteTaskQueue(workflow.GetInfo(ctx).TaskQueueName))
+	}
+	return o.Next.ExecuteActivity(ctx, activityType, args...)
+}
+
+// workflowTaskQueue is the task queue of the activity's workflow, where
+// workflows the activity starts belong too
+func workflowTaskQueue(ctx context.Context) string {
+	return strings.TrimSuffix(activity.GetInfo(ctx).TaskQueue, writeTaskQueueSuffix)
+}
+
+// activityName is the name a function is registered as
+func activityName(a interface{}) string {
+	name := runtime.FuncForPC(reflect.ValueOf(a).Pointer()).Name()
+	return strings.TrimSuffix(name[strings.LastIndex(name, ".")+1:], "-fm")
+}